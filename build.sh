@@ -3,6 +3,11 @@
 # how to use
 # for macOS & linux, run this command in shell
 # ./build.sh v0.1.0
+#
+# 发布签名(可选): 设置 UPDATE_SIGN_KEY 为 ed25519 私钥(PEM)的路径,
+# 编译时内置对应的公钥, 并对 sha256sums.txt 签名生成 sha256sums.txt.sig, 需要 OpenSSL 3.0 以上
+# openssl genpkey -algorithm ed25519 -out update_sign_key.pem
+# UPDATE_SIGN_KEY=update_sign_key.pem ./build.sh v0.1.0
 
 name="cloudpan189-go"
 version=$1
@@ -13,6 +18,17 @@ fi
 
 output="out"
 
+# 更新签名的公钥(base64), 可直接通过 UPDATE_PUBLIC_KEY 指定, 否则从私钥导出
+pubkey=$UPDATE_PUBLIC_KEY
+if [ "$pubkey" = "" ] && [ "$UPDATE_SIGN_KEY" != "" ]; then
+  pubkey=$(openssl pkey -in "$UPDATE_SIGN_KEY" -pubout -outform DER | tail -c 32 | base64)
+  if [ "$pubkey" = "" ]; then
+    echo "导出公钥失败: $UPDATE_SIGN_KEY"
+    exit 1
+  fi
+fi
+ldflags="-X main.Version=$version -X github.com/tickstep/cloudpan189-go/internal/panupdate.UpdatePublicKey=$pubkey"
+
 default_golang() {
   export GOROOT=/usr/local/go
   go=$GOROOT/bin/go
@@ -30,10 +46,10 @@ Build() {
   if [ $2 = "windows" ]; then
     goversioninfo -o=resource_windows_386.syso
     goversioninfo -64 -o=resource_windows_amd64.syso
-    $go build -ldflags "-X main.Version=$version -s -w" -o "$output/$1/$name.exe"
+    $go build -ldflags "$ldflags -s -w" -o "$output/$1/$name.exe"
     RicePack $1 $name.exe
   else
-    $go build -ldflags "-X main.Version=$version -s -w" -o "$output/$1/$name"
+    $go build -ldflags "$ldflags -s -w" -o "$output/$1/$name"
     RicePack $1 $name
   fi
 
@@ -44,7 +60,7 @@ AndroidBuild() {
   default_golang
   echo "Building $1..."
   export GOOS=$2 GOARCH=$3 GOARM=$4 CGO_ENABLED=1
-  $go build -ldflags "-X main.Version=$version -s -w -linkmode=external -extldflags=-pie" -o "$output/$1/$name"
+  $go build -ldflags "$ldflags -s -w -linkmode=external -extldflags=-pie" -o "$output/$1/$name"
 
   RicePack $1 $name
   Pack $1 $2
@@ -56,7 +72,7 @@ IOSBuild() {
   mkdir -p "$output/$1"
   cd "$output/$1"
   export CC=/usr/local/go/misc/ios/clangwrap.sh GOOS=ios GOARCH=arm64 GOARM=7 CGO_ENABLED=1
-  $go build -ldflags "-X main.Version=$version -s -w" -o $name github.com/tickstep/cloudpan189-go
+  $go build -ldflags "$ldflags -s -w" -o $name github.com/tickstep/cloudpan189-go
   jtool --sign --inplace --ent ../../entitlements.xml $name
   cd ../..
   RicePack $1 $name
@@ -80,6 +96,23 @@ Pack() {
   cd ..
 }
 
+# 生成 sha256sums.txt, 设置了 UPDATE_SIGN_KEY 时同时签名, 程序更新时校验
+Checksum() {
+  cd $output
+  if command -v sha256sum > /dev/null; then
+    sha256sum *.zip > sha256sums.txt
+  else
+    shasum -a 256 *.zip > sha256sums.txt
+  fi
+
+  if [ "$UPDATE_SIGN_KEY" != "" ]; then
+    # ed25519 签名, 由程序内置的公钥校验
+    openssl pkeyutl -sign -rawin -inkey "$UPDATE_SIGN_KEY" -in sha256sums.txt -out sha256sums.txt.sig || exit 1
+  fi
+
+  cd ..
+}
+
 # rice 打包静态资源
 RicePack() {
   return # 已取消web功能
@@ -139,4 +172,7 @@ Build $name-$version"-freebsd-amd64" freebsd amd64
 # Build $name-$version"-dragonflybsd-amd64" dragonfly amd64
 
 # 龙芯 LoongArch
-Build $name-$version"-linux-loong64" linux loong64
\ No newline at end of file
+Build $name-$version"-linux-loong64" linux loong64
+
+# 校验文件
+Checksum
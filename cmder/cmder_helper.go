@@ -9,14 +9,22 @@ import (
 	"github.com/tickstep/library-go/logger"
 	"github.com/urfave/cli"
 	"sync"
+	"sync/atomic"
 )
 
 var (
 	appInstance *cli.App
+	cliMode     bool
 
 	saveConfigMutex *sync.Mutex = new(sync.Mutex)
 
+	// 后台任务运行中时不重载配置, 避免后台任务读取配置的同时被替换
+	reloadSuspended int32
+
 	ReloadConfigFunc = func(c *cli.Context) error {
+		if atomic.LoadInt32(&reloadSuspended) > 0 {
+			return nil
+		}
 		err := config.Config.Reload()
 		if err != nil {
 			fmt.Printf("重载配置错误: %s\n", err)
@@ -35,6 +43,26 @@ var (
 	}
 )
 
+// SuspendConfigReload 暂停重载配置, 与 ResumeConfigReload 成对调用
+func SuspendConfigReload() {
+	atomic.AddInt32(&reloadSuspended, 1)
+}
+
+// ResumeConfigReload 恢复重载配置
+func ResumeConfigReload() {
+	atomic.AddInt32(&reloadSuspended, -1)
+}
+
+// SetCliMode 设置是否处于交互命令行模式
+func SetCliMode(isCli bool) {
+	cliMode = isCli
+}
+
+// IsCliMode 是否处于交互命令行模式
+func IsCliMode() bool {
+	return cliMode
+}
+
 func SetApp(app *cli.App) {
 	appInstance = app
 }
@@ -0,0 +1,355 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/taskframework"
+	"github.com/tickstep/library-go/converter"
+	"github.com/urfave/cli"
+)
+
+type (
+	// backgroundJob 交互模式下在后台执行的上传/下载
+	backgroundJob struct {
+		Id        int
+		Kind      string // 下载 / 上传
+		CmdLine   string
+		StartTime time.Time
+
+		mu        sync.Mutex
+		executor  *taskframework.TaskExecutor
+		statistic *functions.Statistic
+		killed    bool
+		done      chan struct{}
+		log       jobLog
+	}
+
+	// jobLog 后台任务的输出, 只保留最后 maxJobLogSize 字节, 通过 fg 查看
+	jobLog struct {
+		mu      sync.Mutex
+		buf     []byte
+		dropped int64 // 已丢弃的字节数
+	}
+)
+
+const (
+	maxJobLogSize = 256 * 1024
+)
+
+var (
+	backgroundJobs      []*backgroundJob
+	backgroundJobIncr   int
+	backgroundJobLocker sync.Mutex
+)
+
+// startBackgroundJob 在后台执行上传/下载, 只能在交互模式下使用, 非交互模式返回 false
+func startBackgroundJob(kind string, args []string, run func(job *backgroundJob)) bool {
+	if !cmder.IsCliMode() {
+		fmt.Println("提示: -bg 只能在交互模式下使用, 将在前台执行")
+		return false
+	}
+
+	backgroundJobLocker.Lock()
+	backgroundJobIncr++
+	job := &backgroundJob{
+		Id:        backgroundJobIncr,
+		Kind:      kind,
+		CmdLine:   fmt.Sprint(args),
+		StartTime: time.Now(),
+		done:      make(chan struct{}),
+	}
+	backgroundJobs = append(backgroundJobs, job)
+	backgroundJobLocker.Unlock()
+
+	// 后台任务运行中不重载配置
+	cmder.SuspendConfigReload()
+	go func() {
+		defer close(job.done)
+		defer cmder.ResumeConfigReload()
+		run(job)
+		fmt.Printf("\n[后台任务 %d] %s结束: %s, 输入 fg %d 查看输出\n", job.Id, job.Kind, job.CmdLine, job.Id)
+	}()
+	fmt.Printf("[后台任务 %d] 已在后台开始%s, 输入 jobs 查看进度, fg 查看输出\n", job.Id, kind)
+	return true
+}
+
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	if n := len(l.buf) - maxJobLogSize; n > 0 {
+		l.buf = append(l.buf[:0:0], l.buf[n:]...)
+		l.dropped += int64(n)
+	}
+	return len(p), nil
+}
+
+// since 返回 offset 之后的输出和新的 offset
+func (l *jobLog) since(offset int64) ([]byte, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if offset < l.dropped {
+		offset = l.dropped
+	}
+	return append([]byte{}, l.buf[offset-l.dropped:]...), l.dropped + int64(len(l.buf))
+}
+
+// output 上传/下载的输出, 后台任务输出到任务日志, 前台任务输出到标准输出
+func (job *backgroundJob) output() io.Writer {
+	if job == nil {
+		return os.Stdout
+	}
+	return &job.log
+}
+
+// attach 关联任务执行器和统计, 任务已被终止则立即停止执行器
+func (job *backgroundJob) attach(executor *taskframework.TaskExecutor, statistic *functions.Statistic) {
+	if job == nil {
+		return
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.executor = executor
+	job.statistic = statistic
+	if job.killed {
+		executor.Stop()
+	}
+}
+
+func (job *backgroundJob) isDone() bool {
+	select {
+	case <-job.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (job *backgroundJob) status() string {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	switch {
+	case job.isDone():
+		if job.killed {
+			return "已终止"
+		}
+		return "已结束"
+	case job.killed:
+		return "终止中"
+	case job.executor == nil:
+		return "准备中"
+	case job.executor.IsPaused():
+		return "已暂停"
+	}
+	return "运行中"
+}
+
+// progress 进度: 已完成大小, 排队数量, 正在执行数量
+func (job *backgroundJob) progress() (size int64, queued, running int) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.statistic != nil {
+		size = job.statistic.TotalSize()
+	}
+	if job.executor != nil {
+		queued, running = job.executor.Count(), job.executor.RunningCount()
+	}
+	return
+}
+
+// findBackgroundJob 根据编号查找后台任务, 编号为空时返回最近一个未结束的任务
+func findBackgroundJob(idStr string) *backgroundJob {
+	backgroundJobLocker.Lock()
+	defer backgroundJobLocker.Unlock()
+	if idStr == "" {
+		for i := len(backgroundJobs) - 1; i >= 0; i-- {
+			if !backgroundJobs[i].isDone() {
+				return backgroundJobs[i]
+			}
+		}
+		fmt.Println("没有正在执行的后台任务")
+		return nil
+	}
+
+	id, err := strconv.Atoi(idStr)
+	if err == nil {
+		for _, job := range backgroundJobs {
+			if job.Id == id {
+				return job
+			}
+		}
+	}
+	fmt.Printf("后台任务不存在: %s\n", idStr)
+	return nil
+}
+
+func backgroundJobCommand(name, usage string, action func(job *backgroundJob)) cli.Command {
+	return cli.Command{
+		Name:      name,
+		Usage:     usage,
+		UsageText: cmder.App().Name + " " + name + " [后台任务编号]",
+		Description: `
+	后台任务编号可通过 jobs 查看, 不指定编号时为最近一个未结束的后台任务.
+	后台任务只能在交互模式下通过 download -bg 或 upload -bg 创建.
+`,
+		Category: "天翼云盘",
+		Action: func(c *cli.Context) error {
+			if job := findBackgroundJob(c.Args().First()); job != nil {
+				action(job)
+			}
+			return nil
+		},
+	}
+}
+
+func CmdFg() cli.Command {
+	return backgroundJobCommand("fg", "在前台等待后台任务结束, 并显示进度", RunBackgroundJobFg)
+}
+
+func CmdPause() cli.Command {
+	return backgroundJobCommand("pause", "暂停后台任务", RunBackgroundJobPause)
+}
+
+func CmdResume() cli.Command {
+	return backgroundJobCommand("resume", "恢复后台任务", RunBackgroundJobResume)
+}
+
+func CmdKill() cli.Command {
+	return backgroundJobCommand("kill", "终止后台任务", RunBackgroundJobKill)
+}
+
+// RunBackgroundJobList 列出后台任务, 已结束的任务只列出一次
+func RunBackgroundJobList() {
+	backgroundJobLocker.Lock()
+	jobs := append([]*backgroundJob{}, backgroundJobs...)
+	running := backgroundJobs[:0]
+	for _, job := range backgroundJobs {
+		if !job.isDone() {
+			running = append(running, job)
+		}
+	}
+	backgroundJobs = running
+	backgroundJobLocker.Unlock()
+
+	if len(jobs) == 0 {
+		fmt.Println("没有后台任务")
+		return
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "类型", "状态", "已完成", "排队/进行中", "用时", "参数"})
+	for _, job := range jobs {
+		size, queued, runningCount := job.progress()
+		tb.Append([]string{
+			strconv.Itoa(job.Id),
+			job.Kind,
+			job.status(),
+			converter.ConvertFileSize(size, 2),
+			fmt.Sprintf("%d/%d", queued, runningCount),
+			time.Since(job.StartTime).Truncate(time.Second).String(),
+			job.CmdLine,
+		})
+	}
+	tb.Render()
+}
+
+// RunBackgroundJobFg 输出后台任务的日志, 并等待后台任务结束
+func RunBackgroundJobFg(job *backgroundJob) {
+	fmt.Printf("[后台任务 %d] %s: %s\n", job.Id, job.Kind, job.CmdLine)
+	var (
+		offset       int64
+		showProgress bool
+	)
+	printLog := func() {
+		var data []byte
+		data, offset = job.log.since(offset)
+		if len(data) == 0 {
+			return
+		}
+		if showProgress {
+			fmt.Printf("\n")
+			showProgress = false
+		}
+		os.Stdout.Write(data)
+	}
+	printLog()
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-job.done:
+			printLog()
+			fmt.Printf("\n")
+			return
+		case <-ticker.C:
+			printLog()
+			showProgress = true
+			size, queued, running := job.progress()
+			fmt.Printf("\r[后台任务 %d] %s, 已完成 %s, 排队 %d, 进行中 %d, 用时 %s ......", job.Id, job.status(),
+				converter.ConvertFileSize(size, 2), queued, running, time.Since(job.StartTime).Truncate(time.Second))
+		}
+	}
+}
+
+// RunBackgroundJobPause 暂停后台任务, 正在下载的文件同时暂停, 正在上传的文件取消上传, 恢复后断点续传
+func RunBackgroundJobPause(job *backgroundJob) {
+	job.mu.Lock()
+	executor := job.executor
+	job.mu.Unlock()
+	if job.isDone() || executor == nil {
+		fmt.Printf("[后台任务 %d] %s, 无法暂停\n", job.Id, job.status())
+		return
+	}
+	executor.Pause()
+	fmt.Printf("[后台任务 %d] 已暂停\n", job.Id)
+}
+
+// RunBackgroundJobResume 恢复后台任务
+func RunBackgroundJobResume(job *backgroundJob) {
+	job.mu.Lock()
+	executor := job.executor
+	job.mu.Unlock()
+	if job.isDone() || executor == nil {
+		fmt.Printf("[后台任务 %d] %s, 无法恢复\n", job.Id, job.status())
+		return
+	}
+	executor.Resume()
+	fmt.Printf("[后台任务 %d] 已恢复\n", job.Id)
+}
+
+// RunBackgroundJobKill 终止后台任务, 未完成的文件保留断点续传信息
+func RunBackgroundJobKill(job *backgroundJob) {
+	if job.isDone() {
+		fmt.Printf("[后台任务 %d] 已结束\n", job.Id)
+		return
+	}
+	job.mu.Lock()
+	job.killed = true
+	if job.executor != nil {
+		job.executor.Stop()
+	}
+	job.mu.Unlock()
+	fmt.Printf("[后台任务 %d] 正在终止...\n", job.Id)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestJobLog(t *testing.T) {
+	l := &jobLog{}
+	fmt.Fprint(l, "line1\n")
+	data, offset := l.since(0)
+	if string(data) != "line1\n" || offset != 6 {
+		t.Fatalf("data = %q, offset = %d", data, offset)
+	}
+	fmt.Fprint(l, "line2\n")
+	if data, _ = l.since(offset); string(data) != "line2\n" {
+		t.Fatalf("data = %q", data)
+	}
+
+	// 超过最大值时丢弃最早的输出
+	l.Write(bytes.Repeat([]byte("x"), maxJobLogSize))
+	data, offset = l.since(0)
+	if len(data) != maxJobLogSize || offset != maxJobLogSize+12 {
+		t.Fatalf("len = %d, offset = %d", len(data), offset)
+	}
+}
+
+func TestJobOutput(t *testing.T) {
+	var job *backgroundJob
+	if job.output() != os.Stdout {
+		t.Fatal("前台任务应输出到标准输出")
+	}
+	job = &backgroundJob{}
+	fmt.Fprint(job.output(), "hello")
+	if data, _ := job.log.since(0); string(data) != "hello" {
+		t.Fatalf("data = %q", data)
+	}
+}
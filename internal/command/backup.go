@@ -20,7 +20,9 @@ import (
 	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
 	"github.com/tickstep/cloudpan189-go/cmder"
 	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
 	"github.com/tickstep/cloudpan189-go/internal/functions/panupload"
+	"github.com/tickstep/cloudpan189-go/internal/utils"
 	"github.com/tickstep/library-go/logger"
 	"github.com/urfave/cli"
 	"os"
@@ -58,19 +60,27 @@ func CmdBackup() cli.Command {
     4. 将本地的 C:\Users\Administrator\Video 整个目录备份到网盘 /视频 目录，但是排除所有的 @eadir 文件夹
     cloudpan189-go backup -exn "^@eadir$" C:/Users/Administrator/Video /视频
 
+    5. 将本地的 C:\Users\Administrator\Video 整个目录备份到网盘 /视频 目录，只备份最近30天修改过的文件
+    cloudpan189-go backup --newer-than 30d C:/Users/Administrator/Video /视频
+
+    6. 将本地的 C:\Users\Administrator\Video 整个目录备份到网盘 /视频 目录，已修改的文件保留最近5个历史版本, 参考 versions help
+    cloudpan189-go backup --keep-versions 5 C:/Users/Administrator/Video /视频
+
   参考：
     以下是典型的排除特定文件或者文件夹的例子，注意：参数值必须是正则表达式。在正则表达式中，^表示匹配开头，$表示匹配结尾。
     1)排除@eadir文件或者文件夹：-exn "^@eadir$"
     2)排除.jpg文件：-exn "\.jpg$"
     3)排除.号开头的文件：-exn "^\."
     4)排除 myfile.txt 文件：-exn "^myfile.txt$"
+
+    备份目录中每一级的 ` + utils.DefaultIgnoreFileName + ` 文件都会被读取, 使用 gitignore 语法, 可通过 --no-ignore 禁用
 `,
 		Usage:     "备份文件或目录",
 		UsageText: "backup <文件/目录路径1> <文件/目录2> <文件/目录3> ... <目标目录>",
 		Category:  "天翼云盘",
 		Before:    cmder.ReloadConfigFunc,
 		Action:    Backup,
-		Flags: append(UploadFlags, cli.BoolFlag{
+		Flags: append(append(UploadFlags, fileFilterFlags...), cli.BoolFlag{
 			Name:  "delete",
 			Usage: "通过本地数据库记录同步删除网盘文件",
 		}, cli.BoolFlag{
@@ -210,6 +220,10 @@ func DelRemoteFileFromDB(familyId int64, localDir string, savePath string, flagS
 			return
 		}
 		for _, fileEntity := range fileResult.FileList {
+			// 历史版本目录和元数据文件在本地没有对应的文件, 不能删除
+			if fileEntity.FileName == panupload.VersionsDirName || fileEntity.FileName == functions.DirMetaFileName {
+				continue
+			}
 			ufm := &panupload.UploadedFileMeta{
 				FileID:   fileEntity.FileId,
 				ParentId: fileEntity.ParentId,
@@ -291,8 +305,19 @@ func Backup(c *cli.Context) error {
 		ShowProgress:  !c.Bool("np"),
 		IsOverwrite:   true,
 		FamilyId:      parseFamilyId(c),
-		ExcludeNames: c.StringSlice("exn"),
+		ExcludeNames:  c.StringSlice("exn"),
+		SmallFirst:    c.Bool("smallfirst"),
+		Report:        c.String("report"),
+		Links:         c.String("links"),
+		SaveMeta:      c.Bool("meta"),
+		KeepVersions:  c.Int("keep-versions"),
+	}
+	filter, err := parseFileFilter(c)
+	if err != nil {
+		fmt.Println(err)
+		return nil
 	}
+	opt.Filter = filter
 
 	localCount := c.NArg() - 1
 	savePath := GetActiveUser().PathJoin(opt.FamilyId, subArgs[localCount])
@@ -95,7 +95,8 @@ func CmdConfig() cli.Command {
 
 	例子:
 		cloudpan189-go config set -cache_size 64KB
-		cloudpan189-go config set -cache_size 16384 -max_download_parallel 200 -savedir D:/download`,
+		cloudpan189-go config set -cache_size 16384 -max_download_parallel 200 -savedir D:/download
+		cloudpan189-go config set -transfer_retry_policy exponential -transfer_retry_wait 2 -transfer_retry_max_wait 60`,
 				Action: func(c *cli.Context) error {
 					if c.NumFlags() <= 0 || c.NArg() > 0 {
 						cli.ShowCommandHelp(c, c.Command.Name)
@@ -128,6 +129,19 @@ func CmdConfig() cli.Command {
 							return nil
 						}
 					}
+					if c.IsSet("transfer_retry_policy") {
+						err := config.Config.SetTransferRetryPolicy(c.String("transfer_retry_policy"))
+						if err != nil {
+							fmt.Printf("设置 transfer_retry_policy 错误: %s\n", err)
+							return nil
+						}
+					}
+					if c.IsSet("transfer_retry_wait") {
+						config.Config.TransferRetryWait = c.Int("transfer_retry_wait")
+					}
+					if c.IsSet("transfer_retry_max_wait") {
+						config.Config.TransferRetryMaxWait = c.Int("transfer_retry_max_wait")
+					}
 					if c.IsSet("savedir") {
 						config.Config.SaveDir = c.String("savedir")
 					}
@@ -173,6 +187,18 @@ func CmdConfig() cli.Command {
 						Name:  "max_upload_rate",
 						Usage: "限制最大上传速度, 0代表不限制",
 					},
+					cli.StringFlag{
+						Name:  "transfer_retry_policy",
+						Usage: "上传下载失败的重试策略, constant | linear | exponential",
+					},
+					cli.IntFlag{
+						Name:  "transfer_retry_wait",
+						Usage: "重试等待时间, 单位秒",
+					},
+					cli.IntFlag{
+						Name:  "transfer_retry_max_wait",
+						Usage: "重试最大等待时间, 单位秒",
+					},
 					cli.StringFlag{
 						Name:  "savedir",
 						Usage: "下载文件的储存目录",
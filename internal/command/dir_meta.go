@@ -0,0 +1,52 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/functions/pandownload"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panupload"
+)
+
+// saveDirMeta 将上传时收集的文件元数据写入各网盘目录的元数据文件, 与已有的记录合并
+func saveDirMeta(panClient *cloudpan.PanClient, familyId int64, collector *functions.DirMetaCollector) {
+	if collector == nil {
+		return
+	}
+	for _, dir := range collector.Dirs() {
+		metaPath := path.Join(dir, functions.DirMetaFileName)
+		dm, err := pandownload.LoadPanDirMetaByPath(panClient, familyId, dir)
+		if err != nil {
+			// 读取失败时不覆盖, 避免丢失已有的记录
+			fmt.Printf("警告: 读取文件元数据 %s 错误, 不更新: %s\n", metaPath, err)
+			continue
+		}
+		if dm == nil {
+			dm = functions.NewDirMeta()
+		}
+		dm.Merge(collector.DirMeta(dir))
+		data, err := dm.Marshal()
+		if err != nil {
+			fmt.Printf("警告: 保存文件元数据 %s 错误: %s\n", metaPath, err)
+			continue
+		}
+		if err = panupload.UploadSmallFile(panClient, familyId, metaPath, data); err != nil {
+			fmt.Printf("警告: 保存文件元数据 %s 错误: %s\n", metaPath, err)
+		}
+	}
+}
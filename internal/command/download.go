@@ -19,13 +19,14 @@ import (
 	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
 	"github.com/tickstep/cloudpan189-go/internal/config"
 	"github.com/tickstep/cloudpan189-go/internal/file/downloader"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
 	"github.com/tickstep/cloudpan189-go/internal/functions/pandownload"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
 	"github.com/tickstep/cloudpan189-go/internal/taskframework"
 	"github.com/tickstep/cloudpan189-go/internal/utils"
 	"github.com/tickstep/cloudpan189-go/library/requester/transfer"
 	"github.com/tickstep/library-go/converter"
 	"github.com/urfave/cli"
-	"os"
 	"path/filepath"
 	"runtime"
 )
@@ -42,7 +43,20 @@ type (
 		NoCheck              bool
 		ShowProgress         bool
 		FamilyId             int64
-		ExcludeNames         []string // 排除的文件名，包括文件夹和文件。即这些文件/文件夹不进行下载，支持正则表达式
+		ExcludeNames         []string          // 排除的文件名，包括文件夹和文件。即这些文件/文件夹不进行下载，支持正则表达式
+		SmallFirst           bool              // 小文件优先下载
+		Report               string            // 传输报告保存路径, 支持 .json 和 .csv
+		Filter               *utils.FileFilter // 文件过滤条件, 为空时只使用 ExcludeNames
+		RestoreLinks         bool              // 将符号链接描述文件还原为符号链接
+		NoMtime              bool              // 不还原文件的修改时间
+		RestoreMode          bool              // 还原上传时记录的文件权限
+		Summary              string            // 下载目录时结束后输出的目录统计格式, text 或 json
+		OnExists             string            // 本地文件已存在时的处理方式, 为空时按 IsOverwrite 覆盖或跳过
+		Prealloc             bool              // 下载前预先分配整个文件的磁盘空间
+		PartFile             bool              // 先下载到 .part 临时文件, 完成后再重命名
+
+		job   *pandownload.DownloadJob // 恢复的下载任务
+		bgJob *backgroundJob           // 交互模式下的后台任务
 	}
 
 	// LocateDownloadOption 获取下载链接可选参数
@@ -91,6 +105,34 @@ func CmdDownload() cli.Command {
     下载 /我的资源/1.mp4 并保存下载的文件到本地的 d:/panfile
 	cloudpan189-go download --saveto d:/panfile /我的资源/1.mp4
 
+	下载 /我的资源 整个目录, 优先下载小文件
+	cloudpan189-go download -smallfirst /我的资源
+
+	交互模式下, 在后台下载 /我的资源 整个目录, 下载进度可通过 jobs 查看
+	download -bg /我的资源
+
+	下载 /我的资源 整个目录, 并将每个文件的下载结果保存到 report.json
+	cloudpan189-go download --report report.json /我的资源
+
+	只重新下载上一次下载失败的文件, 失败列表文件的路径在下载结束时输出
+	cloudpan189-go download --retry-failed ~/.cloud189/cloud189_failed/download_20210101_120000.json
+
+	继续下载ID为 3 的未完成下载任务, 任务列表可通过 jobs list 查看
+	cloudpan189-go download --resume-job 3
+
+	清理下载保存目录中未完成下载的文件, 参考 download-clean help
+	cloudpan189-go download-clean
+
+	上传时使用 --links store 保存的符号链接描述文件 (*` + localfile.SymlinkDescriptorSuffix + `) 默认按普通文件下载, 使用 --restore-links 还原为符号链接.
+	只还原指向保存目录之内的相对路径的符号链接
+	cloudpan189-go download --restore-links /备份/data
+
+	下载 /我的资源 整个目录, 并还原上传时记录的文件权限. 上传时使用 --meta 记录的文件修改时间默认会还原, 可使用 -nomtime 禁用
+	cloudpan189-go download -mode /我的资源
+
+	下载 /我的资源 整个目录中最近7天修改过的, 不超过1GB的 .mp4 文件
+	cloudpan189-go download --include "\.mp4$" --max-size 1GB --newer-than 7d /我的资源
+
   参考：
     以下是典型的排除特定文件或者文件夹的例子，注意：参数值必须是正则表达式。在正则表达式中，^表示匹配开头，$表示匹配结尾。
     1)排除@eadir文件或者文件夹：-exn "^@eadir$"
@@ -98,11 +140,49 @@ func CmdDownload() cli.Command {
     3)排除.号开头的文件：-exn "^\."
     4)排除~号开头的文件：-exn "^~"
     5)排除 myfile.txt 文件：-exn "^myfile.txt$"
+
+    网盘目录中的 ` + utils.DefaultIgnoreFileName + ` 文件使用 gitignore 语法, 对所在目录及其子目录生效, 例如:
+    *.tmp
+    /cache/
+    !keep.tmp
 `,
 		Category: "天翼云盘",
 		Before:   cmder.ReloadConfigFunc,
 		Action: func(c *cli.Context) error {
-			if c.NArg() == 0 {
+			if c.IsSet("resume-job") {
+				job, err := pandownload.LoadDownloadJob(c.Int64("resume-job"))
+				if err != nil {
+					fmt.Printf("读取下载任务失败: %s\n", err)
+					return nil
+				}
+				runDownloadCmd(c, nil, &DownloadOptions{
+					IsPrintStatus:        c.Bool("status"),
+					IsExecutedPermission: job.Options.IsExecutedPermission,
+					IsOverwrite:          job.Options.IsOverwrite,
+					SaveTo:               job.SaveRoot,
+					Parallel:             job.Options.Parallel,
+					MaxRetry:             job.Options.MaxRetry,
+					NoCheck:              job.Options.NoCheck,
+					ShowProgress:         !c.Bool("np"),
+					FamilyId:             job.FamilyId,
+					ExcludeNames:         job.Options.ExcludeNames,
+					Filter:               job.Options.Filter,
+					RestoreLinks:         c.Bool("restore-links"),
+					NoMtime:              c.Bool("nomtime"),
+					RestoreMode:          c.Bool("mode"),
+					SmallFirst:           c.Bool("smallfirst"),
+					Report:               c.String("report"),
+					Summary:              c.String("summary"),
+					OnExists:             string(job.Options.OnExists),
+					Prealloc:             job.Options.Prealloc,
+					PartFile:             job.Options.PartFile,
+					job:                  job,
+				})
+				return nil
+			}
+
+			retryFailed := c.String("retry-failed")
+			if c.NArg() == 0 && retryFailed == "" {
 				cli.ShowCommandHelp(c, c.Command.Name)
 				return nil
 			}
@@ -128,16 +208,42 @@ func CmdDownload() cli.Command {
 				ShowProgress:         !c.Bool("np"),
 				FamilyId:             parseFamilyId(c),
 				ExcludeNames:         c.StringSlice("exn"),
+				SmallFirst:           c.Bool("smallfirst"),
+				Report:               c.String("report"),
+				RestoreLinks:         c.Bool("restore-links"),
+				NoMtime:              c.Bool("nomtime"),
+				RestoreMode:          c.Bool("mode"),
+				Summary:              c.String("summary"),
+				OnExists:             c.String("on-exists"),
+				Prealloc:             c.Bool("prealloc"),
+				PartFile:             c.Bool("part"),
 			}
-
-			RunDownload(c.Args(), do)
+			filter, err := parseFileFilter(c)
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			do.Filter = filter
+
+			paths := c.Args()
+			if retryFailed != "" {
+				if paths, err = loadDownloadRetryFailed(retryFailed, do); err != nil {
+					fmt.Printf("读取失败列表错误: %s\n", err)
+					return nil
+				}
+			}
+			runDownloadCmd(c, paths, do)
 			return nil
 		},
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.BoolFlag{
 				Name:  "ow",
 				Usage: "overwrite, 覆盖已存在的文件",
 			},
+			cli.StringFlag{
+				Name:  "on-exists",
+				Usage: "本地文件已存在时的处理方式, skip: 跳过, overwrite: 覆盖, rename: 重命名保存, newer: 网盘文件较新时覆盖, different: 大小或md5不同时覆盖. 默认跳过, 指定 -ow 时覆盖",
+			},
 			cli.BoolFlag{
 				Name:  "status",
 				Usage: "输出所有线程的工作状态",
@@ -167,10 +273,30 @@ func CmdDownload() cli.Command {
 				Name:  "nocheck",
 				Usage: "下载文件完成后不校验文件",
 			},
+			cli.BoolFlag{
+				Name:  "prealloc",
+				Usage: "下载前预先分配整个文件的磁盘空间, 减少文件碎片 (linux系统使用fallocate, 其他系统修剪文件)",
+			},
+			cli.BoolFlag{
+				Name:  "part",
+				Usage: "先下载到 *" + pandownload.PartSuffix + " 临时文件, 下载并校验完成后再重命名为原文件名",
+			},
 			cli.BoolFlag{
 				Name:  "np",
 				Usage: "no progress 不展示下载进度条",
 			},
+			cli.BoolFlag{
+				Name:  "nomtime",
+				Usage: "不还原文件的修改时间, 使用下载完成的时间",
+			},
+			cli.BoolFlag{
+				Name:  "mode",
+				Usage: "还原上传时记录的文件权限, (windows系统无效)",
+			},
+			cli.BoolFlag{
+				Name:  "restore-links",
+				Usage: "将上传时保存的符号链接描述文件(*" + localfile.SymlinkDescriptorSuffix + ")还原为符号链接, 只还原指向保存目录之内的符号链接",
+			},
 			cli.StringFlag{
 				Name:  "familyId",
 				Usage: "家庭云ID",
@@ -181,8 +307,70 @@ func CmdDownload() cli.Command {
 				Usage: "exclude name，指定排除的文件夹或者文件的名称，被排除的文件不会进行下载，只支持正则表达式。支持同时排除多个名称，每一个名称就是一个exn参数",
 				Value: nil,
 			},
-		},
+			cli.BoolFlag{
+				Name:  "smallfirst",
+				Usage: "小文件优先下载",
+			},
+			cli.Int64Flag{
+				Name:  "resume-job",
+				Usage: "继续下载指定ID的未完成下载任务",
+			},
+			cli.StringFlag{
+				Name:  "report",
+				Usage: "下载结束后将每个文件的下载结果保存到指定文件, 支持 .json 和 .csv 格式",
+			},
+			cli.StringFlag{
+				Name:  "summary",
+				Usage: "下载目录结束后按目录输出统计(文件数, 大小, 跳过, 失败)的格式, 可选 text 或 json",
+				Value: pandownload.SummaryFormatText,
+			},
+			cli.StringFlag{
+				Name:  "retry-failed",
+				Usage: "只重新下载失败列表文件中的文件, 下载失败时会自动生成失败列表文件",
+			},
+			cli.BoolFlag{
+				Name:  "bg",
+				Usage: "在后台下载, 只能在交互模式下使用, 通过 jobs, fg, pause, resume, kill 管理",
+			},
+		}, fileFilterFlags...),
+	}
+}
+
+// loadDownloadRetryFailed 读取下载失败列表, 沿用失败列表的保存目录和家庭云ID, 返回要重新下载的网盘路径
+func loadDownloadRetryFailed(failedFile string, options *DownloadOptions) ([]string, error) {
+	fl, err := functions.LoadFailedList(failedFile, "download")
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(fl.Items))
+	for _, item := range fl.Items {
+		if item.RemotePath != "" {
+			paths = append(paths, item.RemotePath)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("失败列表中没有文件")
 	}
+	options.SaveTo = fl.SaveTo
+	options.FamilyId = fl.FamilyId
+	return paths, nil
+}
+
+// runDownloadCmd 执行下载, 指定 -bg 时在后台执行
+func runDownloadCmd(c *cli.Context, paths []string, options *DownloadOptions) {
+	if c.Bool("bg") {
+		paths = append([]string{}, paths...)
+		started := startBackgroundJob("下载", c.Args(), func(job *backgroundJob) {
+			options.IsPrintStatus = false
+			options.ShowProgress = false
+			options.bgJob = job
+			RunDownload(paths, options)
+		})
+		if started {
+			return
+		}
+	}
+	RunDownload(paths, options)
 }
 
 func downloadPrintFormat() string {
@@ -191,6 +379,7 @@ func downloadPrintFormat() string {
 
 // RunDownload 执行下载网盘内文件
 func RunDownload(paths []string, options *DownloadOptions) {
+	out := options.bgJob.output()
 	if options == nil {
 		options = &DownloadOptions{}
 	}
@@ -199,6 +388,44 @@ func RunDownload(paths []string, options *DownloadOptions) {
 		options.MaxRetry = pandownload.DefaultDownloadMaxRetry
 	}
 
+	if err := pandownload.CheckSummaryFormat(options.Summary); err != nil {
+		fmt.Fprintln(out, err)
+		return
+	}
+	onExists, err := functions.ParseOnExistsPolicy(options.OnExists, options.IsOverwrite, functions.OnExistsSkip)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return
+	}
+	options.IsOverwrite = onExists == functions.OnExistsOverwrite
+
+	// 比较md5时使用文件摘要缓存
+	var hashCache *localfile.HashCache
+	if onExists == functions.OnExistsDifferent {
+		if hashCache, err = openHashCache(); err != nil {
+			panCommandVerbose.Warnf("open hash cache failed: %s\n", err)
+		} else {
+			defer hashCache.Close()
+		}
+	}
+
+	report, reportErr := functions.NewTransferReport("download", options.Report)
+	if reportErr != nil {
+		fmt.Fprintln(out, reportErr)
+		return
+	}
+
+	filter := options.Filter
+	if filter == nil {
+		filter = &utils.FileFilter{ExcludeNames: options.ExcludeNames}
+	}
+	if filter.IgnoreFile != "" && options.job != nil {
+		// 恢复下载任务时重新读取忽略文件
+		if err := filter.SetIgnoreFile(filter.IgnoreFile); err != nil {
+			fmt.Fprintf(out, "警告: 读取忽略文件错误: %s\n", err)
+		}
+	}
+
 	if runtime.GOOS == "windows" {
 		// windows下不加执行权限
 		options.IsExecutedPermission = false
@@ -213,6 +440,7 @@ func RunDownload(paths []string, options *DownloadOptions) {
 		InstanceStateStorageFormat: downloader.InstanceStateStorageFormatJSON,
 		ShowProgress:               options.ShowProgress,
 		ExcludeNames:               options.ExcludeNames,
+		Prealloc:                   options.Prealloc,
 	}
 	if cfg.CacheSize == 0 {
 		cfg.CacheSize = int(DownloadCacheSize)
@@ -229,19 +457,21 @@ func RunDownload(paths []string, options *DownloadOptions) {
 		options.Parallel = config.MaxFileDownloadParallelNum
 	}
 
-	paths, err := makePathAbsolute(options.FamilyId, paths...)
+	paths, err = makePathAbsolute(options.FamilyId, paths...)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(out, err)
 		return
 	}
 
-	fmt.Print("\n")
-	fmt.Printf("[0] 提示: 当前下载最大并发量为: %d, 下载缓存为: %d\n", options.Parallel, cfg.CacheSize)
+	fmt.Fprint(out, "\n")
+	fmt.Fprintf(out, "[0] 提示: 当前下载最大并发量为: %d, 下载缓存为: %d\n", options.Parallel, cfg.CacheSize)
 
 	var (
 		panClient = GetActivePanClient()
 	)
 	cfg.MaxParallel = options.Parallel
+	// 同时下载的文件共享连接数, 单个文件的线程数在预算内自适应调整
+	cfg.ConnBudget = downloader.NewConnectionBudget(options.Parallel * downloader.MaxParallelWorkerCount)
 
 	// 预测要下载的文件数量
 	//for k := range paths {
@@ -281,56 +511,118 @@ func RunDownload(paths []string, options *DownloadOptions) {
 			IsFailedDeque: true, // 统计失败的列表
 		}
 		statistic = &pandownload.DownloadStatistic{}
+		summary   = pandownload.NewDownloadSummary()
+		job       = options.job
 	)
 	executor.SetParallel(cfg.MaxParallel)
+	executor.SetRetryPolicy(transferRetryPolicy())
+	if options.SmallFirst {
+		executor.SetPriorityFunc(taskframework.SmallFirstPriority)
+	}
+	options.bgJob.attach(&executor, &statistic.Statistic)
+
+	newUnit := func(filePanPath, savePath, saveRootPath string) *pandownload.DownloadTaskUnit {
+		newCfg := *cfg // 复制一份新的cfg
+		return &pandownload.DownloadTaskUnit{
+			Cfg:                  &newCfg,
+			PanClient:            panClient,
+			VerbosePrinter:       panCommandVerbose,
+			PrintFormat:          downloadPrintFormat(),
+			ParentTaskExecutor:   &executor,
+			DownloadStatistic:    statistic,
+			DownloadJob:          job,
+			Report:               report,
+			Summary:              summary,
+			Output:               out,
+			OnExists:             onExists,
+			HashCache:            hashCache,
+			PartFile:             options.PartFile,
+			Filter:               filter,
+			IsPrintStatus:        options.IsPrintStatus,
+			IsExecutedPermission: options.IsExecutedPermission,
+			IsOverwrite:          options.IsOverwrite,
+			NoCheck:              options.NoCheck,
+			RestoreLinks:         options.RestoreLinks,
+			NoMtime:              options.NoMtime,
+			RestoreMode:          options.RestoreMode,
+			FilePanPath:          filePanPath,
+			SavePath:             savePath,
+			OriginSaveRootPath:   saveRootPath,
+			FamilyId:             options.FamilyId,
+		}
+	}
 
-	// 处理队列
-	for k := range paths {
-		// 使用通配符匹配
-		fileList, err2 := matchPathByShellPattern(options.FamilyId, paths[k])
-		if err2 != nil {
-			fmt.Printf("获取文件出错，请稍后重试: %s\n", paths[k])
-			continue
+	if job != nil {
+		// 恢复下载任务, 只下载未完成的文件和未展开的目录
+		fmt.Fprintf(out, "[0] 继续下载任务: %d\n", job.Id)
+		for _, p := range job.Paths {
+			filter.AddRoot(p)
+		}
+		for _, item := range job.UnfinishedItems() {
+			info := executor.Append(newUnit(item.PanPath, item.SavePath, job.SaveRoot), options.MaxRetry)
+			fmt.Fprintf(out, "[%s] 加入下载队列: %s\n", info.Id(), item.PanPath)
 		}
-		if fileList == nil || len(fileList) == 0 {
-			// 文件不存在
-			fmt.Printf("文件不存在: %s\n", paths[k])
-			continue
+	} else {
+		// 记录下载任务, 以便进程中断后可以继续下载
+		saveRootPath := options.SaveTo
+		if saveRootPath == "" {
+			// 使用默认的保存路径
+			saveRootPath = GetActiveUser().GetSavePath("")
+		}
+		job, err = pandownload.NewDownloadJob(options.FamilyId, paths, saveRootPath, &pandownload.DownloadJobOptions{
+			IsExecutedPermission: options.IsExecutedPermission,
+			IsOverwrite:          options.IsOverwrite,
+			NoCheck:              options.NoCheck,
+			Parallel:             options.Parallel,
+			MaxRetry:             options.MaxRetry,
+			ExcludeNames:         options.ExcludeNames,
+			Filter:               filter,
+			OnExists:             onExists,
+			Prealloc:             options.Prealloc,
+			PartFile:             options.PartFile,
+		})
+		if err != nil {
+			panCommandVerbose.Warnf("create download job failed: %s\n", err)
+			job = nil
 		}
 
-		for _, f := range fileList {
-			newCfg := *cfg
-			// 是否排除下载
-			if utils.IsExcludeFile(f.Path, &newCfg.ExcludeNames) {
-				fmt.Printf("排除文件: %s\n", f.Path)
+		for k := range paths {
+			// 使用通配符匹配
+			fileList, err2 := matchPathByShellPattern(options.FamilyId, paths[k])
+			if err2 != nil {
+				fmt.Fprintf(out, "获取文件出错，请稍后重试: %s\n", paths[k])
 				continue
 			}
-			unit := pandownload.DownloadTaskUnit{
-				Cfg:                  &newCfg, // 复制一份新的cfg
-				PanClient:            panClient,
-				VerbosePrinter:       panCommandVerbose,
-				PrintFormat:          downloadPrintFormat(),
-				ParentTaskExecutor:   &executor,
-				DownloadStatistic:    statistic,
-				IsPrintStatus:        options.IsPrintStatus,
-				IsExecutedPermission: options.IsExecutedPermission,
-				IsOverwrite:          options.IsOverwrite,
-				NoCheck:              options.NoCheck,
-				FilePanPath:          f.Path,
-				FamilyId:             options.FamilyId,
+			if fileList == nil || len(fileList) == 0 {
+				// 文件不存在
+				fmt.Fprintf(out, "文件不存在: %s\n", paths[k])
+				continue
 			}
 
-			// 设置储存的路径
-			if options.SaveTo != "" {
-				unit.OriginSaveRootPath = options.SaveTo
-				unit.SavePath = filepath.Join(options.SaveTo, f.Path)
-			} else {
-				// 使用默认的保存路径
-				unit.OriginSaveRootPath = GetActiveUser().GetSavePath("")
-				unit.SavePath = GetActiveUser().GetSavePath(f.Path)
+			for _, f := range fileList {
+				// 是否排除下载
+				if filter.IsExcluded(f.Path, f.IsFolder, f.FileSize, pandownload.PanFileModTime(f)) {
+					fmt.Fprintf(out, "排除文件: %s\n", f.Path)
+					continue
+				}
+				if f.IsFolder {
+					// 忽略规则相对于下载的目录
+					filter.AddRoot(f.Path)
+				}
+
+				// 设置储存的路径
+				var savePath string
+				if options.SaveTo != "" {
+					savePath = filepath.Join(options.SaveTo, f.Path)
+				} else {
+					savePath = GetActiveUser().GetSavePath(f.Path)
+				}
+				if job != nil {
+					job.AddItem(f.Path, savePath, f.IsFolder)
+				}
+				info := executor.Append(newUnit(f.Path, savePath, saveRootPath), options.MaxRetry)
+				fmt.Fprintf(out, "[%s] 加入下载队列: %s\n", info.Id(), f.Path)
 			}
-			info := executor.Append(&unit, options.MaxRetry)
-			fmt.Printf("[%s] 加入下载队列: %s\n", info.Id(), f.Path)
 		}
 	}
 
@@ -340,17 +632,65 @@ func RunDownload(paths []string, options *DownloadOptions) {
 	// 开始执行
 	executor.Execute()
 
-	fmt.Printf("\n下载结束, 时间: %s, 数据总量: %s\n", statistic.Elapsed()/1e6*1e6, converter.ConvertFileSize(statistic.TotalSize()))
+	fmt.Fprintf(out, "\n下载结束, 时间: %s, 数据总量: %s\n", statistic.Elapsed()/1e6*1e6, converter.ConvertFileSize(statistic.TotalSize()))
 
 	// 输出失败的文件列表
 	failedList := executor.FailedDeque()
+	failedPaths := map[string]bool{}
+	retryList := functions.NewFailedList("download", options.FamilyId)
+	retryList.SaveTo = options.SaveTo
 	if failedList.Size() != 0 {
-		fmt.Printf("以下文件下载失败: \n")
-		tb := cmdtable.NewTable(os.Stdout)
+		fmt.Fprintf(out, "以下文件下载失败: \n")
+		tb := cmdtable.NewTable(out)
 		for e := failedList.Shift(); e != nil; e = failedList.Shift() {
 			item := e.(*taskframework.TaskInfoItem)
-			tb.Append([]string{item.Info.Id(), item.Unit.(*pandownload.DownloadTaskUnit).FilePanPath})
+			unit := item.Unit.(*pandownload.DownloadTaskUnit)
+			failedPaths[unit.FilePanPath] = true
+			tb.Append([]string{item.Info.Id(), unit.FilePanPath})
+			retryList.Add(&functions.FailedItem{
+				LocalPath:  unit.SavePath,
+				RemotePath: unit.FilePanPath,
+			})
 		}
 		tb.Render()
 	}
+
+	// 输出校验失败的文件列表
+	checksumFailedList := statistic.ChecksumFailedList()
+	if len(checksumFailedList) != 0 {
+		fmt.Fprintf(out, "以下文件下载后校验失败: \n")
+		tb := cmdtable.NewTable(out)
+		tb.SetHeader([]string{"网盘路径", "本地md5", "网盘md5", "处理结果"})
+		for _, item := range checksumFailedList {
+			state := "已重新下载"
+			if failedPaths[item.PanPath] {
+				state = "重新下载失败"
+			}
+			tb.Append([]string{item.PanPath, item.LocalMd5, item.PanMd5, state})
+		}
+		tb.Render()
+	}
+
+	// 输出目录统计
+	if summary.HasDir() {
+		fmt.Fprintf(out, "\n目录统计: \n")
+		if err := summary.Print(out, options.Summary); err != nil {
+			fmt.Fprintf(out, "输出目录统计错误: %s\n", err)
+		}
+	}
+
+	// 保存下载任务
+	if job != nil {
+		if job.IsFinished() {
+			job.Remove()
+		} else {
+			if err = job.Save(); err != nil {
+				panCommandVerbose.Warnf("save download job failed: %s\n", err)
+			}
+			fmt.Fprintf(out, "下载任务未全部完成, 可使用以下命令继续下载: %s download --resume-job %d\n", cmder.App().Name, job.Id)
+		}
+	}
+
+	saveTransferReport(out, report, options.Report)
+	saveFailedList(out, retryList)
 }
@@ -0,0 +1,253 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdliner"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdutil"
+	"github.com/tickstep/cloudpan189-go/internal/file/downloader"
+	"github.com/tickstep/cloudpan189-go/internal/functions/pandownload"
+	"github.com/tickstep/library-go/converter"
+	"github.com/urfave/cli"
+)
+
+type (
+	// orphanDownload 未完成下载的断点续传文件
+	orphanDownload struct {
+		StatePath  string // 断点续传文件路径
+		TargetPath string // 下载的目标文件路径
+		PanPath    string // 推测的网盘文件路径
+		Downloaded int64
+		TotalSize  int64
+	}
+)
+
+// CmdDownloadClean 清理未完成下载的断点续传文件
+func CmdDownloadClean() cli.Command {
+	return cli.Command{
+		Name:      "download-clean",
+		Usage:     "清理未完成下载的断点续传文件",
+		Category:  "天翼云盘",
+		Before:    cmder.ReloadConfigFunc,
+		UsageText: cmder.App().Name + " download-clean [本地目录]",
+		Description: `
+	查找本地目录中未完成下载的断点续传文件 (*` + pandownload.DownloadSuffix + `), 显示下载进度, 并选择继续下载, 删除或保留.
+	本地目录默认为下载保存目录.
+	继续下载时, 根据文件相对于下载保存目录的路径推测网盘路径.
+
+	示例:
+
+	1. 逐个选择处理下载保存目录中的未完成下载
+	cloudpan189-go download-clean
+
+	2. 删除 D:/Downloads 中所有未完成下载的文件
+	cloudpan189-go download-clean -rm D:/Downloads
+
+	3. 继续下载 D:/Downloads 中所有未完成下载的文件
+	cloudpan189-go download-clean -resume D:/Downloads
+`,
+		Action: func(c *cli.Context) error {
+			if c.Bool("rm") && c.Bool("resume") {
+				fmt.Println("不能同时指定 -rm 和 -resume")
+				return nil
+			}
+			RunDownloadClean(c.Args().First(), c.Bool("rm"), c.Bool("resume"), parseFamilyId(c))
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "rm",
+				Usage: "删除所有未完成下载的文件, 不再询问",
+			},
+			cli.BoolFlag{
+				Name:  "resume",
+				Usage: "继续下载所有未完成下载的文件, 不再询问",
+			},
+			cli.StringFlag{
+				Name:  "familyId",
+				Usage: "家庭云ID, 继续下载时使用",
+				Value: "",
+			},
+		},
+	}
+}
+
+// findOrphanDownloads 查找目录中的断点续传文件
+func findOrphanDownloads(dir, saveRoot string) (list []*orphanDownload, err error) {
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Printf("警告: 遍历错误: %s\n", err)
+			return nil
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), pandownload.DownloadSuffix) {
+			return nil
+		}
+
+		od := &orphanDownload{
+			StatePath:  p,
+			TargetPath: strings.TrimSuffix(p, pandownload.DownloadSuffix),
+		}
+		if rel, err := filepath.Rel(saveRoot, od.TargetPath); err == nil && !strings.HasPrefix(rel, "..") {
+			od.PanPath = path.Clean("/" + cmdutil.ConvertToUnixPathSeparator(rel))
+		}
+
+		// 解析断点续传信息
+		f, err := os.Open(p)
+		if err == nil {
+			eii := downloader.NewInstanceState(f, downloader.InstanceStateStorageFormatJSON).Get()
+			if eii != nil && eii.DownloadStatus != nil {
+				od.Downloaded = eii.DownloadStatus.Downloaded()
+				od.TotalSize = eii.DownloadStatus.TotalSize()
+			}
+			f.Close()
+		}
+		list = append(list, od)
+		return nil
+	})
+	return
+}
+
+// RunDownloadClean 清理未完成下载的断点续传文件
+func RunDownloadClean(dir string, removeAll, resumeAll bool, familyId int64) {
+	// 推测网盘路径时使用的本地根目录
+	saveRoot := GetActiveUser().GetSavePath("")
+	isDefaultRoot := true
+	if dir == "" {
+		dir = saveRoot
+	} else {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		dir = absDir
+		if absRoot, err := filepath.Abs(saveRoot); err != nil || !strings.HasPrefix(dir+string(os.PathSeparator), absRoot+string(os.PathSeparator)) {
+			// 不在默认下载目录中, 视为通过 --saveto 指定的保存目录
+			saveRoot = dir
+			isDefaultRoot = false
+		} else {
+			saveRoot = absRoot
+		}
+	}
+
+	list, err := findOrphanDownloads(dir, saveRoot)
+	if err != nil {
+		fmt.Printf("查找断点续传文件失败: %s\n", err)
+		return
+	}
+	if len(list) == 0 {
+		fmt.Printf("目录 %s 中没有未完成下载的文件\n", dir)
+		return
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "本地文件", "网盘路径", "已下载/总大小", "进度"})
+	for k, od := range list {
+		progress := "-"
+		if od.TotalSize > 0 {
+			progress = fmt.Sprintf("%.1f%%", float64(od.Downloaded)*100/float64(od.TotalSize))
+		}
+		tb.Append([]string{strconv.Itoa(k + 1), od.TargetPath, od.PanPath, converter.ConvertFileSize(od.Downloaded, 2) + "/" + converter.ConvertFileSize(od.TotalSize, 2), progress})
+	}
+	tb.Render()
+
+	var resumeList []*orphanDownload
+	if removeAll || resumeAll {
+		for _, od := range list {
+			if removeAll {
+				removeOrphanDownload(od)
+			} else {
+				resumeList = append(resumeList, od)
+			}
+		}
+	} else {
+		line := cmdliner.NewLiner()
+		for k, od := range list {
+			input, err := line.State.Prompt(fmt.Sprintf("[%d] %s, 继续下载(r)/删除(d)/保留(k), 默认保留: ", k+1, od.TargetPath))
+			if err != nil {
+				fmt.Printf("输入错误: %s\n", err)
+				break
+			}
+			switch strings.ToLower(strings.TrimSpace(input)) {
+			case "r":
+				resumeList = append(resumeList, od)
+			case "d":
+				removeOrphanDownload(od)
+			}
+		}
+		line.Close()
+	}
+
+	if len(resumeList) == 0 {
+		return
+	}
+
+	panClient := GetActivePanClient()
+	panPaths := make([]string, 0, len(resumeList))
+	for _, od := range resumeList {
+		if od.PanPath == "" {
+			fmt.Printf("无法推测网盘路径, 跳过: %s\n", od.TargetPath)
+			continue
+		}
+		// 云盘文件发生变化的, 续传会导致文件损坏
+		fileInfo, apierr := panClient.AppFileInfoByPath(familyId, od.PanPath)
+		if apierr != nil {
+			fmt.Printf("获取网盘文件 %s 失败, 跳过: %s\n", od.PanPath, apierr)
+			continue
+		}
+		if od.TotalSize > 0 && fileInfo.FileSize != od.TotalSize {
+			fmt.Printf("网盘文件 %s 大小已变化, 无法继续下载, 请删除后重新下载\n", od.PanPath)
+			continue
+		}
+		panPaths = append(panPaths, od.PanPath)
+	}
+	if len(panPaths) == 0 {
+		return
+	}
+
+	options := &DownloadOptions{
+		MaxRetry:     pandownload.DefaultDownloadMaxRetry,
+		ShowProgress: true,
+		FamilyId:     familyId,
+	}
+	if !isDefaultRoot {
+		options.SaveTo = saveRoot
+	}
+	RunDownload(panPaths, options)
+}
+
+func removeOrphanDownload(od *orphanDownload) {
+	if err := os.Remove(od.TargetPath); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("删除文件失败: %s\n", err)
+		return
+	}
+	if err := os.Remove(od.TargetPath + pandownload.PartSuffix); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("删除文件失败: %s\n", err)
+		return
+	}
+	if err := os.Remove(od.StatePath); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("删除文件失败: %s\n", err)
+		return
+	}
+	fmt.Printf("已删除: %s\n", od.TargetPath)
+}
@@ -14,22 +14,48 @@
 package command
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
 	"github.com/tickstep/cloudpan189-api/cloudpan"
 	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
 	"github.com/tickstep/cloudpan189-go/cmder"
 	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/functions/pandownload"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panupload"
+	"github.com/tickstep/cloudpan189-go/internal/utils"
 	"github.com/tickstep/library-go/logger"
 	"github.com/urfave/cli"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type (
+	// ExportOptions 导出可选项
+	ExportOptions struct {
+		FamilyId  int64
+		Overwrite bool              // 覆盖已存在的导出文件
+		Append    bool              // 追加到已存在的导出文件
+		Format    string            // 导出格式, 为空时根据文件扩展名或已有的内容选择
+		Report    string            // 传输报告保存路径, 支持 .json 和 .csv
+		Since     time.Time         // 只导出该时间及之后修改的文件
+		Rate      float64           // 每秒最多请求的目录数量, 0为不限制
+		Filter    *utils.FileFilter // 文件过滤条件
+	}
+
+	// requestLimiter 限制请求的频率, 并发安全
+	requestLimiter struct {
+		interval time.Duration
+		last     time.Time
+		mu       sync.Mutex
+	}
+
 	ImportExportFileItem struct {
 		FileMd5 string `json:"md5"`
 		FileSize int64 `json:"size"`
@@ -38,6 +64,11 @@ type (
 	}
 )
 
+const (
+	// DefaultExportRate 导出时默认每秒最多读取的网盘目录数量
+	DefaultExportRate = 5
+)
+
 func CmdExport() cli.Command {
 	return cli.Command{
 		Name:      "export",
@@ -57,6 +88,21 @@ func CmdExport() cli.Command {
 
     导出 网盘 整个目录 元数据到文件 /Users/tickstep/Downloads/export_files.txt
 	cloudpan189-go export / /Users/tickstep/Downloads/export_files.txt
+
+	导出 /我的资源 目录中不小于100MB的 .mp4 文件元数据, 目录中的 ` + utils.DefaultIgnoreFileName + ` 忽略文件同样生效
+	cloudpan189-go export --include "\.mp4$" --min-size 100MB /我的资源 /Users/tickstep/Downloads/export_files.txt
+
+	导出 /我的资源 整个目录为秒传链接, 每行一个 md5#大小#路径, 可以直接分享给其他工具导入
+	cloudpan189-go export --format link /我的资源 /Users/tickstep/Downloads/export_links.txt
+
+	导出 /我的资源 整个目录为CSV表格
+	cloudpan189-go export /我的资源 /Users/tickstep/Downloads/export_files.csv
+
+	增量导出, 只导出上一次导出之后修改过的文件, 并追加到原来的导出文件中
+	cloudpan189-go export --since /Users/tickstep/Downloads/export_files.txt --append / /Users/tickstep/Downloads/export_files.txt
+
+	导出最近7天修改过的文件, 每秒最多读取10个目录
+	cloudpan189-go export --since 7d --rate 10 / /Users/tickstep/Downloads/export_files.txt
 `,
 		Category: "天翼云盘",
 		Before:   cmder.ReloadConfigFunc,
@@ -66,11 +112,39 @@ func CmdExport() cli.Command {
 				return nil
 			}
 
+			filter, err := parseFileFilter(c)
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			if c.Bool("ow") && c.Bool("append") {
+				fmt.Println("不能同时指定 -ow 和 --append")
+				return nil
+			}
+			if err = checkFileListFormat(c.String("format"), true); err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			opt := &ExportOptions{
+				FamilyId:  parseFamilyId(c),
+				Overwrite: c.Bool("ow"),
+				Append:    c.Bool("append"),
+				Format:    c.String("format"),
+				Report:    c.String("report"),
+				Rate:      c.Float64("rate"),
+				Filter:    filter,
+			}
+			if s := c.String("since"); s != "" {
+				if opt.Since, err = parseExportSince(s); err != nil {
+					fmt.Println(err)
+					return nil
+				}
+			}
 			subArgs := c.Args()
-			RunExportFiles(parseFamilyId(c), c.Bool("ow"), subArgs[:len(subArgs)-1], subArgs[len(subArgs)-1])
+			RunExportFiles(subArgs[:len(subArgs)-1], subArgs[len(subArgs)-1], opt)
 			return nil
 		},
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.BoolFlag{
 				Name:  "ow",
 				Usage: "overwrite, 覆盖已存在的导出文件",
@@ -80,12 +154,193 @@ func CmdExport() cli.Command {
 				Usage: "家庭云ID",
 				Value: "",
 			},
-		},
+			cli.StringFlag{
+				Name:  "report",
+				Usage: "导出结束后将每个文件的导出结果保存到指定文件, 支持 .json 和 .csv 格式",
+			},
+			cli.StringSliceFlag{
+				Name:  "exn",
+				Usage: "exclude name，指定排除的文件夹或者文件的名称，只支持正则表达式。支持同时排除多个名称，每一个名称就是一个exn参数",
+			},
+			cli.BoolFlag{
+				Name:  "append",
+				Usage: "追加到已存在的导出文件, 不覆盖原有的内容",
+			},
+			cli.StringFlag{
+				Name:  "since",
+				Usage: "只导出指定时间及之后修改的文件, 支持日期, 时长或上一次的导出文件, 例如: 2021-01-01, 24h, export_files.txt",
+			},
+			cli.Float64Flag{
+				Name:  "rate",
+				Usage: "每秒最多读取的网盘目录数量, 0为不限制",
+				Value: DefaultExportRate,
+			},
+			cli.StringFlag{
+				Name:  "format",
+				Usage: "导出格式, json: 每行一个JSON对象, link: 每行一个秒传链接 md5#大小#路径, csv: CSV表格, tree: 带目录结构的JSON文档. 默认根据保存文件的扩展名选择 csv 或 json",
+			},
+		}, fileFilterFlags...),
+	}
+}
+
+// walkPanFiles 递归遍历网盘目录下的文件, 读取每一级目录中的忽略文件, 被过滤的文件和目录会跳过
+func walkPanFiles(panClient *cloudpan.PanClient, familyId int64, panPath string, filter *utils.FileFilter, limiter *requestLimiter, fn cloudpan.HandleAppFileDirectoryFunc) {
+	fd, apierr := panClient.AppFileInfoByPath(familyId, panPath)
+	if apierr != nil {
+		fn(0, panPath, nil, apierr)
+		return
+	}
+	fd.Path = panPath
+	if !fd.IsFolder {
+		if !filter.IsExcluded(fd.Path, false, fd.FileSize, pandownload.PanFileModTime(fd)) {
+			fn(0, fd.Path, fd, nil)
+		}
+		return
+	}
+	filter.AddRoot(panPath)
+	walkPanDir(panClient, familyId, fd, 1, filter, limiter, fn)
+}
+
+func walkPanDir(panClient *cloudpan.PanClient, familyId int64, dir *cloudpan.AppFileEntity, depth int, filter *utils.FileFilter, limiter *requestLimiter, fn cloudpan.HandleAppFileDirectoryFunc) bool {
+	limiter.Wait()
+	param := cloudpan.NewAppFileListParam()
+	param.FamilyId = familyId
+	param.FileId = dir.FileId
+	r, apierr := panClient.AppGetAllFileList(param)
+	if apierr != nil {
+		return fn(depth, dir.Path, nil, apierr)
+	}
+	if err := pandownload.LoadPanIgnoreFile(filter, panClient, familyId, dir.Path, r.FileList); err != nil {
+		fmt.Printf("\n读取忽略文件错误: %s\n", err)
+	}
+	for _, fd := range r.FileList {
+		fd.Path = path.Join(dir.Path, fd.FileName)
+		if fd.IsFolder && fd.FileName == panupload.VersionsDirName {
+			// 历史版本目录不导出
+			continue
+		}
+		if !fd.IsFolder && fd.FileName == functions.DirMetaFileName {
+			// 元数据文件不导出
+			continue
+		}
+		if filter.IsExcluded(fd.Path, fd.IsFolder, fd.FileSize, pandownload.PanFileModTime(fd)) {
+			continue
+		}
+		ok := true
+		if fd.IsFolder {
+			ok = walkPanDir(panClient, familyId, fd, depth+1, filter, limiter, fn)
+		} else {
+			ok = fn(depth, fd.Path, fd, nil)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestLimiter 每秒最多 rate 次请求, rate 为0时不限制
+func newRequestLimiter(rate float64) *requestLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &requestLimiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// Wait 等待到可以发起下一次请求
+func (rl *requestLimiter) Wait() {
+	if rl == nil {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if d := rl.interval - time.Since(rl.last); d > 0 {
+		time.Sleep(d)
+	}
+	rl.last = time.Now()
+}
+
+// parseExportSince 解析 --since 参数, 可以是时间, 时长或上一次的导出文件.
+// 使用导出文件时, 取文件中最新的修改时间, 没有记录修改时间的格式使用导出文件自身的修改时间
+func parseExportSince(s string) (time.Time, error) {
+	info, err := os.Stat(s)
+	if err != nil || info.IsDir() {
+		t, err := parseFilterTime(s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("--since 参数错误, 不是有效的时间或导出文件: %s", s)
+		}
+		return t, nil
+	}
+
+	data, err := ioutil.ReadFile(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	items, _, err := parseFileList("", data, nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("解析导出文件 %s 出错: %s", s, err)
+	}
+	var since time.Time
+	for _, item := range items {
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", item.LastOpTime, time.Local)
+		if err == nil && t.After(since) {
+			since = t
+		}
+	}
+	if since.IsZero() {
+		since = info.ModTime()
+	}
+	return since, nil
+}
+
+// applyExportSince 只导出 since 及之后修改的文件. 修改时间只精确到秒, 包含 since 当时修改的文件,
+// 以免漏掉上一次导出时同一秒内修改的文件, 追加导出时已导出的文件会按路径去重
+func applyExportSince(filter *utils.FileFilter, since time.Time) *utils.FileFilter {
+	if filter == nil {
+		filter = &utils.FileFilter{}
+	}
+	// FileFilter.NewerThan 不包含边界
+	if bound := since.Add(-time.Nanosecond); bound.After(filter.NewerThan) {
+		filter.NewerThan = bound
+	}
+	return filter
+}
+
+// loadExportedFiles 读取已有导出文件中的文件, 路径 => md5
+func loadExportedFiles(data []byte) map[string]string {
+	items, _, err := parseFileList("", data, nil)
+	if err != nil {
+		return nil
 	}
+	exported := make(map[string]string, len(items))
+	for _, item := range items {
+		exported[item.Path] = strings.ToUpper(item.FileMd5)
+	}
+	return exported
 }
 
+// isExportedFile 文件是否已在导出文件中. 同一路径的文件内容已改变时仍需导出
+func isExportedFile(exported map[string]string, filePath, fileMd5 string) bool {
+	md5, ok := exported[filePath]
+	return ok && md5 == strings.ToUpper(fileMd5)
+}
+
+func RunExportFiles(panPaths []string, saveLocalFilePath string, opt *ExportOptions) {
+	if opt == nil {
+		opt = &ExportOptions{}
+	}
+	familyId, reportPath, filter := opt.FamilyId, opt.Report, opt.Filter
+	if !opt.Since.IsZero() {
+		filter = applyExportSince(filter, opt.Since)
+		fmt.Printf("只导出 %s 及之后修改的文件\n", opt.Since.Format("2006-01-02 15:04:05"))
+	}
+
+	report, err := functions.NewTransferReport("export", reportPath)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 
-func RunExportFiles(familyId int64, overwrite bool, panPaths []string, saveLocalFilePath string) {
 	activeUser := config.Config.ActiveUser()
 	panClient := activeUser.PanClient()
 
@@ -93,9 +348,9 @@ func RunExportFiles(familyId int64, overwrite bool, panPaths []string, saveLocal
 	realSaveFilePath := saveLocalFilePath
 	if lfi != nil {
 		if lfi.IsDir() {
-			realSaveFilePath = path.Join(saveLocalFilePath, "export_file_") + strconv.FormatInt(time.Now().Unix(), 10) + ".txt"
+			realSaveFilePath = path.Join(saveLocalFilePath, "export_file_") + strconv.FormatInt(time.Now().Unix(), 10) + fileListFormatExt(opt.Format)
 		} else {
-			if !overwrite {
+			if !opt.Overwrite && !opt.Append {
 				fmt.Println("导出文件已存在")
 				return
 			}
@@ -119,37 +374,83 @@ func RunExportFiles(familyId int64, overwrite bool, panPaths []string, saveLocal
 		realSaveFilePath = saveLocalFilePath
 	}
 
-	totalCount := 0
-	saveFile, err := os.OpenFile(realSaveFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	// 追加时, 未指定格式则沿用已有导出文件的格式, 已导出的文件不重复导出
+	format := opt.Format
+	appending := false
+	var exported map[string]string
+	if opt.Append {
+		if data, err := ioutil.ReadFile(realSaveFilePath); err == nil && len(bytes.TrimSpace(data)) > 0 {
+			appending = true
+			if format == "" {
+				format = detectFileListFormat(data)
+			}
+			exported = loadExportedFiles(data)
+		}
+	}
+	if format == "" {
+		format = fileListFormatByExt(realSaveFilePath)
+	}
+	if appending && format == FileListFormatTree {
+		fmt.Println("tree 格式不支持追加导出")
+		return
+	}
+
+	totalCount, skipCount := 0, 0
+	flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flag = os.O_WRONLY | os.O_APPEND
+	}
+	saveFile, err := os.OpenFile(realSaveFilePath, flag, 0755)
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
+	writer, err := newFileListWriter(format, saveFile, appending)
+	if err != nil {
+		fmt.Println(err)
+		saveFile.Close()
+		return
+	}
 
+	limiter := newRequestLimiter(opt.Rate)
 	for _,panPath := range panPaths {
 		panPath = activeUser.PathJoin(familyId, panPath)
-		panClient.AppFilesDirectoriesRecurseList(familyId, panPath, func(depth int, _ string, fd *cloudpan.AppFileEntity, apiError *apierror.ApiError) bool {
+		walkPanFiles(panClient, familyId, panPath, filter, limiter, func(depth int, filePath string, fd *cloudpan.AppFileEntity, apiError *apierror.ApiError) bool {
 			if apiError != nil {
 				logger.Verbosef("%s\n", apiError)
+				report.Add(&functions.TransferReportItem{
+					LocalPath:  realSaveFilePath,
+					RemotePath: filePath,
+					Status:     functions.TransferStatusFailed,
+					Error:      apiError.Error(),
+				})
 				return true
 			}
 
 			// 只需要存储文件即可
 			if !fd.IsFolder {
+				if isExportedFile(exported, fd.Path, fd.FileMd5) {
+					skipCount += 1
+					return true
+				}
 				item := ImportExportFileItem{
 					FileMd5: fd.FileMd5,
 					FileSize: fd.FileSize,
 					Path: fd.Path,
 					LastOpTime: fd.LastOpTime,
 				}
-				jstr,e := json.Marshal(&item)
-				if e != nil {
-					logger.Verboseln("to json string err")
+				if e := writer.Write(&item); e != nil {
+					logger.Verboseln("write export file err: ", e)
 					return false
 				}
-				saveFile.WriteString(string(jstr) + "\n")
+				report.Add(&functions.TransferReportItem{
+					LocalPath:  realSaveFilePath,
+					RemotePath: fd.Path,
+					Size:       fd.FileSize,
+					Status:     functions.TransferStatusExported,
+					MD5:        strings.ToLower(fd.FileMd5),
+				})
 				totalCount += 1
-				time.Sleep(time.Duration(100) * time.Millisecond)
 				fmt.Printf("\r导出文件数量: %d", totalCount)
 			}
 			return true
@@ -157,10 +458,17 @@ func RunExportFiles(familyId int64, overwrite bool, panPaths []string, saveLocal
 	}
 
 	// close and save
+	if err := writer.Close(); err != nil {
+		fmt.Printf("写入导出文件失败: %s\n", err)
+	}
 	if err := saveFile.Close(); err != nil {
 		log.Fatal(err)
 	}
 
 	fmt.Printf("\r导出文件总数量: %d\n", totalCount)
+	if skipCount > 0 {
+		fmt.Printf("跳过已导出的文件: %d\n", skipCount)
+	}
 	fmt.Printf("导出文件保存路径: %s\n", realSaveFilePath)
+	saveTransferReport(os.Stdout, report, reportPath)
 }
@@ -0,0 +1,88 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tickstep/cloudpan189-go/internal/utils"
+)
+
+func TestExportSinceInclusive(t *testing.T) {
+	data := `{"md5":"3F9EEEBC4E583574D9D64A75E5061E56","size":6365224,"path":"/test/a.dmg","lastOpTime":"2021-01-01 12:00:00"}
+{"md5":"D41D8CD98F00B204E9800998ECF8427E","size":0,"path":"/test/b.txt","lastOpTime":"2021-01-02 12:00:00"}
+`
+	exportFile := filepath.Join(t.TempDir(), "export_files.txt")
+	if err := ioutil.WriteFile(exportFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	since, err := parseExportSince(exportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2021, 1, 2, 12, 0, 0, 0, time.Local)
+	if !since.Equal(want) {
+		t.Fatalf("since = %s, want %s", since, want)
+	}
+
+	filter := applyExportSince(nil, since)
+	cases := []struct {
+		modTime  time.Time
+		excluded bool
+	}{
+		{want.Add(-time.Second), true},
+		{want, false}, // 与上一次导出最新的文件同一秒修改
+		{want.Add(time.Second), false},
+	}
+	for _, c := range cases {
+		if got := filter.IsExcluded("/test/c.txt", false, 1, c.modTime); got != c.excluded {
+			t.Errorf("IsExcluded(%s) = %v, want %v", c.modTime, got, c.excluded)
+		}
+	}
+
+	// --newer 更晚时以 --newer 为准
+	newer := want.Add(time.Hour)
+	filter = applyExportSince(&utils.FileFilter{NewerThan: newer}, since)
+	if !filter.NewerThan.Equal(newer) {
+		t.Errorf("NewerThan = %s, want %s", filter.NewerThan, newer)
+	}
+}
+
+func TestIsExportedFile(t *testing.T) {
+	data := `md5,size,path
+3F9EEEBC4E583574D9D64A75E5061E56,6365224,/test/a.dmg
+d41d8cd98f00b204e9800998ecf8427e,0,/test/b.txt
+`
+	exported := loadExportedFiles([]byte(data))
+	cases := []struct {
+		path, md5 string
+		want      bool
+	}{
+		{"/test/a.dmg", "3f9eeebc4e583574d9d64a75e5061e56", true},
+		{"/test/b.txt", "D41D8CD98F00B204E9800998ECF8427E", true},
+		{"/test/a.dmg", "D41D8CD98F00B204E9800998ECF8427E", false}, // 内容已改变
+		{"/test/c.txt", "D41D8CD98F00B204E9800998ECF8427E", false},
+	}
+	for _, c := range cases {
+		if got := isExportedFile(exported, c.path, c.md5); got != c.want {
+			t.Errorf("isExportedFile(%s, %s) = %v, want %v", c.path, c.md5, got, c.want)
+		}
+	}
+	if isExportedFile(nil, "/test/a.dmg", "3F9EEEBC4E583574D9D64A75E5061E56") {
+		t.Error("nothing is exported without an existing export file")
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// fileListWriter 按指定格式写入导出的文件元数据
+	fileListWriter interface {
+		Write(item *ImportExportFileItem) error
+		// Close 写入缓存的数据, 不关闭底层的 io.Writer
+		Close() error
+	}
+
+	jsonLineWriter struct {
+		w io.Writer
+	}
+
+	linkWriter struct {
+		w io.Writer
+	}
+
+	csvWriter struct {
+		w *csv.Writer
+	}
+
+	// treeWriter 整个目录结构在 Close 时一次写入
+	treeWriter struct {
+		w    io.Writer
+		root *fileListTreeDir
+	}
+
+	// fileListTree 带目录结构的文件元数据文档
+	fileListTree struct {
+		Format string           `json:"format"`
+		Root   *fileListTreeDir `json:"root"`
+	}
+
+	fileListTreeDir struct {
+		Name  string              `json:"name"`
+		Dirs  []*fileListTreeDir  `json:"dirs,omitempty"`
+		Files []*fileListTreeFile `json:"files,omitempty"`
+	}
+
+	fileListTreeFile struct {
+		Name       string `json:"name"`
+		FileMd5    string `json:"md5"`
+		FileSize   int64  `json:"size"`
+		LastOpTime string `json:"time,omitempty"`
+	}
+)
+
+const (
+	// FileListFormatJSON 每行一个 ImportExportFileItem JSON 对象, 默认格式
+	FileListFormatJSON = "json"
+	// FileListFormatLink 每行一个秒传链接: md5#大小#路径
+	FileListFormatLink = "link"
+	// FileListFormatCSV 带表头的 CSV: md5,size,path,lastOpTime
+	FileListFormatCSV = "csv"
+	// FileListFormatTree 带目录结构的单个 JSON 文档
+	FileListFormatTree = "tree"
+
+	fileListTreeFormatName = "cloudpan189-tree"
+)
+
+var (
+	fileListFormats = []string{FileListFormatJSON, FileListFormatLink, FileListFormatCSV, FileListFormatTree}
+	fileListCSVHead = []string{"md5", "size", "path", "lastOpTime"}
+
+	md5Pattern = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+)
+
+// checkFileListFormat 检查格式名称, 为空时 allowEmpty 决定是否允许 (导入时自动识别)
+func checkFileListFormat(format string, allowEmpty bool) error {
+	if format == "" && allowEmpty {
+		return nil
+	}
+	for _, f := range fileListFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("不支持的格式: %s, 可选: %s", format, strings.Join(fileListFormats, ", "))
+}
+
+// fileListFormatByExt 根据文件扩展名推测导出格式
+func fileListFormatByExt(filePath string) string {
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".csv":
+		return FileListFormatCSV
+	default:
+		return FileListFormatJSON
+	}
+}
+
+// fileListFormatExt 导出文件默认的扩展名
+func fileListFormatExt(format string) string {
+	switch format {
+	case FileListFormatCSV:
+		return ".csv"
+	case FileListFormatTree:
+		return ".json"
+	default:
+		return ".txt"
+	}
+}
+
+// newFileListWriter 创建指定格式的 fileListWriter, appending 为 true 时追加到已有的内容, 不再写入CSV表头
+func newFileListWriter(format string, w io.Writer, appending bool) (fileListWriter, error) {
+	switch format {
+	case FileListFormatJSON, "":
+		return &jsonLineWriter{w: w}, nil
+	case FileListFormatLink:
+		return &linkWriter{w: w}, nil
+	case FileListFormatCSV:
+		cw := csv.NewWriter(w)
+		if appending {
+			return &csvWriter{w: cw}, nil
+		}
+		if err := cw.Write(fileListCSVHead); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
+	case FileListFormatTree:
+		if appending {
+			return nil, fmt.Errorf("tree 格式不支持追加")
+		}
+		return &treeWriter{w: w, root: &fileListTreeDir{Name: "/"}}, nil
+	}
+	return nil, checkFileListFormat(format, false)
+}
+
+func (jw *jsonLineWriter) Write(item *ImportExportFileItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = jw.w.Write(append(data, '\n'))
+	return err
+}
+
+func (jw *jsonLineWriter) Close() error {
+	return nil
+}
+
+func (lw *linkWriter) Write(item *ImportExportFileItem) error {
+	_, err := fmt.Fprintf(lw.w, "%s#%d#%s\n", strings.ToUpper(item.FileMd5), item.FileSize, item.Path)
+	return err
+}
+
+func (lw *linkWriter) Close() error {
+	return nil
+}
+
+func (cw *csvWriter) Write(item *ImportExportFileItem) error {
+	return cw.w.Write([]string{item.FileMd5, strconv.FormatInt(item.FileSize, 10), item.Path, item.LastOpTime})
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (tw *treeWriter) Write(item *ImportExportFileItem) error {
+	dir := tw.root
+	for _, name := range strings.Split(strings.Trim(path.Dir(path.Clean("/"+item.Path)), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		var sub *fileListTreeDir
+		for _, d := range dir.Dirs {
+			if d.Name == name {
+				sub = d
+				break
+			}
+		}
+		if sub == nil {
+			sub = &fileListTreeDir{Name: name}
+			dir.Dirs = append(dir.Dirs, sub)
+		}
+		dir = sub
+	}
+	dir.Files = append(dir.Files, &fileListTreeFile{
+		Name:       path.Base(item.Path),
+		FileMd5:    item.FileMd5,
+		FileSize:   item.FileSize,
+		LastOpTime: item.LastOpTime,
+	})
+	return nil
+}
+
+func (tw *treeWriter) Close() error {
+	sortFileListTree(tw.root)
+	data, err := json.MarshalIndent(&fileListTree{Format: fileListTreeFormatName, Root: tw.root}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = tw.w.Write(append(data, '\n'))
+	return err
+}
+
+func sortFileListTree(dir *fileListTreeDir) {
+	sort.Slice(dir.Dirs, func(i, j int) bool { return dir.Dirs[i].Name < dir.Dirs[j].Name })
+	sort.Slice(dir.Files, func(i, j int) bool { return dir.Files[i].Name < dir.Files[j].Name })
+	for _, d := range dir.Dirs {
+		sortFileListTree(d)
+	}
+}
+
+// detectFileListFormat 根据内容识别导入文件的格式
+func detectFileListFormat(data []byte) string {
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	firstLine := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		firstLine = bytes.TrimSpace(data[:i])
+	}
+	if bytes.HasPrefix(data, []byte("{")) {
+		item := map[string]interface{}{}
+		if json.Unmarshal(firstLine, &item) == nil {
+			if _, ok := item["md5"]; ok {
+				return FileListFormatJSON
+			}
+		}
+		return FileListFormatTree
+	}
+	head := map[string]bool{}
+	for _, name := range strings.Split(strings.ToLower(string(firstLine)), ",") {
+		head[strings.Trim(strings.TrimSpace(name), `"`)] = true
+	}
+	if head["md5"] && head["size"] && head["path"] {
+		return FileListFormatCSV
+	}
+	return FileListFormatLink
+}
+
+// parseFileList 解析导入文件, format 为空时自动识别格式. 无法解析的行通过 badLine 返回, 不中断解析
+func parseFileList(format string, data []byte, badLine func(line string)) (items []ImportExportFileItem, detected string, err error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if format == "" {
+		format = detectFileListFormat(data)
+	}
+	switch format {
+	case FileListFormatJSON:
+		items = parseFileListLines(data, badLine, func(line string) (*ImportExportFileItem, error) {
+			item := &ImportExportFileItem{}
+			return item, json.Unmarshal([]byte(line), item)
+		})
+	case FileListFormatLink:
+		items = parseFileListLines(data, badLine, parseRapidUploadLink)
+	case FileListFormatCSV:
+		items, err = parseFileListCSV(data, badLine)
+	case FileListFormatTree:
+		items, err = parseFileListTree(data)
+	default:
+		err = checkFileListFormat(format, false)
+	}
+	return items, format, err
+}
+
+func parseFileListLines(data []byte, badLine func(line string), parse func(line string) (*ImportExportFileItem, error)) (items []ImportExportFileItem) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		item, err := parse(line)
+		if err != nil || item.Path == "" {
+			if badLine != nil {
+				badLine(line)
+			}
+			continue
+		}
+		items = append(items, *item)
+	}
+	return
+}
+
+// parseRapidUploadLink 解析秒传链接, 支持 md5#大小#路径 和 md5#分片md5#大小#路径 两种格式
+func parseRapidUploadLink(line string) (*ImportExportFileItem, error) {
+	fields := strings.SplitN(line, "#", 4)
+	if len(fields) == 4 && md5Pattern.MatchString(fields[1]) {
+		// 分片md5天翼云盘用不到, 丢弃
+		fields = []string{fields[0], fields[2], fields[3]}
+	} else {
+		fields = strings.SplitN(line, "#", 3)
+	}
+	if len(fields) != 3 || !md5Pattern.MatchString(fields[0]) {
+		return nil, fmt.Errorf("秒传链接格式错误")
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("秒传链接文件大小错误")
+	}
+	return &ImportExportFileItem{
+		FileMd5:  strings.ToUpper(fields[0]),
+		FileSize: size,
+		Path:     strings.TrimSpace(fields[2]),
+	}, nil
+}
+
+func parseFileListCSV(data []byte, badLine func(line string)) (items []ImportExportFileItem, err error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	// 根据表头确定列的位置, 支持调整列的顺序
+	index := map[string]int{}
+	for i, name := range records[0] {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range fileListCSVHead[:3] {
+		if _, ok := index[strings.ToLower(name)]; !ok {
+			return nil, fmt.Errorf("CSV表头缺少 %s 列", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := index[strings.ToLower(name)]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	for _, record := range records[1:] {
+		size, err := strconv.ParseInt(field(record, "size"), 10, 64)
+		item := ImportExportFileItem{
+			FileMd5:    field(record, "md5"),
+			FileSize:   size,
+			Path:       field(record, "path"),
+			LastOpTime: field(record, "lastOpTime"),
+		}
+		if err != nil || item.Path == "" || !md5Pattern.MatchString(item.FileMd5) {
+			if badLine != nil {
+				badLine(strings.Join(record, ","))
+			}
+			continue
+		}
+		items = append(items, item)
+	}
+	return
+}
+
+func parseFileListTree(data []byte) (items []ImportExportFileItem, err error) {
+	tree := &fileListTree{}
+	if err = json.Unmarshal(data, tree); err != nil {
+		return nil, fmt.Errorf("解析目录结构文档失败: %s", err)
+	}
+	if tree.Root == nil {
+		return nil, fmt.Errorf("目录结构文档缺少 root")
+	}
+	var walk func(dir *fileListTreeDir, dirPath string)
+	walk = func(dir *fileListTreeDir, dirPath string) {
+		for _, f := range dir.Files {
+			items = append(items, ImportExportFileItem{
+				FileMd5:    f.FileMd5,
+				FileSize:   f.FileSize,
+				Path:       path.Join(dirPath, f.Name),
+				LastOpTime: f.LastOpTime,
+			})
+		}
+		for _, d := range dir.Dirs {
+			walk(d, path.Join(dirPath, d.Name))
+		}
+	}
+	walk(tree.Root, "/")
+	return
+}
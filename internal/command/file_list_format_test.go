@@ -0,0 +1,107 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFileListFormatRoundTrip(t *testing.T) {
+	items := []ImportExportFileItem{
+		{FileMd5: "3F9EEEBC4E583574D9D64A75E5061E56", FileSize: 6365224, Path: "/test/a#b.dmg", LastOpTime: "2021-01-01 12:00:00"},
+		{FileMd5: "D41D8CD98F00B204E9800998ECF8427E", FileSize: 0, Path: "/test/sub/空文件.txt", LastOpTime: "2021-01-02 12:00:00"},
+		{FileMd5: "0CC175B9C0F1B6A831C399E269772661", FileSize: 1, Path: "/root.txt", LastOpTime: "2021-01-03 12:00:00"},
+	}
+	for _, format := range fileListFormats {
+		buf := &bytes.Buffer{}
+		w, err := newFileListWriter(format, buf, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k := range items {
+			if err = w.Write(&items[k]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if detected := detectFileListFormat(buf.Bytes()); detected != format {
+			t.Errorf("%s: detected as %s", format, detected)
+		}
+		got, _, err := parseFileList("", buf.Bytes(), func(line string) {
+			t.Errorf("%s: bad line %s", format, line)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := items
+		if format == FileListFormatLink {
+			// 秒传链接不包含修改时间
+			want = make([]ImportExportFileItem, len(items))
+			for k, item := range items {
+				item.LastOpTime = ""
+				want[k] = item
+			}
+		}
+		if format == FileListFormatTree {
+			// 按目录结构输出, 先文件后子目录
+			want = []ImportExportFileItem{want[2], want[0], want[1]}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", format, got, want)
+		}
+	}
+}
+
+func TestParseRapidUploadLink(t *testing.T) {
+	data := []byte("3f9eeebc4e583574d9d64a75e5061e56#6365224#file.dmg\n" +
+		"3F9EEEBC4E583574D9D64A75E5061E56#0CC175B9C0F1B6A831C399E269772661#100#/dir/file 2.dmg\n" +
+		"bad#line\n")
+	var bad []string
+	items, format, err := parseFileList("", data, func(line string) {
+		bad = append(bad, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != FileListFormatLink {
+		t.Errorf("format = %s", format)
+	}
+	want := []ImportExportFileItem{
+		{FileMd5: "3F9EEEBC4E583574D9D64A75E5061E56", FileSize: 6365224, Path: "file.dmg"},
+		{FileMd5: "3F9EEEBC4E583574D9D64A75E5061E56", FileSize: 100, Path: "/dir/file 2.dmg"},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("got %+v, want %+v", items, want)
+	}
+	if len(bad) != 1 {
+		t.Errorf("bad lines = %v", bad)
+	}
+}
+
+func TestParseFileListCSVColumnOrder(t *testing.T) {
+	data := []byte("\xef\xbb\xbfpath,size,md5\n/a.txt,1,0CC175B9C0F1B6A831C399E269772661\n")
+	items, format, err := parseFileList("", data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != FileListFormatCSV || len(items) != 1 || items[0].Path != "/a.txt" || items[0].FileSize != 1 {
+		t.Errorf("got %s %+v", format, items)
+	}
+}
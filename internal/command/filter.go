@@ -0,0 +1,110 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tickstep/cloudpan189-go/internal/utils"
+	"github.com/tickstep/library-go/converter"
+	"github.com/urfave/cli"
+)
+
+// fileFilterFlags 上传, 备份, 下载和导出共用的过滤参数
+var fileFilterFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name:  "include",
+		Usage: "只传输名称匹配的文件, 只支持正则表达式, 不限制文件夹。支持指定多个, 每一个名称就是一个include参数",
+	},
+	cli.StringFlag{
+		Name:  "min-size",
+		Usage: "只传输不小于指定大小的文件, 例如: 1MB",
+	},
+	cli.StringFlag{
+		Name:  "max-size",
+		Usage: "只传输不大于指定大小的文件, 例如: 2GB",
+	},
+	cli.StringFlag{
+		Name:  "newer-than",
+		Usage: "只传输修改时间晚于指定时间的文件, 支持时长(例如: 12h, 7d)或日期(例如: 2021-01-01, \"2021-01-01 12:00:00\")",
+	},
+	cli.StringFlag{
+		Name:  "older-than",
+		Usage: "只传输修改时间早于指定时间的文件, 格式同 newer-than",
+	},
+	cli.StringFlag{
+		Name:  "ignore-file",
+		Usage: "读取本地的忽略文件, gitignore 语法, 规则相对于传输的根目录",
+	},
+	cli.BoolFlag{
+		Name:  "no-ignore",
+		Usage: "不读取每一级目录中的 " + utils.DefaultIgnoreFileName + " 忽略文件",
+	},
+}
+
+// parseFilterTime 解析时长或日期, 时长表示距离现在的时间
+func parseFilterTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	d, err := parseDuration(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(-d), nil
+}
+
+// parseFileFilter 根据命令参数生成文件过滤条件
+func parseFileFilter(c *cli.Context) (*utils.FileFilter, error) {
+	filter := &utils.FileFilter{
+		ExcludeNames: c.StringSlice("exn"),
+		IncludeNames: c.StringSlice("include"),
+	}
+	if !c.Bool("no-ignore") {
+		filter.IgnoreFileName = utils.DefaultIgnoreFileName
+	}
+
+	var err error
+	if s := c.String("min-size"); s != "" {
+		if filter.MinSize, err = converter.ParseFileSizeStr(s); err != nil {
+			return nil, fmt.Errorf("min-size 格式错误: %s", s)
+		}
+	}
+	if s := c.String("max-size"); s != "" {
+		if filter.MaxSize, err = converter.ParseFileSizeStr(s); err != nil {
+			return nil, fmt.Errorf("max-size 格式错误: %s", s)
+		}
+	}
+	if s := c.String("newer-than"); s != "" {
+		if filter.NewerThan, err = parseFilterTime(s); err != nil {
+			return nil, err
+		}
+	}
+	if s := c.String("older-than"); s != "" {
+		if filter.OlderThan, err = parseFilterTime(s); err != nil {
+			return nil, err
+		}
+	}
+	if s := c.String("ignore-file"); s != "" {
+		if err = filter.SetIgnoreFile(s); err != nil {
+			return nil, fmt.Errorf("读取忽略文件失败: %s", err)
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+	"github.com/tickstep/library-go/converter"
+	"github.com/urfave/cli"
+)
+
+func CmdCache() cli.Command {
+	return cli.Command{
+		Name:  "cache",
+		Usage: "本地文件摘要缓存",
+		Description: `
+	上传文件时, 会缓存本地文件的md5值, 文件未修改时不再重新读取文件计算md5.
+	缓存以文件所在设备, inode, 文件大小和修改时间作为键, 文件修改后缓存自动失效.
+
+	示例:
+
+	1. 查看缓存统计
+	cloudpan189-go cache stats
+
+	2. 清空缓存
+	cloudpan189-go cache clear
+
+	3. 只清除本地文件已删除或已修改的缓存
+	cloudpan189-go cache clear -stale
+`,
+		Category: "其他",
+		Action: func(c *cli.Context) error {
+			cli.ShowCommandHelp(c, c.Command.Name)
+			return nil
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:      "stats",
+				Usage:     "查看缓存统计",
+				UsageText: cmder.App().Name + " cache stats",
+				Action: func(c *cli.Context) error {
+					RunHashCacheStats()
+					return nil
+				},
+			},
+			{
+				Name:      "clear",
+				Usage:     "清空缓存",
+				UsageText: cmder.App().Name + " cache clear [-stale]",
+				Action: func(c *cli.Context) error {
+					RunHashCacheClear(c.Bool("stale"))
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "stale",
+						Usage: "只清除本地文件已删除或已修改的缓存",
+					},
+				},
+			},
+		},
+	}
+}
+
+// openHashCache 打开配置目录下的文件摘要缓存
+func openHashCache() (*localfile.HashCache, error) {
+	return localfile.OpenHashCache(filepath.Join(config.GetConfigDir(), localfile.HashCacheFileName))
+}
+
+// RunHashCacheStats 输出缓存统计
+func RunHashCacheStats() {
+	hashCache, err := openHashCache()
+	if err != nil {
+		fmt.Printf("打开文件摘要缓存失败: %s\n", err)
+		return
+	}
+	defer hashCache.Close()
+
+	stats, err := hashCache.Stats()
+	if err != nil {
+		fmt.Printf("读取文件摘要缓存失败: %s\n", err)
+		return
+	}
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"名称", "值"})
+	tb.Append([]string{"缓存文件", stats.Path})
+	tb.Append([]string{"缓存数量", strconv.Itoa(stats.Entries)})
+	tb.Append([]string{"缓存大小", converter.ConvertFileSize(stats.FileSize, 2)})
+	tb.Render()
+}
+
+// RunHashCacheClear 清空缓存, onlyStale 为 true 时只清除失效的缓存
+func RunHashCacheClear(onlyStale bool) {
+	hashCache, err := openHashCache()
+	if err != nil {
+		fmt.Printf("打开文件摘要缓存失败: %s\n", err)
+		return
+	}
+	defer hashCache.Close()
+
+	if onlyStale {
+		count, err := hashCache.ClearStale()
+		if err != nil {
+			fmt.Printf("清除失效缓存失败: %s\n", err)
+			return
+		}
+		fmt.Printf("已清除 %d 条失效缓存\n", count)
+		return
+	}
+
+	if err = hashCache.Clear(); err != nil {
+		fmt.Printf("清空缓存失败: %s\n", err)
+		return
+	}
+	fmt.Println("已清空缓存")
+}
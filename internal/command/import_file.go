@@ -14,12 +14,14 @@
 package command
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/tickstep/cloudpan189-api/cloudpan"
 	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
 	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdutil"
 	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/taskframework"
 	"github.com/tickstep/library-go/logger"
 	"github.com/urfave/cli"
 	"io/ioutil"
@@ -32,6 +34,21 @@ import (
 )
 
 type (
+	// ImportOptions 导入可选项
+	ImportOptions struct {
+		FamilyId  int64
+		Overwrite bool   // 覆盖已存在的网盘文件
+		SaveTo    string // 网盘保存目录
+		Format    string // 导入文件的格式, 为空时自动识别
+		Report    string // 传输报告保存路径, 支持 .json 和 .csv
+		Parallel  int    // 同时导入的文件数量
+		MaxRetry  int
+		Resume    bool    // 跳过导入进度中已导入的文件
+		Rate      float64 // 每秒最多导入的文件数量, 0为不限制
+
+		UploadMissing string // 本地目录, 无法秒传的文件从该目录上传, 为空则不上传
+	}
+
 	dirFileListData struct {
 		Dir *cloudpan.AppMkdirResult
 		File *cloudpan.AppFileListResult
@@ -40,6 +57,13 @@ type (
 
 const (
 	DefaultSaveToPanPath = "/cloudpan189-go"
+
+	// DefaultImportParallel 默认同时导入的文件数量
+	DefaultImportParallel = 3
+	// DefaultImportMaxRetry 导入失败默认最大重试次数
+	DefaultImportMaxRetry = 2
+	// DefaultImportRate 默认每秒最多导入的文件数量
+	DefaultImportRate = 5
 )
 
 func CmdImport() cli.Command {
@@ -53,6 +77,11 @@ func CmdImport() cli.Command {
     
     导入文件每一行是一个文件元数据，样例如下：
     {"md5":"3F9EEEBC4E583574D9D64A75E5061E56","size":6365224,"path":"/test/file.dmg"}
+
+    同时支持以下格式, 默认根据文件内容自动识别, 也可通过 --format 指定:
+    link: 每行一个秒传链接, md5#大小#路径 或 md5#分片md5#大小#路径, 例如 3F9EEEBC4E583574D9D64A75E5061E56#6365224#/test/file.dmg
+    csv:  第一行为表头 md5,size,path[,lastOpTime], 列的顺序可以调整
+    tree: export --format tree 导出的带目录结构的JSON文档
     
     注意：导入文件依赖秒传功能，即会消耗你每日上传文件的限额，如果你导入的文件过多达到每日限额，则剩余的文件无法在当日完成导入。
     
@@ -66,11 +95,31 @@ func CmdImport() cli.Command {
 
     导入文件 /Users/tickstep/Downloads/export_files.txt 并保存到网盘根目录 / 中
     cloudpan189-go import -saveto=/ /Users/tickstep/Downloads/export_files.txt
+
+    导入秒传链接文件 /Users/tickstep/Downloads/links.txt
+    cloudpan189-go import --format link /Users/tickstep/Downloads/links.txt
+
+    只重新导入上一次导入失败的文件, 失败列表文件的路径在导入结束时输出
+    cloudpan189-go import --retry-failed ~/.cloud189/cloud189_failed/import_20210101_120000.json
+
+    同时导入5个文件, 导入中断后使用 --resume 继续, 跳过已导入的文件
+    cloudpan189-go import -p 5 /Users/tickstep/Downloads/export_files.txt
+    cloudpan189-go import -p 5 --resume /Users/tickstep/Downloads/export_files.txt
+
+    每秒最多导入2个文件, 避免请求过快被服务器限制
+    cloudpan189-go import --rate 2 /Users/tickstep/Downloads/export_files.txt
+
+    根据本地目录 D:/照片 生成导入文件后秒传导入, 无法秒传的文件从本地上传, 参考 manifest help
+    cloudpan189-go manifest D:/照片 D:/照片.txt
+    cloudpan189-go import -saveto=/备份 --upload-missing D:/ D:/照片.txt
+
+    导入进度保存在配置目录的 ` + functions.ImportCheckpointDirName + ` 中, 全部导入成功后自动删除.
+    导入结束时按结果分类统计: 秒传成功, 已存在相同文件, 服务器上没有该文件 (无法秒传), 同名文件冲突 (可使用 -ow 覆盖) 和失败.
 `,
 		Category: "天翼云盘",
 		Before:   cmder.ReloadConfigFunc,
 		Action: func(c *cli.Context) error {
-			if c.NArg() < 1 {
+			if c.NArg() < 1 && !c.IsSet("retry-failed") {
 				cli.ShowCommandHelp(c, c.Command.Name)
 				return nil
 			}
@@ -80,8 +129,36 @@ func CmdImport() cli.Command {
 				saveTo = filepath.Clean(c.String("saveto"))
 			}
 
+			opt := &ImportOptions{
+				FamilyId:  parseFamilyId(c),
+				Overwrite: c.Bool("ow"),
+				SaveTo:    saveTo,
+				Format:    c.String("format"),
+				Report:    c.String("report"),
+				Parallel:  c.Int("p"),
+				MaxRetry:  c.Int("retry"),
+				Resume:    c.Bool("resume"),
+				Rate:      c.Float64("rate"),
+
+				UploadMissing: c.String("upload-missing"),
+			}
+			if opt.UploadMissing != "" {
+				if fi, err := os.Stat(opt.UploadMissing); err != nil || !fi.IsDir() {
+					fmt.Printf("本地目录不存在: %s\n", opt.UploadMissing)
+					return nil
+				}
+			}
+			if c.IsSet("retry-failed") {
+				RunImportRetryFailed(c.String("retry-failed"), opt)
+				return nil
+			}
+
+			if err := checkFileListFormat(opt.Format, true); err != nil {
+				fmt.Println(err)
+				return nil
+			}
 			subArgs := c.Args()
-			RunImportFiles(parseFamilyId(c), c.Bool("ow"), saveTo, subArgs[0])
+			RunImportFiles(subArgs[0], opt)
 			return nil
 		},
 		Flags: []cli.Flag{
@@ -98,11 +175,53 @@ func CmdImport() cli.Command {
 				Name:  "saveto",
 				Usage: "将文件保存到指定的目录",
 			},
+			cli.StringFlag{
+				Name:  "report",
+				Usage: "导入结束后将每个文件的导入结果保存到指定文件, 支持 .json 和 .csv 格式",
+			},
+			cli.StringFlag{
+				Name:  "format",
+				Usage: "导入文件的格式: json, link, csv, tree, 参考 export --format. 默认根据文件内容自动识别",
+			},
+			cli.IntFlag{
+				Name:  "p",
+				Usage: "同时导入的文件数量",
+				Value: DefaultImportParallel,
+			},
+			cli.IntFlag{
+				Name:  "retry",
+				Usage: "导入失败最大重试次数",
+				Value: DefaultImportMaxRetry,
+			},
+			cli.Float64Flag{
+				Name:  "rate",
+				Usage: "每秒最多导入的文件数量, 0为不限制",
+				Value: DefaultImportRate,
+			},
+			cli.BoolFlag{
+				Name:  "resume",
+				Usage: "继续上一次中断的导入, 跳过已导入的文件",
+			},
+			cli.StringFlag{
+				Name:  "upload-missing",
+				Usage: "服务器上没有的文件无法秒传, 从指定的本地目录上传, 本地路径为该目录加上导入文件中记录的路径, 参考 manifest",
+			},
+			cli.StringFlag{
+				Name:  "retry-failed",
+				Usage: "只重新导入失败列表文件中的文件, 导入失败时会自动生成失败列表文件",
+			},
 		},
 	}
 }
 
-func RunImportFiles(familyId int64, overwrite bool, panSavePath, localFilePath string) {
+func RunImportFiles(localFilePath string, opt *ImportOptions) {
+	panSavePath, reportPath := opt.SaveTo, opt.Report
+	report, err := functions.NewTransferReport("import", reportPath)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
 	lfi,_ := os.Stat(localFilePath)
 	if lfi != nil {
 		if lfi.IsDir() {
@@ -134,108 +253,263 @@ func RunImportFiles(familyId int64, overwrite bool, panSavePath, localFilePath s
 		fmt.Println("读取文件出错")
 		return
 	}
-	fileText := string(fileData)
-	if len(fileText) == 0 {
+	if len(strings.TrimSpace(string(fileData))) == 0 {
 		fmt.Println("文件为空")
 		return
 	}
-	fileText = strings.TrimSpace(fileText)
-	fileLines := strings.Split(fileText, "\n")
-	importFileItems := []ImportExportFileItem{}
-	for _,line := range fileLines {
-		line = strings.TrimSpace(line)
-		item := &ImportExportFileItem{}
-		if err := json.Unmarshal([]byte(line), item); err != nil {
-			logger.Verboseln("parse line failed: " + line)
-			fmt.Println("Error Data: " + line)
-			continue
-		}
-		item.Path = path.Join(panSavePath, item.Path)
-		importFileItems = append(importFileItems, *item)
+	importFileItems, format, err := parseFileList(opt.Format, fileData, func(line string) {
+		logger.Verboseln("parse line failed: " + line)
+		fmt.Println("Error Data: " + line)
+	})
+	if err != nil {
+		fmt.Printf("解析导入文件出错: %s\n", err)
+		return
+	}
+	logger.Verbosef("导入文件格式: %s\n", format)
+	for k := range importFileItems {
+		importFileItems[k].Path = path.Join(panSavePath, importFileItems[k].Path)
 	}
 	if len(importFileItems) == 0 {
 		fmt.Println("没有可以导入的文件项目")
 		return
 	}
 
+	header := &functions.ImportCheckpointHeader{
+		Source:        localFilePath,
+		SourceSize:    lfi.Size(),
+		SourceModTime: lfi.ModTime().Unix(),
+		SaveTo:        panSavePath,
+		FamilyId:      opt.FamilyId,
+	}
+	if absPath, err := filepath.Abs(localFilePath); err == nil {
+		header.Source = absPath
+	}
+	checkpoint, err := functions.OpenImportCheckpoint(header, opt.Resume)
+	if err != nil {
+		fmt.Printf("打开导入进度文件错误: %s\n", err)
+		return
+	}
+
+	stat := runImportItems(opt, importFileItems, localFilePath, report, checkpoint)
+	// 全部导入成功后不再需要导入进度
+	allDone := stat.Done() == len(importFileItems)
+	checkpoint.Close(allDone)
+	if !allDone {
+		fmt.Printf("导入进度已保存, 可使用 --resume 继续导入, 跳过已导入的文件\n")
+	}
+	saveTransferReport(os.Stdout, report, reportPath)
+}
+
+// RunImportRetryFailed 只重新导入失败列表中的文件
+func RunImportRetryFailed(failedFile string, opt *ImportOptions) {
+	reportPath := opt.Report
+	report, err := functions.NewTransferReport("import", reportPath)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fl, err := functions.LoadFailedList(failedFile, "import")
+	if err != nil {
+		fmt.Printf("读取失败列表错误: %s\n", err)
+		return
+	}
+	if fl.FamilyId != 0 {
+		retryOpt := *opt
+		retryOpt.FamilyId = fl.FamilyId
+		opt = &retryOpt
+	}
+
+	importFileItems := []ImportExportFileItem{}
+	for _, item := range fl.Items {
+		importFileItems = append(importFileItems, ImportExportFileItem{
+			FileMd5:    item.MD5,
+			FileSize:   item.Size,
+			Path:       item.RemotePath,
+			LastOpTime: item.LastOpTime,
+		})
+	}
+	if len(importFileItems) == 0 {
+		fmt.Println("失败列表中没有文件")
+		return
+	}
+	runImportItems(opt, importFileItems, failedFile, report, nil)
+	saveTransferReport(os.Stdout, report, reportPath)
+}
+
+// runImportItems 导入文件, 跳过导入进度中已导入的文件, 失败和未处理的文件保存到失败列表
+func runImportItems(opt *ImportOptions, importFileItems []ImportExportFileItem, localFilePath string, report *functions.TransferReport, checkpoint *functions.ImportCheckpoint) *importStatistic {
+	stat := &importStatistic{}
+	pendingItems := make([]ImportExportFileItem, 0, len(importFileItems))
+	for _, item := range importFileItems {
+		if checkpoint.IsDone(item.Path, item.FileMd5) {
+			stat.Add(importOutcomeDone)
+			continue
+		}
+		pendingItems = append(pendingItems, item)
+	}
+	if n := stat.Count(importOutcomeDone); n > 0 {
+		fmt.Printf("跳过已导入的文件 %d 个\n", n)
+	}
+
 	fmt.Println("正在准备导入...")
-	dirMap := prepareMkdir(familyId, importFileItems)
+	dirMap := prepareMkdir(opt.FamilyId, pendingItems)
 
 	fmt.Println("正在导入...")
-	successImportFiles := []ImportExportFileItem{}
-	failedImportFiles := []ImportExportFileItem{}
-	for _,item := range importFileItems {
-		fmt.Printf("正在处理导入: %s\n", item.Path)
-		result, abort := processOneImport(familyId, overwrite, dirMap, item)
-		if abort {
-			fmt.Println("导入任务终止了")
-			break
+	executor := &taskframework.TaskExecutor{}
+	executor.SetParallel(opt.Parallel)
+	limiter := newRequestLimiter(opt.Rate)
+	units := make([]*importTaskUnit, 0, len(pendingItems))
+	for _, item := range pendingItems {
+		unit := &importTaskUnit{
+			Item:        item,
+			FamilyId:    opt.FamilyId,
+			IsOverwrite: opt.Overwrite,
+			DirMap:      dirMap,
+			LocalPath:   localFilePath,
+			Report:      report,
+			Checkpoint:  checkpoint,
+			Statistic:   stat,
+			Limiter:     limiter,
+			Abort:       executor.Stop,
 		}
-		if result {
-			successImportFiles = append(successImportFiles, item)
-		} else {
-			failedImportFiles = append(failedImportFiles, item)
+		executor.Append(unit, opt.MaxRetry)
+		units = append(units, unit)
+	}
+	executor.Execute()
+
+	failedList := functions.NewFailedList("import", opt.FamilyId)
+	aborted := false
+	var missingItems []ImportExportFileItem
+	for _, unit := range units {
+		switch unit.outcome {
+		case importOutcomeRapidUploaded, importOutcomeExists:
+			continue
+		case importOutcomeMissing:
+			if opt.UploadMissing != "" {
+				// 上传失败的文件由上传记录到失败列表
+				missingItems = append(missingItems, unit.Item)
+				continue
+			}
+		case "":
+			// 导入终止, 未处理的文件
+			aborted = true
+			stat.Add(importOutcomeUnprocessed)
 		}
-		time.Sleep(time.Duration(200) * time.Millisecond)
+		failedList.Add(&functions.FailedItem{
+			RemotePath: unit.Item.Path,
+			Size:       unit.Item.FileSize,
+			MD5:        unit.Item.FileMd5,
+			LastOpTime: unit.Item.LastOpTime,
+		})
+	}
+	if aborted {
+		fmt.Println("导入任务终止了")
+	}
+	stat.Print()
+	saveFailedList(os.Stdout, failedList)
+
+	if len(missingItems) > 0 {
+		uploadMissingItems(opt, missingItems)
 	}
-	if len(failedImportFiles) > 0 {
-		fmt.Println("\n以下文件导入失败")
-		for _,f := range failedImportFiles {
-			fmt.Printf("%s %s\n", f.FileMd5, f.Path)
+	return stat
+}
+
+// uploadMissingItems 从本地上传服务器上没有的文件, 本地路径为 UploadMissing 目录加上导入文件中记录的路径
+func uploadMissingItems(opt *ImportOptions, items []ImportExportFileItem) {
+	panSavePath := opt.SaveTo
+	if panSavePath == "" {
+		panSavePath = DefaultSaveToPanPath
+	}
+	panSavePath = path.Clean(cmdutil.ConvertToUnixPathSeparator(panSavePath))
+
+	var sources []uploadSource
+	for _, item := range items {
+		relPath := strings.TrimPrefix(item.Path, strings.TrimSuffix(panSavePath, "/"))
+		localPath := filepath.Join(opt.UploadMissing, filepath.FromSlash(relPath))
+		fi, err := os.Stat(localPath)
+		if err != nil || fi.IsDir() {
+			fmt.Printf("本地文件不存在, 无法上传: %s\n", localPath)
+			continue
+		}
+		if fi.Size() != item.FileSize {
+			fmt.Printf("本地文件大小与导入文件记录的不一致, 跳过: %s\n", localPath)
+			continue
 		}
-		fmt.Println("")
+		sources = append(sources, uploadSource{
+			LocalPath: localPath,
+			SaveDir:   GetActiveUser().PathJoin(opt.FamilyId, path.Dir(item.Path)),
+		})
+	}
+	if len(sources) == 0 {
+		return
 	}
-	fmt.Printf("导入结果, 成功 %d, 失败 %d\n", len(successImportFiles), len(failedImportFiles))
+
+	// 所有文件在一次上传中并发执行
+	fmt.Printf("正在从本地上传无法秒传的文件...\n")
+	runUpload(sources, &UploadOptions{
+		Parallel:      1,
+		MaxRetry:      DefaultUploadMaxRetry,
+		NoRapidUpload: true, // 已确认无法秒传
+		NoSplitFile:   true,
+		ShowProgress:  true,
+		IsOverwrite:   opt.Overwrite,
+		FamilyId:      opt.FamilyId,
+	})
 }
 
-func processOneImport(familyId int64, isOverwrite bool, dirMap map[string]*dirFileListData, item ImportExportFileItem) (result, abort bool) {
+// processOneImport 秒传导入一个文件, abort 为 true 表示无法继续导入其他文件
+func processOneImport(familyId int64, isOverwrite bool, dirMap map[string]*dirFileListData, item ImportExportFileItem) (outcome importOutcome, abort bool, err error) {
 	panClient := config.Config.ActiveUser().PanClient()
 	panDir,fileName := path.Split(item.Path)
 	dataItem := dirMap[path.Dir(panDir)]
-	if isOverwrite {
+	if dataItem == nil {
+		return importOutcomeFailed, false, fmt.Errorf("创建云盘文件夹失败")
+	}
+
+	// 检查同名文件是否存在
+	var efi *cloudpan.AppFileEntity = nil
+	for _,fileItem := range dataItem.File.FileList {
+		if fileItem.FileName == fileName {
+			efi = fileItem
+			break
+		}
+	}
+	if efi != nil && efi.FileId != "" {
+		if !efi.IsFolder && strings.EqualFold(efi.FileMd5, item.FileMd5) {
+			return importOutcomeExists, false, nil
+		}
+		if !isOverwrite || efi.IsFolder {
+			return importOutcomeConflict, false, fmt.Errorf("同名文件已存在")
+		}
+
 		// 标记覆盖旧同名文件
-		// 检查同名文件是否存在
-		var efi *cloudpan.AppFileEntity = nil
-		for _,fileItem := range dataItem.File.FileList {
-			if !fileItem.IsFolder && fileItem.FileName == fileName {
-				efi = fileItem
-				break
-			}
+		// existed, delete it
+		infoList := cloudpan.BatchTaskInfoList{}
+		infoItem := &cloudpan.BatchTaskInfo{
+			FileId:      efi.FileId,
+			FileName:    efi.FileName,
+			IsFolder:    0,
+			SrcParentId: efi.ParentId,
+		}
+		infoList = append(infoList, infoItem)
+		delParam := &cloudpan.BatchTaskParam{
+			TypeFlag:  cloudpan.BatchTaskTypeDelete,
+			TaskInfos: infoList,
 		}
-		if efi != nil && efi.FileId != "" {
-			// existed, delete it
-			infoList := cloudpan.BatchTaskInfoList{}
-			isFolder := 0
-			if efi.IsFolder {
-				isFolder = 1
-			}
-			infoItem := &cloudpan.BatchTaskInfo{
-				FileId: efi.FileId,
-				FileName: efi.FileName,
-				IsFolder: isFolder,
-				SrcParentId: efi.ParentId,
-			}
-			infoList = append(infoList, infoItem)
-			delParam := &cloudpan.BatchTaskParam{
-				TypeFlag: cloudpan.BatchTaskTypeDelete,
-				TaskInfos: infoList,
-			}
 
-			var taskId string
-			var err *apierror.ApiError
-			if familyId > 0 {
-				taskId, err = panClient.AppCreateBatchTask(familyId, delParam)
-			} else {
-				taskId, err = panClient.CreateBatchTask(delParam)
-			}
+		var taskId string
+		var apierr *apierror.ApiError
+		if familyId > 0 {
+			taskId, apierr = panClient.AppCreateBatchTask(familyId, delParam)
+		} else {
+			taskId, apierr = panClient.CreateBatchTask(delParam)
+		}
 
-			if err != nil || taskId == "" {
-				fmt.Println("无法删除文件，请稍后重试")
-				return false, false
-			}
-			time.Sleep(time.Duration(500) * time.Millisecond)
-			fmt.Println("检测到同名文件，已移动到回收站")
+		if apierr != nil || taskId == "" {
+			return importOutcomeFailed, false, fmt.Errorf("无法删除文件，请稍后重试")
 		}
+		time.Sleep(time.Duration(500) * time.Millisecond)
+		logger.Verbosef("检测到同名文件，已移动到回收站: %s\n", item.Path)
 	}
 
 	var r *cloudpan.AppCreateUploadFileResult
@@ -259,8 +533,13 @@ func processOneImport(familyId int64, isOverwrite bool, dirMap map[string]*dirFi
 		r, apierr = panClient.AppCreateUploadFile(appCreateUploadFileParam)
 	}
 	if apierr != nil {
-		fmt.Println("创建上传任务失败：", apierr.Error())
-		return false, true
+		switch apierr.Code {
+		case apierror.ApiCodeUserDayFlowOverLimited:
+			return importOutcomeFailed, true, fmt.Errorf("创建上传任务失败：%s", apierr)
+		case apierror.ApiCodeFileAlreadyExisted:
+			return importOutcomeConflict, false, fmt.Errorf("同名文件已存在")
+		}
+		return importOutcomeFailed, false, fmt.Errorf("创建上传任务失败：%s", apierr)
 	}
 
 	if r.FileDataExists == 1 {
@@ -271,14 +550,12 @@ func processOneImport(familyId int64, isOverwrite bool, dirMap map[string]*dirFi
 			_, er = panClient.AppUploadFileCommit(r.FileCommitUrl, r.UploadFileId, r.XRequestId)
 		}
 		if er != nil {
-			fmt.Println("秒传失败")
-			return false, false
+			return importOutcomeFailed, false, fmt.Errorf("秒传失败")
 		} else {
-			return true, false
+			return importOutcomeRapidUploaded, false, nil
 		}
 	} else {
-		fmt.Printf("文件未曾上传，无法秒传\n")
-		return false, false
+		return importOutcomeMissing, false, fmt.Errorf("文件未曾上传，无法秒传")
 	}
 }
 
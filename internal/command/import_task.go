@@ -0,0 +1,218 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/taskframework"
+)
+
+type (
+	// importOutcome 单个文件的导入结果
+	importOutcome string
+
+	// importTaskUnit 导入单个文件的任务单元
+	importTaskUnit struct {
+		Item        ImportExportFileItem
+		FamilyId    int64
+		IsOverwrite bool
+		DirMap      map[string]*dirFileListData
+		LocalPath   string                      // 导入文件路径, 用于传输报告
+		Report      *functions.TransferReport   // 传输报告, 为nil则不记录
+		Checkpoint  *functions.ImportCheckpoint // 导入进度, 为nil则不记录
+		Statistic   *importStatistic
+		Limiter     *requestLimiter // 限制请求频率, 多个任务共用
+		Abort       func()          // 无法继续导入时调用, 终止所有导入任务
+
+		taskInfo  *taskframework.TaskInfo
+		startTime time.Time
+		outcome   importOutcome // 最终的导入结果, 为空表示未处理
+	}
+
+	// importStatistic 按导入结果分类统计文件数量, 并发安全
+	importStatistic struct {
+		counts map[importOutcome]int
+		mu     sync.Mutex
+	}
+)
+
+const (
+	importOutcomeRapidUploaded importOutcome = "rapid-uploaded" // 秒传成功
+	importOutcomeExists        importOutcome = "exists"         // 已存在相同的文件
+	importOutcomeMissing       importOutcome = "missing"        // 服务器上没有该文件, 无法秒传
+	importOutcomeConflict      importOutcome = "conflict"       // 存在内容不同的同名文件
+	importOutcomeFailed        importOutcome = "failed"
+	importOutcomeDone          importOutcome = "done"        // 导入进度中已导入
+	importOutcomeUnprocessed   importOutcome = "unprocessed" // 导入终止时未处理
+)
+
+var (
+	importOutcomeNames = []struct {
+		outcome importOutcome
+		name    string
+	}{
+		{importOutcomeRapidUploaded, "秒传成功"},
+		{importOutcomeExists, "已存在相同文件"},
+		{importOutcomeDone, "之前已导入"},
+		{importOutcomeMissing, "服务器上没有该文件"},
+		{importOutcomeConflict, "同名文件冲突"},
+		{importOutcomeFailed, "失败"},
+		{importOutcomeUnprocessed, "未处理"},
+	}
+)
+
+func (o importOutcome) String() string {
+	for _, n := range importOutcomeNames {
+		if n.outcome == o {
+			return n.name
+		}
+	}
+	return string(o)
+}
+
+// isSucceed 文件已在网盘中, 不需要再导入
+func (o importOutcome) isSucceed() bool {
+	return o == importOutcomeRapidUploaded || o == importOutcomeExists || o == importOutcomeDone
+}
+
+// Add 增加一个文件的导入结果
+func (is *importStatistic) Add(outcome importOutcome) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	if is.counts == nil {
+		is.counts = map[importOutcome]int{}
+	}
+	is.counts[outcome]++
+}
+
+// Count 导入结果为 outcome 的文件数量
+func (is *importStatistic) Count(outcome importOutcome) int {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	return is.counts[outcome]
+}
+
+// Done 已在网盘中的文件数量
+func (is *importStatistic) Done() (n int) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	for outcome, count := range is.counts {
+		if outcome.isSucceed() {
+			n += count
+		}
+	}
+	return
+}
+
+// Print 输出导入结果统计
+func (is *importStatistic) Print() {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	fmt.Println("导入结果:")
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"结果", "文件数量"})
+	for _, n := range importOutcomeNames {
+		if count := is.counts[n.outcome]; count > 0 {
+			tb.Append([]string{n.name, strconv.Itoa(count)})
+		}
+	}
+	tb.Render()
+}
+
+func (itu *importTaskUnit) SetTaskInfo(info *taskframework.TaskInfo) {
+	itu.taskInfo = info
+}
+
+func (itu *importTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
+	if itu.startTime.IsZero() {
+		itu.startTime = time.Now()
+	}
+	itu.Limiter.Wait()
+	outcome, abort, err := processOneImport(itu.FamilyId, itu.IsOverwrite, itu.DirMap, itu.Item)
+	result = &taskframework.TaskUnitRunResult{
+		Succeed: err == nil,
+		Err:     err,
+		Extra:   outcome,
+	}
+	if abort {
+		// 终止后执行器不再调用 OnFailed, 在这里记录结果
+		itu.finish(result)
+		if itu.Abort != nil {
+			itu.Abort()
+		}
+		return
+	}
+	// 只重试未知的错误, 无法秒传和同名冲突重试也不会成功
+	result.NeedRetry = err != nil && outcome == importOutcomeFailed
+	return
+}
+
+func (itu *importTaskUnit) OnRetry(lastRunResult *taskframework.TaskUnitRunResult) {
+	fmt.Printf("[%s] 导入失败, %s, 重试 %d/%d: %s\n", itu.taskInfo.Id(), lastRunResult.Err, itu.taskInfo.Retry(), itu.taskInfo.MaxRetry(), itu.Item.Path)
+}
+
+func (itu *importTaskUnit) OnSuccess(lastRunResult *taskframework.TaskUnitRunResult) {
+	itu.finish(lastRunResult)
+	if err := itu.Checkpoint.Done(itu.Item.Path, itu.Item.FileMd5); err != nil {
+		fmt.Printf("[%s] 保存导入进度错误: %s\n", itu.taskInfo.Id(), err)
+	}
+}
+
+func (itu *importTaskUnit) OnFailed(lastRunResult *taskframework.TaskUnitRunResult) {
+	itu.finish(lastRunResult)
+}
+
+func (itu *importTaskUnit) OnComplete(lastRunResult *taskframework.TaskUnitRunResult) {
+}
+
+// finish 记录最终的导入结果
+func (itu *importTaskUnit) finish(result *taskframework.TaskUnitRunResult) {
+	if itu.outcome != "" {
+		return
+	}
+	outcome, _ := result.Extra.(importOutcome)
+	if outcome == "" {
+		outcome = importOutcomeFailed
+	}
+	itu.outcome = outcome
+	itu.Statistic.Add(outcome)
+
+	reportItem := &functions.TransferReportItem{
+		LocalPath:  itu.LocalPath,
+		RemotePath: itu.Item.Path,
+		Size:       itu.Item.FileSize,
+		Status:     functions.TransferStatusRapidUploaded,
+		Duration:   time.Since(itu.startTime),
+		MD5:        strings.ToLower(itu.Item.FileMd5),
+	}
+	if outcome == importOutcomeExists {
+		reportItem.Status = functions.TransferStatusSkipped
+	}
+	if result.Err != nil {
+		reportItem.Status = functions.TransferStatusFailed
+		reportItem.Error = result.Err.Error()
+		fmt.Printf("[%s] %s, %s: %s\n", itu.taskInfo.Id(), outcome, result.Err, itu.Item.Path)
+	} else {
+		fmt.Printf("[%s] %s: %s\n", itu.taskInfo.Id(), outcome, itu.Item.Path)
+	}
+	itu.Report.Add(reportItem)
+}
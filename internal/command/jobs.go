@@ -0,0 +1,128 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/functions/pandownload"
+	"github.com/urfave/cli"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func CmdJobs() cli.Command {
+	return cli.Command{
+		Name:  "jobs",
+		Usage: "后台传输任务和未完成的下载任务",
+		Description: `
+	下载任务的队列会保存在配置目录中, 程序中断后可以通过 download --resume-job <任务ID> 继续下载.
+	交互模式下, 通过 download -bg 或 upload -bg 在后台执行的任务, 可以使用 fg, pause, resume, kill 管理.
+
+	示例:
+
+	1. 列出未完成的下载任务
+	cloudpan189-go jobs list
+
+	2. 删除ID为 1 和 3 的下载任务, 已下载的文件不会被删除
+	cloudpan189-go jobs rm 1 3
+
+	3. 交互模式下, 列出后台任务的进度和未完成的下载任务
+	jobs
+`,
+		Category: "天翼云盘",
+		Before:   cmder.ReloadConfigFunc,
+		Action: func(c *cli.Context) error {
+			if cmder.IsCliMode() {
+				RunBackgroundJobList()
+				fmt.Println()
+			}
+			RunDownloadJobList()
+			return nil
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:      "list",
+				Aliases:   []string{"ls", "l"},
+				Usage:     "列出未完成的下载任务",
+				UsageText: cmder.App().Name + " jobs list",
+				Action: func(c *cli.Context) error {
+					RunDownloadJobList()
+					return nil
+				},
+			},
+			{
+				Name:      "rm",
+				Usage:     "删除下载任务",
+				UsageText: cmder.App().Name + " jobs rm <任务ID 1> <任务ID 2> ...",
+				Action: func(c *cli.Context) error {
+					if c.NArg() <= 0 {
+						cli.ShowCommandHelp(c, c.Command.Name)
+						return nil
+					}
+					RunDownloadJobRemove(c.Args()...)
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// RunDownloadJobList 列出未完成的下载任务
+func RunDownloadJobList() {
+	jobs, err := pandownload.ListDownloadJobs()
+	if err != nil {
+		fmt.Printf("读取下载任务失败: %s\n", err)
+		return
+	}
+	if len(jobs) == 0 {
+		fmt.Println("没有未完成的下载任务")
+		return
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "任务ID", "网盘路径", "保存目录", "已完成/失败/总数", "更新时间"})
+	for k, job := range jobs {
+		total, completed, failed := job.Count()
+		tb.Append([]string{
+			strconv.Itoa(k + 1),
+			strconv.FormatInt(job.Id, 10),
+			strings.Join(job.Paths, ", "),
+			job.SaveRoot,
+			fmt.Sprintf("%d/%d/%d", completed, failed, total),
+			time.Unix(job.UpdateTime, 0).Format("2006-01-02 15:04:05"),
+		})
+	}
+	tb.Render()
+}
+
+// RunDownloadJobRemove 删除下载任务
+func RunDownloadJobRemove(ids ...string) {
+	for _, idStr := range ids {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			fmt.Printf("任务ID错误: %s\n", idStr)
+			continue
+		}
+		err = pandownload.RemoveDownloadJob(id)
+		if err != nil {
+			fmt.Printf("删除下载任务 %d 失败: %s\n", id, err)
+			continue
+		}
+		fmt.Printf("删除下载任务成功: %d\n", id)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+	"github.com/tickstep/cloudpan189-go/internal/utils"
+)
+
+type (
+	// localWalker 遍历本地文件, upload 和 manifest 共用.
+	// 处理符号链接, 跳过特殊文件和元数据文件, 应用排除规则和忽略文件
+	localWalker struct {
+		filter *utils.FileFilter
+		links  string // 符号链接的处理方式: follow, skip, store
+		out    io.Writer
+	}
+
+	// localWalkFunc 处理遍历到的目录或文件, 目录返回 filepath.SkipDir 时不再遍历该目录.
+	// isSymlink 为 true 时 file 是 --links store 需要保存的符号链接, fi 为符号链接自身的信息
+	localWalkFunc func(file string, fi os.FileInfo, isSymlink bool) error
+)
+
+// walk 遍历 root, root 为目录时忽略规则相对于 root. 遍历错误只输出警告, fn 返回的错误会中止遍历
+func (w *localWalker) walk(root string, fn localWalkFunc) error {
+	// 记录已遍历的目录, 避免符号链接导致循环
+	dirVisitor := localfile.NewDirVisitor()
+	if fi, err := os.Stat(root); err == nil && fi.IsDir() {
+		w.filter.AddRoot(root)
+		if err := w.filter.LoadIgnoreFile(root); err != nil {
+			fmt.Fprintf(w.out, "警告: 读取忽略文件错误: %s\n", err)
+		}
+		dirVisitor.Visit(root, fi)
+	}
+
+	var walkFunc filepath.WalkFunc
+	walkFunc = func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Fprintf(w.out, "警告: 遍历错误: %s\n", err)
+			return nil
+		}
+
+		isSymlink := fi.Mode()&os.ModeSymlink != 0
+		if isSymlink {
+			switch w.links {
+			case LinksSkip:
+				fmt.Fprintf(w.out, "跳过符号链接: %s\n", file)
+				return nil
+			case LinksStore:
+				// 排除检查之后再由 fn 处理
+			default:
+				target, err := os.Stat(file)
+				if err != nil {
+					fmt.Fprintf(w.out, "警告: 符号链接无效, 跳过 %s: %s\n", file, err)
+					return nil
+				}
+				if target.IsDir() {
+					if !dirVisitor.Visit(file, target) {
+						fmt.Fprintf(w.out, "警告: 符号链接指向已遍历的目录, 可能存在循环, 跳过: %s\n", file)
+						return nil
+					}
+					if w.filter.IsExcluded(file, true, 0, target.ModTime()) {
+						fmt.Fprintf(w.out, "排除文件: %s\n", file)
+						return nil
+					}
+					if err := w.filter.LoadIgnoreFile(file); err != nil {
+						fmt.Fprintf(w.out, "警告: 读取忽略文件错误: %s\n", err)
+					}
+					return WalkAllFile(file+string(os.PathSeparator), walkFunc)
+				}
+				fi = target
+				isSymlink = false
+			}
+		}
+		if fileType := localfile.SpecialFileType(fi.Mode()); fileType != "" {
+			fmt.Fprintf(w.out, "警告: 跳过%s: %s\n", fileType, file)
+			return nil
+		}
+		if !fi.IsDir() && fi.Name() == functions.DirMetaFileName {
+			// 元数据文件由上传结束后统一生成
+			return nil
+		}
+
+		// 是否排除
+		if w.filter.IsExcluded(file, fi.IsDir(), fi.Size(), fi.ModTime()) {
+			fmt.Fprintf(w.out, "排除文件: %s\n", file)
+			return filepath.SkipDir
+		}
+		if fi.IsDir() {
+			if !dirVisitor.Visit(file, fi) {
+				return filepath.SkipDir
+			}
+			if err := w.filter.LoadIgnoreFile(file); err != nil {
+				fmt.Fprintf(w.out, "警告: 读取忽略文件错误: %s\n", err)
+			}
+		}
+		return fn(file, fi, isSymlink)
+	}
+	return WalkAllFile(root, walkFunc)
+}
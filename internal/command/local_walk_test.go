@@ -0,0 +1,88 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/utils"
+)
+
+func walkNames(t *testing.T, root, links string) []string {
+	var names []string
+	w := &localWalker{
+		filter: &utils.FileFilter{ExcludeNames: []string{`\.tmp$`}},
+		links:  links,
+		out:    ioutil.Discard,
+	}
+	err := w.walk(root, func(file string, fi os.FileInfo, isSymlink bool) error {
+		rel, _ := filepath.Rel(root, filepath.Clean(file))
+		name := filepath.ToSlash(rel)
+		if fi.IsDir() {
+			name += "/"
+		}
+		if isSymlink {
+			name += "@"
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestLocalWalker(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows 创建符号链接需要权限")
+	}
+	root := t.TempDir()
+	for _, name := range []string{"a.txt", "b.tmp", "sub/c.txt", "sub/" + functions.DirMetaFileName, "other/d.txt"} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 指向其他目录的符号链接, 以及指向上级目录的循环
+	outside := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(outside, "e.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Symlink(outside, filepath.Join(root, "sub", "link"))
+	os.Symlink(root, filepath.Join(root, "sub", "loop"))
+
+	cases := []struct {
+		links string
+		want  string
+	}{
+		{LinksFollow, "a.txt other/ other/d.txt sub/ sub/c.txt sub/link/e.txt"},
+		{LinksSkip, "a.txt other/ other/d.txt sub/ sub/c.txt"},
+		{LinksStore, "a.txt other/ other/d.txt sub/ sub/c.txt sub/link@ sub/loop@"},
+	}
+	for _, c := range cases {
+		if got := strings.Join(walkNames(t, root, c.links), " "); got != c.want {
+			t.Fatalf("%s:\ngot  %s\nwant %s", c.links, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdutil"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+	"github.com/tickstep/cloudpan189-go/internal/utils"
+	"github.com/tickstep/library-go/converter"
+	"github.com/urfave/cli"
+)
+
+type (
+	// progressWriter 输出进度之后另起一行输出其他信息
+	progressWriter struct {
+		w        io.Writer
+		progress bool // 最后输出的是进度
+	}
+
+	// ManifestOptions 生成导入文件的可选项
+	ManifestOptions struct {
+		Overwrite bool              // 覆盖已存在的导入文件
+		Format    string            // 导入文件格式, 为空时根据文件扩展名选择
+		Filter    *utils.FileFilter // 文件过滤条件
+	}
+)
+
+func CmdManifest() cli.Command {
+	return cli.Command{
+		Name:      "manifest",
+		Usage:     "根据本地文件生成导入文件",
+		UsageText: cmder.App().Name + " manifest <本地文件/目录的路径1> <文件/目录2> ... <保存文件路径>",
+		Description: `
+	计算本地文件的md5和大小, 生成可以使用 import 命令导入的文件, 不上传文件.
+	导入文件中的路径与 upload 上传的路径一致, 即上传目录 D:/照片 时为 /照片/<相对路径>.
+	配合 import --upload-missing 使用, 先尝试秒传整个目录, 只上传网盘中没有的文件.
+
+	示例:
+
+	1. 生成 D:/照片 整个目录的导入文件
+	cloudpan189-go manifest D:/照片 D:/照片.txt
+
+	2. 秒传导入到网盘 /备份/照片 目录中, 无法秒传的文件从本地上传
+	cloudpan189-go import -saveto=/备份 --upload-missing D:/ D:/照片.txt
+
+	3. 生成 CSV 格式的导入文件, 排除所有的 .tmp 文件
+	cloudpan189-go manifest -exn "\.tmp$" D:/照片 D:/照片.csv
+`,
+		Category: "天翼云盘",
+		Action: func(c *cli.Context) error {
+			if c.NArg() < 2 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			}
+			if err := checkFileListFormat(c.String("format"), true); err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			filter, err := parseFileFilter(c)
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			subArgs := c.Args()
+			RunManifest(subArgs[:len(subArgs)-1], subArgs[len(subArgs)-1], &ManifestOptions{
+				Overwrite: c.Bool("ow"),
+				Format:    c.String("format"),
+				Filter:    filter,
+			})
+			return nil
+		},
+		Flags: append([]cli.Flag{
+			cli.BoolFlag{
+				Name:  "ow",
+				Usage: "overwrite, 覆盖已存在的导入文件",
+			},
+			cli.StringFlag{
+				Name:  "format",
+				Usage: "导入文件格式: json, link, csv, tree, 参考 export --format. 默认根据保存文件的扩展名选择 csv 或 json",
+			},
+			cli.StringSliceFlag{
+				Name:  "exn",
+				Usage: "exclude name，指定排除的文件夹或者文件的名称，只支持正则表达式。支持同时排除多个名称，每一个名称就是一个exn参数",
+			},
+		}, fileFilterFlags...),
+	}
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	if pw.progress {
+		pw.progress = false
+		if _, err := io.WriteString(pw.w, "\n"); err != nil {
+			return 0, err
+		}
+	}
+	return pw.w.Write(p)
+}
+
+func (pw *progressWriter) printProgress(format string, a ...interface{}) {
+	fmt.Fprintf(pw.w, format, a...)
+	pw.progress = true
+}
+
+// RunManifest 计算本地文件的md5, 生成导入文件
+func RunManifest(localPaths []string, saveFilePath string, opt *ManifestOptions) {
+	if _, err := os.Stat(saveFilePath); err == nil && !opt.Overwrite {
+		fmt.Println("导入文件已存在, 可使用 -ow 覆盖")
+		return
+	}
+	format := opt.Format
+	if format == "" {
+		format = fileListFormatByExt(saveFilePath)
+	}
+	absSavePath, _ := filepath.Abs(saveFilePath)
+
+	// 打开文件摘要缓存, 未修改的文件不再重新计算md5
+	hashCache, err := openHashCache()
+	if err != nil {
+		panCommandVerbose.Warnf("open hash cache failed: %s\n", err)
+	} else {
+		defer hashCache.Close()
+	}
+
+	if dir := filepath.Dir(saveFilePath); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	saveFile, err := os.OpenFile(saveFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer saveFile.Close()
+	writer, err := newFileListWriter(format, saveFile, false)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	filter := opt.Filter
+	if filter == nil {
+		filter = &utils.FileFilter{}
+	}
+	var (
+		totalCount int
+		totalSize  int64
+		startTime  = time.Now()
+	)
+	out := &progressWriter{w: os.Stdout}
+	walker := &localWalker{filter: filter, links: LinksFollow, out: out}
+	for _, localPath := range localPaths {
+		localPath = filepath.Clean(localPath)
+		rootDir := filepath.Dir(localPath)
+		walkFunc := func(file string, fi os.FileInfo, isSymlink bool) error {
+			if fi.IsDir() {
+				return nil
+			}
+			// 不要把导入文件自身写进去
+			if absFile, err := filepath.Abs(file); err == nil && absFile == absSavePath {
+				return nil
+			}
+
+			lfe := localfile.NewLocalFileEntity(file)
+			lfe.HashCache = hashCache
+			if err := lfe.OpenPath(); err != nil {
+				fmt.Fprintf(out, "警告: 打开文件错误, 跳过 %s: %s\n", file, err)
+				return nil
+			}
+			err := lfe.Sum(localfile.CHECKSUM_MD5)
+			lfe.Close()
+			if err != nil {
+				fmt.Fprintf(out, "警告: 计算md5错误, 跳过 %s: %s\n", file, err)
+				return nil
+			}
+
+			relPath, err := filepath.Rel(rootDir, file)
+			if err != nil {
+				relPath = filepath.Base(file)
+			}
+			item := &ImportExportFileItem{
+				FileMd5:    strings.ToUpper(lfe.MD5),
+				FileSize:   lfe.Length,
+				Path:       path.Clean("/" + cmdutil.ConvertToUnixPathSeparator(relPath)),
+				LastOpTime: fi.ModTime().Format("2006-01-02 15:04:05"),
+			}
+			if err := writer.Write(item); err != nil {
+				return err
+			}
+			totalCount++
+			totalSize += lfe.Length
+			out.printProgress("\r已处理文件数量: %d", totalCount)
+			return nil
+		}
+		if err := walker.walk(localPath, walkFunc); err != nil {
+			fmt.Printf("\n写入导入文件错误: %s\n", err)
+			return
+		}
+	}
+	if err := writer.Close(); err != nil {
+		fmt.Printf("\n写入导入文件错误: %s\n", err)
+		return
+	}
+	fmt.Printf("\r文件总数量: %d, 总大小: %s, 耗时: %s\n", totalCount, converter.ConvertFileSize(totalSize, 2), time.Since(startTime).Round(time.Second))
+	fmt.Printf("导入文件保存路径: %s\n", saveFilePath)
+}
@@ -20,7 +20,6 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -31,17 +30,27 @@ import (
 
 	"github.com/tickstep/cloudpan189-go/cmder/cmdutil"
 
-	"github.com/oleiade/lane"
 	"github.com/tickstep/cloudpan189-api/cloudpan"
 	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
 	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
 	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
 	"github.com/tickstep/cloudpan189-go/internal/functions/panupload"
 	"github.com/tickstep/cloudpan189-go/internal/localfile"
 	"github.com/tickstep/cloudpan189-go/internal/taskframework"
+	"github.com/tickstep/cloudpan189-go/internal/utils"
 	"github.com/tickstep/library-go/converter"
 )
 
+const (
+	// LinksFollow 上传符号链接指向的文件或目录
+	LinksFollow = "follow"
+	// LinksSkip 跳过符号链接
+	LinksSkip = "skip"
+	// LinksStore 上传符号链接描述文件, 下载时还原为符号链接
+	LinksStore = "store"
+)
+
 const (
 	// DefaultUploadMaxAllParallel 默认所有文件并发上传数量，即可以同时并发上传多少个文件
 	DefaultUploadMaxAllParallel = 1
@@ -60,7 +69,24 @@ type (
 		ShowProgress  bool
 		IsOverwrite   bool // 覆盖已存在的文件，如果同名文件已存在则移到回收站里
 		FamilyId      int64
-		ExcludeNames  []string // 排除的文件名，包括文件夹和文件。即这些文件/文件夹不进行上传，支持正则表达式
+		ExcludeNames  []string          // 排除的文件名，包括文件夹和文件。即这些文件/文件夹不进行上传，支持正则表达式
+		SmallFirst    bool              // 小文件优先上传
+		Report        string            // 传输报告保存路径, 支持 .json 和 .csv
+		Filter        *utils.FileFilter // 文件过滤条件, 为空时只使用 ExcludeNames
+		Links         string            // 符号链接的处理方式: follow, skip, store
+		SaveMeta      bool              // 在网盘目录中记录文件的修改时间和权限
+		KeepVersions  int               // 覆盖时保留的历史版本数量, 0为移到回收站
+		OnExists      string            // 网盘文件已存在时的处理方式, 为空时按 IsOverwrite 覆盖或不检查
+
+		bgJob      *backgroundJob            // 交互模式下的后台任务
+		report     *functions.TransferReport // 多次执行上传时共享, 由调用方保存
+		failedList *functions.FailedList     // 多次执行上传时共享, 由调用方保存
+	}
+
+	// uploadSource 要上传的本地文件/目录, 以及保存到的网盘目录
+	uploadSource struct {
+		LocalPath string
+		SaveDir   string // 网盘目录的绝对路径
 	}
 )
 
@@ -97,6 +123,27 @@ var UploadFlags = []cli.Flag{
 		Usage: "exclude name，指定排除的文件夹或者文件的名称，只支持正则表达式。支持同时排除多个名称，每一个名称就是一个exn参数",
 		Value: nil,
 	},
+	cli.BoolFlag{
+		Name:  "smallfirst",
+		Usage: "小文件优先上传",
+	},
+	cli.StringFlag{
+		Name:  "report",
+		Usage: "上传结束后将每个文件的上传结果保存到指定文件, 支持 .json 和 .csv 格式",
+	},
+	cli.BoolFlag{
+		Name:  "meta",
+		Usage: "在网盘目录的 " + functions.DirMetaFileName + " 中记录文件的修改时间和权限, 下载时据此还原",
+	},
+	cli.StringFlag{
+		Name:  "links",
+		Usage: "符号链接的处理方式, follow: 上传链接指向的文件或目录, skip: 跳过, store: 上传链接描述文件, 下载时还原为符号链接",
+		Value: LinksFollow,
+	},
+	cli.IntFlag{
+		Name:  "keep-versions",
+		Usage: "覆盖已存在的同名文件时, 将旧文件移到同目录的 " + panupload.VersionsDirName + " 中, 最多保留指定数量的历史版本, 0为移到回收站",
+	},
 }
 
 func CmdUpload() cli.Command {
@@ -125,6 +172,9 @@ func CmdUpload() cli.Command {
     5. 覆盖上传，已存在的同名文件会被移到回收站
     cloudpan189-go upload -ow 1.mp4 /视频
 
+    覆盖上传，已存在的同名文件移到 /视频/.versions/1.mp4/ 中, 最多保留3个历史版本, 参考 versions help
+    cloudpan189-go upload -ow --keep-versions 3 1.mp4 /视频
+
     6. 将本地的 C:\Users\Administrator\Video 整个目录上传到网盘 /视频 目录，但是排除所有的.jpg文件
     cloudpan189-go upload -exn "\.jpg$" C:/Users/Administrator/Video /视频
 
@@ -134,23 +184,47 @@ func CmdUpload() cli.Command {
     8. 将本地的 C:\Users\Administrator\Video 整个目录上传到网盘 /视频 目录，但是排除所有的 @eadir 文件夹
     cloudpan189-go upload -exn "^@eadir$" C:/Users/Administrator/Video /视频
 
+    9. 查看未完成上传的文件, 参考 upload-pending help
+    cloudpan189-go upload-pending
+
+    10. 交互模式下, 在后台上传 C:/Users/Administrator/Video 整个目录, 上传进度可通过 jobs 查看
+    upload -bg C:/Users/Administrator/Video /视频
+
+    11. 上传 C:/Users/Administrator/Video 整个目录, 并将每个文件的上传结果保存到 report.csv
+    cloudpan189-go upload --report report.csv C:/Users/Administrator/Video /视频
+
+    12. 只重新上传上一次上传失败的文件, 失败列表文件的路径在上传结束时输出
+    cloudpan189-go upload --retry-failed ~/.cloud189/cloud189_failed/upload_20210101_120000.json
+
+    13. 上传 C:/Users/Administrator/Video 整个目录中不小于10MB的 .mp4 文件, 并使用 D:/video.ignore 中的忽略规则
+    cloudpan189-go upload --include "\.mp4$" --min-size 10MB --ignore-file D:/video.ignore C:/Users/Administrator/Video /视频
+
+    14. 上传 /volume1/data 整个目录, 符号链接保存为描述文件 (*` + localfile.SymlinkDescriptorSuffix + `), 下载时还原为符号链接
+    cloudpan189-go upload --links store /volume1/data /备份
+    默认 (--links follow) 上传符号链接指向的文件或目录, 已遍历过的目录不会重复上传; 设备文件, socket 和命名管道总是跳过
+
+    使用 --meta 时, 上传结束后文件的本地修改时间和权限会记录到网盘目录的 ` + functions.DirMetaFileName + ` 中, download 时据此还原
+
   参考：
     以下是典型的排除特定文件或者文件夹的例子，注意：参数值必须是正则表达式。在正则表达式中，^表示匹配开头，$表示匹配结尾。
     1)排除@eadir文件或者文件夹：-exn "^@eadir$"
     2)排除.jpg文件：-exn "\.jpg$"
     3)排除.号开头的文件：-exn "^\."
     4)排除 myfile.txt 文件：-exn "^myfile.txt$"
+
+    上传目录中每一级的 ` + utils.DefaultIgnoreFileName + ` 文件都会被读取, 使用 gitignore 语法, 支持 ! 取反和 / 开头的锚定路径, 可通过 --no-ignore 禁用
 `,
 		Category: "天翼云盘",
 		Before:   cmder.ReloadConfigFunc,
 		Action: func(c *cli.Context) error {
-			if c.NArg() < 2 {
+			retryFailed := c.String("retry-failed")
+			if c.NArg() < 2 && retryFailed == "" {
 				cli.ShowCommandHelp(c, c.Command.Name)
 				return nil
 			}
 
-			subArgs := c.Args()
-			RunUpload(subArgs[:c.NArg()-1], subArgs[c.NArg()-1], &UploadOptions{
+			subArgs := append([]string{}, c.Args()...)
+			opt := &UploadOptions{
 				AllParallel:   c.Int("p"),
 				Parallel:      1, // 天翼云盘一个文件只支持单线程上传
 				MaxRetry:      c.Int("retry"),
@@ -160,10 +234,53 @@ func CmdUpload() cli.Command {
 				IsOverwrite:   c.Bool("ow"),
 				FamilyId:      parseFamilyId(c),
 				ExcludeNames:  c.StringSlice("exn"),
-			})
+				SmallFirst:    c.Bool("smallfirst"),
+				Report:        c.String("report"),
+				Links:         c.String("links"),
+				SaveMeta:      c.Bool("meta"),
+				KeepVersions:  c.Int("keep-versions"),
+				OnExists:      c.String("on-exists"),
+			}
+			filter, err := parseFileFilter(c)
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			opt.Filter = filter
+			run := func() {
+				if retryFailed != "" {
+					RunUploadRetryFailed(retryFailed, opt)
+					return
+				}
+				RunUpload(subArgs[:len(subArgs)-1], subArgs[len(subArgs)-1], opt)
+			}
+			if c.Bool("bg") {
+				started := startBackgroundJob("上传", subArgs, func(job *backgroundJob) {
+					opt.ShowProgress = false
+					opt.bgJob = job
+					run()
+				})
+				if started {
+					return nil
+				}
+			}
+			run()
 			return nil
 		},
-		Flags: UploadFlags,
+		Flags: append(append(UploadFlags, fileFilterFlags...),
+			cli.StringFlag{
+				Name:  "on-exists",
+				Usage: "网盘文件已存在时的处理方式, skip: 跳过, overwrite: 覆盖, rename: 重命名保存, newer: 本地文件较新时覆盖, different: 大小或md5不同时覆盖. 指定 -ow 时覆盖",
+			},
+			cli.BoolFlag{
+				Name:  "bg",
+				Usage: "在后台上传, 只能在交互模式下使用, 通过 jobs, fg, pause, resume, kill 管理",
+			},
+			cli.StringFlag{
+				Name:  "retry-failed",
+				Usage: "只重新上传失败列表文件中的文件, 上传失败时会自动生成失败列表文件",
+			},
+		),
 	}
 }
 
@@ -224,6 +341,24 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 		opt = &UploadOptions{}
 	}
 
+	savePath = activeUser.PathJoin(opt.FamilyId, savePath)
+	_, err1 := activeUser.PanClient().AppFileInfoByPath(opt.FamilyId, savePath)
+	if err1 != nil {
+		fmt.Fprintf(opt.bgJob.output(), "警告: 上传文件, 获取云盘路径 %s 错误, %s\n", savePath, err1)
+	}
+
+	sources := make([]uploadSource, 0, len(localPaths))
+	for _, localPath := range localPaths {
+		sources = append(sources, uploadSource{LocalPath: localPath, SaveDir: savePath})
+	}
+	runUpload(sources, opt)
+}
+
+// runUpload 上传本地文件到各自的网盘目录
+func runUpload(sources []uploadSource, opt *UploadOptions) {
+	activeUser := GetActiveUser()
+	out := opt.bgJob.output()
+
 	// 检测opt
 	if opt.AllParallel <= 0 {
 		opt.AllParallel = config.Config.MaxUploadParallel
@@ -242,26 +377,76 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 		opt.MaxRetry = DefaultUploadMaxRetry
 	}
 
-	savePath = activeUser.PathJoin(opt.FamilyId, savePath)
-	_, err1 := activeUser.PanClient().AppFileInfoByPath(opt.FamilyId, savePath)
-	if err1 != nil {
-		fmt.Printf("警告: 上传文件, 获取云盘路径 %s 错误, %s\n", savePath, err1)
+	switch len(sources) {
+	case 0:
+		fmt.Fprintf(out, "本地路径为空\n")
+		return
 	}
 
-	switch len(localPaths) {
-	case 0:
-		fmt.Printf("本地路径为空\n")
+	switch opt.Links {
+	case "":
+		opt.Links = LinksFollow
+	case LinksFollow, LinksSkip, LinksStore:
+	default:
+		fmt.Fprintf(out, "links 参数错误: %s, 只支持 follow, skip, store\n", opt.Links)
+		return
+	}
+	// 上传成功的文件的元数据, 上传结束后写入网盘目录
+	var dirMeta *functions.DirMetaCollector
+	if opt.SaveMeta {
+		dirMeta = functions.NewDirMetaCollector()
+	}
+
+	// 符号链接描述文件的临时目录, 上传结束后删除
+	var linkTempDir string
+	defer func() {
+		if linkTempDir != "" {
+			os.RemoveAll(linkTempDir)
+		}
+	}()
+
+	filter := opt.Filter
+	if filter == nil {
+		filter = &utils.FileFilter{ExcludeNames: opt.ExcludeNames}
+	}
+
+	onExists, err := functions.ParseOnExistsPolicy(opt.OnExists, opt.IsOverwrite, "")
+	if err != nil {
+		fmt.Fprintln(out, err)
 		return
 	}
 
+	report, failedList := opt.report, opt.failedList
+	if report == nil {
+		var err error
+		report, err = functions.NewTransferReport("upload", opt.Report)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return
+		}
+		defer saveTransferReport(out, report, opt.Report)
+	}
+	if failedList == nil {
+		failedList = functions.NewFailedList("upload", opt.FamilyId)
+		defer saveFailedList(out, failedList)
+	}
+
 	// 打开上传状态
 	uploadDatabase, err := panupload.NewUploadingDatabase()
 	if err != nil {
-		fmt.Printf("打开上传未完成数据库错误: %s\n", err)
+		fmt.Fprintf(out, "打开上传未完成数据库错误: %s\n", err)
 		return
 	}
 	defer uploadDatabase.Close()
 
+	// 打开文件摘要缓存, 避免重复计算未修改文件的md5
+	hashCache, err := openHashCache()
+	if err != nil {
+		panCommandVerbose.Warnf("open hash cache failed: %s\n", err)
+	} else {
+		defer hashCache.Close()
+	}
+
 	var (
 		// 使用 task framework
 		executor = &taskframework.TaskExecutor{
@@ -273,6 +458,11 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 		folderCreateMutex = &sync.Mutex{}
 	)
 	executor.SetParallel(opt.AllParallel)
+	executor.SetRetryPolicy(transferRetryPolicy())
+	if opt.SmallFirst {
+		executor.SetPriorityFunc(taskframework.SmallFirstPriority)
+	}
+	opt.bgJob.attach(executor, &statistic.Statistic)
 
 	statistic.StartTimer() // 开始计时
 
@@ -283,7 +473,6 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		var failedList []*lane.Deque
 	FOR:
 		for {
 			select {
@@ -291,39 +480,39 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 				break FOR
 			default:
 				executor.Execute()
-				failed := executor.FailedDeque()
-				if failed.Size() > 0 {
-					failedList = append(failedList, failed)
-				}
 			}
 		}
-		fmt.Printf("\n")
-		fmt.Printf("上传结束, 时间: %s, 总大小: %s\n", statistic.Elapsed()/1e6*1e6, converter.ConvertFileSize(statistic.TotalSize()))
+		fmt.Fprintf(out, "\n")
+		fmt.Fprintf(out, "上传结束, 时间: %s, 总大小: %s\n", statistic.Elapsed()/1e6*1e6, converter.ConvertFileSize(statistic.TotalSize()))
 
 		// 输出上传失败的文件列表
-		for _, failed := range failedList {
-			if failed.Size() != 0 {
-				fmt.Printf("以下文件上传失败: \n")
-				tb := cmdtable.NewTable(os.Stdout)
-				for e := failed.Shift(); e != nil; e = failed.Shift() {
-					item := e.(*taskframework.TaskInfoItem)
-					tb.Append([]string{item.Info.Id(), item.Unit.(*panupload.UploadTaskUnit).LocalFileChecksum.Path})
-				}
-				tb.Render()
+		if failed := executor.FailedDeque(); failed.Size() != 0 {
+			fmt.Fprintf(out, "以下文件上传失败: \n")
+			tb := cmdtable.NewTable(out)
+			for e := failed.Shift(); e != nil; e = failed.Shift() {
+				item := e.(*taskframework.TaskInfoItem)
+				unit := item.Unit.(*panupload.UploadTaskUnit)
+				tb.Append([]string{item.Info.Id(), unit.LocalFileChecksum.Path})
+				failedList.Add(&functions.FailedItem{
+					LocalPath:  unit.LocalFileChecksum.Path,
+					RemotePath: unit.SavePath,
+					Size:       unit.LocalFileChecksum.Length,
+				})
 			}
+			tb.Render()
 		}
 	}()
 
 	// 遍历指定的文件并创建上传任务
-	for _, curPath := range localPaths {
-		var walkFunc filepath.WalkFunc
+	for _, src := range sources {
 		var db panupload.SyncDb
-		curPath = filepath.Clean(curPath)
+		curPath := filepath.Clean(src.LocalPath)
+		savePath := src.SaveDir
 		localPathDir := filepath.Dir(curPath)
 
 		// 是否排除上传
-		if isExcludeFile(curPath, opt) {
-			fmt.Printf("排除文件: %s\n", curPath)
+		if utils.IsExcludeFile(curPath, &filter.ExcludeNames) {
+			fmt.Fprintf(out, "排除文件: %s\n", curPath)
 			continue
 		}
 
@@ -346,26 +535,28 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 						db.Close()
 					}(db)
 				} else {
-					fmt.Println(curPath, "同步数据库打开失败,跳过该目录的备份", err)
+					fmt.Fprintln(out, curPath, "同步数据库打开失败,跳过该目录的备份", err)
 					continue
 				}
 			}
 		}
 
-		walkFunc = func(file string, fi os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			// 是否排除上传
-			if isExcludeFile(file, opt) {
-				fmt.Printf("排除文件: %s\n", file)
-				return filepath.SkipDir
-			}
-
-			if fi.Mode()&os.ModeSymlink != 0 { // 读取 symbol link
-				err = WalkAllFile(file+string(os.PathSeparator), walkFunc)
-				return err
+		walker := &localWalker{filter: filter, links: opt.Links, out: out}
+		walkFunc := func(file string, fi os.FileInfo, isSymlink bool) error {
+			localPath := file // 实际上传的本地文件, 符号链接描述文件为临时文件
+			if isSymlink {
+				var err error
+				if linkTempDir == "" {
+					if linkTempDir, err = ioutil.TempDir("", "cloud189_links"); err != nil {
+						fmt.Fprintf(out, "警告: 创建临时目录错误, 跳过符号链接 %s: %s\n", file, err)
+						return nil
+					}
+				}
+				if localPath, fi, err = writeSymlinkDescriptor(file, fi, linkTempDir); err != nil {
+					fmt.Fprintf(out, "警告: 读取符号链接错误, 跳过 %s: %s\n", file, err)
+					return nil
+				}
+				file += localfile.SymlinkDescriptorSuffix
 			}
 
 			subSavePath := strings.TrimPrefix(file, localPathDir)
@@ -381,6 +572,15 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 			if db != nil {
 				if ufm = db.Get(subSavePath); ufm.Size == fi.Size() && ufm.ModTime == fi.ModTime().Unix() {
 					logger.Verbosef("文件未修改跳过:%s\n", file)
+					if !fi.IsDir() {
+						report.Add(&functions.TransferReportItem{
+							LocalPath:  file,
+							RemotePath: subSavePath,
+							Size:       fi.Size(),
+							Status:     functions.TransferStatusSkipped,
+							MD5:        ufm.MD5,
+						})
+					}
 					return nil
 				}
 			}
@@ -394,7 +594,7 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 					return nil
 				}
 				panClient := activeUser.PanClient()
-				fmt.Println(subSavePath, "云盘文件夹预创建")
+				fmt.Fprintln(out, subSavePath, "云盘文件夹预创建")
 				//首先尝试直接创建文件夹
 				if ufm = db.Get(path.Dir(subSavePath)); ufm.IsFolder == true && ufm.FileID != "" {
 					rs, err := panClient.AppMkdir(opt.FamilyId, ufm.FileID, fi.Name())
@@ -408,12 +608,14 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 					db.Put(subSavePath, &panupload.UploadedFileMeta{FileID: rs.FileId, IsFolder: true, ModTime: fi.ModTime().Unix(), Rev: rs.Rev, ParentId: rs.ParentId})
 					return nil
 				}
-				fmt.Println(subSavePath, "创建云盘文件夹失败", err)
+				fmt.Fprintln(out, subSavePath, "创建云盘文件夹失败", err)
 				return filepath.SkipDir
 			}
 
+			localFileEntity := localfile.NewLocalFileEntity(localPath)
+			localFileEntity.HashCache = hashCache
 			taskinfo := executor.Append(&panupload.UploadTaskUnit{
-				LocalFileChecksum: localfile.NewLocalFileEntity(file),
+				LocalFileChecksum: localFileEntity,
 				SavePath:          subSavePath,
 				FamilyId:          opt.FamilyId,
 				PanClient:         activeUser.PanClient(),
@@ -423,38 +625,107 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 				NoRapidUpload:     opt.NoRapidUpload,
 				NoSplitFile:       opt.NoSplitFile,
 				UploadStatistic:   statistic,
+				Report:            report,
+				DirMeta:           dirMeta,
 				ShowProgress:      opt.ShowProgress,
-				IsOverwrite:       opt.IsOverwrite,
+				IsOverwrite:       onExists == functions.OnExistsOverwrite,
+				OnExists:          onExists,
+				KeepVersions:      opt.KeepVersions,
 				FolderSyncDb:      db,
+				Output:            out,
 			}, opt.MaxRetry)
 
-			fmt.Printf("%s [%s] 加入上传队列: %s\n", time.Now().Format("2006-01-02 15:04:05"), taskinfo.Id(), file)
+			fmt.Fprintf(out, "%s [%s] 加入上传队列: %s\n", time.Now().Format("2006-01-02 15:04:05"), taskinfo.Id(), file)
 			return nil
 		}
-		if err := WalkAllFile(curPath, walkFunc); err != nil {
-			fmt.Printf("警告: 遍历错误: %s\n", err)
+		if err := walker.walk(curPath, walkFunc); err != nil {
+			fmt.Fprintf(out, "警告: 遍历错误: %s\n", err)
 		}
 	}
 	time.Sleep(500 * time.Millisecond)
 	close(Done)
 	wg.Wait()
+	saveDirMeta(activeUser.PanClient(), opt.FamilyId, dirMeta)
 }
 
-// 是否是排除上传的文件
-func isExcludeFile(filePath string, opt *UploadOptions) bool {
-	if opt == nil || len(opt.ExcludeNames) == 0 {
-		return false
+// RunUploadRetryFailed 只重新上传失败列表中的文件
+func RunUploadRetryFailed(failedFile string, opt *UploadOptions) {
+	out := opt.bgJob.output()
+	fl, err := functions.LoadFailedList(failedFile, "upload")
+	if err != nil {
+		fmt.Fprintf(out, "读取失败列表错误: %s\n", err)
+		return
+	}
+	if len(fl.Items) == 0 {
+		fmt.Fprintln(out, "失败列表中没有文件")
+		return
+	}
+	report, err := functions.NewTransferReport("upload", opt.Report)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return
 	}
 
-	for _, pattern := range opt.ExcludeNames {
-		fileName := path.Base(filePath)
-
-		m, _ := regexp.MatchString(pattern, fileName)
-		if m {
-			return true
+	// 按网盘目录分组上传
+	type uploadGroup struct {
+		saveDir    string
+		localPaths []string
+	}
+	var (
+		groups   []*uploadGroup
+		groupMap = map[string]*uploadGroup{}
+	)
+	for _, item := range fl.Items {
+		if item.LocalPath == "" || item.RemotePath == "" {
+			continue
+		}
+		dir := path.Dir(item.RemotePath)
+		g, ok := groupMap[dir]
+		if !ok {
+			g = &uploadGroup{saveDir: dir}
+			groupMap[dir] = g
+			groups = append(groups, g)
 		}
+		g.localPaths = append(g.localPaths, item.LocalPath)
+	}
+
+	failedList := functions.NewFailedList("upload", fl.FamilyId)
+	for _, g := range groups {
+		groupOpt := *opt
+		groupOpt.FamilyId = fl.FamilyId
+		groupOpt.report = report
+		groupOpt.failedList = failedList
+		RunUpload(g.localPaths, g.saveDir, &groupOpt)
+	}
+	saveTransferReport(out, report, opt.Report)
+	saveFailedList(out, failedList)
+}
+
+// writeSymlinkDescriptor 在临时目录中生成符号链接描述文件, 修改时间与符号链接相同, 以便备份时判断是否修改
+func writeSymlinkDescriptor(linkPath string, linkInfo os.FileInfo, tempDir string) (string, os.FileInfo, error) {
+	data, err := localfile.NewSymlinkDescriptor(linkPath)
+	if err != nil {
+		return "", nil, err
+	}
+	f, err := ioutil.TempFile(tempDir, "link")
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if err = os.Chtimes(f.Name(), linkInfo.ModTime(), linkInfo.ModTime()); err != nil {
+		return "", nil, err
+	}
+	info, err := os.Stat(f.Name())
+	if err != nil {
+		return "", nil, err
 	}
-	return false
+	return f.Name(), info, nil
 }
 
 func WalkAllFile(dirPath string, walkFn filepath.WalkFunc) error {
@@ -0,0 +1,338 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panupload"
+	"github.com/tickstep/library-go/converter"
+	"github.com/urfave/cli"
+)
+
+// CmdUploadPending 未完成的上传
+func CmdUploadPending() cli.Command {
+	return cli.Command{
+		Name:      "upload-pending",
+		Usage:     "未完成的上传",
+		Category:  "天翼云盘",
+		Before:    cmder.ReloadConfigFunc,
+		UsageText: cmder.App().Name + " upload-pending",
+		Description: `
+	列出和管理未完成上传的文件, 这些记录用于断点续传.
+
+	示例:
+
+	1. 列出未完成上传的文件
+	cloudpan189-go upload-pending
+
+	2. 删除编号为 1 和 3 的记录
+	cloudpan189-go upload-pending rm 1 3
+
+	3. 删除超过7天未更新的记录
+	cloudpan189-go upload-pending rm --older-than 7d
+
+	4. 继续上传所有未完成的文件
+	cloudpan189-go upload-pending resume
+`,
+		Action: func(c *cli.Context) error {
+			RunUploadPendingList()
+			return nil
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:      "rm",
+				Usage:     "删除未完成上传的记录",
+				UsageText: cmder.App().Name + " upload-pending rm <编号1> <编号2> ... | --older-than <时间>",
+				Action: func(c *cli.Context) error {
+					if c.NArg() <= 0 && !c.IsSet("older-than") {
+						cli.ShowCommandHelp(c, c.Command.Name)
+						return nil
+					}
+					var olderThan time.Duration
+					if c.IsSet("older-than") {
+						d, err := parseDuration(c.String("older-than"))
+						if err != nil {
+							fmt.Println(err)
+							return nil
+						}
+						olderThan = d
+					}
+					RunUploadPendingRemove(c.Args(), olderThan)
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "older-than",
+						Usage: "删除超过指定时间未更新的记录, 例如: 12h, 7d",
+					},
+				},
+			},
+			{
+				Name:      "resume",
+				Usage:     "继续上传未完成的文件",
+				UsageText: cmder.App().Name + " upload-pending resume [编号1] [编号2] ...",
+				Action: func(c *cli.Context) error {
+					RunUploadPendingResume(c.Args(), &UploadOptions{
+						Parallel:     1,
+						MaxRetry:     c.Int("retry"),
+						NoSplitFile:  true,
+						ShowProgress: !c.Bool("np"),
+					})
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.IntFlag{
+						Name:  "retry",
+						Usage: "上传失败最大重试次数",
+						Value: DefaultUploadMaxRetry,
+					},
+					cli.BoolFlag{
+						Name:  "np",
+						Usage: "no progress 不展示上传进度条",
+					},
+				},
+			},
+		},
+	}
+}
+
+// selectUploading 根据编号选择未完成上传的记录, 编号为空则选择全部
+func selectUploading(ud *panupload.UploadingDatabase, ids []string) []*panupload.Uploading {
+	uploadingList := ud.List()
+	if len(ids) == 0 {
+		return uploadingList
+	}
+	list := make([]*panupload.Uploading, 0, len(ids))
+	for _, idStr := range ids {
+		id, err := strconv.Atoi(idStr)
+		if err != nil || id < 1 || id > len(uploadingList) {
+			fmt.Printf("编号错误: %s\n", idStr)
+			continue
+		}
+		list = append(list, uploadingList[id-1])
+	}
+	return list
+}
+
+// uploadingLocalStatus 检查未完成上传的本地文件是否还有效
+func uploadingLocalStatus(uploading *panupload.Uploading) string {
+	info, err := os.Stat(uploading.Path)
+	if err != nil {
+		return "本地文件不存在"
+	}
+	if uploading.ModTime != -1 && uploading.ModTime != info.ModTime().Unix() {
+		return "本地文件已修改"
+	}
+	return "可续传"
+}
+
+// uploadingSavePath 获取未完成上传的网盘保存路径, 旧的记录没有保存路径, 通过上传目录ID获取
+func uploadingSavePath(uploading *panupload.Uploading) (string, error) {
+	if uploading.SavePath != "" {
+		return uploading.SavePath, nil
+	}
+	if uploading.ParentFolderId == "" {
+		return "", fmt.Errorf("未知的网盘保存路径")
+	}
+	dir, apierr := GetActivePanClient().AppFilePathById(uploading.FamilyId, uploading.ParentFolderId)
+	if apierr != nil {
+		return "", apierr
+	}
+	return path.Join(dir, filepath.Base(uploading.Path)), nil
+}
+
+func formatAge(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%d天前", d/(24*time.Hour))
+	case d >= time.Hour:
+		return fmt.Sprintf("%d小时前", d/time.Hour)
+	default:
+		return fmt.Sprintf("%d分钟前", d/time.Minute)
+	}
+}
+
+// RunUploadPendingList 列出未完成上传的文件
+func RunUploadPendingList() {
+	ud, err := panupload.NewUploadingDatabase()
+	if err != nil {
+		fmt.Printf("打开上传未完成数据库错误: %s\n", err)
+		return
+	}
+	defer ud.Close()
+
+	uploadingList := ud.List()
+	if len(uploadingList) == 0 {
+		fmt.Println("没有未完成上传的文件")
+		return
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "本地路径", "网盘路径", "文件大小", "上传进度", "更新时间", "状态"})
+	for k, uploading := range uploadingList {
+		if uploading.LocalFileMeta == nil {
+			continue
+		}
+		progress := "-"
+		if uploading.State != nil && uploading.Length > 0 {
+			uploaded := uploading.Length - uploading.State.Left()
+			if uploaded < 0 {
+				uploaded = 0
+			}
+			progress = fmt.Sprintf("%s (%.1f%%)", converter.ConvertFileSize(uploaded, 2), float64(uploaded)*100/float64(uploading.Length))
+		}
+		updateTime := "-"
+		if uploading.UpdateTime > 0 {
+			tm := time.Unix(uploading.UpdateTime, 0)
+			updateTime = tm.Format("2006-01-02 15:04:05") + " " + formatAge(time.Since(tm))
+		}
+		savePath := uploading.SavePath
+		if savePath == "" {
+			savePath = "-"
+		}
+		tb.Append([]string{strconv.Itoa(k + 1), uploading.Path, savePath, converter.ConvertFileSize(uploading.Length, 2), progress, updateTime, uploadingLocalStatus(uploading)})
+	}
+	tb.Render()
+}
+
+// RunUploadPendingRemove 删除未完成上传的记录, olderThan 大于0时删除超过该时间未更新的记录
+func RunUploadPendingRemove(ids []string, olderThan time.Duration) {
+	ud, err := panupload.NewUploadingDatabase()
+	if err != nil {
+		fmt.Printf("打开上传未完成数据库错误: %s\n", err)
+		return
+	}
+	defer ud.Close()
+
+	var list []*panupload.Uploading
+	if olderThan > 0 {
+		for _, uploading := range ud.List() {
+			// 旧的记录没有更新时间, 一并删除
+			if uploading.UpdateTime == 0 || time.Since(time.Unix(uploading.UpdateTime, 0)) > olderThan {
+				list = append(list, uploading)
+			}
+		}
+	}
+	if len(ids) > 0 {
+		list = append(list, selectUploading(ud, ids)...)
+	}
+
+	count := 0
+	for _, uploading := range list {
+		if ud.DeleteUploading(uploading) {
+			count++
+			if uploading.LocalFileMeta != nil {
+				fmt.Printf("删除记录: %s\n", uploading.Path)
+			}
+		}
+	}
+	if err = ud.Save(); err != nil {
+		fmt.Printf("保存上传未完成数据库错误: %s\n", err)
+		return
+	}
+	fmt.Printf("共删除 %d 条记录\n", count)
+}
+
+// RunUploadPendingResume 继续上传未完成的文件
+func RunUploadPendingResume(ids []string, opt *UploadOptions) {
+	ud, err := panupload.NewUploadingDatabase()
+	if err != nil {
+		fmt.Printf("打开上传未完成数据库错误: %s\n", err)
+		return
+	}
+
+	type uploadGroup struct {
+		familyId   int64
+		saveDir    string
+		localPaths []string
+	}
+	var (
+		panClient = GetActivePanClient()
+		groups    []*uploadGroup
+		groupMap  = map[string]*uploadGroup{}
+	)
+	for _, uploading := range selectUploading(ud, ids) {
+		if uploading.LocalFileMeta == nil {
+			continue
+		}
+		if status := uploadingLocalStatus(uploading); status != "可续传" {
+			fmt.Printf("%s: %s, 删除记录\n", uploading.Path, status)
+			ud.DeleteUploading(uploading)
+			continue
+		}
+
+		savePath, err := uploadingSavePath(uploading)
+		if err != nil {
+			fmt.Printf("%s: 获取网盘保存路径失败, %s\n", uploading.Path, err)
+			continue
+		}
+
+		// 检查服务器端的上传进度
+		if uploading.UploadFileId != "" {
+			var (
+				r      *cloudpan.AppGetUploadFileStatusResult
+				apierr *apierror.ApiError
+			)
+			if uploading.FamilyId > 0 {
+				r, apierr = panClient.AppFamilyGetUploadFileStatus(uploading.FamilyId, uploading.UploadFileId)
+			} else {
+				r, apierr = panClient.AppGetUploadFileStatus(uploading.UploadFileId)
+			}
+			if apierr != nil {
+				if apierr.Code == apierror.ApiCodeUploadFileNotFound {
+					fmt.Printf("%s: 服务器端的上传记录已失效, 将重新上传\n", uploading.Path)
+				} else {
+					fmt.Printf("%s: 检查服务器端上传进度失败, %s\n", uploading.Path, apierr)
+				}
+			} else {
+				fmt.Printf("%s: 服务器端已接收 %s/%s\n", uploading.Path, converter.ConvertFileSize(r.Size, 2), converter.ConvertFileSize(uploading.Length, 2))
+			}
+		}
+
+		key := strconv.FormatInt(uploading.FamilyId, 10) + ":" + path.Dir(savePath)
+		g, ok := groupMap[key]
+		if !ok {
+			g = &uploadGroup{familyId: uploading.FamilyId, saveDir: path.Dir(savePath)}
+			groupMap[key] = g
+			groups = append(groups, g)
+		}
+		g.localPaths = append(g.localPaths, uploading.Path)
+	}
+
+	// 上传时会重新打开数据库
+	if err = ud.Save(); err != nil {
+		fmt.Printf("保存上传未完成数据库错误: %s\n", err)
+	}
+	ud.Close()
+
+	if len(groups) == 0 {
+		fmt.Println("没有需要继续上传的文件")
+		return
+	}
+	for _, g := range groups {
+		groupOpt := *opt
+		groupOpt.FamilyId = g.familyId
+		RunUpload(g.localPaths, g.saveDir, &groupOpt)
+	}
+}
@@ -16,9 +16,16 @@ package command
 import (
 	"fmt"
 	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/cmder"
 	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/taskframework"
 	"github.com/tickstep/library-go/logger"
+	"io"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
@@ -76,3 +83,64 @@ func GetFamilyCloudMark(familyId int64) string {
 	}
 	return "个人云"
 }
+
+// parseDuration 解析时间长度, 在 time.ParseDuration 的基础上支持天数, 例如: 7d, 1d12h
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	origin := s
+	var days int64
+	if idx := strings.Index(s, "d"); idx > 0 {
+		n, err := strconv.ParseInt(s[:idx], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("时间格式错误: %s", origin)
+		}
+		days = n
+		s = s[idx+1:]
+	}
+
+	d := time.Duration(days) * 24 * time.Hour
+	if s == "" {
+		return d, nil
+	}
+	d2, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("时间格式错误: %s", origin)
+	}
+	return d + d2, nil
+}
+
+// transferRetryPolicy 上传和下载任务使用的重试策略, 由配置项 transfer_retry_policy 控制
+func transferRetryPolicy() taskframework.RetryPolicy {
+	policy, err := taskframework.ParseRetryPolicy(config.Config.GetTransferRetryPolicy())
+	if err != nil {
+		panCommandVerbose.Warnf("%s, 使用默认重试策略\n", err)
+		return taskframework.DefaultRetryPolicy
+	}
+	return policy
+}
+
+// saveTransferReport 保存传输报告
+func saveTransferReport(out io.Writer, report *functions.TransferReport, reportPath string) {
+	if report == nil {
+		return
+	}
+	if err := report.Save(reportPath); err != nil {
+		fmt.Fprintf(out, "保存传输报告失败: %s\n", err)
+		return
+	}
+	fmt.Fprintf(out, "传输报告已保存: %s\n", reportPath)
+}
+
+// saveFailedList 保存失败列表, 并提示重试的命令
+func saveFailedList(out io.Writer, fl *functions.FailedList) {
+	filePath, err := fl.Save()
+	if err != nil {
+		fmt.Fprintf(out, "保存失败列表错误: %s\n", err)
+		return
+	}
+	if filePath == "" {
+		return
+	}
+	fmt.Fprintf(out, "失败的文件已保存到: %s\n", filePath)
+	fmt.Fprintf(out, "可使用以下命令只重试这些文件: %s %s --retry-failed \"%s\"\n", cmder.App().Name, fl.Command, filePath)
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panupload"
+	"github.com/tickstep/library-go/converter"
+	"github.com/urfave/cli"
+)
+
+func CmdVersions() cli.Command {
+	familyIdFlag := cli.StringFlag{
+		Name:  "familyId",
+		Usage: "家庭云ID",
+		Value: "",
+	}
+	return cli.Command{
+		Name:  "versions",
+		Usage: "文件的历史版本",
+		Description: `
+	upload/backup 指定 --keep-versions 覆盖文件时, 旧文件保存在同目录的 ` + panupload.VersionsDirName + `/<文件名>/<时间> 中.
+
+	示例:
+
+	1. 列出 /视频/1.mp4 的历史版本
+	cloudpan189-go versions ls /视频/1.mp4
+
+	2. 将 /视频/1.mp4 恢复为编号为 2 的历史版本, 当前文件会保存为新的历史版本
+	cloudpan189-go versions restore /视频/1.mp4 2
+
+	3. 将 /视频/1.mp4 恢复为最近的历史版本, 最多保留3个历史版本
+	cloudpan189-go versions restore --keep 3 /视频/1.mp4
+`,
+		Category: "天翼云盘",
+		Before:   cmder.ReloadConfigFunc,
+		Action: func(c *cli.Context) error {
+			// 在子命令中执行, 名称为空时显示子命令列表
+			cli.ShowCommandHelp(c, "")
+			return nil
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:      "ls",
+				Usage:     "列出文件的历史版本",
+				UsageText: cmder.App().Name + " versions ls <文件路径>",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						cli.ShowCommandHelp(c, "ls")
+						return nil
+					}
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					RunVersionsList(parseFamilyId(c), c.Args().Get(0))
+					return nil
+				},
+				Flags: []cli.Flag{familyIdFlag},
+			},
+			{
+				Name:      "restore",
+				Usage:     "恢复文件的历史版本",
+				UsageText: cmder.App().Name + " versions restore <文件路径> [编号]",
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 1 || c.NArg() > 2 {
+						cli.ShowCommandHelp(c, "restore")
+						return nil
+					}
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					id := 1
+					if c.NArg() == 2 {
+						n, err := strconv.Atoi(c.Args().Get(1))
+						if err != nil || n < 1 {
+							fmt.Printf("编号错误: %s\n", c.Args().Get(1))
+							return nil
+						}
+						id = n
+					}
+					RunVersionsRestore(parseFamilyId(c), c.Args().Get(0), id, c.Int("keep"))
+					return nil
+				},
+				Flags: []cli.Flag{
+					familyIdFlag,
+					cli.IntFlag{
+						Name:  "keep",
+						Usage: "恢复后最多保留的历史版本数量, 0为不限制",
+					},
+				},
+			},
+		},
+	}
+}
+
+// RunVersionsList 列出文件的历史版本
+func RunVersionsList(familyId int64, filePath string) {
+	activeUser := GetActiveUser()
+	filePath = activeUser.PathJoin(familyId, strings.TrimSpace(filePath))
+
+	versions, err := panupload.ListVersions(activeUser.PanClient(), familyId, filePath)
+	if err != nil {
+		fmt.Printf("获取历史版本失败: %s\n", err)
+		return
+	}
+	if len(versions) == 0 {
+		fmt.Printf("%s 没有历史版本\n", filePath)
+		return
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "版本时间", "文件大小", "md5"})
+	for k, v := range versions {
+		versionTime := v.Name
+		if !v.Time.IsZero() {
+			versionTime = v.Time.Format("2006-01-02 15:04:05")
+		}
+		size, md5 := "-", "-"
+		if v.File != nil {
+			size = converter.ConvertFileSize(v.File.FileSize, 2)
+			md5 = strings.ToLower(v.File.FileMd5)
+		}
+		tb.Append([]string{strconv.Itoa(k + 1), versionTime, size, md5})
+	}
+	tb.Render()
+}
+
+// RunVersionsRestore 恢复文件的历史版本, id 为 versions ls 列出的编号
+func RunVersionsRestore(familyId int64, filePath string, id, keep int) {
+	activeUser := GetActiveUser()
+	filePath = activeUser.PathJoin(familyId, strings.TrimSpace(filePath))
+
+	panClient := activeUser.PanClient()
+	versions, err := panupload.ListVersions(panClient, familyId, filePath)
+	if err != nil {
+		fmt.Printf("获取历史版本失败: %s\n", err)
+		return
+	}
+	if len(versions) == 0 {
+		fmt.Printf("%s 没有历史版本\n", filePath)
+		return
+	}
+	if id > len(versions) {
+		fmt.Printf("编号错误: %d, 共有 %d 个历史版本\n", id, len(versions))
+		return
+	}
+
+	v := versions[id-1]
+	if err = panupload.RestoreVersion(panClient, familyId, filePath, v, keep); err != nil {
+		fmt.Printf("恢复历史版本失败: %s\n", err)
+		return
+	}
+	fmt.Printf("已将 %s 恢复为 %s 的版本\n", filePath, v.Name)
+}
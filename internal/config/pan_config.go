@@ -83,6 +83,10 @@ type PanConfig struct {
 	MaxDownloadRate int64 `json:"maxDownloadRate"` // 限制最大下载速度，单位 B/s, 即字节/每秒
 	MaxUploadRate   int64 `json:"maxUploadRate"`   // 限制最大上传速度，单位 B/s, 即字节/每秒
 
+	TransferRetryPolicy  string `json:"transferRetryPolicy"`  // 上传下载失败的重试策略, constant | linear | exponential
+	TransferRetryWait    int    `json:"transferRetryWait"`    // 重试等待时间，单位秒
+	TransferRetryMaxWait int    `json:"transferRetryMaxWait"` // 重试最大等待时间，单位秒
+
 	SaveDir string `json:"saveDir"` // 下载储存路径
 
 	Proxy           string          `json:"proxy"`        // 代理
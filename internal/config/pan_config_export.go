@@ -14,9 +14,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
@@ -77,8 +79,35 @@ func (c *PanConfig) SetMaxUploadRateByStr(sizeStr string) error {
 	return nil
 }
 
+// SetTransferRetryPolicy 设置 transfer_retry_policy
+func (c *PanConfig) SetTransferRetryPolicy(policy string) error {
+	policy = strings.ToLower(policy)
+	switch policy {
+	case "constant", "linear", "exponential":
+		c.TransferRetryPolicy = policy
+		return nil
+	}
+	return fmt.Errorf("未知的重试策略: %s", policy)
+}
+
+// GetTransferRetryPolicy 获取上传下载失败的重试策略和等待时间, 未设置时依次等待 2, 4, 6, 6... 秒
+func (c *PanConfig) GetTransferRetryPolicy() (policy string, wait, maxWait time.Duration) {
+	policy, wait, maxWait = c.TransferRetryPolicy, time.Duration(c.TransferRetryWait)*time.Second, time.Duration(c.TransferRetryMaxWait)*time.Second
+	if policy == "" {
+		policy = "linear"
+	}
+	if wait <= 0 {
+		wait = 2 * time.Second
+	}
+	if maxWait <= 0 {
+		maxWait = 6 * time.Second
+	}
+	return
+}
+
 // PrintTable 输出表格
 func (c *PanConfig) PrintTable() {
+	policy, wait, maxWait := c.GetTransferRetryPolicy()
 	tb := cmdtable.NewTable(os.Stdout)
 	tb.SetHeader([]string{"名称", "值", "建议值", "描述"})
 	tb.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
@@ -89,6 +118,9 @@ func (c *PanConfig) PrintTable() {
 		[]string{"max_upload_parallel", strconv.Itoa(c.MaxUploadParallel), "1 ~ 20", "最大上传并发量，即同时上传文件最大数量"},
 		[]string{"max_download_rate", showMaxRate(c.MaxDownloadRate), "", "限制最大下载速度, 0代表不限制"},
 		[]string{"max_upload_rate", showMaxRate(c.MaxUploadRate), "", "限制最大上传速度, 0代表不限制"},
+		[]string{"transfer_retry_policy", policy, "constant | linear | exponential", "上传下载失败的重试策略: 固定等待时间, 线性增长或指数增长"},
+		[]string{"transfer_retry_wait", strconv.Itoa(int(wait / time.Second)), "1 ~ 10", "重试等待时间(秒), 线性和指数策略中为初始等待时间"},
+		[]string{"transfer_retry_max_wait", strconv.Itoa(int(maxWait / time.Second)), "6 ~ 300", "重试最大等待时间(秒)"},
 		[]string{"savedir", c.SaveDir, "", "下载文件的储存目录"},
 		[]string{"proxy", c.Proxy, "", "设置代理, 支持 http/socks5 代理，例如：http://127.0.0.1:8888"},
 		[]string{"local_addrs", c.LocalAddrs, "", "设置本地网卡地址, 多个地址用逗号隔开"},
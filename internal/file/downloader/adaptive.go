@@ -0,0 +1,158 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloader
+
+import (
+	"sync/atomic"
+)
+
+const (
+	// AdaptiveSampleCount 每个调整周期的采样次数, 监控器每秒采样一次
+	AdaptiveSampleCount = 3
+	// AdaptiveRiseRatio 速度提升超过该比例才继续增加线程
+	AdaptiveRiseRatio = 0.1
+	// AdaptiveDropRatio 速度下降超过该比例则减少线程
+	AdaptiveDropRatio = 0.25
+	// AdaptiveProbePeriods 速度稳定多少个周期后再次尝试增加线程
+	AdaptiveProbePeriods = 5
+	// AdaptiveCooldownPeriods 减少线程后保持多少个周期不调整
+	AdaptiveCooldownPeriods = 2
+)
+
+// AdaptiveController 单个文件下载线程数的自适应控制.
+// 总速度持续上升时增加线程, 遇到 429/5xx 或速度下降时减少线程
+type AdaptiveController struct {
+	min, max   int
+	target     int
+	samples    int
+	sum        int64
+	lastSpeeds int64 // 上一个周期的平均速度
+	rising     bool  // 上一次调整是否为增加线程
+	stable     int   // 速度稳定的周期数
+	cooldown   int
+	throttled  int32
+}
+
+// NewAdaptiveController 初始化, initial 为初始线程数
+func NewAdaptiveController(initial, min, max int) *AdaptiveController {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	if initial < min {
+		initial = min
+	}
+	if initial > max {
+		initial = max
+	}
+	return &AdaptiveController{
+		min:    min,
+		max:    max,
+		target: initial,
+	}
+}
+
+// Target 目标线程数
+func (ac *AdaptiveController) Target() int {
+	return ac.target
+}
+
+// Throttled 记录一次服务器限流(429/5xx), 可在 worker 中并发调用
+func (ac *AdaptiveController) Throttled() {
+	atomic.StoreInt32(&ac.throttled, 1)
+}
+
+// Reject 上一次增加线程未能执行, 例如连接数预算不足
+func (ac *AdaptiveController) Reject() {
+	if ac.target > ac.min {
+		ac.target--
+	}
+	ac.rising = false
+	ac.stable = 0
+}
+
+// Sample 采样当前的总速度, 返回线程数的调整量: 1 增加, -1 减少, 0 不变
+func (ac *AdaptiveController) Sample(speeds int64) int {
+	// 限流马上减少线程
+	if atomic.SwapInt32(&ac.throttled, 0) == 1 {
+		ac.resetPeriod()
+		ac.lastSpeeds = 0
+		ac.rising = false
+		ac.stable = 0
+		ac.cooldown = AdaptiveCooldownPeriods
+		return ac.adjust(-1)
+	}
+
+	ac.samples++
+	ac.sum += speeds
+	if ac.samples < AdaptiveSampleCount {
+		return 0
+	}
+	avg := ac.sum / int64(ac.samples)
+	ac.resetPeriod()
+
+	last := ac.lastSpeeds
+	ac.lastSpeeds = avg
+	if ac.cooldown > 0 {
+		ac.cooldown--
+		return 0
+	}
+	if last <= 0 {
+		// 第一个周期, 试探增加
+		ac.rising = true
+		return ac.adjust(1)
+	}
+
+	switch {
+	case float64(avg) >= float64(last)*(1+AdaptiveRiseRatio):
+		ac.stable = 0
+		if ac.rising {
+			return ac.adjust(1)
+		}
+	case float64(avg) <= float64(last)*(1-AdaptiveDropRatio):
+		ac.rising = false
+		ac.stable = 0
+		ac.cooldown = AdaptiveCooldownPeriods
+		return ac.adjust(-1)
+	default:
+		// 增加线程没有带来提升, 停止增加
+		ac.rising = false
+		ac.stable++
+		if ac.stable >= AdaptiveProbePeriods {
+			ac.stable = 0
+			ac.rising = true
+			return ac.adjust(1)
+		}
+	}
+	return 0
+}
+
+func (ac *AdaptiveController) resetPeriod() {
+	ac.samples = 0
+	ac.sum = 0
+}
+
+func (ac *AdaptiveController) adjust(delta int) int {
+	n := ac.target + delta
+	if n < ac.min || n > ac.max {
+		if delta > 0 {
+			ac.rising = false
+		}
+		return 0
+	}
+	ac.target = n
+	return delta
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloader_test
+
+import (
+	"testing"
+
+	"github.com/tickstep/cloudpan189-go/internal/file/downloader"
+)
+
+// sample 采样一个完整周期, 返回周期结束时的调整量
+func sample(ac *downloader.AdaptiveController, speeds int64) int {
+	delta := 0
+	for i := 0; i < downloader.AdaptiveSampleCount; i++ {
+		delta = ac.Sample(speeds)
+	}
+	return delta
+}
+
+func TestAdaptiveControllerGrowWhileRising(t *testing.T) {
+	ac := downloader.NewAdaptiveController(1, 1, 4)
+	speeds := int64(100)
+	for i := 0; i < 10; i++ {
+		sample(ac, speeds)
+		speeds *= 2
+	}
+	if ac.Target() != 4 {
+		t.Fatalf("target = %d, want 4", ac.Target())
+	}
+}
+
+func TestAdaptiveControllerStopWhenFlat(t *testing.T) {
+	ac := downloader.NewAdaptiveController(2, 1, 8)
+	if d := sample(ac, 100); d != 1 {
+		t.Fatalf("first period delta = %d, want 1", d)
+	}
+	// 增加线程后速度没有提升, 不再增加
+	for i := 0; i < downloader.AdaptiveProbePeriods-1; i++ {
+		if d := sample(ac, 100); d != 0 {
+			t.Fatalf("flat period %d delta = %d, want 0", i, d)
+		}
+	}
+	if ac.Target() != 3 {
+		t.Fatalf("target = %d, want 3", ac.Target())
+	}
+	// 稳定一段时间后再次试探
+	if d := sample(ac, 100); d != 1 {
+		t.Fatalf("probe delta = %d, want 1", d)
+	}
+}
+
+func TestAdaptiveControllerShrinkOnDrop(t *testing.T) {
+	ac := downloader.NewAdaptiveController(3, 1, 8)
+	sample(ac, 1000)
+	if d := sample(ac, 500); d != -1 {
+		t.Fatalf("drop delta = %d, want -1", d)
+	}
+	if ac.Target() != 3 {
+		t.Fatalf("target = %d, want 3", ac.Target())
+	}
+	// 冷却期间不调整
+	for i := 0; i < downloader.AdaptiveCooldownPeriods; i++ {
+		if d := sample(ac, 100); d != 0 {
+			t.Fatalf("cooldown delta = %d, want 0", d)
+		}
+	}
+}
+
+func TestAdaptiveControllerShrinkOnThrottle(t *testing.T) {
+	ac := downloader.NewAdaptiveController(3, 1, 8)
+	ac.Throttled()
+	if d := ac.Sample(1000); d != -1 {
+		t.Fatalf("throttle delta = %d, want -1", d)
+	}
+	if ac.Target() != 2 {
+		t.Fatalf("target = %d, want 2", ac.Target())
+	}
+
+	// 不低于最小值
+	ac = downloader.NewAdaptiveController(1, 1, 8)
+	ac.Throttled()
+	if d := ac.Sample(1000); d != 0 || ac.Target() != 1 {
+		t.Fatalf("delta = %d, target = %d, want 0, 1", d, ac.Target())
+	}
+}
+
+func TestAdaptiveControllerReject(t *testing.T) {
+	ac := downloader.NewAdaptiveController(1, 1, 8)
+	if d := sample(ac, 100); d != 1 {
+		t.Fatalf("delta = %d, want 1", d)
+	}
+	ac.Reject()
+	if ac.Target() != 1 {
+		t.Fatalf("target = %d, want 1", ac.Target())
+	}
+	// 被拒绝后, 速度上升也不继续增加
+	if d := sample(ac, 1000); d != 0 {
+		t.Fatalf("delta = %d, want 0", d)
+	}
+}
+
+func TestConnectionBudget(t *testing.T) {
+	cb := downloader.NewConnectionBudget(2)
+	cb.Acquire(1)
+	if !cb.TryAcquire() {
+		t.Fatal("second acquire should succeed")
+	}
+	if cb.TryAcquire() {
+		t.Fatal("third acquire should fail")
+	}
+	if cb.Available() != 0 {
+		t.Fatalf("available = %d, want 0", cb.Available())
+	}
+	cb.Release()
+	if cb.Available() != 1 || cb.InUse() != 1 {
+		t.Fatalf("available = %d, in use = %d", cb.Available(), cb.InUse())
+	}
+
+	var unlimited *downloader.ConnectionBudget
+	if !unlimited.TryAcquire() || unlimited.Available() != -1 {
+		t.Fatal("nil budget should be unlimited")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloader
+
+import (
+	"sync"
+)
+
+// ConnectionBudget 连接数预算, 多个文件同时下载时共享, 限制总的连接数
+type ConnectionBudget struct {
+	mu    sync.Mutex
+	max   int
+	inUse int
+}
+
+// NewConnectionBudget 初始化连接数预算, max<=0 表示不限制
+func NewConnectionBudget(max int) *ConnectionBudget {
+	return &ConnectionBudget{
+		max: max,
+	}
+}
+
+// TryAcquire 尝试占用一个连接, 超出预算返回false
+func (cb *ConnectionBudget) TryAcquire() bool {
+	if cb == nil {
+		return true
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.max > 0 && cb.inUse >= cb.max {
+		return false
+	}
+	cb.inUse++
+	return true
+}
+
+// Acquire 强制占用n个连接, 用于每个文件必需的连接, 可以超出预算
+func (cb *ConnectionBudget) Acquire(n int) {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.inUse += n
+}
+
+// Release 释放一个连接
+func (cb *ConnectionBudget) Release() {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.inUse > 0 {
+		cb.inUse--
+	}
+}
+
+// Available 剩余可用的连接数, 不限制时返回-1
+func (cb *ConnectionBudget) Available() int {
+	if cb == nil || cb.max <= 0 {
+		return -1
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.inUse >= cb.max {
+		return 0
+	}
+	return cb.max - cb.inUse
+}
+
+// InUse 已占用的连接数
+func (cb *ConnectionBudget) InUse() int {
+	if cb == nil {
+		return 0
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.inUse
+}
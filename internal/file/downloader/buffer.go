@@ -0,0 +1,50 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloader
+
+import (
+	"github.com/tickstep/library-go/cachepool"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// bufferSize 下载缓存的大小
+	bufferSize int64 = CacheSize
+
+	// bufferPool 下载缓存池, library-go 的 cachepool.SyncPool 创建缓存时无锁写全局变量, 多个线程同时启动会有数据竞争
+	bufferPool = sync.Pool{
+		New: func() interface{} {
+			return cachepool.RawMallocByteSlice(int(atomic.LoadInt64(&bufferSize)))
+		},
+	}
+)
+
+// setBufferSize 调整下载缓存的大小
+func setBufferSize(size int) {
+	atomic.StoreInt64(&bufferSize, int64(size))
+}
+
+// getBuffer 从缓存池获取下载缓存, 大小已调整的旧缓存丢弃
+func getBuffer() []byte {
+	buf := bufferPool.Get().([]byte)
+	if size := int(atomic.LoadInt64(&bufferSize)); len(buf) != size {
+		return cachepool.RawMallocByteSlice(size)
+	}
+	return buf
+}
+
+func putBuffer(buf []byte) {
+	bufferPool.Put(buf)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloader
+
+import (
+	"hash"
+	"io"
+	"sync"
+)
+
+type (
+	// ChecksumWriter 边下载边计算校验值的 Writer.
+	// 按顺序写入的数据即时计算, 多线程下载乱序写入的部分在下载完成后从文件读取补齐
+	ChecksumWriter struct {
+		writer Writer
+		hash   hash.Hash
+		offset int64 // 已计算校验值的数据长度
+		mu     sync.Mutex
+	}
+)
+
+// NewChecksumWriter 初始化 ChecksumWriter
+func NewChecksumWriter(writer Writer, h hash.Hash) *ChecksumWriter {
+	return &ChecksumWriter{
+		writer: writer,
+		hash:   h,
+	}
+}
+
+// WriteAt 写入数据, 并计算校验值
+func (cw *ChecksumWriter) WriteAt(p []byte, off int64) (n int, err error) {
+	n, err = cw.writer.WriteAt(p, off)
+	if n <= 0 {
+		return
+	}
+
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	end := off + int64(n)
+	if off <= cw.offset && end > cw.offset {
+		cw.hash.Write(p[cw.offset-off : n])
+		cw.offset = end
+	}
+	return
+}
+
+// Unwrap 返回被包装的 Writer
+func (cw *ChecksumWriter) Unwrap() Writer {
+	return cw.writer
+}
+
+// Sum 返回全部数据的校验值, 未能即时计算的部分从 readerAt 读取
+func (cw *ChecksumWriter) Sum(readerAt io.ReaderAt, size int64) ([]byte, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	if cw.offset < size {
+		n, err := io.Copy(cw.hash, io.NewSectionReader(readerAt, cw.offset, size-cw.offset))
+		cw.offset += n
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cw.hash.Sum(nil), nil
+}
+
+// unwrapWriter 获取最内层的 Writer
+func unwrapWriter(writer io.WriterAt) io.WriterAt {
+	for {
+		w, ok := writer.(interface{ Unwrap() Writer })
+		if !ok {
+			return writer
+		}
+		writer = w.Unwrap()
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloader_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tickstep/cloudpan189-go/internal/file/downloader"
+)
+
+// errReaderAt 读取时返回错误, 用于确认校验值已全部即时计算
+type errReaderAt struct{}
+
+func (errReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errors.New("unexpected read")
+}
+
+func checksumTestData() []byte {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func openChecksumTestFile(t *testing.T) *os.File {
+	f, err := os.Create(filepath.Join(t.TempDir(), "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// writeChunks 按顺序写入 data 中 [start, end) 的分片
+func writeChunks(t *testing.T, cw *downloader.ChecksumWriter, data []byte, chunks [][2]int) {
+	for _, r := range chunks {
+		if _, err := cw.WriteAt(data[r[0]:r[1]], int64(r[0])); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestChecksumWriterInOrder(t *testing.T) {
+	data := checksumTestData()
+	f := openChecksumTestFile(t)
+	cw := downloader.NewChecksumWriter(f, md5.New())
+	if cw.Unwrap() != f {
+		t.Error("Unwrap() should return the wrapped writer")
+	}
+
+	// 重试时重复写入已计算的部分, 只计算新的数据
+	writeChunks(t, cw, data, [][2]int{{0, 300}, {300, 500}, {400, 700}, {0, 100}, {700, 1000}})
+	sum, err := cw.Sum(errReaderAt{}, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := md5.Sum(data); !bytes.Equal(sum, want[:]) {
+		t.Errorf("Sum() = %x, want %x", sum, want)
+	}
+}
+
+func TestChecksumWriterOutOfOrder(t *testing.T) {
+	data := checksumTestData()
+	f := openChecksumTestFile(t)
+	cw := downloader.NewChecksumWriter(f, md5.New())
+
+	// 多线程下载: 后面的分片先写入, 只有开头连续的 0-300 能即时计算
+	outOfOrder := [][2]int{{500, 1000}, {0, 200}, {300, 500}, {200, 300}}
+	writeChunks(t, cw, data, outOfOrder)
+	// 剩余部分需要从文件读取
+	if _, err := cw.Sum(errReaderAt{}, int64(len(data))); err == nil {
+		t.Fatal("expected the rest of the file to be read")
+	}
+
+	cw = downloader.NewChecksumWriter(f, md5.New())
+	writeChunks(t, cw, data, outOfOrder)
+	sum, err := cw.Sum(f, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := md5.Sum(data); !bytes.Equal(sum, want[:]) {
+		t.Errorf("Sum() = %x, want %x", sum, want)
+	}
+}
@@ -26,8 +26,11 @@ var (
 	// MinParallelSize 单个线程最小的数据量
 	MinParallelSize int64 = 1 * 1024 * 1024 // 1MB
 
-	// MaxParallelWorkerCount 单个文件下载最大并发线程数量
+	// MaxParallelWorkerCount 单个文件下载初始的并发线程数量
 	MaxParallelWorkerCount int = 3
+
+	// MaxAdaptiveWorkerCount 单个文件下载自适应调整的最大并发线程数量
+	MaxAdaptiveWorkerCount int = 8
 )
 
 //Config 下载配置
@@ -42,6 +45,9 @@ type Config struct {
 	TryHTTP                    bool                       // 是否尝试使用 http 连接
 	ShowProgress               bool                       // 是否展示下载进度条
 	ExcludeNames               []string                   // 排除的文件名，包括文件夹和文件。即这些文件/文件夹不进行下载，支持正则表达式
+	ConnBudget                 *ConnectionBudget          // 所有文件共享的连接数预算, 为空则不限制
+
+	Prealloc bool // 下载前预先分配整个文件的磁盘空间, 不支持时退回到修剪文件
 }
 
 //NewConfig 返回默认配置
@@ -0,0 +1,52 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloader
+
+import (
+	"sync/atomic"
+)
+
+type (
+	// CountWriter 统计写入数据量的 Writer
+	CountWriter struct {
+		writer Writer
+		count  int64
+	}
+)
+
+// NewCountWriter 初始化 CountWriter
+func NewCountWriter(writer Writer) *CountWriter {
+	return &CountWriter{
+		writer: writer,
+	}
+}
+
+// WriteAt 写入数据, 并统计写入的数据量
+func (cw *CountWriter) WriteAt(p []byte, off int64) (n int, err error) {
+	n, err = cw.writer.WriteAt(p, off)
+	if n > 0 {
+		atomic.AddInt64(&cw.count, int64(n))
+	}
+	return
+}
+
+// Count 返回已写入的数据量
+func (cw *CountWriter) Count() int64 {
+	return atomic.LoadInt64(&cw.count)
+}
+
+// Unwrap 返回被包装的 Writer
+func (cw *CountWriter) Unwrap() Writer {
+	return cw.writer
+}
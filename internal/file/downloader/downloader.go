@@ -21,7 +21,6 @@ import (
 	"github.com/tickstep/cloudpan189-go/cmder/cmdutil"
 	"github.com/tickstep/cloudpan189-go/internal/waitgroup"
 	"github.com/tickstep/cloudpan189-go/library/requester/transfer"
-	"github.com/tickstep/library-go/cachepool"
 	"github.com/tickstep/library-go/logger"
 	"github.com/tickstep/library-go/prealloc"
 	"github.com/tickstep/library-go/requester"
@@ -49,6 +48,7 @@ type (
 		onDownloadStatusEvent DownloadStatusFunc //状态处理事件
 
 		monitorCancelFunc context.CancelFunc
+		ctx               context.Context // 取消后停止下载, 保留断点续传信息
 
 		fileInfo                *cloudpan.AppFileEntity // 下载的文件信息
 		familyId                int64
@@ -57,6 +57,7 @@ type (
 		statusCodeBodyCheckFunc StatusCodeBodyCheckFunc
 		executeTime             time.Time
 		loadBalansers           []string
+		endpoints               *EndpointList // 下载节点
 		writer                  io.WriterAt
 		client                  *requester.HTTPClient
 		panClient               *cloudpan.PanClient
@@ -82,6 +83,11 @@ func NewDownloader(writer io.WriterAt, config *Config, p *cloudpan.PanClient) (d
 	return
 }
 
+// SetContext 设置下载的 context, ctx 取消后停止下载
+func (der *Downloader) SetContext(ctx context.Context) {
+	der.ctx = ctx
+}
+
 //SetClient 设置http客户端
 func (der *Downloader) SetFileInfo(f *cloudpan.AppFileEntity) {
 	der.fileInfo = f
@@ -123,6 +129,12 @@ func (der *Downloader) lazyInit() {
 	if der.loadBalancerCompareFunc == nil {
 		der.loadBalancerCompareFunc = DefaultLoadBalancerCompareFunc
 	}
+	if der.ctx == nil {
+		der.ctx = context.Background()
+	}
+	if der.endpoints == nil {
+		der.endpoints = NewEndpointList()
+	}
 }
 
 // SelectParallel 获取合适的 parallel
@@ -145,6 +157,21 @@ func (der *Downloader) SelectParallel(single bool, maxParallel int, totalSize in
 	return
 }
 
+// limitParallelByBudget 其他文件占用了连接数预算时, 先减少初始线程数, 之后再自适应增加.
+// 断点续传的range需要全部分配, 不受限制
+func (der *Downloader) limitParallelByBudget(parallel int, instanceRangeList transfer.RangeList) int {
+	if len(instanceRangeList) > 0 {
+		return parallel
+	}
+	if available := der.config.ConnBudget.Available(); available >= 0 && parallel > available {
+		parallel = available
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+	return parallel
+}
+
 // SelectBlockSizeAndInitRangeGen 获取合适的 BlockSize, 和初始化 RangeGen
 func (der *Downloader) SelectBlockSizeAndInitRangeGen(single bool, status *transfer.DownloadStatus, parallel int) (blockSize int64, initErr error) {
 	// Range 生成器
@@ -189,6 +216,20 @@ func (der *Downloader) SelectCacheSize(confCacheSize int, blockSize int64) (cach
 	return
 }
 
+// preAlloc 预分配文件空间, 开启 Prealloc 时优先使用 fallocate, 失败则修剪文件
+func (der *Downloader) preAlloc(fd uintptr, size int64) {
+	if der.config.Prealloc {
+		err := fallocate(fd, size)
+		if err == nil {
+			return
+		}
+		logger.Verbosef("DEBUG: fallocate file error: %s, fallback to truncate\n", err)
+	}
+	if err := prealloc.PreAlloc(fd, size); err != nil {
+		logger.Verbosef("DEBUG: truncate file error: %s\n", err)
+	}
+}
+
 // DefaultDURLCheckFunc 默认的 DURLCheckFunc
 func DefaultDURLCheckFunc(client *requester.HTTPClient, durl string) (contentLength int64, resp *http.Response, err error) {
 	resp, err = client.Req(http.MethodGet, durl, nil, nil)
@@ -222,11 +263,6 @@ func (der *Downloader) checkLoadBalancers() *LoadBalancerResponseList {
 		}
 	)
 
-	// 加入第一个
-	loadBalancerResponses = append(loadBalancerResponses, &LoadBalancerResponse{
-		URL: "der.durl",
-	})
-
 	// 负载均衡
 	wg := waitgroup.NewWaitGroup(10)
 	privTimeout := der.client.Client.Timeout
@@ -280,9 +316,64 @@ func (der *Downloader) checkLoadBalancers() *LoadBalancerResponseList {
 	return loadBalancerResponseList
 }
 
+// fetchEndpoint 从接口获取一个下载链接, 并记录到下载节点列表.
+// 获取失败时使用已有的节点, 都没有则返回nil
+func (der *Downloader) fetchEndpoint() *Endpoint {
+	var durl string
+	var apierr *apierror.ApiError
+	if der.familyId > 0 {
+		durl, apierr = der.panClient.AppFamilyGetFileDownloadUrl(der.familyId, der.fileInfo.FileId)
+	} else {
+		durl, apierr = der.panClient.AppGetFileDownloadUrl(der.fileInfo.FileId)
+	}
+	time.Sleep(time.Duration(200) * time.Millisecond)
+	if apierr == nil && durl != "" {
+		return der.endpoints.Add(durl)
+	}
+	logger.Verbosef("ERROR: get download url error: %s\n", der.fileInfo.FileId)
+
+	if best := der.endpoints.Best(); best != nil {
+		return best
+	}
+	var first *Endpoint
+	der.endpoints.Range(func(ep *Endpoint) bool {
+		first = ep
+		return false
+	})
+	return first
+}
+
+// newWorker 创建使用指定下载节点的worker
+func (der *Downloader) newWorker(id int, endpoint *Endpoint, writer io.WriterAt, writeMu *sync.Mutex) *Worker {
+	client := requester.NewHTTPClient()
+	client.SetKeepAlive(true)
+	client.SetTimeout(10 * time.Minute)
+
+	worker := NewWorker(id, der.familyId, der.fileInfo.FileId, endpoint.URL(), writer)
+	worker.SetClient(client)
+	worker.SetPanClient(der.panClient)
+	worker.SetEndpointList(der.endpoints)
+	worker.SetEndpoint(endpoint)
+	worker.SetWriteMutex(writeMu)
+	worker.SetTotalSize(der.fileInfo.FileSize)
+	worker.SetAcceptRange("bytes")
+	return worker
+}
+
+// RangeEndpoint 遍历下载节点
+func (der *Downloader) RangeEndpoint(f func(ep *Endpoint) bool) {
+	if der.endpoints == nil {
+		return
+	}
+	der.endpoints.Range(f)
+}
+
 //Execute 开始任务
 func (der *Downloader) Execute() error {
 	der.lazyInit()
+	if err := der.ctx.Err(); err != nil {
+		return err
+	}
 
 	var (
 		loadBalancerResponseList = der.checkLoadBalancers()
@@ -322,13 +413,17 @@ func (der *Downloader) Execute() error {
 
 	// 数据处理
 	parallel := der.SelectParallel(single, MaxParallelWorkerCount, status.TotalSize(), bii.Ranges) // 实际的下载并行量
+	parallel = der.limitParallelByBudget(parallel, bii.Ranges)                                     // 受连接数预算限制
 	blockSize, err := der.SelectBlockSizeAndInitRangeGen(single, status, parallel)                 // 实际的BlockSize
 	if err != nil {
 		return err
 	}
 
+	// 自适应调整线程数的上限
+	maxWorkers := der.SelectParallel(single, MaxAdaptiveWorkerCount, status.TotalSize(), nil)
+
 	cacheSize := der.SelectCacheSize(der.config.CacheSize, blockSize) // 实际下载缓存
-	cachepool.SetSyncPoolSize(cacheSize)                              // 调整pool大小
+	setBufferSize(cacheSize)                                          // 调整pool大小
 
 	logger.Verbosef("DEBUG: download task CREATED: parallel: %d, cache size: %d\n", parallel, cacheSize)
 
@@ -336,11 +431,8 @@ func (der *Downloader) Execute() error {
 
 	var writer Writer
 	// 尝试修剪文件
-	if fder, ok := der.writer.(Fder); ok {
-		err = prealloc.PreAlloc(fder.Fd(), status.TotalSize())
-		if err != nil {
-			logger.Verbosef("DEBUG: truncate file error: %s\n", err)
-		}
+	if fder, ok := unwrapWriter(der.writer).(Fder); ok {
+		der.preAlloc(fder.Fd(), status.TotalSize())
 	}
 	writer = der.writer
 
@@ -364,50 +456,51 @@ func (der *Downloader) Execute() error {
 		}
 	}
 
+	// 负载均衡服务器也作为可选的下载节点
+	for _, lbr := range loadBalancerResponseList.lbr {
+		der.endpoints.Add(lbr.URL)
+	}
+
 	var (
 		writeMu = &sync.Mutex{}
 	)
 	for k, r := range bii.Ranges {
-		loadBalancer := loadBalancerResponseList.SequentialGet()
-		if loadBalancer == nil {
+		// 每个线程单独获取下载链接, 不同的链接可能指向不同的下载节点
+		endpoint := der.fetchEndpoint()
+		if endpoint == nil {
 			continue
 		}
-
-		// 获取下载链接
-		var durl string
-		var apierr *apierror.ApiError
-		if der.familyId > 0 {
-			durl, apierr = der.panClient.AppFamilyGetFileDownloadUrl(der.familyId, der.fileInfo.FileId)
-		} else {
-			durl, apierr = der.panClient.AppGetFileDownloadUrl(der.fileInfo.FileId)
-		}
-		time.Sleep(time.Duration(200) * time.Millisecond)
-		if apierr != nil {
-			logger.Verbosef("ERROR: get download url error: %s\n", der.fileInfo.FileId)
-			continue
-		}
-		logger.Verbosef("work id: %d, download url: %s\n", k, durl)
-		client := requester.NewHTTPClient()
-		client.SetKeepAlive(true)
-		client.SetTimeout(10 * time.Minute)
-
-		worker := NewWorker(k, der.familyId, der.fileInfo.FileId, durl, writer)
-		worker.SetClient(client)
-		worker.SetPanClient(der.panClient)
-		worker.SetWriteMutex(writeMu)
-		worker.SetTotalSize(der.fileInfo.FileSize)
-
-		worker.SetAcceptRange("bytes")
+		logger.Verbosef("work id: %d, download url: %s\n", k, endpoint.URL())
+		worker := der.newWorker(k, endpoint, writer, writeMu)
 		worker.SetRange(r) // 分配Range
+		der.config.ConnBudget.Acquire(1)
 		der.monitor.Append(worker)
 	}
+	logger.Verbosef("DEBUG: download endpoints: %d\n", der.endpoints.Len())
 
+	der.monitor.SetEndpointList(der.endpoints)
 	der.monitor.SetStatus(status)
 
 	// 服务器不支持断点续传, 或者单线程下载, 都不重载worker
-	der.monitor.SetReloadWorker(parallel > 1)
+	der.monitor.SetReloadWorker(parallel > 1 || maxWorkers > 1)
+	der.monitor.SetConnectionBudget(der.config.ConnBudget)
+	if !single && maxWorkers > 1 {
+		der.monitor.SetAdaptive(NewAdaptiveController(parallel, 1, maxWorkers), func(id int) *Worker {
+			endpoint := der.endpoints.Best()
+			if endpoint == nil {
+				der.endpoints.Range(func(ep *Endpoint) bool {
+					endpoint = ep
+					return false
+				})
+			}
+			if endpoint == nil {
+				return nil
+			}
+			return der.newWorker(id, endpoint, writer, writeMu)
+		})
+	}
 
-	moniterCtx, moniterCancelFunc := context.WithCancel(context.Background())
+	moniterCtx, moniterCancelFunc := context.WithCancel(der.ctx)
 	der.monitorCancelFunc = moniterCancelFunc
 
 	der.monitor.SetInstanceState(der.instanceState)
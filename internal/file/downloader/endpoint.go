@@ -0,0 +1,198 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloader
+
+import (
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// EndpointSwitchRatio 当前节点评分低于最优节点的 1/EndpointSwitchRatio 时切换
+	EndpointSwitchRatio = 3
+)
+
+type (
+	// Endpoint 下载节点, 同一主机的下载链接视为同一个节点
+	Endpoint struct {
+		host     string
+		url      atomic.Value // 最近一次获取的下载链接
+		requests int64
+		errors   int64
+		bytes    int64
+		readTime int64 // 读取数据耗费的时间, 纳秒
+	}
+
+	// EndpointList 下载节点列表
+	EndpointList struct {
+		mu        sync.Mutex
+		endpoints []*Endpoint
+	}
+)
+
+// EndpointHost 获取下载链接对应的节点
+func EndpointHost(durl string) string {
+	u, err := url.Parse(durl)
+	if err != nil || u.Host == "" {
+		return durl
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// Host 节点主机
+func (ep *Endpoint) Host() string {
+	return ep.host
+}
+
+// URL 节点最近一次的下载链接
+func (ep *Endpoint) URL() string {
+	u, _ := ep.url.Load().(string)
+	return u
+}
+
+// Requests 请求次数
+func (ep *Endpoint) Requests() int64 {
+	return atomic.LoadInt64(&ep.requests)
+}
+
+// Errors 出错次数
+func (ep *Endpoint) Errors() int64 {
+	return atomic.LoadInt64(&ep.errors)
+}
+
+// Downloaded 从该节点下载的数据量
+func (ep *Endpoint) Downloaded() int64 {
+	return atomic.LoadInt64(&ep.bytes)
+}
+
+// AddRequest 记录一次请求
+func (ep *Endpoint) AddRequest() {
+	atomic.AddInt64(&ep.requests, 1)
+}
+
+// AddError 记录一次错误
+func (ep *Endpoint) AddError() {
+	atomic.AddInt64(&ep.errors, 1)
+}
+
+// AddData 记录读取的数据量和耗时
+func (ep *Endpoint) AddData(n int64, d time.Duration) {
+	atomic.AddInt64(&ep.bytes, n)
+	atomic.AddInt64(&ep.readTime, int64(d))
+}
+
+// SpeedsPerSecond 单连接的平均速度
+func (ep *Endpoint) SpeedsPerSecond() int64 {
+	readTime := atomic.LoadInt64(&ep.readTime)
+	if readTime <= 0 {
+		return 0
+	}
+	return int64(float64(atomic.LoadInt64(&ep.bytes)) / time.Duration(readTime).Seconds())
+}
+
+// ErrorRate 错误率
+func (ep *Endpoint) ErrorRate() float64 {
+	requests := ep.Requests()
+	if requests <= 0 {
+		return 0
+	}
+	rate := float64(ep.Errors()) / float64(requests)
+	if rate > 1 {
+		rate = 1
+	}
+	return rate
+}
+
+// Measured 是否已有测速数据
+func (ep *Endpoint) Measured() bool {
+	return atomic.LoadInt64(&ep.readTime) > 0 || ep.Errors() > 0
+}
+
+// Score 节点评分, 平均速度按错误率折算
+func (ep *Endpoint) Score() int64 {
+	return int64(float64(ep.SpeedsPerSecond()) * (1 - ep.ErrorRate()))
+}
+
+// NewEndpointList 初始化下载节点列表
+func NewEndpointList() *EndpointList {
+	return &EndpointList{}
+}
+
+// Add 加入下载链接, 返回对应的节点, 同一节点只保留最新的链接
+func (el *EndpointList) Add(durl string) *Endpoint {
+	host := EndpointHost(durl)
+
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	for _, ep := range el.endpoints {
+		if ep.host == host {
+			ep.url.Store(durl)
+			return ep
+		}
+	}
+	ep := &Endpoint{host: host}
+	ep.url.Store(durl)
+	el.endpoints = append(el.endpoints, ep)
+	return ep
+}
+
+// Len 节点数量
+func (el *EndpointList) Len() int {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	return len(el.endpoints)
+}
+
+// Best 获取评分最高的节点, 没有测速数据的节点不参与比较
+func (el *EndpointList) Best() *Endpoint {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	var best *Endpoint
+	for _, ep := range el.endpoints {
+		if !ep.Measured() {
+			continue
+		}
+		if best == nil || ep.Score() > best.Score() {
+			best = ep
+		}
+	}
+	return best
+}
+
+// Range 遍历节点
+func (el *EndpointList) Range(f func(ep *Endpoint) bool) {
+	el.mu.Lock()
+	endpoints := make([]*Endpoint, len(el.endpoints))
+	copy(endpoints, el.endpoints)
+	el.mu.Unlock()
+
+	for _, ep := range endpoints {
+		if !f(ep) {
+			break
+		}
+	}
+}
+
+// ShouldSwitch 判断从 cur 节点切换到 best 节点是否值得
+func ShouldSwitch(cur, best *Endpoint) bool {
+	if best == nil || cur == nil || cur == best {
+		return false
+	}
+	if !cur.Measured() {
+		return false
+	}
+	return cur.Score()*EndpointSwitchRatio < best.Score()
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloader_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tickstep/cloudpan189-go/internal/file/downloader"
+)
+
+// endpointStat 节点的测速数据, speeds 为每秒读取的数据量, 为0表示没有测速
+type endpointStat struct {
+	speeds   int64
+	requests int
+	errors   int
+}
+
+func newEndpoint(el *downloader.EndpointList, host string, stat endpointStat) *downloader.Endpoint {
+	ep := el.Add("https://" + host + "/file")
+	for i := 0; i < stat.requests; i++ {
+		ep.AddRequest()
+	}
+	for i := 0; i < stat.errors; i++ {
+		ep.AddError()
+	}
+	if stat.speeds > 0 {
+		ep.AddData(stat.speeds*2, 2*time.Second)
+	}
+	return ep
+}
+
+func TestEndpointScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		stat     endpointStat
+		measured bool
+		score    int64
+	}{
+		{"unmeasured", endpointStat{}, false, 0},
+		{"no errors", endpointStat{speeds: 1000, requests: 4}, true, 1000},
+		{"quarter errors", endpointStat{speeds: 1000, requests: 4, errors: 1}, true, 750},
+		{"errors only", endpointStat{requests: 2, errors: 2}, true, 0},
+		{"errors exceed requests", endpointStat{speeds: 1000, requests: 1, errors: 3}, true, 0},
+	}
+	for k, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ep := newEndpoint(downloader.NewEndpointList(), fmt.Sprintf("host%d", k), tt.stat)
+			if ep.Measured() != tt.measured {
+				t.Errorf("measured = %v, want %v", ep.Measured(), tt.measured)
+			}
+			if ep.Score() != tt.score {
+				t.Errorf("score = %d, want %d", ep.Score(), tt.score)
+			}
+		})
+	}
+}
+
+func TestEndpointListBest(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats []endpointStat
+		best  int // 最优节点的序号, -1 表示没有
+	}{
+		{"empty", nil, -1},
+		{"none measured", []endpointStat{{}, {requests: 3}}, -1},
+		{"fastest", []endpointStat{{speeds: 100, requests: 1}, {speeds: 300, requests: 1}, {speeds: 200, requests: 1}}, 1},
+		{"skip unmeasured", []endpointStat{{}, {speeds: 100, requests: 1}}, 1},
+		{"errors lower the score", []endpointStat{{speeds: 1000, requests: 10, errors: 8}, {speeds: 500, requests: 10}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			el := downloader.NewEndpointList()
+			eps := make([]*downloader.Endpoint, len(tt.stats))
+			for k, stat := range tt.stats {
+				eps[k] = newEndpoint(el, fmt.Sprintf("host%d", k), stat)
+			}
+			best := el.Best()
+			if tt.best < 0 {
+				if best != nil {
+					t.Fatalf("best = %s, want nil", best.Host())
+				}
+				return
+			}
+			if best != eps[tt.best] {
+				t.Fatalf("best = %v, want %s", best, eps[tt.best].Host())
+			}
+		})
+	}
+}
+
+func TestEndpointListAddSameHost(t *testing.T) {
+	el := downloader.NewEndpointList()
+	a := el.Add("https://host/file?sign=1")
+	b := el.Add("https://host/file?sign=2")
+	if a != b || el.Len() != 1 {
+		t.Fatalf("same host should be one endpoint, len = %d", el.Len())
+	}
+	if b.URL() != "https://host/file?sign=2" {
+		t.Fatalf("url = %s, want the latest one", b.URL())
+	}
+}
+
+func TestShouldSwitch(t *testing.T) {
+	tests := []struct {
+		name string
+		cur  *endpointStat
+		best *endpointStat
+		want bool
+	}{
+		{"no best", &endpointStat{speeds: 100, requests: 1}, nil, false},
+		{"no current", nil, &endpointStat{speeds: 100, requests: 1}, false},
+		{"current unmeasured", &endpointStat{}, &endpointStat{speeds: 100, requests: 1}, false},
+		{"slightly faster", &endpointStat{speeds: 100, requests: 1}, &endpointStat{speeds: 200, requests: 1}, false},
+		{"exactly ratio", &endpointStat{speeds: 100, requests: 1}, &endpointStat{speeds: 100 * downloader.EndpointSwitchRatio, requests: 1}, false},
+		{"much faster", &endpointStat{speeds: 100, requests: 1}, &endpointStat{speeds: 400, requests: 1}, true},
+		{"current failing", &endpointStat{requests: 2, errors: 2}, &endpointStat{speeds: 100, requests: 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			el := downloader.NewEndpointList()
+			var cur, best *downloader.Endpoint
+			if tt.cur != nil {
+				cur = newEndpoint(el, "cur", *tt.cur)
+			}
+			if tt.best != nil {
+				best = newEndpoint(el, "best", *tt.best)
+			}
+			if got := downloader.ShouldSwitch(cur, best); got != tt.want {
+				t.Fatalf("ShouldSwitch = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 同一个节点不切换
+	el := downloader.NewEndpointList()
+	ep := newEndpoint(el, "same", endpointStat{requests: 1, errors: 1})
+	if downloader.ShouldSwitch(ep, ep) {
+		t.Fatal("ShouldSwitch to itself")
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package downloader
+
+import (
+	"syscall"
+)
+
+// fallocate 为文件分配磁盘空间, 使文件在磁盘上尽量连续
+func fallocate(fd uintptr, size int64) error {
+	return syscall.Fallocate(int(fd), 0, 0, size)
+}
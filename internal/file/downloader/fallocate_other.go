@@ -11,14 +11,16 @@
 // WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
 // See the License for the specific language governing permissions and
 // limitations under the License.
-package functions
 
-import "time"
+// +build !linux
 
-// RetryWait 失败重试等待事件
-func RetryWait(retry int) time.Duration {
-	if retry < 3 {
-		return 2 * time.Duration(retry) * time.Second
-	}
-	return 6 * time.Second
+package downloader
+
+import (
+	"errors"
+)
+
+// fallocate 当前系统不支持, 由调用方退回到修剪文件
+func fallocate(fd uintptr, size int64) error {
+	return errors.New("fallocate not supported")
 }
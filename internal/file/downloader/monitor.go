@@ -19,6 +19,8 @@ import (
 	"github.com/tickstep/library-go/logger"
 	"github.com/tickstep/cloudpan189-go/library/requester/transfer"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,13 +32,19 @@ var (
 type (
 	//Monitor 线程监控器
 	Monitor struct {
+		mu              sync.RWMutex // 保护 workers, 只有监控协程会修改
 		workers         WorkerList
 		status          *transfer.DownloadStatus
 		instanceState   *InstanceState
 		completed       chan struct{}
 		err             error
 		resetController *ResetController
+		endpoints       *EndpointList
+		adaptive        *AdaptiveController
+		budget          *ConnectionBudget
+		newWorker       func(id int) *Worker
 		isReloadWorker  bool //是否重载worker, 单线程模式不重载
+		paused          int32 // 是否暂停, 暂停时不重设和分配worker
 
 		// 临时变量
 		lastAvaliableIndex int
@@ -74,12 +82,34 @@ func (mt *Monitor) Append(worker *Worker) {
 	if worker == nil {
 		return
 	}
+	mt.mu.Lock()
 	mt.workers = append(mt.workers, worker)
+	mt.mu.Unlock()
 }
 
 //SetWorkers 设置workers, 此操作会覆盖原有的workers
 func (mt *Monitor) SetWorkers(workers WorkerList) {
+	mt.mu.Lock()
 	mt.workers = workers
+	mt.mu.Unlock()
+}
+
+// workerList 返回workers的副本, 供监控协程以外的协程遍历
+func (mt *Monitor) workerList() WorkerList {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	return mt.workers.Duplicate()
+}
+
+//SetAdaptive 设置线程数自适应控制, newWorker 用于创建新增的worker
+func (mt *Monitor) SetAdaptive(adaptive *AdaptiveController, newWorker func(id int) *Worker) {
+	mt.adaptive = adaptive
+	mt.newWorker = newWorker
+}
+
+//SetConnectionBudget 设置连接数预算, 每个worker占用一个连接
+func (mt *Monitor) SetConnectionBudget(budget *ConnectionBudget) {
+	mt.budget = budget
 }
 
 //SetStatus 设置DownloadStatus
@@ -87,6 +117,11 @@ func (mt *Monitor) SetStatus(status *transfer.DownloadStatus) {
 	mt.status = status
 }
 
+//SetEndpointList 设置下载节点列表
+func (mt *Monitor) SetEndpointList(el *EndpointList) {
+	mt.endpoints = el
+}
+
 //SetInstanceState 设置状态
 func (mt *Monitor) SetInstanceState(instanceState *InstanceState) {
 	mt.instanceState = instanceState
@@ -113,7 +148,7 @@ func (mt *Monitor) GetAvailableWorker() *Worker {
 	for i := mt.lastAvaliableIndex; i < mt.lastAvaliableIndex+workerCount; i++ {
 		index := i % workerCount
 		worker := mt.workers[index]
-		if worker.Completed() {
+		if worker.retired == 0 && worker.Completed() {
 			mt.lastAvaliableIndex = index
 			return worker
 		}
@@ -123,8 +158,9 @@ func (mt *Monitor) GetAvailableWorker() *Worker {
 
 //GetAllWorkersRange 获取所有worker的范围
 func (mt *Monitor) GetAllWorkersRange() transfer.RangeList {
-	allWorkerRanges := make(transfer.RangeList, 0, len(mt.workers))
-	for _, worker := range mt.workers {
+	workers := mt.workerList()
+	allWorkerRanges := make(transfer.RangeList, 0, len(workers))
+	for _, worker := range workers {
 		allWorkerRanges = append(allWorkerRanges, worker.GetRange())
 	}
 	return allWorkerRanges
@@ -164,17 +200,15 @@ func (mt *Monitor) IsLeftWorkersAllFailed() bool {
 //registerAllCompleted 全部完成则发送消息
 func (mt *Monitor) registerAllCompleted() {
 	mt.completed = make(chan struct{}, 0)
-	var (
-		workerNum   = len(mt.workers)
-		completeNum = 0
-	)
 
 	go func() {
 		for {
 			time.Sleep(1 * time.Second)
 
-			completeNum = 0
-			for _, worker := range mt.workers {
+			// worker 可能会动态增加, 每次都重新获取
+			workers := mt.workerList()
+			completeNum := 0
+			for _, worker := range workers {
 				switch worker.GetStatus().StatusCode() {
 				case StatusCodeInternalError:
 					// 检测到内部错误
@@ -189,7 +223,7 @@ func (mt *Monitor) registerAllCompleted() {
 			// status 在 lazyInit 之后, 不可能为空
 			// 完成条件: 所有worker 都已经完成, 且 rangeGen 已生成完毕
 			gen := mt.status.RangeListGen()
-			if completeNum >= workerNum && (gen == nil || gen.IsDone()) { // 已完成
+			if completeNum >= len(workers) && (gen == nil || gen.IsDone()) { // 已完成
 				close(mt.completed)
 				return
 			}
@@ -216,15 +250,50 @@ func (mt *Monitor) ResetFailedAndNetErrorWorkers() {
 		}
 
 	reset:
+		mt.switchEndpoint(mt.workers[k])
 		mt.workers[k].Reset()
 		mt.resetController.AddResetNum()
 	}
 }
 
+// switchEndpoint 当前节点明显慢于最优节点时, 切换到最优节点
+func (mt *Monitor) switchEndpoint(worker *Worker) bool {
+	if mt.endpoints == nil {
+		return false
+	}
+	best := mt.endpoints.Best()
+	if !ShouldSwitch(worker.Endpoint(), best) {
+		return false
+	}
+	logger.Verbosef("MONITOR: worker[%d] switch endpoint: %s -> %s\n", worker.ID(), worker.Endpoint().Host(), best.Host())
+	worker.SetEndpoint(best)
+	return true
+}
+
+// RebalanceEndpoints 将慢速节点上的线程重新分配到更快的节点
+func (mt *Monitor) RebalanceEndpoints() {
+	if mt.endpoints == nil || mt.endpoints.Len() < 2 {
+		return
+	}
+	for _, worker := range mt.workers {
+		if worker.GetStatus().StatusCode() != StatusCodeDownloading {
+			continue
+		}
+		if !mt.resetController.CanReset() {
+			return
+		}
+		if mt.switchEndpoint(worker) {
+			mt.resetController.AddResetNum()
+			worker.Reset()
+		}
+	}
+}
+
 //RangeWorker 遍历worker
 func (mt *Monitor) RangeWorker(f RangeWorkerFunc) {
-	for k := range mt.workers {
-		if !f(k, mt.workers[k]) {
+	workers := mt.workerList()
+	for k := range workers {
+		if !f(k, workers[k]) {
 			break
 		}
 	}
@@ -232,16 +301,18 @@ func (mt *Monitor) RangeWorker(f RangeWorkerFunc) {
 
 //Pause 暂停所有的下载
 func (mt *Monitor) Pause() {
-	for k := range mt.workers {
-		mt.workers[k].Pause()
+	atomic.StoreInt32(&mt.paused, 1)
+	for _, worker := range mt.workerList() {
+		worker.Pause()
 	}
 }
 
 //Resume 恢复所有的下载
 func (mt *Monitor) Resume() {
-	for k := range mt.workers {
-		mt.workers[k].Resume()
+	for _, worker := range mt.workerList() {
+		worker.Resume()
 	}
+	atomic.StoreInt32(&mt.paused, 0)
 }
 
 // TryAddNewWork 尝试加入新range
@@ -272,6 +343,7 @@ func (mt *Monitor) TryAddNewWork() {
 
 	availableWorker.SetRange(r)
 	availableWorker.ClearStatus()
+	mt.switchEndpoint(availableWorker)
 
 	mt.resetController.AddResetNum()
 	logger.Verbosef("MONITER: worker[%d] add new range: %s\n", availableWorker.ID(), r.ShowDetails())
@@ -284,7 +356,7 @@ func (mt *Monitor) DynamicSplitWorker(worker *Worker) {
 		return
 	}
 
-	switch worker.status.statusCode {
+	switch worker.status.StatusCode() {
 	case StatusCodeDownloading, StatusCodeFailed, StatusCodeNetError:
 	//pass
 	default:
@@ -297,26 +369,32 @@ func (mt *Monitor) DynamicSplitWorker(worker *Worker) {
 		return
 	}
 
+	if !mt.splitRange(worker, availableWorker) {
+		return
+	}
+	availableWorker.ClearStatus()
+	mt.switchEndpoint(availableWorker)
+
+	mt.resetController.AddResetNum()
+	logger.Verbosef("MONITOR: worker duplicated: %d <- %d\n", availableWorker.ID(), worker.ID())
+	go availableWorker.Execute()
+}
+
+// splitRange 将 worker 剩余range的后半部分分给 target
+func (mt *Monitor) splitRange(worker, target *Worker) bool {
 	workerRange := worker.GetRange()
 
 	end := workerRange.LoadEnd()
 	middle := (workerRange.LoadBegin() + end) / 2
 
 	if end-middle < MinParallelSize/5 { // 如果线程剩余的下载量太少, 不分配空闲线程
-		return
+		return false
 	}
 
 	// 折半
-	availableWorkerRange := availableWorker.GetRange()
-	availableWorkerRange.StoreBegin(middle) // middle不能加1
-	availableWorkerRange.StoreEnd(end)
-	availableWorker.ClearStatus()
-
+	target.SetRange(&transfer.Range{Begin: middle, End: end}) // middle不能加1
 	workerRange.StoreEnd(middle)
-
-	mt.resetController.AddResetNum()
-	logger.Verbosef("MONITOR: worker duplicated: %d <- %d\n", availableWorker.ID(), worker.ID())
-	go availableWorker.Execute()
+	return true
 }
 
 // ResetWorker 重设长时间无响应, 和下载速度为 0 的 Worker
@@ -357,6 +435,127 @@ func (mt *Monitor) ResetWorker(worker *Worker) {
 	worker.Reset()
 }
 
+// addWorker 新增一个worker, 优先分配未下载的range, 否则分担剩余最多的worker
+func (mt *Monitor) addWorker() bool {
+	if mt.newWorker == nil || !mt.resetController.CanReset() {
+		return false
+	}
+	if !mt.budget.TryAcquire() {
+		return false
+	}
+
+	worker := mt.newWorker(len(mt.workers))
+	if worker == nil {
+		mt.budget.Release()
+		return false
+	}
+	worker.SetDownloadStatus(mt.status)
+	if mt.adaptive != nil {
+		worker.SetOnThrottled(mt.adaptive.Throttled)
+	}
+
+	assigned := false
+	if gen := mt.status.RangeListGen(); gen != nil && !gen.IsDone() {
+		if _, r := gen.GenRange(); r != nil {
+			worker.SetRange(r)
+			assigned = true
+		}
+	}
+	if !assigned {
+		var largest *Worker
+		for _, w := range mt.workers {
+			if w.Completed() {
+				continue
+			}
+			if largest == nil || w.GetRange().Len() > largest.GetRange().Len() {
+				largest = w
+			}
+		}
+		assigned = largest != nil && mt.splitRange(largest, worker)
+	}
+	if !assigned {
+		mt.budget.Release()
+		return false
+	}
+
+	mt.Append(worker)
+	mt.resetController.AddResetNum()
+	logger.Verbosef("MONITOR: adaptive add worker[%d]: %s\n", worker.ID(), worker.GetRange().ShowDetails())
+	go worker.Execute()
+	return true
+}
+
+// retireWorker 减少一个worker, 优先选择空闲的, 其次是剩余最少的.
+// 退役的worker下载完当前range后不再分配新的range
+func (mt *Monitor) retireWorker() bool {
+	var selected *Worker
+	for _, w := range mt.workers {
+		if w.retired != 0 {
+			continue
+		}
+		if w.Completed() {
+			selected = w
+			break
+		}
+		if selected == nil || w.GetRange().Len() < selected.GetRange().Len() {
+			selected = w
+		}
+	}
+	if selected == nil || mt.activeWorkers() <= 1 {
+		return false
+	}
+	selected.retired = 1
+	logger.Verbosef("MONITOR: adaptive retire worker[%d]\n", selected.ID())
+	mt.releaseRetired()
+	return true
+}
+
+// releaseRetired 释放已完成的退役worker占用的连接
+func (mt *Monitor) releaseRetired() {
+	for _, w := range mt.workers {
+		if w.retired == 1 && w.Completed() {
+			w.retired = 2
+			mt.budget.Release()
+		}
+	}
+}
+
+// releaseBudget 释放所有worker占用的连接
+func (mt *Monitor) releaseBudget() {
+	for _, w := range mt.workers {
+		if w.retired != 2 {
+			w.retired = 2
+			mt.budget.Release()
+		}
+	}
+}
+
+// activeWorkers 未退役的worker数量
+func (mt *Monitor) activeWorkers() (num int) {
+	for _, w := range mt.workers {
+		if w.retired == 0 {
+			num++
+		}
+	}
+	return
+}
+
+// adjustWorkers 根据自适应控制调整worker数量
+func (mt *Monitor) adjustWorkers() {
+	mt.releaseRetired()
+	if mt.adaptive == nil {
+		return
+	}
+	switch mt.adaptive.Sample(mt.status.SpeedsPerSecond()) {
+	case 1:
+		if !mt.addWorker() {
+			mt.adaptive.Reject()
+		}
+	case -1:
+		mt.retireWorker()
+	}
+}
+
 //Execute 执行任务
 func (mt *Monitor) Execute(cancelCtx context.Context) {
 	if len(mt.workers) == 0 {
@@ -365,8 +564,12 @@ func (mt *Monitor) Execute(cancelCtx context.Context) {
 	}
 
 	mt.lazyInit()
+	defer mt.releaseBudget()
 	for _, worker := range mt.workers {
 		worker.SetDownloadStatus(mt.status)
+		if mt.adaptive != nil {
+			worker.SetOnThrottled(mt.adaptive.Throttled)
+		}
 		go worker.Execute()
 	}
 
@@ -378,6 +581,8 @@ func (mt *Monitor) Execute(cancelCtx context.Context) {
 	for {
 		select {
 		case <-cancelCtx.Done():
+			// 取消的下载不算成功, 保留断点续传信息
+			mt.err = cancelCtx.Err()
 			for _, worker := range mt.workers {
 				err := worker.Cancel()
 				if err != nil {
@@ -388,6 +593,10 @@ func (mt *Monitor) Execute(cancelCtx context.Context) {
 		case <-mt.completed:
 			return
 		case <-ticker.C:
+			if atomic.LoadInt32(&mt.paused) == 1 {
+				continue
+			}
+
 			// 初始化监控工作
 			mt.ResetFailedAndNetErrorWorkers()
 
@@ -406,7 +615,7 @@ func (mt *Monitor) Execute(cancelCtx context.Context) {
 
 			// 是否有失败的worker
 			for _, w := range mt.workers {
-				if w.status.statusCode == StatusCodeDownloadUrlExpired {
+				if w.status.StatusCode() == StatusCodeDownloadUrlExpired {
 					mt.ResetWorker(w)
 				}
 			}
@@ -416,6 +625,9 @@ func (mt *Monitor) Execute(cancelCtx context.Context) {
 				continue
 			}
 
+			// 自适应调整线程数
+			mt.adjustWorkers()
+
 			// 更新maxSpeeds
 			mt.status.SetMaxSpeeds(mt.status.SpeedsPerSecond())
 
@@ -428,9 +640,14 @@ func (mt *Monitor) Execute(cancelCtx context.Context) {
 				}
 				mt.status.ClearMaxSpeeds() //清空最大速度的统计
 
+				// 慢速节点上的线程转移到更快的节点
+				mt.RebalanceEndpoints()
+
 				// 先进行动态分配线程
 				logger.Verbosef("DEBUG: monitor: start duplicate.\n")
+				mt.mu.Lock()
 				sort.Sort(ByLeftDesc{mt.workers})
+				mt.mu.Unlock()
 				for _, worker := range mt.workers {
 					//动态分配线程
 					mt.DynamicSplitWorker(worker)
@@ -0,0 +1,227 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloader_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/file/downloader"
+	"github.com/tickstep/cloudpan189-go/library/requester/transfer"
+)
+
+// rangeServer 支持 Range 请求的下载服务器, 每个连接限速
+type rangeServer struct {
+	data          []byte
+	chunk         int           // 每次写入的数据量
+	interval      time.Duration // 每次写入的间隔
+	maxConns      int32         // 超过该连接数返回429, 0 不限制
+	conns         int32
+	maxSeen       int32
+	requests      int32
+	tooManyResult int32
+}
+
+func (rs *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&rs.requests, 1)
+	n := atomic.AddInt32(&rs.conns, 1)
+	defer atomic.AddInt32(&rs.conns, -1)
+	for {
+		seen := atomic.LoadInt32(&rs.maxSeen)
+		if n <= seen || atomic.CompareAndSwapInt32(&rs.maxSeen, seen, n) {
+			break
+		}
+	}
+	if rs.maxConns > 0 && n > rs.maxConns {
+		atomic.AddInt32(&rs.tooManyResult, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	http.ServeContent(&slowWriter{ResponseWriter: w, chunk: rs.chunk, interval: rs.interval}, r, "file", time.Time{}, bytes.NewReader(rs.data))
+}
+
+type slowWriter struct {
+	http.ResponseWriter
+	chunk    int
+	interval time.Duration
+}
+
+func (sw *slowWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := sw.chunk
+		if n > len(p) {
+			n = len(p)
+		}
+		nn, err := sw.ResponseWriter.Write(p[:n])
+		written += nn
+		if err != nil {
+			return written, err
+		}
+		if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		p = p[n:]
+		time.Sleep(sw.interval)
+	}
+	return written, nil
+}
+
+func runMonitor(t *testing.T, rs *rangeServer, initial, max int, budget *downloader.ConnectionBudget) []byte {
+	server := httptest.NewServer(rs)
+	defer server.Close()
+
+	file, err := ioutil.TempFile(t.TempDir(), "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	totalSize := int64(len(rs.data))
+	status := transfer.NewDownloadStatus()
+	status.SetTotalSize(totalSize)
+	status.SetRangeListGen(transfer.NewRangeListGenBlockSize(totalSize, 0, downloader.MinParallelSize))
+
+	var (
+		panClient = cloudpan.NewPanClient(cloudpan.WebLoginToken{}, cloudpan.AppLoginToken{})
+		writeMu   = &sync.Mutex{}
+		durl      = server.URL + "/file?id=1"
+	)
+	newWorker := func(id int) *downloader.Worker {
+		worker := downloader.NewWorker(id, 0, "1", durl, file)
+		worker.SetPanClient(panClient)
+		worker.SetWriteMutex(writeMu)
+		worker.SetTotalSize(totalSize)
+		worker.SetAcceptRange("bytes")
+		return worker
+	}
+
+	monitor := downloader.NewMonitor()
+	monitor.InitMonitorCapacity(initial)
+	for i := 0; i < initial; i++ {
+		_, r := status.RangeListGen().GenRange()
+		worker := newWorker(i)
+		worker.SetRange(r)
+		budget.Acquire(1)
+		monitor.Append(worker)
+	}
+	monitor.SetStatus(status)
+	monitor.SetReloadWorker(true)
+	monitor.SetConnectionBudget(budget)
+	monitor.SetAdaptive(downloader.NewAdaptiveController(initial, 1, max), newWorker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	monitor.Execute(ctx)
+	if err := monitor.Err(); err != nil {
+		t.Fatalf("download error: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Clean(file.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func TestMonitorAdaptiveAddWorkers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip slow download test")
+	}
+	rs := &rangeServer{
+		data:     randomData(int(6 * downloader.MinParallelSize)),
+		chunk:    32 * 1024,
+		interval: 50 * time.Millisecond, // 单连接约 640KB/s
+	}
+	budget := downloader.NewConnectionBudget(4)
+	data := runMonitor(t, rs, 1, 4, budget)
+
+	if !bytes.Equal(data, rs.data) {
+		t.Fatal("downloaded data mismatch")
+	}
+	if seen := atomic.LoadInt32(&rs.maxSeen); seen < 2 {
+		t.Fatalf("max connections = %d, adaptive should add workers", seen)
+	}
+	if seen := atomic.LoadInt32(&rs.maxSeen); seen > 4 {
+		t.Fatalf("max connections = %d, exceed budget 4", seen)
+	}
+	if budget.InUse() != 0 {
+		t.Fatalf("budget in use = %d after download", budget.InUse())
+	}
+}
+
+func TestMonitorAdaptiveBudgetShared(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip slow download test")
+	}
+	rs := &rangeServer{
+		data:     randomData(int(4 * downloader.MinParallelSize)),
+		chunk:    32 * 1024,
+		interval: 50 * time.Millisecond,
+	}
+	// 其他文件已占用了大部分预算
+	budget := downloader.NewConnectionBudget(3)
+	budget.Acquire(2)
+	data := runMonitor(t, rs, 1, 4, budget)
+
+	if !bytes.Equal(data, rs.data) {
+		t.Fatal("downloaded data mismatch")
+	}
+	if seen := atomic.LoadInt32(&rs.maxSeen); seen > 1 {
+		t.Fatalf("max connections = %d, budget allows only 1", seen)
+	}
+	if budget.InUse() != 2 {
+		t.Fatalf("budget in use = %d, want 2", budget.InUse())
+	}
+}
+
+func TestMonitorAdaptiveThrottled(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip slow download test")
+	}
+	rs := &rangeServer{
+		data:     randomData(int(4 * downloader.MinParallelSize)),
+		chunk:    32 * 1024,
+		interval: 20 * time.Millisecond,
+		maxConns: 2,
+	}
+	budget := downloader.NewConnectionBudget(8)
+	data := runMonitor(t, rs, 3, 8, budget)
+
+	if !bytes.Equal(data, rs.data) {
+		t.Fatal("downloaded data mismatch")
+	}
+	if atomic.LoadInt32(&rs.tooManyResult) == 0 {
+		t.Fatal("server should reject some requests")
+	}
+	if budget.InUse() != 0 {
+		t.Fatalf("budget in use = %d after download", budget.InUse())
+	}
+}
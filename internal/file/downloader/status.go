@@ -15,6 +15,7 @@ package downloader
 
 import (
 	"github.com/tickstep/cloudpan189-go/library/requester/transfer"
+	"sync/atomic"
 )
 
 type (
@@ -29,7 +30,7 @@ type (
 
 	//WorkerStatus worker状态
 	WorkerStatus struct {
-		statusCode StatusCode
+		statusCode int32 // 下载线程和监控会同时读写, 使用原子操作
 	}
 
 	// DownloadStatusFunc 下载状态处理函数
@@ -100,21 +101,21 @@ func GetStatusText(sc StatusCode) string {
 //NewWorkerStatus 初始化WorkerStatus
 func NewWorkerStatus() *WorkerStatus {
 	return &WorkerStatus{
-		statusCode: StatusCodeInit,
+		statusCode: int32(StatusCodeInit),
 	}
 }
 
 //SetStatusCode 设置worker状态码
 func (ws *WorkerStatus) SetStatusCode(sc StatusCode) {
-	ws.statusCode = sc
+	atomic.StoreInt32(&ws.statusCode, int32(sc))
 }
 
 //StatusCode 返回状态码
 func (ws *WorkerStatus) StatusCode() StatusCode {
-	return ws.statusCode
+	return StatusCode(atomic.LoadInt32(&ws.statusCode))
 }
 
 //StatusText 返回状态信息
 func (ws *WorkerStatus) StatusText() string {
-	return GetStatusText(ws.statusCode)
+	return GetStatusText(ws.StatusCode())
 }
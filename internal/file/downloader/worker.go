@@ -19,7 +19,6 @@ import (
 	"fmt"
 	"github.com/tickstep/cloudpan189-api/cloudpan"
 	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
-	"github.com/tickstep/library-go/cachepool"
 	"github.com/tickstep/library-go/logger"
 	"github.com/tickstep/library-go/requester"
 	"github.com/tickstep/library-go/requester/rio/speeds"
@@ -27,6 +26,7 @@ import (
 	"io"
 	"net/http"
 	"sync"
+	"time"
 )
 
 type (
@@ -39,12 +39,17 @@ type (
 		fileId       string // 文件ID
 		familyId     int64
 		url          string // 下载地址
+		endpoint     *Endpoint
+		endpoints    *EndpointList
+		retired      int // 0 正常, 1 退役中, 不再分配新的range, 2 已退役并释放连接
+		onThrottled  func()
 		acceptRanges string
 		panClient    *cloudpan.PanClient
 		client       *requester.HTTPClient
 		writerAt     io.WriterAt
 		writeMu      *sync.Mutex
 		execMu       sync.Mutex
+		endpointMu   sync.Mutex
 
 		pauseChan              chan struct{}
 		workerCancelFunc       context.CancelFunc
@@ -87,7 +92,7 @@ func (wer *Worker) lazyInit() {
 		wer.client = requester.NewHTTPClient()
 	}
 	if wer.pauseChan == nil {
-		wer.pauseChan = make(chan struct{})
+		wer.pauseChan = make(chan struct{}, 1)
 	}
 	if wer.wrange == nil {
 		wer.wrange = &transfer.Range{}
@@ -116,6 +121,48 @@ func (wer *Worker) SetPanClient(p *cloudpan.PanClient) {
 	wer.panClient = p
 }
 
+//SetEndpointList 设置可选的下载节点, 刷新下载链接时会记录到节点列表
+func (wer *Worker) SetEndpointList(el *EndpointList) {
+	wer.endpoints = el
+}
+
+//SetEndpoint 设置下载节点, 使用该节点最新的下载链接.
+//正在下载的 worker 不受影响, 下次执行时才使用新的节点
+func (wer *Worker) SetEndpoint(ep *Endpoint) {
+	if ep == nil {
+		return
+	}
+	wer.endpointMu.Lock()
+	defer wer.endpointMu.Unlock()
+	wer.endpoint = ep
+	wer.url = ep.URL()
+}
+
+//Endpoint 返回当前使用的下载节点
+func (wer *Worker) Endpoint() *Endpoint {
+	wer.endpointMu.Lock()
+	defer wer.endpointMu.Unlock()
+	return wer.endpoint
+}
+
+// downloadURL 返回本次执行使用的下载地址和节点
+func (wer *Worker) downloadURL() (string, *Endpoint) {
+	wer.endpointMu.Lock()
+	defer wer.endpointMu.Unlock()
+	return wer.url, wer.endpoint
+}
+
+//SetOnThrottled 设置服务器限流(429/5xx)时的回调
+func (wer *Worker) SetOnThrottled(f func()) {
+	wer.onThrottled = f
+}
+
+func (wer *Worker) throttled() {
+	if wer.onThrottled != nil {
+		wer.onThrottled()
+	}
+}
+
 //SetAcceptRange 设置AcceptRange
 func (wer *Worker) SetAcceptRange(acceptRanges string) {
 	wer.acceptRanges = acceptRanges
@@ -165,16 +212,20 @@ func (wer *Worker) Pause() {
 		return
 	}
 
-	if wer.status.statusCode == StatusCodePaused {
+	if wer.status.StatusCode() == StatusCodePaused || wer.Completed() {
 		return
 	}
-	wer.pauseChan <- struct{}{}
-	wer.status.statusCode = StatusCodePaused
+	// 不阻塞, worker 读取数据时才会处理暂停
+	select {
+	case wer.pauseChan <- struct{}{}:
+	default:
+	}
+	wer.status.SetStatusCode(StatusCodePaused)
 }
 
 //Resume 恢复下载
 func (wer *Worker) Resume() {
-	if wer.status.statusCode != StatusCodePaused {
+	if wer.status.StatusCode() != StatusCodePaused {
 		return
 	}
 	go wer.Execute()
@@ -217,20 +268,26 @@ func (wer *Worker) RefreshDownloadUrl() {
 		durl, apierr = wer.panClient.AppGetFileDownloadUrl(wer.fileId)
 	}
 	if apierr != nil {
-		wer.status.statusCode = StatusCodeTooManyConnections
+		wer.status.SetStatusCode(StatusCodeTooManyConnections)
 		return
 	}
+	if wer.endpoints != nil {
+		wer.SetEndpoint(wer.endpoints.Add(durl))
+		return
+	}
+	wer.endpointMu.Lock()
 	wer.url = durl
+	wer.endpointMu.Unlock()
 }
 
 // Canceled 是否已经取消
 func (wer *Worker) Canceled() bool {
-	return wer.status.statusCode == StatusCodeCanceled
+	return wer.status.StatusCode() == StatusCodeCanceled
 }
 
 //Completed 是否已经完成
 func (wer *Worker) Completed() bool {
-	switch wer.status.statusCode {
+	switch wer.status.StatusCode() {
 	case StatusCodeSuccessed, StatusCodeCanceled:
 		return true
 	default:
@@ -240,7 +297,7 @@ func (wer *Worker) Completed() bool {
 
 //Failed 是否失败
 func (wer *Worker) Failed() bool {
-	switch wer.status.statusCode {
+	switch wer.status.StatusCode() {
 	case StatusCodeFailed, StatusCodeInternalError, StatusCodeTooManyConnections, StatusCodeNetError:
 		return true
 	default:
@@ -250,7 +307,7 @@ func (wer *Worker) Failed() bool {
 
 //ClearStatus 清空状态
 func (wer *Worker) ClearStatus() {
-	wer.status.statusCode = StatusCodeInit
+	wer.status.SetStatusCode(StatusCodeInit)
 }
 
 //Err 返回worker错误
@@ -265,11 +322,11 @@ func (wer *Worker) Execute() {
 	wer.execMu.Lock()
 	defer wer.execMu.Unlock()
 
-	wer.status.statusCode = StatusCodeInit
+	wer.status.SetStatusCode(StatusCodeInit)
 	single := wer.acceptRanges == ""
 
 	// 如果已暂停, 退出
-	if wer.status.statusCode == StatusCodePaused {
+	if wer.status.StatusCode() == StatusCodePaused {
 		return
 	}
 
@@ -279,14 +336,14 @@ func (wer *Worker) Execute() {
 			if rlen < 0 {
 				logger.Verbosef("DEBUG: RangeLen is negative at begin: %v, %d\n", wer.wrange, wer.wrange.Len())
 			}
-			wer.status.statusCode = StatusCodeSuccessed
+			wer.status.SetStatusCode(StatusCodeSuccessed)
 			return
 		}
 	}
 
 	// zero size file
 	if wer.totalSize == 0 {
-		wer.status.statusCode = StatusCodeSuccessed
+		wer.status.SetStatusCode(StatusCodeSuccessed)
 		return
 	}
 
@@ -295,11 +352,23 @@ func (wer *Worker) Execute() {
 	resetCtx, resetFunc := context.WithCancel(context.Background())
 	wer.resetFunc = resetFunc
 
-	wer.status.statusCode = StatusCodePending
+	wer.status.SetStatusCode(StatusCodePending)
+
+	// 统计节点的请求数和错误数
+	durl, endpoint := wer.downloadURL()
+	if endpoint != nil {
+		endpoint.AddRequest()
+		defer func() {
+			switch wer.status.StatusCode() {
+			case StatusCodeNetError, StatusCodeFailed, StatusCodeTooManyConnections, StatusCodeDownloadUrlExpired:
+				endpoint.AddError()
+			}
+		}()
+	}
 
 	var resp *http.Response
 
-	apierr := wer.panClient.AppDownloadFileData(wer.url, cloudpan.AppFileDownloadRange{
+	apierr := wer.panClient.AppDownloadFileData(durl, cloudpan.AppFileDownloadRange{
 		Offset: wer.wrange.Begin,
 		End: wer.wrange.End - 1,
 	}, func(httpMethod, fullUrl string, headers map[string]string) (*http.Response, error) {
@@ -319,7 +388,7 @@ func (wer *Worker) Execute() {
 		}
 	}
 	if wer.err != nil || apierr != nil {
-		wer.status.statusCode = StatusCodeNetError
+		wer.status.SetStatusCode(StatusCodeNetError)
 		return
 	}
 
@@ -327,26 +396,30 @@ func (wer *Worker) Execute() {
 	switch resp.StatusCode {
 	case 200, 206:
 		// do nothing, continue
-		wer.status.statusCode = StatusCodeDownloading
+		wer.status.SetStatusCode(StatusCodeDownloading)
 		break
 	case 416: //Requested Range Not Satisfiable
 		fallthrough
 	case 403: // Forbidden
 		fallthrough
 	case 406: // Not Acceptable
-		wer.status.statusCode = StatusCodeNetError
+		wer.status.SetStatusCode(StatusCodeNetError)
 		wer.err = errors.New(resp.Status)
 		return
 	case 404:
-		wer.status.statusCode = StatusCodeDownloadUrlExpired
+		wer.status.SetStatusCode(StatusCodeDownloadUrlExpired)
 		wer.err = errors.New(resp.Status)
 		return
 	case 429, 509: // Too Many Requests
 		wer.status.SetStatusCode(StatusCodeTooManyConnections)
 		wer.err = errors.New(resp.Status)
+		wer.throttled()
 		return
 	default:
-		wer.status.statusCode = StatusCodeNetError
+		if resp.StatusCode >= 500 {
+			wer.throttled()
+		}
+		wer.status.SetStatusCode(StatusCodeNetError)
 		wer.err = fmt.Errorf("unexpected http status code, %d, %s", resp.StatusCode, resp.Status)
 		return
 	}
@@ -359,7 +432,7 @@ func (wer *Worker) Execute() {
 	if !single {
 		// 检查请求长度
 		if contentLength != rangeLength {
-			wer.status.statusCode = StatusCodeNetError
+			wer.status.SetStatusCode(StatusCodeNetError)
 			wer.err = fmt.Errorf("Content-Length is unexpected: %d, need %d", contentLength, rangeLength)
 			return
 		}
@@ -368,7 +441,7 @@ func (wer *Worker) Execute() {
 			total := ParseContentRange(resp.Header.Get("Content-Range"))
 			if total > 0 {
 				if total != wer.totalSize {
-					wer.status.statusCode = StatusCodeInternalError // 这里设置为内部错误, 强制停止下载
+					wer.status.SetStatusCode(StatusCodeInternalError) // 这里设置为内部错误, 强制停止下载
 					wer.err = fmt.Errorf("Content-Range total length is unexpected: %d, need %d", total, wer.totalSize)
 					return
 				}
@@ -377,33 +450,41 @@ func (wer *Worker) Execute() {
 	}
 
 	var (
-		buf       = cachepool.SyncPool.Get().([]byte)
+		buf       = getBuffer()
 		n, nn     int
 		n64, nn64 int64
 	)
-	defer cachepool.SyncPool.Put(buf)
+	defer putBuffer(buf)
 
 	for {
 		select {
 		case <-workerCancelCtx.Done(): //取消
-			wer.status.statusCode = StatusCodeCanceled
+			wer.status.SetStatusCode(StatusCodeCanceled)
 			return
 		case <-resetCtx.Done(): //重设连接
-			wer.status.statusCode = StatusCodeReseted
+			wer.status.SetStatusCode(StatusCodeReseted)
 			return
 		case <-wer.pauseChan: //暂停
+			wer.status.SetStatusCode(StatusCodePaused)
 			return
 		default:
-			wer.status.statusCode = StatusCodeDownloading
+			wer.status.SetStatusCode(StatusCodeDownloading)
 
 			// 初始化数据
-			var readErr error
+			var (
+				readErr   error
+				readStart time.Time
+			)
 			n = 0
 
 			// 读取数据
 			for n < len(buf) && readErr == nil && (single || wer.wrange.Len() > 0) {
+				readStart = time.Now()
 				nn, readErr = resp.Body.Read(buf[n:])
 				nn64 = int64(nn)
+				if endpoint != nil {
+					endpoint.AddData(nn64, time.Since(readStart))
+				}
 
 				// 更新速度统计
 				if wer.downloadStatus != nil {
@@ -425,7 +506,7 @@ func (wer *Worker) Execute() {
 
 				// 已完成
 				if rangeLength <= 0 {
-					wer.status.statusCode = StatusCodeCanceled
+					wer.status.SetStatusCode(StatusCodeCanceled)
 					wer.err = errors.New("worker already complete")
 					return
 				}
@@ -440,7 +521,7 @@ func (wer *Worker) Execute() {
 
 			// 写入数据
 			if wer.writerAt != nil {
-				wer.status.statusCode = StatusCodeWaitToWrite
+				wer.status.SetStatusCode(StatusCodeWaitToWrite)
 				if wer.writeMu != nil {
 					wer.writeMu.Lock() // 加锁, 减轻硬盘的压力
 				}
@@ -449,14 +530,14 @@ func (wer *Worker) Execute() {
 					if wer.writeMu != nil {
 						wer.writeMu.Unlock() //解锁
 					}
-					wer.status.statusCode = StatusCodeInternalError
+					wer.status.SetStatusCode(StatusCodeInternalError)
 					return
 				}
 
 				if wer.writeMu != nil {
 					wer.writeMu.Unlock() //解锁
 				}
-				wer.status.statusCode = StatusCodeDownloading
+				wer.status.SetStatusCode(StatusCodeDownloading)
 			}
 
 			// 更新下载统计数据
@@ -479,14 +560,14 @@ func (wer *Worker) Execute() {
 				case rlen <= 0:
 					// 下载完成
 					// 小于0可能是因为 worker 被 duplicate
-					wer.status.statusCode = StatusCodeSuccessed
+					wer.status.SetStatusCode(StatusCodeSuccessed)
 					if rlen < 0 {
 						logger.Verbosef("DEBUG: RangeLen is negative at end: %v, %d\n", wer.wrange, wer.wrange.Len())
 					}
 					return
 				default:
 					// 其他错误, 返回
-					wer.status.statusCode = StatusCodeFailed
+					wer.status.SetStatusCode(StatusCodeFailed)
 					wer.err = readErr
 					return
 				}
@@ -13,6 +13,11 @@
 // limitations under the License.
 package uploader
 
+import (
+	"fmt"
+	"strings"
+)
+
 type (
 	// MultiError 多线程上传的错误
 	MultiError struct {
@@ -20,8 +25,49 @@ type (
 		// IsRetry 是否重试,
 		Terminated bool
 	}
+
+	// PartError 分片上传的错误
+	PartError struct {
+		ID    int   // 分片ID
+		Retry int   // 已重试次数
+		Err   error // 最后一次的错误
+	}
+
+	// PartsError 上传失败的分片列表
+	PartsError struct {
+		Parts []*PartError
+		// Fatal 是否遇到不可恢复的错误, 此时 Parts 只包含出错的分片;
+		// 为 false 时表示 Parts 中的分片重试次数已用尽
+		Fatal bool
+	}
 )
 
 func (me *MultiError) Error() string {
+	if me.Err == nil {
+		return "upload terminated"
+	}
 	return me.Err.Error()
 }
+
+func (pe *PartError) Error() string {
+	return fmt.Sprintf("part %d (retry %d): %s", pe.ID, pe.Retry, pe.Err)
+}
+
+func (pe *PartsError) Error() string {
+	msgs := make([]string, 0, len(pe.Parts))
+	for _, part := range pe.Parts {
+		msgs = append(msgs, part.Error())
+	}
+	if pe.Fatal {
+		return "upload terminated, " + strings.Join(msgs, "; ")
+	}
+	return fmt.Sprintf("%d part(s) failed, %s", len(pe.Parts), strings.Join(msgs, "; "))
+}
+
+// Cause 返回第一个失败分片的错误, 遇到不可恢复的错误时即为该错误
+func (pe *PartsError) Cause() error {
+	if len(pe.Parts) == 0 {
+		return nil
+	}
+	return pe.Parts[0].Err
+}
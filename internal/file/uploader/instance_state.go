@@ -20,9 +20,10 @@ import (
 type (
 	// BlockState 文件区块信息
 	BlockState struct {
-		ID       int            `json:"id"`
-		Range    transfer.Range `json:"range"`
-		UploadDone bool `json:"upload_done"`
+		ID         int            `json:"id"`
+		Range      transfer.Range `json:"range"`
+		UploadDone bool           `json:"upload_done"`
+		Uploaded   int64          `json:"uploaded"` // 区块内已上传的数据量
 	}
 
 	// InstanceState 上传断点续传信息
@@ -31,6 +32,21 @@ type (
 	}
 )
 
+// Left 返回剩余未上传的数据量
+func (is *InstanceState) Left() int64 {
+	var left int64
+	for _, blockState := range is.BlockList {
+		if blockState.UploadDone {
+			continue
+		}
+		left += blockState.Range.End - blockState.Range.Begin - blockState.Uploaded
+	}
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
 func (muer *MultiUploader) getWorkerListByInstanceState(is *InstanceState) workerList {
 	workers := make(workerList, 0, len(is.BlockList))
 	for _, blockState := range is.BlockList {
@@ -24,6 +24,15 @@ import (
 	"time"
 )
 
+const (
+	// DefaultMaxPartRetry 单个分片默认最大重试次数
+	DefaultMaxPartRetry = 5
+	// DefaultRetryBaseWait 分片重试默认初始等待时间
+	DefaultRetryBaseWait = 1 * time.Second
+	// DefaultRetryMaxWait 分片重试默认最大等待时间
+	DefaultRetryMaxWait = 30 * time.Second
+)
+
 type (
 	// MultiUpload 支持多线程的上传, 可用于断点续传
 	MultiUpload interface {
@@ -68,9 +77,12 @@ type (
 
 	// MultiUploaderConfig 多线程上传配置
 	MultiUploaderConfig struct {
-		Parallel  int   // 上传并发量
-		BlockSize int64 // 上传分块
-		MaxRate   int64 // 限制最大上传速度
+		Parallel      int           // 上传并发量
+		BlockSize     int64         // 上传分块
+		MaxRate       int64         // 限制最大上传速度
+		MaxPartRetry  int           // 单个分片最大重试次数
+		RetryBaseWait time.Duration // 分片重试的初始等待时间, 之后每次重试翻倍
+		RetryMaxWait  time.Duration // 分片重试的最大等待时间
 	}
 )
 
@@ -111,6 +123,18 @@ func (muer *MultiUploader) lazyInit() {
 	if muer.config.BlockSize <= 0 {
 		muer.config.BlockSize = 1 * converter.GB
 	}
+	if muer.config.MaxPartRetry <= 0 {
+		muer.config.MaxPartRetry = DefaultMaxPartRetry
+	}
+	if muer.config.RetryBaseWait <= 0 {
+		muer.config.RetryBaseWait = DefaultRetryBaseWait
+	}
+	if muer.config.RetryMaxWait < muer.config.RetryBaseWait {
+		muer.config.RetryMaxWait = DefaultRetryMaxWait
+		if muer.config.RetryMaxWait < muer.config.RetryBaseWait {
+			muer.config.RetryMaxWait = muer.config.RetryBaseWait
+		}
+	}
 	if muer.speedsStat == nil {
 		muer.speedsStat = &speeds.Speeds{}
 	}
@@ -190,9 +214,10 @@ func (muer *MultiUploader) InstanceState() *InstanceState {
 	blockStates := make([]*BlockState, 0, len(muer.workers))
 	for _, wer := range muer.workers {
 		blockStates = append(blockStates, &BlockState{
-			ID:       wer.id,
-			Range:    wer.splitUnit.Range(),
+			ID:         wer.id,
+			Range:      wer.splitUnit.Range(),
 			UploadDone: wer.uploadDone,
+			Uploaded:   wer.splitUnit.Readed(),
 		})
 	}
 	return &InstanceState{
@@ -202,7 +227,9 @@ func (muer *MultiUploader) InstanceState() *InstanceState {
 
 // Cancel 取消上传
 func (muer *MultiUploader) Cancel() {
-	close(muer.canceled)
+	muer.closeCanceledOnce.Do(func() {
+		close(muer.canceled)
+	})
 }
 
 //OnExecute 设置开始上传事件
@@ -17,8 +17,12 @@ import (
 	"context"
 	"github.com/tickstep/cloudpan189-go/internal/waitgroup"
 	"github.com/oleiade/lane"
+	"math/rand"
 	"os"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
 )
 
 type (
@@ -27,6 +31,7 @@ type (
 		partOffset int64
 		splitUnit  SplitUnit
 		uploadDone   bool
+		retry      int // 已重试次数
 	}
 
 	workerList []*worker
@@ -40,6 +45,17 @@ func (werl *workerList) Readed() int64 {
 	return readed
 }
 
+// retryWait 分片第 retry 次重试前的等待时间, 指数退避并加入随机抖动, 避免多个分片同时重试
+func (muer *MultiUploader) retryWait(retry int) time.Duration {
+	wait := muer.config.RetryMaxWait
+	if retry < 32 {
+		if w := muer.config.RetryBaseWait << uint(retry-1); w > 0 && w < wait {
+			wait = w
+		}
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
 func (muer *MultiUploader) upload() (uperr error) {
 	err := muer.multiUpload.Precreate()
 	if err != nil {
@@ -48,6 +64,9 @@ func (muer *MultiUploader) upload() (uperr error) {
 
 	var (
 		uploadDeque = lane.NewDeque()
+		fatalErr    *PartsError  // 不可恢复的错误
+		failedParts []*PartError // 重试次数用尽的分片
+		errLocker   sync.Mutex
 	)
 
 	// 加入队列
@@ -97,15 +116,36 @@ func (muer *MultiUploader) upload() (uperr error) {
 				if terr != nil {
 					if me, ok := terr.(*MultiError); ok {
 						if me.Terminated { // 终止
+							errLocker.Lock()
+							if fatalErr == nil {
+								fatalErr = &PartsError{Fatal: true}
+							}
+							fatalErr.Parts = append(fatalErr.Parts, &PartError{ID: wer.id, Retry: wer.retry, Err: me})
+							errLocker.Unlock()
 							muer.closeCanceledOnce.Do(func() { // 只关闭一次
 								close(muer.canceled)
 							})
-							uperr = me.Err
 							return
 						}
 					}
 
-					uploaderVerbose.Warnf("upload err: %s, id: %d\n", terr, wer.id)
+					if wer.retry >= muer.config.MaxPartRetry {
+						uploaderVerbose.Warnf("upload err: %s, id: %d, retry limit exceeded\n", terr, wer.id)
+						errLocker.Lock()
+						failedParts = append(failedParts, &PartError{ID: wer.id, Retry: wer.retry, Err: terr})
+						errLocker.Unlock()
+						return
+					}
+					wer.retry++
+					wait := muer.retryWait(wer.retry)
+					uploaderVerbose.Warnf("upload err: %s, id: %d, retry %d/%d after %s\n", terr, wer.id, wer.retry, muer.config.MaxPartRetry, wait)
+					timer := time.NewTimer(wait)
+					select {
+					case <-muer.canceled:
+						timer.Stop()
+						return
+					case <-timer.C:
+					}
 					wer.splitUnit.Seek(0, os.SEEK_SET)
 					uploadDeque.Append(wer)
 					return
@@ -128,13 +168,20 @@ func (muer *MultiUploader) upload() (uperr error) {
 
 	select {
 	case <-muer.canceled:
-		if uperr != nil {
-			return uperr
+		if fatalErr != nil {
+			return fatalErr
 		}
 		return context.Canceled
 	default:
 	}
 
+	if len(failedParts) > 0 {
+		sort.Slice(failedParts, func(i, j int) bool {
+			return failedParts[i].ID < failedParts[j].ID
+		})
+		return &PartsError{Parts: failedParts}
+	}
+
 	// upload file commit
 	// 检测是否全部分片上传成功
 	allSuccess := true
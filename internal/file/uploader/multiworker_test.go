@@ -0,0 +1,113 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package uploader_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tickstep/cloudpan189-go/internal/file/uploader"
+	"github.com/tickstep/library-go/requester/rio"
+)
+
+type failingUpload struct {
+	calls     int32
+	failTimes int32 // 前 failTimes 次上传失败
+	err       error
+	committed bool
+}
+
+type stringReaderAtLen64 struct {
+	*strings.Reader
+}
+
+func (sr stringReaderAtLen64) Len() int64 {
+	return sr.Size()
+}
+
+func (fu *failingUpload) Precreate() error {
+	return nil
+}
+
+func (fu *failingUpload) UploadFile(ctx context.Context, partseq int, partOffset int64, partEnd int64, r rio.ReaderLen64) (bool, error) {
+	io.Copy(ioutil.Discard, r)
+	if atomic.AddInt32(&fu.calls, 1) <= fu.failTimes {
+		return false, fu.err
+	}
+	return true, nil
+}
+
+func (fu *failingUpload) CommitFile() error {
+	fu.committed = true
+	return nil
+}
+
+func executeUpload(fu *failingUpload) (err error) {
+	muer := uploader.NewMultiUploader("url", "commit", "fileid", "requestid", fu,
+		stringReaderAtLen64{strings.NewReader("0123456789")}, &uploader.MultiUploaderConfig{
+			Parallel:      1,
+			BlockSize:     10,
+			MaxPartRetry:  3,
+			RetryBaseWait: time.Millisecond,
+			RetryMaxWait:  4 * time.Millisecond,
+		})
+	muer.OnError(func(e error) {
+		err = e
+	})
+	muer.Execute()
+	return
+}
+
+func TestMultiUploaderRetrySucceed(t *testing.T) {
+	fu := &failingUpload{failTimes: 2, err: errors.New("timeout")}
+	if err := executeUpload(fu); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fu.calls != 3 || !fu.committed {
+		t.Fatalf("calls: %d, committed: %v", fu.calls, fu.committed)
+	}
+}
+
+func TestMultiUploaderRetryExceeded(t *testing.T) {
+	fu := &failingUpload{failTimes: 100, err: errors.New("timeout")}
+	err := executeUpload(fu)
+	pe, ok := err.(*uploader.PartsError)
+	if !ok {
+		t.Fatalf("expected PartsError, got: %v", err)
+	}
+	if pe.Fatal || len(pe.Parts) != 1 || pe.Parts[0].Retry != 3 {
+		t.Fatalf("unexpected error: %s", pe)
+	}
+	if fu.calls != 4 || fu.committed {
+		t.Fatalf("calls: %d, committed: %v", fu.calls, fu.committed)
+	}
+}
+
+func TestMultiUploaderTerminated(t *testing.T) {
+	fu := &failingUpload{failTimes: 100, err: &uploader.MultiError{Err: errors.New("forbidden"), Terminated: true}}
+	err := executeUpload(fu)
+	pe, ok := err.(*uploader.PartsError)
+	if !ok || !pe.Fatal {
+		t.Fatalf("expected fatal PartsError, got: %v", err)
+	}
+	if fu.calls != 1 {
+		t.Fatalf("calls: %d", fu.calls)
+	}
+}
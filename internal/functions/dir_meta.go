@@ -0,0 +1,150 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// FileMeta 上传时记录的本地文件元数据
+	FileMeta struct {
+		ModTime int64       `json:"mtime"`
+		Mode    os.FileMode `json:"mode,omitempty"`
+		Size    int64       `json:"size"`
+		MD5     string      `json:"md5,omitempty"`
+	}
+
+	// DirMeta 网盘目录中的元数据文件, 记录目录下文件的本地修改时间和权限
+	DirMeta struct {
+		UpdateTime int64                `json:"updateTime"`
+		Files      map[string]*FileMeta `json:"files"`
+	}
+
+	// DirMetaCollector 上传时按网盘目录收集文件元数据, 上传结束后写入各目录的元数据文件
+	DirMetaCollector struct {
+		dirs map[string]*DirMeta
+		mu   sync.Mutex
+	}
+)
+
+const (
+	// DirMetaFileName 网盘目录中的元数据文件名
+	DirMetaFileName = ".cloud189-meta.json"
+)
+
+// NewDirMeta 初始化 DirMeta
+func NewDirMeta() *DirMeta {
+	return &DirMeta{
+		Files: map[string]*FileMeta{},
+	}
+}
+
+// ParseDirMeta 解析元数据文件的内容
+func ParseDirMeta(data []byte) (*DirMeta, error) {
+	dm := NewDirMeta()
+	if err := json.Unmarshal(data, dm); err != nil {
+		return nil, err
+	}
+	if dm.Files == nil {
+		dm.Files = map[string]*FileMeta{}
+	}
+	return dm, nil
+}
+
+// Get 获取文件的元数据, 文件大小或md5不一致的视为已失效
+func (dm *DirMeta) Get(fileName string, size int64, md5 string) *FileMeta {
+	if dm == nil {
+		return nil
+	}
+	fm, ok := dm.Files[fileName]
+	if !ok || !fm.Match(size, md5) {
+		return nil
+	}
+	return fm
+}
+
+// Merge 合并其他的元数据, other 中的记录优先
+func (dm *DirMeta) Merge(other *DirMeta) {
+	for name, fm := range other.Files {
+		dm.Files[name] = fm
+	}
+}
+
+// Marshal 生成元数据文件的内容
+func (dm *DirMeta) Marshal() ([]byte, error) {
+	dm.UpdateTime = time.Now().Unix()
+	return json.Marshal(dm)
+}
+
+// Match 元数据是否与网盘文件一致, 文件可能在网页或其他客户端被替换, md5 为空时只比较大小
+func (fm *FileMeta) Match(size int64, md5 string) bool {
+	if fm.Size != size {
+		return false
+	}
+	return fm.MD5 == "" || md5 == "" || strings.EqualFold(fm.MD5, md5)
+}
+
+// Time 文件的修改时间
+func (fm *FileMeta) Time() time.Time {
+	return time.Unix(fm.ModTime, 0)
+}
+
+// NewDirMetaCollector 初始化 DirMetaCollector
+func NewDirMetaCollector() *DirMetaCollector {
+	return &DirMetaCollector{
+		dirs: map[string]*DirMeta{},
+	}
+}
+
+// Add 记录网盘文件的元数据, nil 时忽略
+func (dmc *DirMetaCollector) Add(panPath string, fm *FileMeta) {
+	if dmc == nil {
+		return
+	}
+	dmc.mu.Lock()
+	defer dmc.mu.Unlock()
+	dir := path.Dir(panPath)
+	dm, ok := dmc.dirs[dir]
+	if !ok {
+		dm = NewDirMeta()
+		dmc.dirs[dir] = dm
+	}
+	dm.Files[path.Base(panPath)] = fm
+}
+
+// Dirs 已记录的网盘目录, 按路径排序
+func (dmc *DirMetaCollector) Dirs() []string {
+	dmc.mu.Lock()
+	defer dmc.mu.Unlock()
+	dirs := make([]string, 0, len(dmc.dirs))
+	for dir := range dmc.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// DirMeta 获取网盘目录收集的元数据
+func (dmc *DirMetaCollector) DirMeta(dir string) *DirMeta {
+	dmc.mu.Lock()
+	defer dmc.mu.Unlock()
+	return dmc.dirs[dir]
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/library-go/jsonhelper"
+)
+
+type (
+	// FailedItem 执行失败的文件
+	FailedItem struct {
+		LocalPath  string `json:"localPath,omitempty"`
+		RemotePath string `json:"remotePath"`
+		Size       int64  `json:"size,omitempty"`
+		MD5        string `json:"md5,omitempty"`
+		LastOpTime string `json:"lastOpTime,omitempty"`
+	}
+
+	// FailedList 上传/下载/导入失败的文件列表, 通过 --retry-failed 只重试这些文件
+	FailedList struct {
+		Command    string        `json:"command"`
+		FamilyId   int64         `json:"familyId"`
+		SaveTo     string        `json:"saveTo,omitempty"` // 下载时指定的本地保存目录
+		CreateTime int64         `json:"createTime"`
+		Items      []*FailedItem `json:"items"`
+	}
+)
+
+const (
+	// FailedListDirName 失败列表文件的存储目录
+	FailedListDirName = "cloud189_failed"
+)
+
+// NewFailedList 初始化失败列表
+func NewFailedList(command string, familyId int64) *FailedList {
+	return &FailedList{
+		Command:  command,
+		FamilyId: familyId,
+		Items:    []*FailedItem{},
+	}
+}
+
+// LoadFailedList 读取失败列表文件, command 不一致时返回错误
+func LoadFailedList(filePath, command string) (*FailedList, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fl := &FailedList{}
+	if err = jsonhelper.UnmarshalData(file, fl); err != nil {
+		return nil, fmt.Errorf("解析失败列表文件错误: %s", err)
+	}
+	if fl.Command != command {
+		return nil, fmt.Errorf("失败列表文件是 %s 命令生成的, 请使用 %s --retry-failed 重试", fl.Command, fl.Command)
+	}
+	return fl, nil
+}
+
+// Add 添加失败的文件
+func (fl *FailedList) Add(item *FailedItem) {
+	if fl == nil || item == nil {
+		return
+	}
+	fl.Items = append(fl.Items, item)
+}
+
+// Save 保存到配置目录, 返回保存的文件路径, 列表为空则不保存
+func (fl *FailedList) Save() (string, error) {
+	if fl == nil || len(fl.Items) == 0 {
+		return "", nil
+	}
+	dir := filepath.Join(config.GetConfigDir(), FailedListDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	fl.CreateTime = time.Now().Unix()
+	builder := &strings.Builder{}
+	if err := jsonhelper.MarshalData(builder, fl); err != nil {
+		return "", err
+	}
+
+	// 同一秒内多次保存时, 文件名加上序号
+	name := fl.Command + "_" + time.Now().Format("20060102_150405")
+	filePath := filepath.Join(dir, name+".json")
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(filePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		filePath = filepath.Join(dir, name+"_"+strconv.Itoa(i)+".json")
+	}
+	return filePath, ioutil.WriteFile(filePath, []byte(builder.String()), 0644)
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tickstep/cloudpan189-go/internal/config"
+)
+
+type (
+	// ImportCheckpoint 导入进度, 每导入成功一个文件追加一行记录, 中断后可通过 --resume 跳过已导入的文件
+	ImportCheckpoint struct {
+		path string
+		file *os.File
+		done map[string]bool
+		mu   sync.Mutex
+	}
+
+	// ImportCheckpointHeader 进度文件的第一行, 导入文件或保存位置变化时不能继续
+	ImportCheckpointHeader struct {
+		Source        string `json:"source"`
+		SourceSize    int64  `json:"sourceSize"`
+		SourceModTime int64  `json:"sourceModTime"`
+		SaveTo        string `json:"saveTo"`
+		FamilyId      int64  `json:"familyId"`
+	}
+
+	importCheckpointRecord struct {
+		Path string `json:"path"`
+		MD5  string `json:"md5"`
+	}
+)
+
+const (
+	// ImportCheckpointDirName 导入进度文件的存储目录
+	ImportCheckpointDirName = "cloud189_import"
+)
+
+// ImportCheckpointPath 导入进度文件的路径, 由导入文件, 保存目录和家庭云ID确定
+func ImportCheckpointPath(header *ImportCheckpointHeader) string {
+	sum := md5.Sum([]byte(header.Source + "\n" + header.SaveTo + "\n" + strconv.FormatInt(header.FamilyId, 10)))
+	return filepath.Join(config.GetConfigDir(), ImportCheckpointDirName, hex.EncodeToString(sum[:])+".jsonl")
+}
+
+// OpenImportCheckpoint 打开导入进度文件. resume 为 true 时读取已有的进度, 否则清空重新记录
+func OpenImportCheckpoint(header *ImportCheckpointHeader, resume bool) (*ImportCheckpoint, error) {
+	ic := &ImportCheckpoint{
+		path: ImportCheckpointPath(header),
+		done: map[string]bool{},
+	}
+	if err := os.MkdirAll(filepath.Dir(ic.path), 0755); err != nil {
+		return nil, err
+	}
+
+	if resume {
+		if err := ic.load(header); err != nil {
+			return nil, err
+		}
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !resume || len(ic.done) == 0 {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(ic.path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	ic.file = f
+	if flag&os.O_TRUNC != 0 {
+		if err = ic.writeLine(header); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return ic, nil
+}
+
+func (ic *ImportCheckpoint) load(header *ImportCheckpointHeader) error {
+	f, err := os.Open(ic.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+	saved := &ImportCheckpointHeader{}
+	if err = json.Unmarshal(scanner.Bytes(), saved); err != nil {
+		return fmt.Errorf("解析导入进度文件错误: %s", err)
+	}
+	if *saved != *header {
+		return fmt.Errorf("导入文件在上一次导入后已修改, 无法继续导入, 请去掉 --resume 重新导入")
+	}
+	for scanner.Scan() {
+		rec := &importCheckpointRecord{}
+		// 中断时最后一行可能不完整, 忽略
+		if json.Unmarshal(scanner.Bytes(), rec) == nil {
+			ic.done[importCheckpointKey(rec.Path, rec.MD5)] = true
+		}
+	}
+	return scanner.Err()
+}
+
+func importCheckpointKey(panPath, md5 string) string {
+	return panPath + "\n" + strings.ToUpper(md5)
+}
+
+func (ic *ImportCheckpoint) writeLine(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = ic.file.Write(append(data, '\n'))
+	return err
+}
+
+// IsDone 文件是否已导入
+func (ic *ImportCheckpoint) IsDone(panPath, md5 string) bool {
+	if ic == nil {
+		return false
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	return ic.done[importCheckpointKey(panPath, md5)]
+}
+
+// Done 记录导入成功的文件, 并发安全
+func (ic *ImportCheckpoint) Done(panPath, md5 string) error {
+	if ic == nil {
+		return nil
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.done[importCheckpointKey(panPath, md5)] = true
+	return ic.writeLine(&importCheckpointRecord{Path: panPath, MD5: strings.ToUpper(md5)})
+}
+
+// Count 已导入的文件数量
+func (ic *ImportCheckpoint) Count() int {
+	if ic == nil {
+		return 0
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	return len(ic.done)
+}
+
+// Path 进度文件路径
+func (ic *ImportCheckpoint) Path() string {
+	return ic.path
+}
+
+// Close 关闭进度文件, remove 为 true 时删除, 用于全部导入成功之后
+func (ic *ImportCheckpoint) Close(remove bool) error {
+	if ic == nil {
+		return nil
+	}
+	err := ic.file.Close()
+	if remove {
+		return os.Remove(ic.path)
+	}
+	return err
+}
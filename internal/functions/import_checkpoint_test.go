@@ -0,0 +1,153 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"os"
+	"testing"
+
+	"github.com/tickstep/cloudpan189-go/internal/config"
+)
+
+func newTestCheckpointHeader() *ImportCheckpointHeader {
+	return &ImportCheckpointHeader{
+		Source:        "/tmp/export_files.txt",
+		SourceSize:    100,
+		SourceModTime: 1600000000,
+		SaveTo:        "/cloudpan189-go",
+	}
+}
+
+func TestImportCheckpointResume(t *testing.T) {
+	t.Setenv(config.EnvConfigDir, t.TempDir())
+	header := newTestCheckpointHeader()
+
+	ic, err := OpenImportCheckpoint(header, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ic.Done("/a/1.txt", "3f9eeebc4e583574d9d64a75e5061e56"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ic.Done("/a/2.txt", "C2A2F3B8C1D5F08C6E2E9B1F1E0C7A55"); err != nil {
+		t.Fatal(err)
+	}
+	ic.Close(false)
+
+	// 继续导入时跳过已导入的文件, md5 不区分大小写
+	ic, err = OpenImportCheckpoint(header, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ic.Count() != 2 {
+		t.Errorf("Count() = %d, want 2", ic.Count())
+	}
+	if !ic.IsDone("/a/1.txt", "3F9EEEBC4E583574D9D64A75E5061E56") || !ic.IsDone("/a/2.txt", "c2a2f3b8c1d5f08c6e2e9b1f1e0c7a55") {
+		t.Error("completed files should be skipped")
+	}
+	if ic.IsDone("/a/3.txt", "3F9EEEBC4E583574D9D64A75E5061E56") || ic.IsDone("/a/1.txt", "C2A2F3B8C1D5F08C6E2E9B1F1E0C7A55") {
+		t.Error("pending files should not be skipped")
+	}
+	// 继续导入后新记录追加到原有进度之后
+	if err = ic.Done("/a/3.txt", "3F9EEEBC4E583574D9D64A75E5061E56"); err != nil {
+		t.Fatal(err)
+	}
+	ic.Close(false)
+
+	ic, err = OpenImportCheckpoint(header, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ic.Count() != 3 {
+		t.Errorf("Count() = %d, want 3", ic.Count())
+	}
+	ic.Close(false)
+
+	// 不继续导入时清空进度
+	ic, err = OpenImportCheckpoint(header, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ic.Count() != 0 || ic.IsDone("/a/1.txt", "3F9EEEBC4E583574D9D64A75E5061E56") {
+		t.Error("progress should be cleared without resume")
+	}
+	ic.Close(false)
+	ic, err = OpenImportCheckpoint(header, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ic.Count() != 0 {
+		t.Errorf("Count() = %d, want 0", ic.Count())
+	}
+
+	if err = ic.Close(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(ic.Path()); !os.IsNotExist(err) {
+		t.Errorf("checkpoint file should be removed: %v", err)
+	}
+}
+
+func TestImportCheckpointIncompleteLine(t *testing.T) {
+	t.Setenv(config.EnvConfigDir, t.TempDir())
+	header := newTestCheckpointHeader()
+
+	ic, err := OpenImportCheckpoint(header, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ic.Done("/a/1.txt", "3F9EEEBC4E583574D9D64A75E5061E56")
+	// 模拟写入时中断
+	ic.file.WriteString(`{"path":"/a/2.txt","md5":"C2A2F3`)
+	ic.Close(false)
+
+	ic, err = OpenImportCheckpoint(header, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ic.Close(true)
+	if ic.Count() != 1 || !ic.IsDone("/a/1.txt", "3F9EEEBC4E583574D9D64A75E5061E56") {
+		t.Errorf("Count() = %d, want 1", ic.Count())
+	}
+	if ic.IsDone("/a/2.txt", "C2A2F3B8C1D5F08C6E2E9B1F1E0C7A55") {
+		t.Error("incomplete line should be ignored")
+	}
+}
+
+func TestImportCheckpointHeaderChanged(t *testing.T) {
+	t.Setenv(config.EnvConfigDir, t.TempDir())
+	header := newTestCheckpointHeader()
+
+	ic, err := OpenImportCheckpoint(header, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ic.Done("/a/1.txt", "3F9EEEBC4E583574D9D64A75E5061E56")
+	ic.Close(false)
+
+	// 导入文件已修改, 不能继续导入
+	changed := newTestCheckpointHeader()
+	changed.SourceSize = 200
+	if ic, err = OpenImportCheckpoint(changed, true); err == nil {
+		ic.Close(true)
+		t.Error("expected error when the import file changed")
+	}
+
+	// 保存目录不同时使用另一个进度文件
+	other := newTestCheckpointHeader()
+	other.SaveTo = "/other"
+	if ImportCheckpointPath(other) == ImportCheckpointPath(header) {
+		t.Error("checkpoint path should depend on the save directory")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// OnExistsPolicy 目标文件已存在时的处理方式
+type OnExistsPolicy string
+
+const (
+	// OnExistsSkip 跳过
+	OnExistsSkip OnExistsPolicy = "skip"
+	// OnExistsOverwrite 覆盖
+	OnExistsOverwrite OnExistsPolicy = "overwrite"
+	// OnExistsRename 自动重命名, 保存为 "文件名 (1).扩展名"
+	OnExistsRename OnExistsPolicy = "rename"
+	// OnExistsNewer 源文件比目标文件新时覆盖, 否则跳过
+	OnExistsNewer OnExistsPolicy = "newer"
+	// OnExistsDifferent 大小或md5不同时覆盖, 否则跳过
+	OnExistsDifferent OnExistsPolicy = "different"
+
+	// MaxRenameCount 自动重命名的最大尝试次数
+	MaxRenameCount = 1000
+)
+
+// ParseOnExistsPolicy 解析 --on-exists, 未指定时按 overwrite 参数决定覆盖或使用默认的 def
+func ParseOnExistsPolicy(s string, overwrite bool, def OnExistsPolicy) (OnExistsPolicy, error) {
+	switch p := OnExistsPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		if overwrite {
+			return OnExistsOverwrite, nil
+		}
+		return def, nil
+	case OnExistsSkip, OnExistsOverwrite, OnExistsRename, OnExistsNewer, OnExistsDifferent:
+		if overwrite && p != OnExistsOverwrite {
+			return "", fmt.Errorf("-ow 与 --on-exists %s 不能同时使用", p)
+		}
+		return p, nil
+	}
+	return "", fmt.Errorf("不支持的 --on-exists: %s, 可选 skip, overwrite, rename, newer, different", s)
+}
+
+// RenameCandidate 生成第n个重命名候选, 例如 a.txt -> a (1).txt
+func RenameCandidate(name string, n int) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if base == "" {
+		// .bashrc 之类的文件没有扩展名
+		base, ext = name, ""
+	}
+	return fmt.Sprintf("%s (%d)%s", base, n, ext)
+}
+
+// ParsePanTime 解析网盘文件的时间, 例如 LastOpTime, 格式错误时返回零值
+func ParsePanTime(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// IsNewer 源文件的修改时间是否比目标文件新, 按秒比较, 网盘的时间只精确到秒
+func IsNewer(src, dst time.Time) bool {
+	return src.Truncate(time.Second).After(dst.Truncate(time.Second))
+}
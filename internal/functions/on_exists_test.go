@@ -0,0 +1,66 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"testing"
+)
+
+func TestParseOnExistsPolicy(t *testing.T) {
+	cases := []struct {
+		s         string
+		overwrite bool
+		def       OnExistsPolicy
+		want      OnExistsPolicy
+		ok        bool
+	}{
+		{"", false, "", "", true},
+		{"", false, OnExistsSkip, OnExistsSkip, true},
+		{"", true, OnExistsSkip, OnExistsOverwrite, true},
+		{"rename", false, "", OnExistsRename, true},
+		{" Newer ", false, "", OnExistsNewer, true},
+		{"different", false, OnExistsSkip, OnExistsDifferent, true},
+		{"overwrite", true, "", OnExistsOverwrite, true},
+		{"skip", true, "", "", false},
+		{"rename", true, "", "", false},
+		{"unknown", false, "", "", false},
+	}
+	for _, c := range cases {
+		p, err := ParseOnExistsPolicy(c.s, c.overwrite, c.def)
+		if c.ok != (err == nil) || p != c.want {
+			t.Fatalf("%q, -ow=%v: got %q, %v", c.s, c.overwrite, p, err)
+		}
+	}
+}
+
+func TestRenameCandidate(t *testing.T) {
+	cases := []struct {
+		name string
+		n    int
+		want string
+	}{
+		{"a.txt", 1, "a (1).txt"},
+		{"a.txt", 12, "a (12).txt"},
+		{"noext", 1, "noext (1)"},
+		{".bashrc", 1, ".bashrc (1)"},
+		{".config.json", 2, ".config (2).json"},
+		{"a.tar.gz", 1, "a.tar (1).gz"},
+		{"a.", 1, "a (1)."},
+	}
+	for _, c := range cases {
+		if got := RenameCandidate(c.name, c.n); got != c.want {
+			t.Fatalf("%s, %d: got %s, want %s", c.name, c.n, got, c.want)
+		}
+	}
+}
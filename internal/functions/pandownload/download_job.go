@@ -0,0 +1,327 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pandownload
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/utils"
+	"github.com/tickstep/library-go/jsonhelper"
+)
+
+type (
+	// DownloadJobItemStatus 下载任务中单个路径的状态
+	DownloadJobItemStatus string
+
+	// DownloadJobItem 下载任务中的单个文件或目录
+	DownloadJobItem struct {
+		PanPath  string                `json:"panPath"`
+		SavePath string                `json:"savePath"`
+		IsFolder bool                  `json:"isFolder"`
+		Status   DownloadJobItemStatus `json:"status"`
+	}
+
+	// DownloadJobOptions 下载任务的可选参数, 恢复任务时沿用
+	DownloadJobOptions struct {
+		IsExecutedPermission bool              `json:"isExecutedPermission"`
+		IsOverwrite          bool              `json:"isOverwrite"`
+		NoCheck              bool              `json:"noCheck"`
+		Parallel             int               `json:"parallel"`
+		MaxRetry             int               `json:"maxRetry"`
+		ExcludeNames         []string          `json:"excludeNames"`
+		Filter               *utils.FileFilter `json:"filter,omitempty"`
+
+		OnExists functions.OnExistsPolicy `json:"onExists,omitempty"`
+		Prealloc bool                     `json:"prealloc,omitempty"`
+		PartFile bool                     `json:"partFile,omitempty"`
+	}
+
+	// DownloadJob 可恢复的下载任务, 进程退出后可以从队列文件中继续下载
+	DownloadJob struct {
+		Id         int64               `json:"id"`
+		FamilyId   int64               `json:"familyId"`
+		Paths      []string            `json:"paths"`
+		SaveRoot   string              `json:"saveRoot"`
+		Options    *DownloadJobOptions `json:"options"`
+		Items      []*DownloadJobItem  `json:"items"`
+		CreateTime int64               `json:"createTime"`
+		UpdateTime int64               `json:"updateTime"`
+
+		itemMap  map[string]*DownloadJobItem
+		lastSave time.Time
+		locker   sync.Mutex
+	}
+)
+
+const (
+	// DownloadJobItemPending 等待下载
+	DownloadJobItemPending DownloadJobItemStatus = "pending"
+	// DownloadJobItemCompleted 已完成
+	DownloadJobItemCompleted DownloadJobItemStatus = "completed"
+	// DownloadJobItemFailed 下载失败
+	DownloadJobItemFailed DownloadJobItemStatus = "failed"
+
+	// DownloadJobDirName 下载任务队列文件的存储目录
+	DownloadJobDirName = "cloud189_download_jobs"
+
+	// downloadJobSaveInterval 下载任务队列文件的最短保存间隔
+	downloadJobSaveInterval = 1 * time.Second
+)
+
+var (
+	// ErrDownloadJobNotFound 下载任务不存在
+	ErrDownloadJobNotFound = errors.New("download job not found")
+)
+
+func downloadJobDir() string {
+	return filepath.Join(config.GetConfigDir(), DownloadJobDirName)
+}
+
+func downloadJobFilePath(id int64) string {
+	return filepath.Join(downloadJobDir(), strconv.FormatInt(id, 10)+".json")
+}
+
+// NewDownloadJob 创建新的下载任务, 并分配任务ID
+func NewDownloadJob(familyId int64, paths []string, saveRoot string, options *DownloadJobOptions) (*DownloadJob, error) {
+	err := os.MkdirAll(downloadJobDir(), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := ListDownloadJobs()
+	if err != nil {
+		return nil, err
+	}
+	var id int64 = 1
+	for _, j := range jobs {
+		if j.Id >= id {
+			id = j.Id + 1
+		}
+	}
+
+	// 占用任务文件, 避免多个进程分配到相同的ID
+	for {
+		f, err := os.OpenFile(downloadJobFilePath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		id++
+	}
+
+	if options == nil {
+		options = &DownloadJobOptions{}
+	}
+	job := &DownloadJob{
+		Id:         id,
+		FamilyId:   familyId,
+		Paths:      paths,
+		SaveRoot:   saveRoot,
+		Options:    options,
+		Items:      []*DownloadJobItem{},
+		CreateTime: time.Now().Unix(),
+		itemMap:    map[string]*DownloadJobItem{},
+	}
+	return job, job.Save()
+}
+
+// LoadDownloadJob 读取下载任务
+func LoadDownloadJob(id int64) (*DownloadJob, error) {
+	file, err := os.Open(downloadJobFilePath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrDownloadJobNotFound
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	job := &DownloadJob{}
+	err = jsonhelper.UnmarshalData(file, job)
+	if err != nil {
+		return nil, err
+	}
+	job.Id = id
+	if job.Options == nil {
+		job.Options = &DownloadJobOptions{}
+	}
+	job.itemMap = make(map[string]*DownloadJobItem, len(job.Items))
+	for _, item := range job.Items {
+		job.itemMap[item.PanPath] = item
+	}
+	return job, nil
+}
+
+// ListDownloadJobs 列出所有未删除的下载任务, 按ID排序
+func ListDownloadJobs() ([]*DownloadJob, error) {
+	infos, err := ioutil.ReadDir(downloadJobDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	jobs := make([]*DownloadJob, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		job, err := LoadDownloadJob(id)
+		if err != nil {
+			// 正在创建或已损坏的任务文件, 仍然占用ID
+			job = &DownloadJob{Id: id, Options: &DownloadJobOptions{}}
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Id < jobs[j].Id
+	})
+	return jobs, nil
+}
+
+// RemoveDownloadJob 删除下载任务
+func RemoveDownloadJob(id int64) error {
+	err := os.Remove(downloadJobFilePath(id))
+	if err != nil && os.IsNotExist(err) {
+		return ErrDownloadJobNotFound
+	}
+	return err
+}
+
+// AddItem 添加下载路径, 已存在则返回false
+func (job *DownloadJob) AddItem(panPath, savePath string, isFolder bool) bool {
+	job.locker.Lock()
+	defer job.locker.Unlock()
+
+	if _, ok := job.itemMap[panPath]; ok {
+		return false
+	}
+	item := &DownloadJobItem{
+		PanPath:  panPath,
+		SavePath: savePath,
+		IsFolder: isFolder,
+		Status:   DownloadJobItemPending,
+	}
+	job.Items = append(job.Items, item)
+	job.itemMap[panPath] = item
+	job.saveLazily()
+	return true
+}
+
+// SetItemStatus 更新下载路径的状态
+func (job *DownloadJob) SetItemStatus(panPath string, status DownloadJobItemStatus) {
+	job.locker.Lock()
+	defer job.locker.Unlock()
+
+	item, ok := job.itemMap[panPath]
+	if !ok {
+		return
+	}
+	item.Status = status
+	job.saveLazily()
+}
+
+// UnfinishedItems 返回未完成的下载路径, 包括下载失败的
+func (job *DownloadJob) UnfinishedItems() []*DownloadJobItem {
+	job.locker.Lock()
+	defer job.locker.Unlock()
+
+	items := make([]*DownloadJobItem, 0)
+	for _, item := range job.Items {
+		if item.Status != DownloadJobItemCompleted {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Count 统计下载路径的数量
+func (job *DownloadJob) Count() (total, completed, failed int) {
+	job.locker.Lock()
+	defer job.locker.Unlock()
+
+	for _, item := range job.Items {
+		switch item.Status {
+		case DownloadJobItemCompleted:
+			completed++
+		case DownloadJobItemFailed:
+			failed++
+		}
+	}
+	return len(job.Items), completed, failed
+}
+
+// IsFinished 是否所有路径均已下载完成
+func (job *DownloadJob) IsFinished() bool {
+	total, completed, _ := job.Count()
+	return total == completed
+}
+
+func (job *DownloadJob) saveLazily() {
+	if time.Since(job.lastSave) < downloadJobSaveInterval {
+		return
+	}
+	job.save()
+}
+
+// Save 保存任务到队列文件
+func (job *DownloadJob) Save() error {
+	job.locker.Lock()
+	defer job.locker.Unlock()
+	return job.save()
+}
+
+func (job *DownloadJob) save() error {
+	job.lastSave = time.Now()
+	job.UpdateTime = job.lastSave.Unix()
+
+	builder := &strings.Builder{}
+	err := jsonhelper.MarshalData(builder, job)
+	if err != nil {
+		return err
+	}
+
+	// 先写入临时文件再替换, 防止进程中断导致队列文件损坏
+	target := downloadJobFilePath(job.Id)
+	tmp := target + ".tmp"
+	err = ioutil.WriteFile(tmp, []byte(builder.String()), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+// Remove 删除队列文件
+func (job *DownloadJob) Remove() error {
+	return RemoveDownloadJob(job.Id)
+}
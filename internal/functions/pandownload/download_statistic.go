@@ -15,10 +15,36 @@ package pandownload
 
 import (
 	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"sync"
 )
 
 type (
 	DownloadStatistic struct {
 		functions.Statistic
+
+		checksumFailedList []*ChecksumFailedItem
+		mu                 sync.Mutex
+	}
+
+	// ChecksumFailedItem 校验失败的文件
+	ChecksumFailedItem struct {
+		PanPath  string
+		SavePath string
+		LocalMd5 string
+		PanMd5   string
 	}
 )
+
+// AddChecksumFailed 记录校验失败的文件
+func (ds *DownloadStatistic) AddChecksumFailed(item *ChecksumFailedItem) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.checksumFailedList = append(ds.checksumFailedList, item)
+}
+
+// ChecksumFailedList 返回校验失败的文件列表
+func (ds *DownloadStatistic) ChecksumFailedList() []*ChecksumFailedItem {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return append([]*ChecksumFailedItem{}, ds.checksumFailedList...)
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pandownload
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/library-go/converter"
+)
+
+const (
+	// SummaryFormatText 以表格输出目录统计
+	SummaryFormatText = "text"
+	// SummaryFormatJSON 以json输出目录统计
+	SummaryFormatJSON = "json"
+)
+
+type (
+	// DirSummary 单个目录的下载统计, 只统计目录下直接包含的文件
+	DirSummary struct {
+		PanPath  string `json:"panPath"`
+		SavePath string `json:"savePath,omitempty"`
+		Files    int    `json:"files"` // 下载成功的文件数
+		Bytes    int64  `json:"bytes"` // 下载成功的文件大小
+		Skipped  int    `json:"skipped"`
+		Failed   int    `json:"failed"`
+		Error    string `json:"error,omitempty"` // 目录本身的错误, 例如创建本地目录失败
+	}
+
+	// DownloadSummary 按目录汇总的下载统计
+	DownloadSummary struct {
+		mu       sync.Mutex
+		dirs     map[string]*DirSummary
+		dirCount int // 下载的目录数量
+	}
+)
+
+// CheckSummaryFormat 检查目录统计的输出格式
+func CheckSummaryFormat(format string) error {
+	switch format {
+	case "", SummaryFormatText, SummaryFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("不支持的统计输出格式: %s, 可选 %s 或 %s", format, SummaryFormatText, SummaryFormatJSON)
+}
+
+// NewDownloadSummary 初始化
+func NewDownloadSummary() *DownloadSummary {
+	return &DownloadSummary{
+		dirs: map[string]*DirSummary{},
+	}
+}
+
+func (ds *DownloadSummary) dir(panPath string) *DirSummary {
+	d, ok := ds.dirs[panPath]
+	if !ok {
+		d = &DirSummary{PanPath: panPath}
+		ds.dirs[panPath] = d
+	}
+	return d
+}
+
+// AddDir 记录已创建的本地目录, 空目录也会出现在统计中
+func (ds *DownloadSummary) AddDir(panPath, savePath string) {
+	if ds == nil {
+		return
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	d := ds.dir(panPath)
+	if d.SavePath == "" {
+		ds.dirCount++
+	}
+	d.SavePath = savePath
+}
+
+// SetDirError 记录目录本身的错误
+func (ds *DownloadSummary) SetDirError(panPath, savePath string, err string) {
+	if ds == nil {
+		return
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	d := ds.dir(panPath)
+	if d.SavePath == "" {
+		ds.dirCount++
+	}
+	d.SavePath = savePath
+	d.Error = err
+}
+
+// AddFile 记录文件的下载结果, 统计到所在目录
+func (ds *DownloadSummary) AddFile(panPath string, size int64, status functions.TransferStatus) {
+	if ds == nil {
+		return
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	d := ds.dir(path.Dir(panPath))
+	switch status {
+	case functions.TransferStatusDownloaded:
+		d.Files++
+		d.Bytes += size
+	case functions.TransferStatusSkipped:
+		d.Skipped++
+	case functions.TransferStatusFailed:
+		d.Failed++
+	}
+}
+
+// Dirs 返回按路径排序的目录统计
+func (ds *DownloadSummary) Dirs() []*DirSummary {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	dirs := make([]*DirSummary, 0, len(ds.dirs))
+	for _, d := range ds.dirs {
+		item := *d
+		dirs = append(dirs, &item)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].PanPath < dirs[j].PanPath
+	})
+	return dirs
+}
+
+// HasDir 是否下载了目录, 只下载文件时不需要输出目录统计
+func (ds *DownloadSummary) HasDir() bool {
+	if ds == nil {
+		return false
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.dirCount > 0
+}
+
+// Print 输出目录统计
+func (ds *DownloadSummary) Print(w io.Writer, format string) error {
+	dirs := ds.Dirs()
+	total := DirSummary{PanPath: "总计"}
+	for _, d := range dirs {
+		total.Files += d.Files
+		total.Bytes += d.Bytes
+		total.Skipped += d.Skipped
+		total.Failed += d.Failed
+	}
+
+	if format == SummaryFormatJSON {
+		type summaryTotal struct {
+			Files   int   `json:"files"`
+			Bytes   int64 `json:"bytes"`
+			Skipped int   `json:"skipped"`
+			Failed  int   `json:"failed"`
+		}
+		data, err := jsoniter.MarshalIndent(struct {
+			Dirs  []*DirSummary `json:"dirs"`
+			Total summaryTotal  `json:"total"`
+		}{
+			Dirs:  dirs,
+			Total: summaryTotal{total.Files, total.Bytes, total.Skipped, total.Failed},
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+
+	tb := cmdtable.NewTable(w)
+	tb.SetHeader([]string{"目录", "文件", "大小", "跳过", "失败", "错误"})
+	for _, d := range append(dirs, &total) {
+		tb.Append([]string{d.PanPath, strconv.Itoa(d.Files), converter.ConvertFileSize(d.Bytes, 2), strconv.Itoa(d.Skipped), strconv.Itoa(d.Failed), d.Error})
+	}
+	tb.Render()
+	return nil
+}
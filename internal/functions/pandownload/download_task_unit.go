@@ -14,6 +14,9 @@
 package pandownload
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/tickstep/cloudpan189-api/cloudpan"
@@ -21,6 +24,8 @@ import (
 	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
 	"github.com/tickstep/cloudpan189-go/internal/file/downloader"
 	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panupload"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
 	"github.com/tickstep/cloudpan189-go/internal/taskframework"
 	"github.com/tickstep/cloudpan189-go/internal/utils"
 	"github.com/tickstep/cloudpan189-go/library/requester/transfer"
@@ -34,6 +39,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -46,7 +52,12 @@ type (
 		PanClient          *cloudpan.PanClient
 		ParentTaskExecutor *taskframework.TaskExecutor
 
-		DownloadStatistic *DownloadStatistic // 下载统计
+		DownloadStatistic *DownloadStatistic        // 下载统计
+		DownloadJob       *DownloadJob              // 可恢复的下载任务, 为nil则不记录
+		Report            *functions.TransferReport // 传输报告, 为nil则不记录
+		Filter            *utils.FileFilter         // 文件过滤条件, 为nil时只使用 Cfg.ExcludeNames
+		Summary           *DownloadSummary          // 按目录汇总的下载统计, 为nil则不记录
+		Output            io.Writer                 // 输出, 为nil时输出到标准输出
 
 		// 可选项
 		VerbosePrinter       *logger.CmdVerbose
@@ -55,6 +66,13 @@ type (
 		IsExecutedPermission bool // 下载成功后是否加上执行权限
 		IsOverwrite          bool // 是否覆盖已存在的文件
 		NoCheck              bool // 不校验文件
+		RestoreLinks         bool // 将符号链接描述文件还原为符号链接
+		NoMtime              bool // 不还原文件的修改时间
+		RestoreMode          bool // 还原上传时记录的文件权限
+
+		OnExists  functions.OnExistsPolicy // 本地文件已存在时的处理方式, 为空时按 IsOverwrite 覆盖或跳过
+		HashCache *localfile.HashCache     // 文件摘要缓存, 用于 different 比较md5
+		PartFile  bool                     // 先下载到 .part 临时文件, 完成后再重命名为保存路径
 
 		FilePanPath        string // 要下载的网盘文件路径
 		SavePath           string // 文件保存在本地的路径
@@ -62,6 +80,24 @@ type (
 		FamilyId           int64  // 家庭云ID, 个人云默认为0
 
 		fileInfo *cloudpan.AppFileEntity // 文件或目录详情
+		localMd5 string                  // 下载过程中计算的文件md5
+		partPath string                  // 使用 .part 临时文件时实际写入的路径
+		control  *downloadControl        // 暂停和恢复下载, 每个任务单独一个
+
+		fileMeta   *functions.FileMeta // 上传时记录的文件元数据
+		metaLoaded bool                // 是否已读取所在目录的元数据文件
+
+		startTime   time.Time // 首次执行的时间, 重试不重置
+		transferred int64     // 实际下载的数据量, 不包含断点续传已下载的部分
+		skipped     bool      // 本地文件已存在, 跳过下载
+		reported    bool
+	}
+
+	// downloadControl 记录正在执行的下载, 用于暂停和恢复
+	downloadControl struct {
+		mu     sync.Mutex
+		der    *downloader.Downloader
+		paused bool
 	}
 )
 
@@ -70,6 +106,8 @@ const (
 	DefaultPrintFormat = "\r[%s] ↓ %s/%s %s/s in %s, left %s ............"
 	//DownloadSuffix 文件下载后缀
 	DownloadSuffix = ".cloudpan189-downloading"
+	//PartSuffix 下载未完成的临时文件后缀
+	PartSuffix = ".part"
 	//StrDownloadInitError 初始化下载发生错误
 	StrDownloadInitError = "初始化下载发生错误"
 	// StrDownloadFailed 下载文件失败
@@ -84,6 +122,45 @@ const (
 
 func (dtu *DownloadTaskUnit) SetTaskInfo(info *taskframework.TaskInfo) {
 	dtu.taskInfo = info
+	dtu.control = &downloadControl{}
+}
+
+// setDownloader 设置正在执行的下载, 已暂停则立即暂停该下载
+func (dc *downloadControl) setDownloader(der *downloader.Downloader) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.der = der
+	if der != nil && dc.paused {
+		der.Pause()
+	}
+}
+
+// Pause 暂停下载
+func (dtu *DownloadTaskUnit) Pause() {
+	dtu.control.mu.Lock()
+	defer dtu.control.mu.Unlock()
+	dtu.control.paused = true
+	if dtu.control.der != nil {
+		dtu.control.der.Pause()
+	}
+}
+
+// Resume 恢复下载
+func (dtu *DownloadTaskUnit) Resume() {
+	dtu.control.mu.Lock()
+	defer dtu.control.mu.Unlock()
+	dtu.control.paused = false
+	if dtu.control.der != nil {
+		dtu.control.der.Resume()
+	}
+}
+
+// TaskSize 文件大小, 目录和未获取文件信息的返回0
+func (dtu *DownloadTaskUnit) TaskSize() int64 {
+	if dtu.fileInfo == nil || dtu.fileInfo.IsFolder {
+		return 0
+	}
+	return dtu.fileInfo.FileSize
 }
 
 func (dtu *DownloadTaskUnit) verboseInfof(format string, a ...interface{}) {
@@ -100,6 +177,8 @@ func (dtu *DownloadTaskUnit) download() (err error) {
 	)
 
 	dtu.Cfg.InstanceStatePath = dtu.SavePath + DownloadSuffix
+	dtu.setPartPath()
+	writePath := dtu.writePath()
 
 	// 创建下载的目录
 	// 获取SavePath所在的目录
@@ -116,14 +195,28 @@ func (dtu *DownloadTaskUnit) download() (err error) {
 		return fmt.Errorf("%s, path %s: not a directory", StrDownloadInitError, dir)
 	}
 
-	// 打开文件
-	writer, file, err = downloader.NewDownloaderWriterByFilename(dtu.SavePath, os.O_CREATE|os.O_WRONLY, 0666)
+	// 打开文件, 需要可读, 用于下载完成后补齐计算md5
+	writer, file, err = downloader.NewDownloaderWriterByFilename(writePath, os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
 		return fmt.Errorf("%s, %s", StrDownloadInitError, err)
 	}
 	defer file.Close()
 
+	// 边下载边计算md5
+	var checksumWriter *downloader.ChecksumWriter
+	dtu.localMd5 = ""
+	if !dtu.NoCheck && dtu.fileInfo.FileMd5 != "" {
+		checksumWriter = downloader.NewChecksumWriter(writer, md5.New())
+		writer = checksumWriter
+	}
+	countWriter := downloader.NewCountWriter(writer)
+	writer = countWriter
+	defer func() {
+		dtu.transferred += countWriter.Count()
+	}()
+
 	der := downloader.NewDownloader(writer, dtu.Cfg, dtu.PanClient)
+	der.SetContext(dtu.taskInfo.Context())
 	der.SetFileInfo(dtu.fileInfo)
 	der.SetFamilyId(dtu.FamilyId)
 	der.SetStatusCodeBodyCheckFunc(func(respBody io.Reader) error {
@@ -146,16 +239,35 @@ func (dtu *DownloadTaskUnit) download() (err error) {
 			var (
 				tb = cmdtable.NewTable(builder)
 			)
-			tb.SetHeader([]string{"#", "status", "range", "left", "speeds", "error"})
+			tb.SetHeader([]string{"#", "status", "range", "left", "speeds", "endpoint", "error"})
+			endpointWorkers := map[*downloader.Endpoint]int{}
 			workersCallback(func(key int, worker *downloader.Worker) bool {
 				wrange := worker.GetRange()
-				tb.Append([]string{fmt.Sprint(worker.ID()), worker.GetStatus().StatusText(), wrange.ShowDetails(), strconv.FormatInt(wrange.Len(), 10), strconv.FormatInt(worker.GetSpeedsPerSecond(), 10), fmt.Sprint(worker.Err())})
+				var host string
+				if ep := worker.Endpoint(); ep != nil {
+					host = ep.Host()
+					if !worker.Completed() {
+						endpointWorkers[ep]++
+					}
+				}
+				tb.Append([]string{fmt.Sprint(worker.ID()), worker.GetStatus().StatusText(), wrange.ShowDetails(), strconv.FormatInt(wrange.Len(), 10), strconv.FormatInt(worker.GetSpeedsPerSecond(), 10), host, fmt.Sprint(worker.Err())})
 				return true
 			})
 
 			// 先空两行
 			builder.WriteString("\n\n")
 			tb.Render()
+
+			// 输出各下载节点的统计
+			etb := cmdtable.NewTable(builder)
+			etb.SetHeader([]string{"endpoint", "workers", "requests", "errors", "downloaded", "avg speeds", "score"})
+			der.RangeEndpoint(func(ep *downloader.Endpoint) bool {
+				etb.Append([]string{ep.Host(), strconv.Itoa(endpointWorkers[ep]), strconv.FormatInt(ep.Requests(), 10), strconv.FormatInt(ep.Errors(), 10),
+					converter.ConvertFileSize(ep.Downloaded(), 2), converter.ConvertFileSize(ep.SpeedsPerSecond(), 2) + "/s", strconv.FormatInt(ep.Score(), 10)})
+				return true
+			})
+			builder.WriteString("\n")
+			etb.Render()
 		}
 
 		// 如果下载速度为0, 剩余下载时间未知, 则用 - 代替
@@ -178,17 +290,19 @@ func (dtu *DownloadTaskUnit) download() (err error) {
 
 		if !isComplete {
 			// 如果未完成下载, 就输出
-			fmt.Print(builder.String())
+			dtu.printf("%s", builder.String())
 		}
 	})
 
 	der.OnExecute(func() {
-		fmt.Printf("[%s] 下载开始\n\n", dtu.taskInfo.Id())
+		dtu.control.setDownloader(der)
+		dtu.printf("[%s] 下载开始\n\n", dtu.taskInfo.Id())
 	})
 
 	err = der.Execute()
+	dtu.control.setDownloader(nil)
 	isComplete = true
-	fmt.Print("\n")
+	dtu.printf("\n")
 
 	if err != nil {
 		// check zero size file
@@ -201,8 +315,8 @@ func (dtu *DownloadTaskUnit) download() (err error) {
 			if info, infoErr := file.Stat(); infoErr == nil {
 				if info.Size() == 0 {
 					// 空文件, 应该删除
-					dtu.verboseInfof("[%s] remove empty file: %s\n", dtu.taskInfo.Id(), dtu.SavePath)
-					removeErr := os.Remove(dtu.SavePath)
+					dtu.verboseInfof("[%s] remove empty file: %s\n", dtu.taskInfo.Id(), writePath)
+					removeErr := os.Remove(writePath)
 					if removeErr != nil {
 						dtu.verboseInfof("[%s] remove file error: %s\n", dtu.taskInfo.Id(), removeErr)
 					}
@@ -213,17 +327,54 @@ func (dtu *DownloadTaskUnit) download() (err error) {
 	}
 
 	// 下载成功
+	if checksumWriter != nil {
+		sum, sumErr := checksumWriter.Sum(file, dtu.fileInfo.FileSize)
+		if sumErr != nil {
+			dtu.verboseInfof("[%s] checksum error: %s\n", dtu.taskInfo.Id(), sumErr)
+		} else {
+			dtu.localMd5 = hex.EncodeToString(sum)
+		}
+	}
+
 	if dtu.IsExecutedPermission {
 		err = file.Chmod(0766)
 		if err != nil {
-			fmt.Printf("[%s] 警告, 加执行权限错误: %s\n", dtu.taskInfo.Id(), err)
+			dtu.printf("[%s] 警告, 加执行权限错误: %s\n", dtu.taskInfo.Id(), err)
 		}
 	}
-	fmt.Printf("[%s] 下载完成, 保存位置: %s\n", dtu.taskInfo.Id(), dtu.SavePath)
+	dtu.printf("[%s] 下载完成, 保存位置: %s\n", dtu.taskInfo.Id(), dtu.SavePath)
 
 	return nil
 }
 
+// setPartPath 指定 --part 或存在未完成的 .part 下载时, 写入 .part 临时文件, 未完成的文件不出现在保存路径上
+func (dtu *DownloadTaskUnit) setPartPath() {
+	dtu.partPath = ""
+	if dtu.PartFile || hasPartDownload(dtu.SavePath) {
+		dtu.partPath = dtu.SavePath + PartSuffix
+	}
+}
+
+// writePath 下载时实际写入的文件路径
+func (dtu *DownloadTaskUnit) writePath() string {
+	if dtu.partPath != "" {
+		return dtu.partPath
+	}
+	return dtu.SavePath
+}
+
+// commitPartFile 下载并校验完成后, 将 .part 临时文件重命名为保存路径
+func (dtu *DownloadTaskUnit) commitPartFile() error {
+	if dtu.partPath == "" {
+		return nil
+	}
+	if err := os.Rename(dtu.partPath, dtu.SavePath); err != nil {
+		return fmt.Errorf("重命名临时文件失败: %s", err)
+	}
+	dtu.partPath = ""
+	return nil
+}
+
 //panHTTPClient 获取包含特定User-Agent的HTTPClient
 func (dtu *DownloadTaskUnit) panHTTPClient() (client *requester.HTTPClient) {
 	client = requester.NewHTTPClient()
@@ -261,16 +412,27 @@ func (dtu *DownloadTaskUnit) handleError(result *taskframework.TaskUnitRunResult
 func (dtu *DownloadTaskUnit) checkFileValid(result *taskframework.TaskUnitRunResult) (ok bool) {
 	if dtu.NoCheck {
 		// 不检测文件有效性
-		return
-	}
-
-	if dtu.fileInfo.FileSize >= 128*converter.MB {
-		// 大文件, 输出一句提示消息
-		fmt.Printf("[%s] 开始检验文件有效性, 请稍候...\n", dtu.taskInfo.Id())
+		return true
 	}
 
 	// 就在这里处理校验出错
-	err := CheckFileValid(dtu.SavePath, dtu.fileInfo)
+	var err error
+	localMd5 := dtu.localMd5
+	if localMd5 == "" && dtu.fileInfo.FileMd5 != "" {
+		// 下载时未能计算md5, 重新读取文件计算
+		if dtu.fileInfo.FileSize >= 128*converter.MB {
+			// 大文件, 输出一句提示消息
+			dtu.printf("[%s] 开始检验文件有效性, 请稍候...\n", dtu.taskInfo.Id())
+		}
+		var lfc *localfile.LocalFileEntity
+		lfc, err = localfile.GetFileSum(dtu.writePath(), localfile.CHECKSUM_MD5)
+		if err == nil {
+			localMd5 = lfc.MD5
+		}
+	}
+	if err == nil {
+		err = CheckFileMd5(localMd5, dtu.fileInfo)
+	}
 	if err != nil {
 		result.ResultMessage = StrDownloadChecksumFailed
 		result.Err = err
@@ -279,14 +441,24 @@ func (dtu *DownloadTaskUnit) checkFileValid(result *taskframework.TaskUnitRunRes
 			// 文件不支持校验
 			result.ResultMessage = "检验文件有效性"
 			result.Err = err
-			fmt.Printf("[%s] 检验文件有效性: %s\n", dtu.taskInfo.Id(), err)
+			dtu.printf("[%s] 检验文件有效性: %s\n", dtu.taskInfo.Id(), err)
 			return true
 		case ErrDownloadFileBanned:
 			// 违规文件
 			result.NeedRetry = false
 			return
 		case ErrDownloadChecksumFailed:
-			// 校验失败, 需要重新下载
+			// 校验失败, 删除损坏的文件, 重新下载
+			dtu.printf("[%s] 文件校验失败, 本地md5: %s, 网盘md5: %s, 将重新下载: %s\n", dtu.taskInfo.Id(), localMd5, dtu.fileInfo.FileMd5, dtu.FilePanPath)
+			dtu.DownloadStatistic.AddChecksumFailed(&ChecksumFailedItem{
+				PanPath:  dtu.FilePanPath,
+				SavePath: dtu.SavePath,
+				LocalMd5: localMd5,
+				PanMd5:   dtu.fileInfo.FileMd5,
+			})
+			if removeErr := os.Remove(dtu.writePath()); removeErr != nil {
+				dtu.verboseInfof("[%s] remove file error: %s\n", dtu.taskInfo.Id(), removeErr)
+			}
 			result.NeedRetry = true
 			// 设置允许覆盖
 			dtu.IsOverwrite = true
@@ -297,7 +469,7 @@ func (dtu *DownloadTaskUnit) checkFileValid(result *taskframework.TaskUnitRunRes
 		}
 	}
 
-	fmt.Printf("[%s] 检验文件有效性成功: %s\n", dtu.taskInfo.Id(), dtu.SavePath)
+	dtu.printf("[%s] 检验文件有效性成功: %s\n", dtu.taskInfo.Id(), dtu.SavePath)
 	return true
 }
 
@@ -305,33 +477,219 @@ func (dtu *DownloadTaskUnit) OnRetry(lastRunResult *taskframework.TaskUnitRunRes
 	// 输出错误信息
 	if lastRunResult.Err == nil {
 		// result中不包含Err, 忽略输出
-		fmt.Printf("[%s] %s, 重试 %d/%d\n", dtu.taskInfo.Id(), lastRunResult.ResultMessage, dtu.taskInfo.Retry(), dtu.taskInfo.MaxRetry())
+		dtu.printf("[%s] %s, 重试 %d/%d\n", dtu.taskInfo.Id(), lastRunResult.ResultMessage, dtu.taskInfo.Retry(), dtu.taskInfo.MaxRetry())
+		return
+	}
+	dtu.printf("[%s] %s, %s, 重试 %d/%d\n", dtu.taskInfo.Id(), lastRunResult.ResultMessage, lastRunResult.Err, dtu.taskInfo.Retry(), dtu.taskInfo.MaxRetry())
+}
+
+// report 记录传输报告, 目录不记录, 每个任务只记录一次
+func (dtu *DownloadTaskUnit) report(status functions.TransferStatus, result *taskframework.TaskUnitRunResult) {
+	if dtu.Report == nil || dtu.reported || (dtu.fileInfo != nil && dtu.fileInfo.IsFolder) {
+		return
+	}
+	dtu.reported = true
+
+	item := &functions.TransferReportItem{
+		LocalPath:   dtu.SavePath,
+		RemotePath:  dtu.FilePanPath,
+		Status:      status,
+		Transferred: dtu.transferred,
+		Duration:    time.Since(dtu.startTime),
+		MD5:         dtu.localMd5,
+	}
+	if dtu.fileInfo != nil {
+		item.Size = dtu.fileInfo.FileSize
+		if item.MD5 == "" {
+			item.MD5 = strings.ToLower(dtu.fileInfo.FileMd5)
+		}
+	}
+	if status == functions.TransferStatusFailed && result != nil {
+		item.Error = result.ResultMessage
+		if result.Err != nil {
+			item.Error += ", " + result.Err.Error()
+		}
+	}
+	dtu.Report.Add(item)
+}
+
+// summarize 记录到目录统计, 目录只记录失败原因
+func (dtu *DownloadTaskUnit) summarize(status functions.TransferStatus, result *taskframework.TaskUnitRunResult) {
+	if dtu.Summary == nil || dtu.fileInfo == nil {
+		if dtu.Summary != nil && status == functions.TransferStatusFailed {
+			// 未获取到文件信息, 按文件记录失败
+			dtu.Summary.AddFile(dtu.FilePanPath, 0, status)
+		}
+		return
+	}
+	if dtu.fileInfo.IsFolder {
+		if status == functions.TransferStatusFailed && result != nil {
+			msg := result.ResultMessage
+			if result.Err != nil {
+				msg += ", " + result.Err.Error()
+			}
+			dtu.Summary.SetDirError(dtu.FilePanPath, dtu.SavePath, msg)
+		}
 		return
 	}
-	fmt.Printf("[%s] %s, %s, 重试 %d/%d\n", dtu.taskInfo.Id(), lastRunResult.ResultMessage, lastRunResult.Err, dtu.taskInfo.Retry(), dtu.taskInfo.MaxRetry())
+	dtu.Summary.AddFile(dtu.FilePanPath, dtu.fileInfo.FileSize, status)
 }
 
 func (dtu *DownloadTaskUnit) OnSuccess(lastRunResult *taskframework.TaskUnitRunResult) {
+	if dtu.DownloadJob != nil {
+		dtu.DownloadJob.SetItemStatus(dtu.FilePanPath, DownloadJobItemCompleted)
+	}
+	if dtu.skipped {
+		dtu.report(functions.TransferStatusSkipped, lastRunResult)
+		dtu.summarize(functions.TransferStatusSkipped, lastRunResult)
+	} else {
+		dtu.report(functions.TransferStatusDownloaded, lastRunResult)
+		dtu.summarize(functions.TransferStatusDownloaded, lastRunResult)
+	}
 }
 
 func (dtu *DownloadTaskUnit) OnFailed(lastRunResult *taskframework.TaskUnitRunResult) {
+	if dtu.DownloadJob != nil {
+		dtu.DownloadJob.SetItemStatus(dtu.FilePanPath, DownloadJobItemFailed)
+	}
+	dtu.report(functions.TransferStatusFailed, lastRunResult)
+	dtu.summarize(functions.TransferStatusFailed, lastRunResult)
+
 	// 失败
 	if lastRunResult.Err == nil {
 		// result中不包含Err, 忽略输出
-		fmt.Printf("[%s] %s\n", dtu.taskInfo.Id(), lastRunResult.ResultMessage)
+		dtu.printf("[%s] %s\n", dtu.taskInfo.Id(), lastRunResult.ResultMessage)
 		return
 	}
-	fmt.Printf("[%s] %s, %s\n", dtu.taskInfo.Id(), lastRunResult.ResultMessage, lastRunResult.Err)
+	dtu.printf("[%s] %s, %s\n", dtu.taskInfo.Id(), lastRunResult.ResultMessage, lastRunResult.Err)
 }
 
 func (dtu *DownloadTaskUnit) OnComplete(lastRunResult *taskframework.TaskUnitRunResult) {
+	// 已取消的任务不会调用 OnFailed
+	if lastRunResult != nil && !lastRunResult.Succeed && dtu.taskInfo.IsCanceled() {
+		dtu.report(functions.TransferStatusFailed, lastRunResult)
+		dtu.summarize(functions.TransferStatusFailed, lastRunResult)
+	}
 }
 
-func (dtu *DownloadTaskUnit) RetryWait() time.Duration {
-	return functions.RetryWait(dtu.taskInfo.Retry())
+// restoreMeta 还原文件的修改时间和权限, 没有上传时记录的元数据则使用网盘文件的修改时间
+func (dtu *DownloadTaskUnit) restoreMeta() {
+	if dtu.NoMtime {
+		return
+	}
+	if !dtu.metaLoaded {
+		// 直接下载的文件, 读取所在目录的元数据文件
+		dtu.metaLoaded = true
+		dirMeta, err := LoadPanDirMetaByPath(dtu.PanClient, dtu.FamilyId, path.Dir(dtu.FilePanPath))
+		if err != nil {
+			dtu.verboseInfof("[%s] load dir meta error: %s\n", dtu.taskInfo.Id(), err)
+		}
+		dtu.fileMeta = dirMeta.Get(path.Base(dtu.FilePanPath), dtu.fileInfo.FileSize, dtu.fileInfo.FileMd5)
+	}
+
+	if dtu.fileMeta != nil && !dtu.fileMeta.Match(dtu.fileInfo.FileSize, dtu.fileInfo.FileMd5) {
+		// 记录之后网盘文件已被替换
+		dtu.fileMeta = nil
+	}
+
+	modTime := PanFileModTime(dtu.fileInfo)
+	if dtu.fileMeta != nil {
+		modTime = dtu.fileMeta.Time()
+		if dtu.RestoreMode && dtu.fileMeta.Mode != 0 {
+			if err := os.Chmod(dtu.SavePath, dtu.fileMeta.Mode); err != nil {
+				dtu.printf("[%s] 警告, 还原文件权限错误: %s\n", dtu.taskInfo.Id(), err)
+			}
+		}
+	}
+	if modTime.IsZero() {
+		return
+	}
+	if err := os.Chtimes(dtu.SavePath, time.Now(), modTime); err != nil {
+		dtu.printf("[%s] 警告, 还原文件修改时间错误: %s\n", dtu.taskInfo.Id(), err)
+	}
+}
+
+// onExists 本地文件已存在时的处理方式
+func (dtu *DownloadTaskUnit) onExists() functions.OnExistsPolicy {
+	if dtu.OnExists != "" {
+		return dtu.OnExists
+	}
+	if dtu.IsOverwrite {
+		return functions.OnExistsOverwrite
+	}
+	return functions.OnExistsSkip
+}
+
+// resolveExists 处理本地已存在的文件, 返回跳过的原因, 为空表示继续下载.
+// rename 会修改保存路径
+func (dtu *DownloadTaskUnit) resolveExists() string {
+	switch dtu.onExists() {
+	case functions.OnExistsOverwrite:
+		return ""
+	case functions.OnExistsRename:
+		dir, name := filepath.Split(dtu.SavePath)
+		for i := 1; i <= functions.MaxRenameCount; i++ {
+			savePath := filepath.Join(dir, functions.RenameCandidate(name, i))
+			// FileExist 对空文件返回 false, 这里只判断路径是否被占用
+			if _, err := os.Lstat(savePath); os.IsNotExist(err) {
+				dtu.printf("[%s] 文件已经存在, 重命名保存为: %s\n", dtu.taskInfo.Id(), savePath)
+				dtu.SavePath = savePath
+				return ""
+			}
+		}
+		return "没有可用的文件名"
+	case functions.OnExistsNewer:
+		info, err := os.Stat(dtu.SavePath)
+		if err != nil || functions.IsNewer(PanFileModTime(dtu.fileInfo), info.ModTime()) {
+			return ""
+		}
+		return "本地文件不比网盘文件旧"
+	case functions.OnExistsDifferent:
+		if dtu.isLocalDifferent() {
+			return ""
+		}
+		return "本地文件与网盘文件相同"
+	}
+	return "未指定覆盖"
+}
+
+// isLocalDifferent 比较本地文件和网盘文件, 大小不同即不同,
+// 大小相同时只在md5已缓存或文件较小时比较md5
+func (dtu *DownloadTaskUnit) isLocalDifferent() bool {
+	info, err := os.Stat(dtu.SavePath)
+	if err != nil || info.Size() != dtu.fileInfo.FileSize {
+		return true
+	}
+	if dtu.fileInfo.FileMd5 == "" {
+		return false
+	}
+	md5Str, ok := dtu.HashCache.GetMD5(dtu.SavePath, info)
+	if !ok {
+		if info.Size() > MaxCheapMd5Size {
+			return false
+		}
+		lfc, err := localfile.GetFileSum(dtu.SavePath, localfile.CHECKSUM_MD5)
+		if err != nil {
+			return true
+		}
+		md5Str = lfc.MD5
+		dtu.HashCache.PutMD5(dtu.SavePath, info, md5Str)
+	}
+	return CheckFileMd5(md5Str, dtu.fileInfo) != nil
+}
+
+// isExcluded 目录中的文件或子目录是否排除下载
+func (dtu *DownloadTaskUnit) isExcluded(fileInfo *cloudpan.AppFileEntity) bool {
+	if dtu.Filter == nil {
+		return utils.IsExcludeFile(fileInfo.Path, &dtu.Cfg.ExcludeNames)
+	}
+	return dtu.Filter.IsExcluded(fileInfo.Path, fileInfo.IsFolder, fileInfo.FileSize, PanFileModTime(fileInfo))
 }
 
 func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
+	if dtu.startTime.IsZero() {
+		dtu.startTime = time.Now()
+	}
 	result = &taskframework.TaskUnitRunResult{}
 	// 获取文件信息
 	var apierr *apierror.ApiError
@@ -350,14 +708,16 @@ func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 	}
 
 	// 输出文件信息
-	fmt.Print("\n")
-	fmt.Printf("[%s] ----\n%s\n", dtu.taskInfo.Id(), dtu.fileInfo.String())
+	dtu.printf("\n")
+	dtu.printf("[%s] ----\n%s\n", dtu.taskInfo.Id(), dtu.fileInfo.String())
 
 	// 如果是一个目录, 将子文件和子目录加入队列
 	if dtu.fileInfo.IsFolder {
-		_, err := os.Stat(dtu.SavePath)
-		if err != nil && !os.IsExist(err) {
-			os.MkdirAll(dtu.SavePath, 0777) // 首先在本地创建目录, 保证空目录也能被保存
+		// 首先在本地创建目录, 保证空目录也能被保存
+		if err := os.MkdirAll(dtu.SavePath, 0777); err != nil {
+			result.ResultMessage = "创建本地目录失败"
+			result.Err = err
+			return
 		}
 
 		// 获取该目录下的文件列表
@@ -368,23 +728,56 @@ func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 		//fileList := dtu.PanClient.AppFilesDirectoriesRecurseList(dtu.FamilyId, dtu.FilePanPath, nil)
 		if apierr != nil {
 			result.ResultMessage = "获取目录信息错误"
-			result.Err = err
+			result.Err = apierr
 			result.NeedRetry = true
 			return
 		}
+		dtu.Summary.AddDir(dtu.FilePanPath, dtu.SavePath)
 
 		fileList := fileListResult.FileList
+		if err := LoadPanIgnoreFile(dtu.Filter, dtu.PanClient, dtu.FamilyId, dtu.FilePanPath, fileList); err != nil {
+			dtu.printf("[%s] 读取忽略文件错误: %s\n", dtu.taskInfo.Id(), err)
+		}
+		var (
+			dirMeta *functions.DirMeta
+			err     error
+		)
+		for _, f := range fileList {
+			if dtu.NoMtime || f.IsFolder || f.FileName != functions.DirMetaFileName {
+				continue
+			}
+			if dirMeta, err = LoadPanDirMeta(dtu.PanClient, dtu.FamilyId, f); err != nil {
+				dtu.printf("[%s] 读取文件元数据错误: %s\n", dtu.taskInfo.Id(), err)
+			}
+		}
 		for k := range fileList {
 			fileList[k].Path = path.Join(dtu.FilePanPath, fileList[k].FileName)
+			if !fileList[k].IsFolder && fileList[k].FileName == functions.DirMetaFileName {
+				// 元数据文件不下载
+				continue
+			}
+			if fileList[k].IsFolder && fileList[k].FileName == panupload.VersionsDirName {
+				// 历史版本目录不下载
+				continue
+			}
 
 			// 是否排除下载
-			if utils.IsExcludeFile(fileList[k].Path, &dtu.Cfg.ExcludeNames) {
-				fmt.Printf("排除文件: %s\n", fileList[k].Path)
+			if dtu.isExcluded(fileList[k]) {
+				dtu.printf("排除文件: %s\n", fileList[k].Path)
+				continue
+			}
+			subSavePath := filepath.Join(dtu.OriginSaveRootPath, fileList[k].Path) // 保存位置
+			if dtu.DownloadJob != nil && !dtu.DownloadJob.AddItem(fileList[k].Path, subSavePath, fileList[k].IsFolder) {
+				// 恢复下载任务时, 已记录的路径会直接从任务中加入队列
 				continue
 			}
 			if fileList[k].IsFolder {
 				logger.Verbosef("[%s] create sub folder download task: %s\n",
 					dtu.taskInfo.Id(), fileList[k].Path)
+				// 子目录任务还未执行时中断, 也保留空目录
+				if err := os.MkdirAll(subSavePath, 0777); err != nil {
+					dtu.printf("[%s] 创建本地目录失败: %s, %s\n", dtu.taskInfo.Id(), subSavePath, err)
+				}
 			}
 
 			// 添加子任务
@@ -393,29 +786,50 @@ func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 			subUnit.Cfg = &newCfg
 			subUnit.fileInfo = fileList[k] // 保存文件信息
 			subUnit.FilePanPath = fileList[k].Path
-			subUnit.SavePath = filepath.Join(dtu.OriginSaveRootPath, fileList[k].Path) // 保存位置
+			subUnit.SavePath = subSavePath
+			subUnit.startTime = time.Time{}
+			subUnit.fileMeta = dirMeta.Get(fileList[k].FileName, fileList[k].FileSize, fileList[k].FileMd5)
+			subUnit.metaLoaded = true
 
 			// 加入父队列
 			info := dtu.ParentTaskExecutor.Append(&subUnit, dtu.taskInfo.MaxRetry())
-			fmt.Printf("[%s] 加入下载队列: %s\n", info.Id(), fileList[k].Path)
+			dtu.printf("[%s] 加入下载队列: %s\n", info.Id(), fileList[k].Path)
 		}
 
 		result.Succeed = true // 执行成功
 		return
 	}
 
-	fmt.Printf("[%s] 准备下载: %s\n", dtu.taskInfo.Id(), dtu.FilePanPath)
+	dtu.printf("[%s] 准备下载: %s\n", dtu.taskInfo.Id(), dtu.FilePanPath)
 
-	if !dtu.IsOverwrite && FileExist(dtu.SavePath) {
-		fmt.Printf("[%s] 文件已经存在: %s, 跳过...\n", dtu.taskInfo.Id(), dtu.SavePath)
-		result.Succeed = true // 执行成功
-		return
+	isLink := dtu.RestoreLinks && localfile.IsSymlinkDescriptor(dtu.SavePath, dtu.fileInfo.FileSize)
+	if isLink && !dtu.IsOverwrite {
+		if _, err := os.Lstat(strings.TrimSuffix(dtu.SavePath, localfile.SymlinkDescriptorSuffix)); err == nil {
+			dtu.printf("[%s] 符号链接已经存在: %s, 跳过...\n", dtu.taskInfo.Id(), strings.TrimSuffix(dtu.SavePath, localfile.SymlinkDescriptorSuffix))
+			dtu.skipped = true
+			result.Succeed = true
+			return
+		}
+	}
+	if FileExist(dtu.SavePath) {
+		if reason := dtu.resolveExists(); reason != "" {
+			dtu.printf("[%s] 文件已经存在: %s, %s, 跳过...\n", dtu.taskInfo.Id(), dtu.SavePath, reason)
+			dtu.skipped = true
+			result.Succeed = true // 执行成功
+			return
+		}
 	}
 
-	fmt.Printf("[%s] 将会下载到路径: %s\n\n", dtu.taskInfo.Id(), dtu.SavePath)
+	dtu.printf("[%s] 将会下载到路径: %s\n\n", dtu.taskInfo.Id(), dtu.SavePath)
 
 	var ok bool
 	er := dtu.download()
+	if er == context.Canceled {
+		dtu.printf("[%s] 下载已取消: %s\n", dtu.taskInfo.Id(), dtu.FilePanPath)
+		result.ResultMessage = "下载已取消"
+		result.Err = er
+		return result
+	}
 
 	if er != nil {
 		// 以上执行不成功, 返回
@@ -432,9 +846,38 @@ func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 		return result
 	}
 
+	if err := dtu.commitPartFile(); err != nil {
+		result.ResultMessage = StrDownloadFailed
+		result.Err = err
+		result.NeedRetry = false
+		return result
+	}
+
+	if !isLink {
+		dtu.restoreMeta()
+	}
+
+	// 还原符号链接, 失败时保留描述文件
+	if isLink {
+		if linkPath, err := localfile.RestoreSymlink(dtu.SavePath, dtu.OriginSaveRootPath, dtu.IsOverwrite); err != nil {
+			dtu.printf("[%s] 还原符号链接失败, 保留描述文件: %s, %s\n", dtu.taskInfo.Id(), dtu.SavePath, err)
+		} else {
+			dtu.printf("[%s] 已还原符号链接: %s\n", dtu.taskInfo.Id(), linkPath)
+		}
+	}
+
 	// 统计下载
 	dtu.DownloadStatistic.AddTotalSize(dtu.fileInfo.FileSize)
 	// 下载成功
 	result.Succeed = true
 	return
 }
+
+// printf 输出信息, 交互模式下的后台任务输出到任务日志
+func (dtu *DownloadTaskUnit) printf(format string, a ...interface{}) {
+	out := dtu.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprintf(out, format, a...)
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pandownload
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/taskframework"
+)
+
+func newExistsUnit(savePath string, onExists functions.OnExistsPolicy, fileInfo *cloudpan.AppFileEntity) *DownloadTaskUnit {
+	return &DownloadTaskUnit{
+		taskInfo: &taskframework.TaskInfo{},
+		Output:   ioutil.Discard,
+		OnExists: onExists,
+		SavePath: savePath,
+		fileInfo: fileInfo,
+	}
+}
+
+func TestResolveExistsRename(t *testing.T) {
+	dir := t.TempDir()
+	savePath := filepath.Join(dir, "a.txt")
+	// 空文件也占用文件名
+	for _, name := range []string{"a.txt", "a (1).txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dtu := newExistsUnit(savePath, functions.OnExistsRename, nil)
+	if reason := dtu.resolveExists(); reason != "" {
+		t.Fatalf("reason = %s", reason)
+	}
+	if want := filepath.Join(dir, "a (2).txt"); dtu.SavePath != want {
+		t.Fatalf("SavePath = %s, want %s", dtu.SavePath, want)
+	}
+}
+
+func TestResolveExists(t *testing.T) {
+	dir := t.TempDir()
+	savePath := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(savePath, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.Local)
+	if err := os.Chtimes(savePath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		onExists functions.OnExistsPolicy
+		fileInfo *cloudpan.AppFileEntity
+		skip     bool
+	}{
+		{functions.OnExistsOverwrite, nil, false},
+		{functions.OnExistsSkip, nil, true},
+		{functions.OnExistsNewer, &cloudpan.AppFileEntity{LastOpTime: "2021-01-02 03:04:06"}, false},
+		{functions.OnExistsNewer, &cloudpan.AppFileEntity{LastOpTime: "2021-01-02 03:04:05"}, true},
+		{functions.OnExistsDifferent, &cloudpan.AppFileEntity{FileSize: 4}, false},
+		{functions.OnExistsDifferent, &cloudpan.AppFileEntity{FileSize: 5, FileMd5: "5D41402ABC4B2A76B9719D911017C592"}, true},
+		{functions.OnExistsDifferent, &cloudpan.AppFileEntity{FileSize: 5, FileMd5: "00000000000000000000000000000000"}, false},
+	}
+	for _, c := range cases {
+		dtu := newExistsUnit(savePath, c.onExists, c.fileInfo)
+		if reason := dtu.resolveExists(); c.skip != (reason != "") {
+			t.Fatalf("%s %+v: reason = %q", c.onExists, c.fileInfo, reason)
+		}
+		if dtu.SavePath != savePath {
+			t.Fatalf("%s: SavePath = %s", c.onExists, dtu.SavePath)
+		}
+	}
+}
+
+func TestPartPath(t *testing.T) {
+	dir := t.TempDir()
+	savePath := filepath.Join(dir, "a.txt")
+
+	dtu := &DownloadTaskUnit{SavePath: savePath}
+	dtu.setPartPath()
+	if dtu.writePath() != savePath {
+		t.Fatalf("writePath = %s", dtu.writePath())
+	}
+
+	dtu.PartFile = true
+	dtu.setPartPath()
+	if dtu.writePath() != savePath+PartSuffix {
+		t.Fatalf("writePath = %s", dtu.writePath())
+	}
+
+	// 未指定 --part, 但存在未完成的 .part 下载时继续写入 .part
+	dtu.PartFile = false
+	for _, suffix := range []string{PartSuffix, DownloadSuffix} {
+		if err := ioutil.WriteFile(savePath+suffix, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !hasPartDownload(savePath) {
+		t.Fatal("hasPartDownload = false")
+	}
+	dtu.setPartPath()
+	if dtu.writePath() != savePath+PartSuffix {
+		t.Fatalf("writePath = %s", dtu.writePath())
+	}
+
+	// 只有 .part 没有断点信息, 不是未完成的下载
+	os.Remove(savePath + DownloadSuffix)
+	if hasPartDownload(savePath) {
+		t.Fatal("hasPartDownload = true")
+	}
+}
+
+func TestCommitPartFileAfterVerify(t *testing.T) {
+	dir := t.TempDir()
+	savePath := filepath.Join(dir, "a.txt")
+	fileInfo := &cloudpan.AppFileEntity{FileSize: 5, FileMd5: "5D41402ABC4B2A76B9719D911017C592"}
+
+	newUnit := func(data string) *DownloadTaskUnit {
+		dtu := newExistsUnit(savePath, functions.OnExistsOverwrite, fileInfo)
+		dtu.PartFile = true
+		dtu.DownloadStatistic = &DownloadStatistic{}
+		dtu.setPartPath()
+		if err := ioutil.WriteFile(dtu.writePath(), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return dtu
+	}
+
+	// 校验失败, 删除 .part, 保存路径上没有文件
+	dtu := newUnit("world")
+	if dtu.checkFileValid(&taskframework.TaskUnitRunResult{}) {
+		t.Fatal("校验应失败")
+	}
+	for _, p := range []string{savePath, savePath + PartSuffix} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("%s 不应存在", p)
+		}
+	}
+
+	// 校验成功前只有 .part, 提交后才出现保存路径
+	dtu = newUnit("hello")
+	if _, err := os.Stat(savePath); !os.IsNotExist(err) {
+		t.Fatal("校验前保存路径不应存在")
+	}
+	if !dtu.checkFileValid(&taskframework.TaskUnitRunResult{}) {
+		t.Fatal("校验应成功")
+	}
+	if _, err := os.Stat(savePath); !os.IsNotExist(err) {
+		t.Fatal("提交前保存路径不应存在")
+	}
+	if err := dtu.commitPartFile(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(savePath); err != nil || string(data) != "hello" {
+		t.Fatalf("data = %s, err = %v", data, err)
+	}
+	if _, err := os.Stat(savePath + PartSuffix); !os.IsNotExist(err) {
+		t.Fatal(".part 应已重命名")
+	}
+	if dtu.writePath() != savePath {
+		t.Fatalf("writePath = %s", dtu.writePath())
+	}
+}
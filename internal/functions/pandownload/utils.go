@@ -14,15 +14,39 @@
 package pandownload
 
 import (
-	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/utils"
+	"github.com/tickstep/library-go/requester"
+)
+
+const (
+	// MaxIgnoreFileSize 网盘忽略文件的最大值, 超过则不读取
+	MaxIgnoreFileSize = 1024 * 1024
+	// MaxDirMetaFileSize 网盘元数据文件的最大值, 超过则不读取
+	MaxDirMetaFileSize = 16 * 1024 * 1024
+	// MaxCheapMd5Size 比较本地文件是否不同时, 未缓存md5的文件超过该大小则只比较大小
+	MaxCheapMd5Size = 32 * 1024 * 1024
 )
 
-// CheckFileValid 检测文件有效性
-func CheckFileValid(filePath string, fileInfo *cloudpan.AppFileEntity) error {
-	// 检查MD5
-	// 检查文件大小
-	// 检查digest签名
+// CheckFileMd5 比较本地文件的md5与云盘记录的md5
+func CheckFileMd5(localMd5 string, fileInfo *cloudpan.AppFileEntity) error {
+	if fileInfo.FileMd5 == "" {
+		return ErrDownloadNotSupportChecksum
+	}
+	if !strings.EqualFold(localMd5, fileInfo.FileMd5) {
+		return ErrDownloadChecksumFailed
+	}
 	return nil
 }
 
@@ -40,3 +64,102 @@ func FileExist(path string) bool {
 
 	return false
 }
+
+// hasPartDownload 保存路径是否有未完成的 .part 临时文件及其断点续传信息
+func hasPartDownload(path string) bool {
+	if _, err := os.Stat(path + PartSuffix); err != nil {
+		return false
+	}
+	_, err := os.Stat(path + DownloadSuffix)
+	return err == nil
+}
+
+// PanFileModTime 网盘文件的修改时间
+func PanFileModTime(fileInfo *cloudpan.AppFileEntity) time.Time {
+	return functions.ParsePanTime(fileInfo.LastOpTime)
+}
+
+// ReadPanFile 读取网盘文件的内容, 只用于较小的文件
+func ReadPanFile(panClient *cloudpan.PanClient, familyId int64, fileInfo *cloudpan.AppFileEntity) ([]byte, error) {
+	var (
+		durl string
+		err  error
+	)
+	if familyId > 0 {
+		durl, err = panClient.AppFamilyGetFileDownloadUrl(familyId, fileInfo.FileId)
+	} else {
+		durl, err = panClient.AppGetFileDownloadUrl(fileInfo.FileId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	client := requester.NewHTTPClient()
+	downloadFunc := func(httpMethod, fullUrl string, headers map[string]string) (*http.Response, error) {
+		resp, err := client.Req(httpMethod, fullUrl, nil, headers)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("http status: %s", resp.Status)
+		}
+		_, err = io.Copy(buf, io.LimitReader(resp.Body, fileInfo.FileSize))
+		return resp, err
+	}
+	if familyId > 0 {
+		err = panClient.AppFamilyDownloadFileData(durl, cloudpan.AppFileDownloadRange{}, downloadFunc)
+	} else {
+		err = panClient.AppDownloadFileData(durl, cloudpan.AppFileDownloadRange{}, downloadFunc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// LoadPanIgnoreFile 网盘目录中包含忽略文件时, 读取并添加到过滤条件
+func LoadPanIgnoreFile(filter *utils.FileFilter, panClient *cloudpan.PanClient, familyId int64, dir string, fileList cloudpan.AppFileList) error {
+	if filter == nil || filter.IgnoreFileName == "" {
+		return nil
+	}
+	for _, f := range fileList {
+		if f.IsFolder || f.FileName != filter.IgnoreFileName {
+			continue
+		}
+		if f.FileSize > MaxIgnoreFileSize {
+			return fmt.Errorf("忽略文件 %s 超过 %d 字节, 不读取", f.FileName, MaxIgnoreFileSize)
+		}
+		data, err := ReadPanFile(panClient, familyId, f)
+		if err != nil {
+			return err
+		}
+		return filter.AddIgnoreRules(dir, bytes.NewReader(data))
+	}
+	return nil
+}
+
+// LoadPanDirMeta 读取网盘目录中的元数据文件
+func LoadPanDirMeta(panClient *cloudpan.PanClient, familyId int64, fileInfo *cloudpan.AppFileEntity) (*functions.DirMeta, error) {
+	if fileInfo.FileSize > MaxDirMetaFileSize {
+		return nil, fmt.Errorf("元数据文件超过 %d 字节, 不读取", MaxDirMetaFileSize)
+	}
+	data, err := ReadPanFile(panClient, familyId, fileInfo)
+	if err != nil {
+		return nil, err
+	}
+	return functions.ParseDirMeta(data)
+}
+
+// LoadPanDirMetaByPath 读取网盘目录中的元数据文件, 文件不存在时返回 nil
+func LoadPanDirMetaByPath(panClient *cloudpan.PanClient, familyId int64, dir string) (*functions.DirMeta, error) {
+	fileInfo, apierr := panClient.AppFileInfoByPath(familyId, path.Join(dir, functions.DirMetaFileName))
+	if apierr != nil {
+		if apierr.Code == apierror.ApiCodeFileNotFoundCode {
+			return nil, nil
+		}
+		return nil, apierr
+	}
+	return LoadPanDirMeta(panClient, familyId, fileInfo)
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
+	"github.com/tickstep/library-go/requester"
+)
+
+const (
+	// smallFileTempSuffix 家庭云上传小文件时的临时文件名后缀
+	smallFileTempSuffix = ".uploading"
+)
+
+// UploadSmallFile 上传内存中的小文件, 用于元数据等程序生成的文件, 已存在的同名文件会被覆盖
+func UploadSmallFile(panClient *cloudpan.PanClient, familyId int64, savePath string, data []byte) error {
+	var parentId string
+	if dir := path.Dir(savePath); dir == "/" {
+		if familyId <= 0 {
+			parentId = "-11"
+		}
+	} else {
+		fi, apierr := panClient.AppFileInfoByPath(familyId, dir)
+		if apierr != nil {
+			return apierr
+		}
+		parentId = fi.FileId
+	}
+
+	if familyId <= 0 {
+		return uploadSmallFileData(panClient, familyId, parentId, savePath, data)
+	}
+
+	// 家庭云提交时不支持覆盖, 先上传到临时文件名, 再删除同名文件并重命名, 上传失败时不会丢失原文件
+	tmpPath := savePath + smallFileTempSuffix
+	if apierr := deleteFamilyFile(panClient, familyId, tmpPath); apierr != nil {
+		return apierr
+	}
+	if err := uploadSmallFileData(panClient, familyId, parentId, tmpPath, data); err != nil {
+		return err
+	}
+	tmpFile, apierr := panClient.AppFileInfoByPath(familyId, tmpPath)
+	if apierr != nil {
+		return apierr
+	}
+	if apierr = deleteFamilyFile(panClient, familyId, savePath); apierr != nil {
+		return apierr
+	}
+	if _, apierr = panClient.AppFamilyRenameFile(familyId, tmpFile.FileId, path.Base(savePath)); apierr != nil {
+		return apierr
+	}
+	return nil
+}
+
+// deleteFamilyFile 删除家庭云文件, 文件不存在时直接返回
+func deleteFamilyFile(panClient *cloudpan.PanClient, familyId int64, filePath string) *apierror.ApiError {
+	efi, apierr := panClient.AppFileInfoByPath(familyId, filePath)
+	if apierr != nil {
+		if apierr.Code == apierror.ApiCodeFileNotFoundCode {
+			return nil
+		}
+		return apierr
+	}
+	if efi == nil || efi.FileId == "" {
+		return nil
+	}
+	_, apierr = panClient.AppCreateBatchTask(familyId, &cloudpan.BatchTaskParam{
+		TypeFlag: cloudpan.BatchTaskTypeDelete,
+		TaskInfos: cloudpan.BatchTaskInfoList{
+			&cloudpan.BatchTaskInfo{
+				FileId:      efi.FileId,
+				FileName:    efi.FileName,
+				SrcParentId: efi.ParentId,
+			},
+		},
+	})
+	if apierr != nil {
+		return apierr
+	}
+	time.Sleep(500 * time.Millisecond)
+	return nil
+}
+
+// uploadSmallFileData 上传并提交文件, 个人云覆盖同名文件
+func uploadSmallFileData(panClient *cloudpan.PanClient, familyId int64, parentId, savePath string, data []byte) error {
+	sum := md5.Sum(data)
+	param := &cloudpan.AppCreateUploadFileParam{
+		ParentFolderId: parentId,
+		FileName:       path.Base(savePath),
+		Size:           int64(len(data)),
+		Md5:            hex.EncodeToString(sum[:]),
+		LastWrite:      time.Now().Format("2006-01-02 15:04:05"),
+		LocalPath:      savePath,
+		FamilyId:       familyId,
+	}
+	var (
+		r      *cloudpan.AppCreateUploadFileResult
+		apierr *apierror.ApiError
+	)
+	if familyId > 0 {
+		r, apierr = panClient.AppFamilyCreateUploadFile(param)
+	} else {
+		r, apierr = panClient.AppCreateUploadFile(param)
+	}
+	if apierr != nil {
+		return apierr
+	}
+
+	if r.FileDataExists != 1 {
+		client := requester.NewHTTPClient()
+		uploadFunc := func(httpMethod, fullUrl string, headers map[string]string) (*http.Response, error) {
+			return client.Req(httpMethod, fullUrl, bytes.NewReader(data), headers)
+		}
+		fileRange := &cloudpan.AppFileUploadRange{
+			Offset: 0,
+			Len:    int64(len(data)),
+		}
+		if familyId > 0 {
+			apierr = panClient.AppFamilyUploadFileData(familyId, r.FileUploadUrl, r.UploadFileId, r.XRequestId, fileRange, uploadFunc)
+		} else {
+			apierr = panClient.AppUploadFileData(r.FileUploadUrl, r.UploadFileId, r.XRequestId, fileRange, uploadFunc)
+		}
+		if apierr != nil {
+			return apierr
+		}
+	}
+
+	if familyId > 0 {
+		_, apierr = panClient.AppFamilyUploadFileCommit(familyId, r.FileCommitUrl, r.UploadFileId, r.XRequestId)
+	} else {
+		_, apierr = panClient.AppUploadFileCommitOverwrite(r.FileCommitUrl, r.UploadFileId, r.XRequestId, true)
+	}
+	if apierr != nil {
+		return apierr
+	}
+	return nil
+}
@@ -15,6 +15,7 @@ package panupload
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"time"
@@ -111,6 +112,7 @@ func (pu *PanUpload) UploadFile(ctx context.Context, partseq int, partOffset int
 				switch resp.StatusCode {
 				case 400, 401, 403, 413, 600:
 					respErr = &uploader.MultiError{
+						Err:        fmt.Errorf("upload response status: %s", resp.Status),
 						Terminated: true,
 					}
 				}
@@ -136,12 +138,33 @@ func (pu *PanUpload) UploadFile(ctx context.Context, partseq int, partOffset int
 	}
 
 	if apiError != nil {
+		if IsUploadErrorFatal(apiError) {
+			return false, &uploader.MultiError{
+				Err:        apiError,
+				Terminated: true,
+			}
+		}
 		return false, apiError
 	}
 
 	return true, nil
 }
 
+// IsUploadErrorFatal 上传分片遇到的错误是否不可恢复, 不可恢复的错误重试分片也不会成功
+func IsUploadErrorFatal(apiError *apierror.ApiError) bool {
+	switch apiError.ErrCode() {
+	case apierror.ApiCodeUploadFileNotFound,
+		apierror.ApiCodeUploadOffsetVerifyFailed,
+		apierror.ApiCodeFileAlreadyExisted,
+		apierror.ApiCodeUserDayFlowOverLimited,
+		apierror.ApiCodeInvalidArgument,
+		apierror.ApiCodeInfoSecurityError,
+		apierror.ApiCodeTokenExpiredCode:
+		return true
+	}
+	return false
+}
+
 func (pu *PanUpload) CommitFile() (cerr error) {
 	time.Sleep(time.Duration(500) * time.Millisecond)
 	pu.lazyInit()
@@ -18,6 +18,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tickstep/cloudpan189-go/internal/config"
@@ -31,7 +32,10 @@ type (
 	// Uploading 未完成上传的信息
 	Uploading struct {
 		*localfile.LocalFileMeta
-		State *uploader.InstanceState `json:"state"`
+		State      *uploader.InstanceState `json:"state"`
+		SavePath   string                  `json:"savePath"`   // 网盘保存路径
+		FamilyId   int64                   `json:"familyId"`   // 家庭云ID, 个人云为0
+		UpdateTime int64                   `json:"updateTime"` // 最后更新时间
 	}
 
 	// UploadingDatabase 未完成上传的数据库
@@ -40,11 +44,33 @@ type (
 		Timestamp     int64        `json:"timestamp"`
 
 		dataFile *os.File
+		mu       sync.Mutex
+		refs     int
 	}
 )
 
-// NewUploadingDatabase 初始化未完成上传的数据库, 从库中读取内容
-func NewUploadingDatabase() (ud *UploadingDatabase, err error) {
+var (
+	sharedUploadingDatabase *UploadingDatabase
+	sharedUploadingLocker   sync.Mutex
+)
+
+// NewUploadingDatabase 打开未完成上传的数据库. 同一进程内共享一个实例, 交互模式下的后台任务同时上传时不会互相覆盖记录
+func NewUploadingDatabase() (*UploadingDatabase, error) {
+	sharedUploadingLocker.Lock()
+	defer sharedUploadingLocker.Unlock()
+	if sharedUploadingDatabase == nil {
+		ud, err := openUploadingDatabase()
+		if err != nil {
+			return nil, err
+		}
+		sharedUploadingDatabase = ud
+	}
+	sharedUploadingDatabase.refs++
+	return sharedUploadingDatabase, nil
+}
+
+// openUploadingDatabase 初始化未完成上传的数据库, 从库中读取内容
+func openUploadingDatabase() (ud *UploadingDatabase, err error) {
 	file, err := os.OpenFile(filepath.Join(config.GetConfigDir(), UploadingFileName), os.O_CREATE|os.O_RDWR, 0777)
 	if err != nil {
 		return nil, err
@@ -64,14 +90,24 @@ func NewUploadingDatabase() (ud *UploadingDatabase, err error) {
 
 	err = jsonhelper.UnmarshalData(file, ud)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
 	return ud, nil
 }
 
+// List 返回全部未完成上传的记录
+func (ud *UploadingDatabase) List() []*Uploading {
+	ud.mu.Lock()
+	defer ud.mu.Unlock()
+	return append([]*Uploading{}, ud.UploadingList...)
+}
+
 // Save 保存内容
 func (ud *UploadingDatabase) Save() error {
+	ud.mu.Lock()
+	defer ud.mu.Unlock()
 	if ud.dataFile == nil {
 		return errors.New("dataFile is nil")
 	}
@@ -101,18 +137,24 @@ func (ud *UploadingDatabase) Save() error {
 }
 
 // UpdateUploading 更新正在上传
-func (ud *UploadingDatabase) UpdateUploading(meta *localfile.LocalFileMeta, state *uploader.InstanceState) {
+func (ud *UploadingDatabase) UpdateUploading(meta *localfile.LocalFileMeta, savePath string, familyId int64, state *uploader.InstanceState) {
 	if meta == nil {
 		return
 	}
 
+	ud.mu.Lock()
+	defer ud.mu.Unlock()
 	meta.CompleteAbsPath()
+	now := time.Now().Unix()
 	for k, uploading := range ud.UploadingList {
 		if uploading.LocalFileMeta == nil {
 			continue
 		}
 		if uploading.LocalFileMeta.EqualLengthMD5(meta) || uploading.LocalFileMeta.Path == meta.Path {
 			ud.UploadingList[k].State = state
+			ud.UploadingList[k].SavePath = savePath
+			ud.UploadingList[k].FamilyId = familyId
+			ud.UploadingList[k].UpdateTime = now
 			return
 		}
 	}
@@ -120,6 +162,9 @@ func (ud *UploadingDatabase) UpdateUploading(meta *localfile.LocalFileMeta, stat
 	ud.UploadingList = append(ud.UploadingList, &Uploading{
 		LocalFileMeta: meta,
 		State:         state,
+		SavePath:      savePath,
+		FamilyId:      familyId,
+		UpdateTime:    now,
 	})
 }
 
@@ -133,6 +178,12 @@ func (ud *UploadingDatabase) Delete(meta *localfile.LocalFileMeta) bool {
 		return false
 	}
 
+	ud.mu.Lock()
+	defer ud.mu.Unlock()
+	return ud.delete(meta)
+}
+
+func (ud *UploadingDatabase) delete(meta *localfile.LocalFileMeta) bool {
 	meta.CompleteAbsPath()
 	for k, uploading := range ud.UploadingList {
 		if uploading.LocalFileMeta == nil {
@@ -146,12 +197,27 @@ func (ud *UploadingDatabase) Delete(meta *localfile.LocalFileMeta) bool {
 	return false
 }
 
+// DeleteUploading 删除指定的未完成上传记录
+func (ud *UploadingDatabase) DeleteUploading(uploading *Uploading) bool {
+	ud.mu.Lock()
+	defer ud.mu.Unlock()
+	for k := range ud.UploadingList {
+		if ud.UploadingList[k] == uploading {
+			ud.deleteIndex(k)
+			return true
+		}
+	}
+	return false
+}
+
 // Search 搜索
 func (ud *UploadingDatabase) Search(meta *localfile.LocalFileMeta) *uploader.InstanceState {
 	if meta == nil {
 		return nil
 	}
 
+	ud.mu.Lock()
+	defer ud.mu.Unlock()
 	meta.CompleteAbsPath()
 	ud.clearModTimeChange()
 	for _, uploading := range ud.UploadingList {
@@ -165,7 +231,7 @@ func (ud *UploadingDatabase) Search(meta *localfile.LocalFileMeta) *uploader.Ins
 			// 移除旧的信息
 			// 目前只是比较了文件大小
 			if meta.Length != uploading.LocalFileMeta.Length {
-				ud.Delete(meta)
+				ud.delete(meta)
 				return nil
 			}
 
@@ -211,7 +277,15 @@ func (ud *UploadingDatabase) clearModTimeChange() {
 	}
 }
 
-// Close 关闭数据库
+// Close 关闭数据库, 所有使用者都关闭后才关闭文件
 func (ud *UploadingDatabase) Close() error {
+	sharedUploadingLocker.Lock()
+	defer sharedUploadingLocker.Unlock()
+	if ud.refs--; ud.refs > 0 {
+		return nil
+	}
+	if sharedUploadingDatabase == ud {
+		sharedUploadingDatabase = nil
+	}
 	return ud.dataFile.Close()
 }
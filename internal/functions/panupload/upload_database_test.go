@@ -0,0 +1,70 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+)
+
+func TestUploadingDatabaseShared(t *testing.T) {
+	t.Setenv(config.EnvConfigDir, t.TempDir())
+
+	ud1, err := NewUploadingDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ud2, err := NewUploadingDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ud1 != ud2 {
+		t.Fatal("同一进程内应共享数据库")
+	}
+
+	// 两个上传同时更新记录, 不会互相覆盖
+	wg := sync.WaitGroup{}
+	for i, ud := range []*UploadingDatabase{ud1, ud2} {
+		wg.Add(1)
+		go func(i int, ud *UploadingDatabase) {
+			defer wg.Done()
+			for k := 0; k < 20; k++ {
+				meta := &localfile.LocalFileMeta{Path: fmt.Sprintf("/tmp/%d/%d", i, k), Length: int64(i*100 + k + 1), ModTime: -1}
+				ud.UpdateUploading(meta, "/save", 0, nil)
+				if err := ud.Save(); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i, ud)
+	}
+	wg.Wait()
+	ud1.Close()
+	ud2.Close()
+
+	ud, err := NewUploadingDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ud.Close()
+	if ud == ud1 {
+		t.Fatal("全部关闭后应重新打开数据库")
+	}
+	if n := len(ud.List()); n != 40 {
+		t.Fatalf("记录数量 %d, 应为 40", n)
+	}
+}
@@ -14,9 +14,11 @@
 package panupload
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
 	"path"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -54,6 +56,9 @@ type (
 		NoSplitFile       bool // 禁用分片上传
 
 		UploadStatistic *UploadStatistic
+		Report          *functions.TransferReport   // 传输报告, 为nil则不记录
+		DirMeta         *functions.DirMetaCollector // 文件元数据, 为nil则不记录
+		Output          io.Writer                   // 输出, 为nil时输出到标准输出
 
 		taskInfo *taskframework.TaskInfo
 		panDir   string
@@ -62,6 +67,23 @@ type (
 
 		ShowProgress bool
 		IsOverwrite  bool // 覆盖已存在的文件，如果同名文件已存在则移到回收站里
+		KeepVersions int  // 覆盖时保留的历史版本数量, 大于0时旧文件移到历史版本目录而不是回收站
+
+		OnExists functions.OnExistsPolicy // 网盘文件已存在时的处理方式, 为空时按 IsOverwrite 覆盖或不检查
+
+		control     *uploadControl
+		startTime   time.Time // 首次执行的时间, 重试不重置
+		skipped     bool      // 网盘文件已存在, 按 OnExists 跳过上传
+		transferred int64     // 实际上传的数据量, 不包含断点续传已上传的部分
+		reported    bool
+	}
+
+	// uploadControl 暂停和恢复上传, 上传不支持暂停, 暂停时取消上传, 恢复后重新执行任务
+	uploadControl struct {
+		mu     sync.Mutex
+		muer   *uploader.MultiUploader
+		paused bool
+		resume chan struct{}
 	}
 )
 
@@ -80,8 +102,76 @@ const (
 	StrUploadFailed = "上传文件失败"
 )
 
+// TaskSize 本地文件大小
+func (utu *UploadTaskUnit) TaskSize() int64 {
+	info, err := os.Stat(utu.LocalFileChecksum.Path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
 func (utu *UploadTaskUnit) SetTaskInfo(taskInfo *taskframework.TaskInfo) {
 	utu.taskInfo = taskInfo
+	utu.control = &uploadControl{}
+}
+
+func (uc *uploadControl) setUploader(muer *uploader.MultiUploader) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	uc.muer = muer
+	if muer != nil && uc.paused {
+		muer.Cancel()
+	}
+}
+
+func (uc *uploadControl) isPaused() bool {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	return uc.paused
+}
+
+// waitResume 已暂停则等待恢复, 返回 false 表示未暂停或任务已取消
+func (uc *uploadControl) waitResume(ctx context.Context) bool {
+	uc.mu.Lock()
+	if !uc.paused {
+		uc.mu.Unlock()
+		return false
+	}
+	resume := uc.resume
+	uc.mu.Unlock()
+
+	select {
+	case <-resume:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Pause 暂停上传
+func (utu *UploadTaskUnit) Pause() {
+	utu.control.mu.Lock()
+	defer utu.control.mu.Unlock()
+	if utu.control.paused {
+		return
+	}
+	utu.control.paused = true
+	utu.control.resume = make(chan struct{})
+	if utu.control.muer != nil {
+		utu.control.muer.Cancel()
+	}
+}
+
+// Resume 恢复上传
+func (utu *UploadTaskUnit) Resume() {
+	utu.control.mu.Lock()
+	defer utu.control.mu.Unlock()
+	if !utu.control.paused {
+		return
+	}
+	utu.control.paused = false
+	close(utu.control.resume)
 }
 
 // prepareFile 解析文件阶段
@@ -111,7 +201,7 @@ func (utu *UploadTaskUnit) prepareFile() {
 				cmdUploadVerbose.Warn("断点续传失败，需要重新从0开始上传文件：" + apierr.Error())
 				utu.Step = StepUploadPrepareUpload
 			}
-		} else {
+		} else if utu.state != nil && len(utu.state.BlockList) > 0 {
 			// 需要修正上一次上传值，断点续传
 			utu.state.BlockList[0].Range.Begin = appGetUploadFileStatusResult.Size
 		}
@@ -129,7 +219,7 @@ func (utu *UploadTaskUnit) prepareFile() {
 	}
 
 	if utu.LocalFileChecksum.Length > MaxRapidUploadSize {
-		fmt.Printf("[%s] 文件超过20GB, 无法使用秒传功能, 跳过秒传...\n", utu.taskInfo.Id())
+		utu.printf("[%s] 文件超过20GB, 无法使用秒传功能, 跳过秒传...\n", utu.taskInfo.Id())
 		utu.Step = StepUploadUpload
 		return
 	}
@@ -143,7 +233,7 @@ func (utu *UploadTaskUnit) rapidUpload() (isContinue bool, result *taskframework
 
 	// 对于天翼云盘，文件必须是存在才支持秒传
 	result = &taskframework.TaskUnitRunResult{}
-	fmt.Printf("[%s] 检测秒传中, 请稍候...\n", utu.taskInfo.Id())
+	utu.printf("[%s] 检测秒传中, 请稍候...\n", utu.taskInfo.Id())
 	if utu.LocalFileChecksum.FileDataExists == 1 {
 		var er *apierror.ApiError
 		var ret *cloudpan.AppUploadFileCommitResult
@@ -157,13 +247,13 @@ func (utu *UploadTaskUnit) rapidUpload() (isContinue bool, result *taskframework
 			result.Err = er
 			return true, result
 		} else {
-			fmt.Printf("[%s] 秒传成功, 保存到网盘路径: %s\n\n", utu.taskInfo.Id(), utu.SavePath)
+			utu.printf("[%s] 秒传成功, 保存到网盘路径: %s\n\n", utu.taskInfo.Id(), utu.SavePath)
 			result.Succeed = true
 			result.Extra = ret
 			return false, result
 		}
 	} else {
-		fmt.Printf("[%s] 秒传失败，开始正常上传文件\n", utu.taskInfo.Id())
+		utu.printf("[%s] 秒传失败，开始正常上传文件\n", utu.taskInfo.Id())
 		result.Succeed = false
 		result.ResultMessage = "文件未曾上传，无法秒传"
 		return true, result
@@ -191,20 +281,22 @@ func (utu *UploadTaskUnit) upload() (result *taskframework.TaskUnitRunResult) {
 		})
 
 	// 设置断点续传
+	transferred := utu.LocalFileChecksum.Length
 	if utu.state != nil {
 		muer.SetInstanceState(utu.state)
+		transferred = utu.state.Left()
 	}
 
 	muer.OnUploadStatusEvent(func(status uploader.Status, updateChan <-chan struct{}) {
 		select {
 		case <-updateChan:
-			utu.UploadingDatabase.UpdateUploading(&utu.LocalFileChecksum.LocalFileMeta, muer.InstanceState())
+			utu.UploadingDatabase.UpdateUploading(&utu.LocalFileChecksum.LocalFileMeta, utu.SavePath, utu.FamilyId, muer.InstanceState())
 			utu.UploadingDatabase.Save()
 		default:
 		}
 
 		if utu.ShowProgress {
-			fmt.Printf("\r[%s] ↑ %s/%s %s/s in %s ............", utu.taskInfo.Id(),
+			utu.printf("\r[%s] ↑ %s/%s %s/s in %s ............", utu.taskInfo.Id(),
 				converter.ConvertFileSize(status.Uploaded(), 2),
 				converter.ConvertFileSize(status.TotalSize(), 2),
 				converter.ConvertFileSize(status.SpeedsPerSecond(), 2),
@@ -213,39 +305,70 @@ func (utu *UploadTaskUnit) upload() (result *taskframework.TaskUnitRunResult) {
 		}
 	})
 
+	// 任务取消时停止上传, 保留断点续传信息
+	ctx, executeDone := utu.taskInfo.Context(), make(chan struct{})
+	defer close(executeDone)
+	muer.OnExecute(func() {
+		utu.control.setUploader(muer)
+		select {
+		case <-ctx.Done():
+			muer.Cancel()
+		case <-executeDone:
+		}
+	})
+
 	// result
 	result = &taskframework.TaskUnitRunResult{}
+	muer.OnCancel(func() {
+		if utu.control.isPaused() {
+			utu.printf("\n[%s] 上传已暂停: %s\n", utu.taskInfo.Id(), utu.LocalFileChecksum.Path)
+			result.ResultMessage = "上传已暂停"
+			return
+		}
+		utu.printf("\n[%s] 上传已取消: %s\n", utu.taskInfo.Id(), utu.LocalFileChecksum.Path)
+		result.ResultMessage = "上传已取消"
+		result.Err = context.Canceled
+	})
 	muer.OnSuccess(func() {
-		fmt.Printf("\n")
-		fmt.Printf("[%s] 上传文件成功, 保存到网盘路径: %s\n", utu.taskInfo.Id(), utu.SavePath)
+		utu.printf("\n")
+		utu.printf("[%s] 上传文件成功, 保存到网盘路径: %s\n", utu.taskInfo.Id(), utu.SavePath)
 		// 统计
 		utu.UploadStatistic.AddTotalSize(utu.LocalFileChecksum.Length)
 		utu.UploadingDatabase.Delete(&utu.LocalFileChecksum.LocalFileMeta) // 删除
 		utu.UploadingDatabase.Save()
+		utu.transferred = transferred
 		result.Succeed = true
 	})
 	muer.OnError(func(err error) {
-		apiError, ok := err.(*apierror.ApiError)
-		if !ok {
+		result.ResultMessage = StrUploadFailed
+		result.Err = err
+
+		switch e := err.(type) {
+		case *uploader.PartsError:
+			if !e.Fatal {
+				// 分片重试次数用尽, 交由任务重试
+				result.NeedRetry = true
+				return
+			}
+			// 上传记录失效或偏移量校验失败, 重试任务时会重新检查服务器端的上传进度
+			if me, ok := e.Cause().(*uploader.MultiError); ok {
+				if apiError, ok := me.Err.(*apierror.ApiError); ok {
+					switch apiError.ErrCode() {
+					case apierror.ApiCodeUploadFileNotFound, apierror.ApiCodeUploadOffsetVerifyFailed:
+						result.NeedRetry = true
+					}
+				}
+			}
+		case *apierror.ApiError:
+			result.NeedRetry = !IsUploadErrorFatal(e)
+		default:
 			// 未知错误类型 (非预期的)
 			// 不重试
 			result.ResultMessage = "上传文件错误"
-			result.Err = err
-			return
 		}
-
-		// 默认需要重试
-		result.NeedRetry = true
-
-		switch apiError.ErrCode() {
-		default:
-			result.ResultMessage = StrUploadFailed
-			result.NeedRetry = false
-			result.Err = apiError
-		}
-		return
 	})
 	muer.Execute()
+	utu.control.setUploader(nil)
 
 	return
 }
@@ -254,13 +377,81 @@ func (utu *UploadTaskUnit) OnRetry(lastRunResult *taskframework.TaskUnitRunResul
 	// 输出错误信息
 	if lastRunResult.Err == nil {
 		// result中不包含Err, 忽略输出
-		fmt.Printf("[%s] %s, 重试 %d/%d\n", utu.taskInfo.Id(), lastRunResult.ResultMessage, utu.taskInfo.Retry(), utu.taskInfo.MaxRetry())
+		utu.printf("[%s] %s, 重试 %d/%d\n", utu.taskInfo.Id(), lastRunResult.ResultMessage, utu.taskInfo.Retry(), utu.taskInfo.MaxRetry())
 		return
 	}
-	fmt.Printf("[%s] %s, %s, 重试 %d/%d\n", utu.taskInfo.Id(), lastRunResult.ResultMessage, lastRunResult.Err, utu.taskInfo.Retry(), utu.taskInfo.MaxRetry())
+	utu.printf("[%s] %s, %s, 重试 %d/%d\n", utu.taskInfo.Id(), lastRunResult.ResultMessage, lastRunResult.Err, utu.taskInfo.Retry(), utu.taskInfo.MaxRetry())
+}
+
+// report 记录传输报告, 每个任务只记录一次
+func (utu *UploadTaskUnit) report(status functions.TransferStatus, result *taskframework.TaskUnitRunResult) {
+	if utu.Report == nil || utu.reported {
+		return
+	}
+	utu.reported = true
+
+	item := &functions.TransferReportItem{
+		LocalPath:  utu.LocalFileChecksum.Path,
+		RemotePath: utu.SavePath,
+		Size:       utu.LocalFileChecksum.Length,
+		Status:     status,
+		Duration:   time.Since(utu.startTime),
+		MD5:        utu.LocalFileChecksum.MD5,
+	}
+	if status == functions.TransferStatusUploaded {
+		item.Transferred = utu.transferred
+	}
+	if status == functions.TransferStatusFailed {
+		switch {
+		case result == nil:
+			item.Error = "文件不可读"
+		case result.Err != nil:
+			item.Error = result.ResultMessage + ", " + result.Err.Error()
+		default:
+			item.Error = result.ResultMessage
+		}
+	}
+	utu.Report.Add(item)
+}
+
+// successStatus 根据执行结果判断是正常上传, 秒传还是跳过
+func successStatus(result *taskframework.TaskUnitRunResult) functions.TransferStatus {
+	if result == ResultLocalFileNotUpdated || result == ResultUpdateLocalDatabase {
+		return functions.TransferStatusSkipped
+	}
+	switch result.Extra.(type) {
+	case *cloudpan.AppUploadFileCommitResult:
+		return functions.TransferStatusRapidUploaded
+	case *cloudpan.AppFileEntity:
+		// 网盘已存在md5相同的文件
+		return functions.TransferStatusSkipped
+	}
+	return functions.TransferStatusUploaded
+}
+
+// recordMeta 记录本地文件的修改时间和权限, 下载时还原
+func (utu *UploadTaskUnit) recordMeta() {
+	if utu.DirMeta == nil {
+		return
+	}
+	fm := &functions.FileMeta{
+		ModTime: utu.LocalFileChecksum.ModTime,
+		Size:    utu.LocalFileChecksum.Length,
+		MD5:     strings.ToLower(utu.LocalFileChecksum.MD5),
+	}
+	if info, err := os.Stat(utu.LocalFileChecksum.Path); err == nil {
+		fm.Mode = info.Mode().Perm()
+	}
+	utu.DirMeta.Add(utu.SavePath, fm)
 }
 
 func (utu *UploadTaskUnit) OnSuccess(lastRunResult *taskframework.TaskUnitRunResult) {
+	utu.report(successStatus(lastRunResult), lastRunResult)
+	if lastRunResult != ResultLocalFileNotUpdated && !utu.skipped {
+		// 跳过时网盘文件未被替换, 不能记录本地文件的元数据
+		utu.recordMeta()
+	}
+
 	//文件上传成功
 	if utu.FolderSyncDb == nil || lastRunResult == ResultLocalFileNotUpdated { //不需要更新数据库
 		return
@@ -292,24 +483,87 @@ func (utu *UploadTaskUnit) OnSuccess(lastRunResult *taskframework.TaskUnitRunRes
 
 func (utu *UploadTaskUnit) OnFailed(lastRunResult *taskframework.TaskUnitRunResult) {
 	// 失败
+	utu.report(functions.TransferStatusFailed, lastRunResult)
 }
 
 var ResultLocalFileNotUpdated = &taskframework.TaskUnitRunResult{ResultCode: 1, Succeed: true, ResultMessage: "本地文件未更新，无需上传！"}
 var ResultUpdateLocalDatabase = &taskframework.TaskUnitRunResult{ResultCode: 2, Succeed: true, ResultMessage: "本地文件和云端文件MD5一致，无需上传！"}
 
 func (utu *UploadTaskUnit) OnComplete(lastRunResult *taskframework.TaskUnitRunResult) {
+	// 文件不可读或任务已取消的, 不会调用 OnFailed
+	if lastRunResult == nil || (!lastRunResult.Succeed && utu.taskInfo.IsCanceled()) {
+		utu.report(functions.TransferStatusFailed, lastRunResult)
+	}
+}
 
+// onExists 网盘文件已存在时的处理方式, 为空表示不检查同名文件
+func (utu *UploadTaskUnit) onExists() functions.OnExistsPolicy {
+	if utu.IsOverwrite {
+		return functions.OnExistsOverwrite
+	}
+	return utu.OnExists
 }
 
-func (utu *UploadTaskUnit) RetryWait() time.Duration {
-	return functions.RetryWait(utu.taskInfo.Retry())
+// skipExisting 判断是否跳过已存在的网盘文件, 返回跳过的原因, 为空表示覆盖
+func (utu *UploadTaskUnit) skipExisting(onExists functions.OnExistsPolicy, efi *cloudpan.AppFileEntity) string {
+	if efi.IsFolder {
+		// 只有明确指定覆盖时才删除同名文件夹
+		if onExists == functions.OnExistsOverwrite {
+			return ""
+		}
+		return "网盘已存在同名文件夹, 跳过"
+	}
+	switch onExists {
+	case functions.OnExistsSkip:
+		return "网盘文件已存在, 跳过"
+	case functions.OnExistsNewer:
+		if !functions.IsNewer(time.Unix(utu.LocalFileChecksum.ModTime, 0), functions.ParsePanTime(efi.LastOpTime)) {
+			return "本地文件不比网盘文件新, 跳过"
+		}
+	case functions.OnExistsDifferent:
+		if efi.FileSize == utu.LocalFileChecksum.Length && strings.EqualFold(efi.FileMd5, utu.LocalFileChecksum.MD5) {
+			return "网盘文件与本地文件相同, 跳过"
+		}
+	}
+	return ""
+}
+
+// renameSavePath 网盘文件已存在时, 选择一个不存在的文件名
+func (utu *UploadTaskUnit) renameSavePath() *apierror.ApiError {
+	dir, name := path.Split(utu.SavePath)
+	for i := 1; i <= functions.MaxRenameCount; i++ {
+		savePath := path.Join(dir, functions.RenameCandidate(name, i))
+		efi, apierr := utu.PanClient.AppFileInfoByPath(utu.FamilyId, savePath)
+		if apierr != nil && apierr.Code != apierror.ApiCodeFileNotFoundCode {
+			return apierr
+		}
+		if efi == nil || efi.FileId == "" {
+			utu.printf("[%s] 网盘文件已存在, 重命名保存为: %s\n", utu.taskInfo.Id(), savePath)
+			utu.SavePath = savePath
+			return nil
+		}
+	}
+	return apierror.NewFailedApiError("没有可用的文件名")
 }
 
 func (utu *UploadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
+	if utu.startTime.IsZero() {
+		utu.startTime = time.Now()
+	}
+	for {
+		result = utu.run()
+		// 暂停时取消了上传, 恢复后从断点继续上传
+		if (result != nil && result.Succeed) || !utu.control.waitResume(utu.taskInfo.Context()) {
+			return
+		}
+		utu.printf("[%s] 恢复上传: %s\n", utu.taskInfo.Id(), utu.LocalFileChecksum.Path)
+	}
+}
 
+func (utu *UploadTaskUnit) run() (result *taskframework.TaskUnitRunResult) {
 	err := utu.LocalFileChecksum.OpenPath()
 	if err != nil {
-		fmt.Printf("[%s] 文件不可读, 错误信息: %s, 跳过...\n", utu.taskInfo.Id(), err)
+		utu.printf("[%s] 文件不可读, 错误信息: %s, 跳过...\n", utu.taskInfo.Id(), err)
 		return
 	}
 	defer utu.LocalFileChecksum.Close() // 关闭文件
@@ -317,7 +571,7 @@ func (utu *UploadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 	timeStart := time.Now()
 	result = &taskframework.TaskUnitRunResult{}
 
-	fmt.Printf("[%s] 准备上传: %s=>%s\n", utu.taskInfo.Id(), utu.LocalFileChecksum.Path, utu.SavePath)
+	utu.printf("[%s] 准备上传: %s=>%s\n", utu.taskInfo.Id(), utu.LocalFileChecksum.Path, utu.SavePath)
 
 	defer func() {
 		var msg string
@@ -328,7 +582,7 @@ func (utu *UploadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 		} else {
 			msg = result.ResultMessage
 		}
-		fmt.Printf("%s [%s] 文件上传结果：%s  耗时 %s\n", time.Now().Format("2006-01-02 15:04:06"), utu.taskInfo.Id(), msg, time.Now().Sub(timeStart))
+		utu.printf("%s [%s] 文件上传结果：%s  耗时 %s\n", time.Now().Format("2006-01-02 15:04:06"), utu.taskInfo.Id(), msg, time.Now().Sub(timeStart))
 	}()
 	// 准备文件
 	utu.prepareFile()
@@ -339,6 +593,8 @@ func (utu *UploadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 	var appCreateUploadFileParam *cloudpan.AppCreateUploadFileParam
 	var md5Str string
 	var saveFilePath string
+	var fileName string
+	var onExists functions.OnExistsPolicy
 	var testFileMeta = &UploadedFileMeta{}
 
 	switch utu.Step {
@@ -392,8 +648,10 @@ StepUploadPrepareUpload:
 	time.Sleep(time.Duration(2) * time.Second)
 	utu.FolderCreateMutex.Unlock()
 
-	if utu.IsOverwrite {
-		// 标记覆盖旧同名文件
+	// 上传到网盘的文件名以保存路径为准, 重试时保存路径可能已被重命名
+	fileName = path.Base(utu.SavePath)
+	onExists = utu.onExists()
+	if onExists != "" {
 		// 检查同名文件是否存在
 		efi, apierr := utu.PanClient.AppFileInfoByPath(utu.FamilyId, utu.SavePath)
 		if apierr != nil && apierr.Code != apierror.ApiCodeFileNotFoundCode {
@@ -401,45 +659,69 @@ StepUploadPrepareUpload:
 			result.ResultMessage = "检测同名文件失败"
 			return
 		}
-		if efi != nil && efi.FileId != "" {
-			if efi.FileMd5 == strings.ToUpper(utu.LocalFileChecksum.MD5) {
+		if efi != nil && efi.FileId != "" && onExists == functions.OnExistsRename {
+			if apierr = utu.renameSavePath(); apierr != nil {
+				result.Err = apierr
+				result.ResultMessage = "检测重命名的文件失败"
+				return
+			}
+			fileName = path.Base(utu.SavePath)
+		} else if efi != nil && efi.FileId != "" {
+			if reason := utu.skipExisting(onExists, efi); reason != "" {
+				utu.skipped = true
 				result.Succeed = true
 				result.Extra = efi
+				result.ResultMessage = reason
 				return
 			}
-			// existed, delete it
-			infoList := cloudpan.BatchTaskInfoList{}
-			isFolder := 0
-			if efi.IsFolder {
-				isFolder = 1
-			}
-			infoItem := &cloudpan.BatchTaskInfo{
-				FileId:      efi.FileId,
-				FileName:    efi.FileName,
-				IsFolder:    isFolder,
-				SrcParentId: efi.ParentId,
-			}
-			infoList = append(infoList, infoItem)
-			delParam := &cloudpan.BatchTaskParam{
-				TypeFlag:  cloudpan.BatchTaskTypeDelete,
-				TaskInfos: infoList,
+			// 标记覆盖旧同名文件
+			if efi.FileMd5 == strings.ToUpper(utu.LocalFileChecksum.MD5) {
+				result.Succeed = true
+				result.Extra = efi
+				return
 			}
-
-			var taskId string
-			var err *apierror.ApiError
-			if utu.FamilyId > 0 {
-				taskId, err = utu.PanClient.AppCreateBatchTask(utu.FamilyId, delParam)
+			if utu.KeepVersions > 0 && !efi.IsFolder {
+				if err := KeepVersion(utu.PanClient, utu.FamilyId, efi, utu.SavePath, utu.KeepVersions); err != nil {
+					result.Err = err
+					result.ResultMessage = "保存历史版本失败"
+					return
+				}
+				logger.Verbosef("[%s] 检测到同名文件，已移动到历史版本目录: %s", utu.taskInfo.Id(), utu.SavePath)
 			} else {
-				taskId, err = utu.PanClient.CreateBatchTask(delParam)
+				// existed, delete it
+				infoList := cloudpan.BatchTaskInfoList{}
+				isFolder := 0
+				if efi.IsFolder {
+					isFolder = 1
+				}
+				infoItem := &cloudpan.BatchTaskInfo{
+					FileId:      efi.FileId,
+					FileName:    efi.FileName,
+					IsFolder:    isFolder,
+					SrcParentId: efi.ParentId,
+				}
+				infoList = append(infoList, infoItem)
+				delParam := &cloudpan.BatchTaskParam{
+					TypeFlag:  cloudpan.BatchTaskTypeDelete,
+					TaskInfos: infoList,
+				}
+
+				var taskId string
+				var err *apierror.ApiError
+				if utu.FamilyId > 0 {
+					taskId, err = utu.PanClient.AppCreateBatchTask(utu.FamilyId, delParam)
+				} else {
+					taskId, err = utu.PanClient.CreateBatchTask(delParam)
+				}
+
+				if err != nil || taskId == "" {
+					result.Err = err
+					result.ResultMessage = "无法删除文件，请稍后重试"
+					return
+				}
+				time.Sleep(time.Duration(500) * time.Millisecond)
+				logger.Verbosef("[%s] 检测到同名文件，已移动到回收站: %s", utu.taskInfo.Id(), utu.SavePath)
 			}
-
-			if err != nil || taskId == "" {
-				result.Err = err
-				result.ResultMessage = "无法删除文件，请稍后重试"
-				return
-			}
-			time.Sleep(time.Duration(500) * time.Millisecond)
-			logger.Verbosef("[%s] 检测到同名文件，已移动到回收站: %s", utu.taskInfo.Id(), utu.SavePath)
 		}
 	}
 
@@ -450,7 +732,7 @@ StepUploadPrepareUpload:
 
 	appCreateUploadFileParam = &cloudpan.AppCreateUploadFileParam{
 		ParentFolderId: rs.FileId,
-		FileName:       filepath.Base(utu.LocalFileChecksum.Path),
+		FileName:       fileName,
 		Size:           utu.LocalFileChecksum.Length,
 		Md5:            md5Str,
 		LastWrite:      time.Unix(utu.LocalFileChecksum.ModTime, 0).Format("2006-01-02 15:04:05"),
@@ -491,3 +773,12 @@ stepUploadUpload:
 
 	return uploadResult
 }
+
+// printf 输出信息, 交互模式下的后台任务输出到任务日志
+func (utu *UploadTaskUnit) printf(format string, a ...interface{}) {
+	out := utu.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprintf(out, format, a...)
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+	"testing"
+)
+
+func TestSkipExistingFolder(t *testing.T) {
+	utu := &UploadTaskUnit{
+		LocalFileChecksum: &localfile.LocalFileEntity{LocalFileMeta: localfile.LocalFileMeta{Length: 10, MD5: "abc"}},
+	}
+	efi := &cloudpan.AppFileEntity{FileName: "a", IsFolder: true}
+	for _, onExists := range []functions.OnExistsPolicy{functions.OnExistsSkip, functions.OnExistsNewer, functions.OnExistsDifferent} {
+		if reason := utu.skipExisting(onExists, efi); reason == "" {
+			t.Errorf("%s: expect skip for folder", onExists)
+		}
+	}
+	if reason := utu.skipExisting(functions.OnExistsOverwrite, efi); reason != "" {
+		t.Errorf("overwrite: expect no skip, got %s", reason)
+	}
+}
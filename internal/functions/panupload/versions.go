@@ -0,0 +1,230 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
+)
+
+type (
+	// FileVersion 网盘文件的历史版本, 保存在 .versions/<文件名>/<时间>/<文件名>
+	FileVersion struct {
+		Name   string                  // 版本目录名, 即保存的时间
+		Time   time.Time               // 保存的时间
+		Folder *cloudpan.AppFileEntity // 版本目录
+		File   *cloudpan.AppFileEntity // 历史版本的文件
+	}
+)
+
+const (
+	// VersionsDirName 历史版本目录名, 位于文件所在的目录中
+	VersionsDirName = ".versions"
+
+	// versionTimeFormat 版本目录名, 精确到微秒, 同一秒内多次覆盖也不会使用同一个目录
+	versionTimeFormat = "20060102_150405.000000"
+	// legacyVersionTimeFormat 旧的精确到秒的版本目录名
+	legacyVersionTimeFormat = "20060102_150405"
+)
+
+// VersionsDir 网盘文件的历史版本目录
+func VersionsDir(filePath string) string {
+	return path.Join(path.Dir(filePath), VersionsDirName, path.Base(filePath))
+}
+
+// parseVersionTime 解析版本目录名中的时间
+func parseVersionTime(name string) time.Time {
+	t, err := time.ParseInLocation(versionTimeFormat, name, time.Local)
+	if err != nil {
+		t, _ = time.ParseInLocation(legacyVersionTimeFormat, name, time.Local)
+	}
+	return t
+}
+
+// KeepVersion 将已存在的网盘文件移动到历史版本目录, 并只保留最近的 keep 个版本
+func KeepVersion(panClient *cloudpan.PanClient, familyId int64, efi *cloudpan.AppFileEntity, filePath string, keep int) error {
+	versionDir := path.Join(VersionsDir(filePath), time.Now().Format(versionTimeFormat))
+	rs, apierr := panClient.AppMkdirRecursive(familyId, "", "", 0, strings.Split(versionDir, "/"))
+	if apierr != nil {
+		return apierr
+	}
+	if rs == nil || rs.FileId == "" {
+		return fmt.Errorf("创建历史版本目录失败: %s", versionDir)
+	}
+	if err := moveFile(panClient, familyId, efi, rs.FileId); err != nil {
+		return err
+	}
+	return PruneVersions(panClient, familyId, filePath, keep)
+}
+
+// ListVersions 列出网盘文件的历史版本, 按时间从新到旧排序
+func ListVersions(panClient *cloudpan.PanClient, familyId int64, filePath string) ([]*FileVersion, error) {
+	dir, apierr := panClient.AppFileInfoByPath(familyId, VersionsDir(filePath))
+	if apierr != nil {
+		if apierr.Code == apierror.ApiCodeFileNotFoundCode {
+			return nil, nil
+		}
+		return nil, apierr
+	}
+
+	folders, err := listDir(panClient, familyId, dir.FileId)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]*FileVersion, 0, len(folders))
+	for _, folder := range folders {
+		if !folder.IsFolder {
+			continue
+		}
+		v := &FileVersion{
+			Name:   folder.FileName,
+			Folder: folder,
+		}
+		v.Time = parseVersionTime(folder.FileName)
+		files, err := listDir(panClient, familyId, folder.FileId)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if !f.IsFolder && f.FileName == path.Base(filePath) {
+				v.File = f
+				break
+			}
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Name > versions[j].Name
+	})
+	return versions, nil
+}
+
+// PruneVersions 删除超过 keep 个的旧版本
+func PruneVersions(panClient *cloudpan.PanClient, familyId int64, filePath string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	versions, err := ListVersions(panClient, familyId, filePath)
+	if err != nil {
+		return err
+	}
+	if len(versions) <= keep {
+		return nil
+	}
+	folders := make([]*cloudpan.AppFileEntity, 0, len(versions)-keep)
+	for _, v := range versions[keep:] {
+		folders = append(folders, v.Folder)
+	}
+	return deleteFiles(panClient, familyId, folders)
+}
+
+// RestoreVersion 将历史版本恢复为当前文件, 当前文件会保存为新的历史版本
+func RestoreVersion(panClient *cloudpan.PanClient, familyId int64, filePath string, v *FileVersion, keep int) error {
+	if v.File == nil {
+		return fmt.Errorf("历史版本 %s 中没有文件", v.Name)
+	}
+	parent, apierr := panClient.AppFileInfoByPath(familyId, path.Dir(filePath))
+	if apierr != nil {
+		return apierr
+	}
+
+	efi, apierr := panClient.AppFileInfoByPath(familyId, filePath)
+	if apierr != nil && apierr.Code != apierror.ApiCodeFileNotFoundCode {
+		return apierr
+	}
+	if efi != nil && efi.FileId != "" {
+		// 当前文件保存为历史版本, 恢复的版本不计入保留数量
+		if err := KeepVersion(panClient, familyId, efi, filePath, 0); err != nil {
+			return err
+		}
+	}
+	if err := moveFile(panClient, familyId, v.File, parent.FileId); err != nil {
+		return err
+	}
+	if err := deleteFiles(panClient, familyId, []*cloudpan.AppFileEntity{v.Folder}); err != nil {
+		return err
+	}
+	if keep > 0 {
+		return PruneVersions(panClient, familyId, filePath, keep)
+	}
+	return nil
+}
+
+func listDir(panClient *cloudpan.PanClient, familyId int64, fileId string) (cloudpan.AppFileList, error) {
+	param := cloudpan.NewAppFileListParam()
+	param.FamilyId = familyId
+	param.FileId = fileId
+	r, apierr := panClient.AppGetAllFileList(param)
+	if apierr != nil {
+		return nil, apierr
+	}
+	return r.FileList, nil
+}
+
+func moveFile(panClient *cloudpan.PanClient, familyId int64, fi *cloudpan.AppFileEntity, targetFolderId string) error {
+	var apierr *apierror.ApiError
+	if familyId > 0 {
+		_, apierr = panClient.AppFamilyMoveFile(familyId, fi.FileId, targetFolderId)
+	} else {
+		_, apierr = panClient.AppMoveFile([]string{fi.FileId}, targetFolderId)
+	}
+	if apierr != nil {
+		return apierr
+	}
+	return nil
+}
+
+// deleteFiles 删除网盘文件或目录, 删除的文件可在回收站找回
+func deleteFiles(panClient *cloudpan.PanClient, familyId int64, files []*cloudpan.AppFileEntity) error {
+	infoList := cloudpan.BatchTaskInfoList{}
+	for _, fi := range files {
+		isFolder := 0
+		if fi.IsFolder {
+			isFolder = 1
+		}
+		infoList = append(infoList, &cloudpan.BatchTaskInfo{
+			FileId:      fi.FileId,
+			FileName:    fi.FileName,
+			IsFolder:    isFolder,
+			SrcParentId: fi.ParentId,
+		})
+	}
+	delParam := &cloudpan.BatchTaskParam{
+		TypeFlag:  cloudpan.BatchTaskTypeDelete,
+		TaskInfos: infoList,
+	}
+
+	var (
+		taskId string
+		apierr *apierror.ApiError
+	)
+	if familyId > 0 {
+		taskId, apierr = panClient.AppCreateBatchTask(familyId, delParam)
+	} else {
+		taskId, apierr = panClient.CreateBatchTask(delParam)
+	}
+	if apierr != nil {
+		return apierr
+	}
+	if taskId == "" {
+		return fmt.Errorf("删除文件失败")
+	}
+	return nil
+}
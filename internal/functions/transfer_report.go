@@ -0,0 +1,158 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+type (
+	// TransferStatus 文件传输结果
+	TransferStatus string
+
+	// TransferReportItem 单个文件的传输记录
+	TransferReportItem struct {
+		LocalPath   string         `json:"localPath"`
+		RemotePath  string         `json:"remotePath"`
+		Size        int64          `json:"size"`
+		Status      TransferStatus `json:"status"`
+		Error       string         `json:"error,omitempty"`
+		Transferred int64          `json:"transferred"` // 实际传输的字节数, 秒传和跳过的文件为0
+		Duration    time.Duration  `json:"-"`
+		MD5         string         `json:"md5"`
+	}
+
+	// TransferReport 传输报告, 记录每个文件的传输结果, 结束时保存为 json 或 csv 文件
+	TransferReport struct {
+		Command   string                `json:"command"`
+		StartTime time.Time             `json:"startTime"`
+		EndTime   time.Time             `json:"endTime"`
+		Items     []*TransferReportItem `json:"items"`
+
+		mu sync.Mutex
+	}
+)
+
+const (
+	TransferStatusUploaded      TransferStatus = "uploaded"
+	TransferStatusRapidUploaded TransferStatus = "rapid-uploaded"
+	TransferStatusDownloaded    TransferStatus = "downloaded"
+	TransferStatusExported      TransferStatus = "exported"
+	TransferStatusSkipped       TransferStatus = "skipped"
+	TransferStatusFailed        TransferStatus = "failed"
+)
+
+var transferReportCsvHeader = []string{"local_path", "remote_path", "size", "status", "error", "transferred", "duration", "md5"}
+
+// CheckTransferReportPath 检查报告文件路径, 只支持 .json 和 .csv
+func CheckTransferReportPath(reportPath string) error {
+	switch strings.ToLower(filepath.Ext(reportPath)) {
+	case ".json", ".csv":
+		return nil
+	}
+	return fmt.Errorf("报告文件只支持 .json 或 .csv 格式: %s", reportPath)
+}
+
+// NewTransferReport 初始化传输报告, reportPath 为空时返回 nil, 不记录
+func NewTransferReport(command, reportPath string) (*TransferReport, error) {
+	if reportPath == "" {
+		return nil, nil
+	}
+	if err := CheckTransferReportPath(reportPath); err != nil {
+		return nil, err
+	}
+	return &TransferReport{
+		Command:   command,
+		StartTime: time.Now(),
+		Items:     []*TransferReportItem{},
+	}, nil
+}
+
+// Add 添加传输记录, 并发安全
+func (tr *TransferReport) Add(item *TransferReportItem) {
+	if tr == nil || item == nil {
+		return
+	}
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.Items = append(tr.Items, item)
+}
+
+// Save 保存报告, 根据扩展名保存为 json 或 csv
+func (tr *TransferReport) Save(reportPath string) error {
+	if tr == nil {
+		return nil
+	}
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.EndTime = time.Now()
+
+	if dir := filepath.Dir(reportPath); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(reportPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.ToLower(filepath.Ext(reportPath)) == ".csv" {
+		w := csv.NewWriter(f)
+		w.Write(transferReportCsvHeader)
+		for _, item := range tr.Items {
+			w.Write([]string{
+				item.LocalPath,
+				item.RemotePath,
+				strconv.FormatInt(item.Size, 10),
+				string(item.Status),
+				item.Error,
+				strconv.FormatInt(item.Transferred, 10),
+				strconv.FormatFloat(item.Duration.Seconds(), 'f', 3, 64),
+				item.MD5,
+			})
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	data, err := jsoniter.MarshalIndent(tr, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// MarshalJSON 耗时以秒输出
+func (item *TransferReportItem) MarshalJSON() ([]byte, error) {
+	type reportItem TransferReportItem
+	return jsoniter.Marshal(&struct {
+		*reportItem
+		Duration float64 `json:"duration"`
+	}{
+		reportItem: (*reportItem)(item),
+		Duration:   float64(item.Duration.Milliseconds()) / 1000,
+	})
+}
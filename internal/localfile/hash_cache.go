@@ -0,0 +1,218 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package localfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/tickstep/bolt"
+)
+
+type (
+	// HashCache 文件摘要缓存, 以 (设备号, inode, 文件大小, 修改时间) 作为键,
+	// 文件未修改时直接使用缓存的md5, 不再重新读取文件
+	HashCache struct {
+		db   *bolt.DB
+		path string
+		refs int
+	}
+
+	// HashCacheStats 文件摘要缓存统计
+	HashCacheStats struct {
+		Path     string // 缓存文件路径
+		Entries  int    // 缓存条目数量
+		FileSize int64  // 缓存文件大小
+	}
+
+	hashCacheEntry struct {
+		Path       string `json:"path"`
+		MD5        string `json:"md5"`
+		UpdateTime int64  `json:"updateTime"`
+	}
+)
+
+const (
+	// HashCacheFileName 文件摘要缓存的文件名
+	HashCacheFileName = "cloud189_hash_cache.db"
+
+	hashCacheBucket = "md5"
+)
+
+var (
+	hashCaches      = map[string]*HashCache{}
+	hashCacheLocker sync.Mutex
+)
+
+// OpenHashCache 打开文件摘要缓存, 同一进程内打开相同的缓存文件会共享同一个实例, 需要调用 Close 释放
+func OpenHashCache(path string) (*HashCache, error) {
+	hashCacheLocker.Lock()
+	defer hashCacheLocker.Unlock()
+
+	if hc, ok := hashCaches[path]; ok {
+		hc.refs++
+		return hc, nil
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	hc := &HashCache{
+		db:   db,
+		path: path,
+		refs: 1,
+	}
+	hashCaches[path] = hc
+	return hc, nil
+}
+
+// hashCacheKey 生成缓存的键, 不支持获取inode的系统使用文件的绝对路径
+func hashCacheKey(path string, info os.FileInfo) []byte {
+	if dev, ino, ok := FileIdentity(info); ok {
+		return []byte(fmt.Sprintf("%d:%d:%d:%d", dev, ino, info.Size(), info.ModTime().UnixNano()))
+	}
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	return []byte(fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano()))
+}
+
+// GetMD5 获取缓存的文件md5
+func (hc *HashCache) GetMD5(path string, info os.FileInfo) (md5 string, ok bool) {
+	if hc == nil || info == nil {
+		return "", false
+	}
+	key := hashCacheKey(path, info)
+	hc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(hashCacheBucket))
+		if b == nil {
+			return nil
+		}
+		v := b.Get(key)
+		if v == nil {
+			return nil
+		}
+		entry := &hashCacheEntry{}
+		if err := jsoniter.Unmarshal(v, entry); err != nil {
+			return nil
+		}
+		md5, ok = entry.MD5, entry.MD5 != ""
+		return nil
+	})
+	return
+}
+
+// PutMD5 缓存文件md5
+func (hc *HashCache) PutMD5(path string, info os.FileInfo, md5 string) error {
+	if hc == nil || info == nil || md5 == "" {
+		return nil
+	}
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	data, err := jsoniter.Marshal(&hashCacheEntry{
+		Path:       path,
+		MD5:        md5,
+		UpdateTime: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	return hc.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(hashCacheBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put(hashCacheKey(path, info), data)
+	})
+}
+
+// Stats 获取缓存统计
+func (hc *HashCache) Stats() (*HashCacheStats, error) {
+	stats := &HashCacheStats{
+		Path: hc.path,
+	}
+	err := hc.db.View(func(tx *bolt.Tx) error {
+		stats.FileSize = tx.Size()
+		b := tx.Bucket([]byte(hashCacheBucket))
+		if b == nil {
+			return nil
+		}
+		stats.Entries = b.Stats().KeyN
+		return nil
+	})
+	return stats, err
+}
+
+// Clear 清空缓存
+func (hc *HashCache) Clear() error {
+	return hc.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(hashCacheBucket)) == nil {
+			return nil
+		}
+		return tx.DeleteBucket([]byte(hashCacheBucket))
+	})
+}
+
+// ClearStale 清除本地文件已删除或已修改的缓存, 返回清除的数量
+func (hc *HashCache) ClearStale() (count int, err error) {
+	err = hc.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(hashCacheBucket))
+		if b == nil {
+			return nil
+		}
+		var staleKeys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			entry := &hashCacheEntry{}
+			if err := jsoniter.Unmarshal(v, entry); err != nil {
+				staleKeys = append(staleKeys, append([]byte{}, k...))
+				return nil
+			}
+			info, err := os.Stat(entry.Path)
+			if err != nil || string(hashCacheKey(entry.Path, info)) != string(k) {
+				staleKeys = append(staleKeys, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range staleKeys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		count = len(staleKeys)
+		return nil
+	})
+	return
+}
+
+// Close 释放缓存, 所有引用都释放后关闭缓存文件
+func (hc *HashCache) Close() error {
+	hashCacheLocker.Lock()
+	defer hashCacheLocker.Unlock()
+
+	hc.refs--
+	if hc.refs > 0 {
+		return nil
+	}
+	delete(hashCaches, hc.path)
+	return hc.db.Close()
+}
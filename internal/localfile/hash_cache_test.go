@@ -0,0 +1,141 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package localfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testMD5 = "3F9EEEBC4E583574D9D64A75E5061E56"
+
+func openTestHashCache(t *testing.T) *HashCache {
+	hc, err := OpenHashCache(filepath.Join(t.TempDir(), HashCacheFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hc.Close() })
+	return hc
+}
+
+// cacheTestFile 写入文件并缓存md5, 返回文件信息
+func cacheTestFile(t *testing.T, hc *HashCache, filePath, content string, modTime time.Time) os.FileInfo {
+	if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = hc.PutMD5(filePath, info, testMD5); err != nil {
+		t.Fatal(err)
+	}
+	if md5, ok := hc.GetMD5(filePath, info); !ok || md5 != testMD5 {
+		t.Fatalf("GetMD5() = %q, %v, want cached md5", md5, ok)
+	}
+	return info
+}
+
+func assertHashCacheMiss(t *testing.T, hc *HashCache, filePath string) {
+	t.Helper()
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md5, ok := hc.GetMD5(filePath, info); ok {
+		t.Errorf("GetMD5() = %q, want cache miss", md5)
+	}
+}
+
+func TestHashCacheInvalidation(t *testing.T) {
+	hc := openTestHashCache(t)
+	dir := t.TempDir()
+	modTime := time.Date(2021, 1, 1, 12, 0, 0, 0, time.Local)
+
+	t.Run("size", func(t *testing.T) {
+		filePath := filepath.Join(dir, "size.txt")
+		cacheTestFile(t, hc, filePath, "hello", modTime)
+		// 保持修改时间不变, 只改变文件大小
+		if err := ioutil.WriteFile(filePath, []byte("hello world"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(filePath, modTime, modTime)
+		assertHashCacheMiss(t, hc, filePath)
+	})
+
+	t.Run("mtime", func(t *testing.T) {
+		filePath := filepath.Join(dir, "mtime.txt")
+		cacheTestFile(t, hc, filePath, "hello", modTime)
+		newTime := modTime.Add(time.Second)
+		if err := os.Chtimes(filePath, newTime, newTime); err != nil {
+			t.Fatal(err)
+		}
+		assertHashCacheMiss(t, hc, filePath)
+	})
+
+	t.Run("inode", func(t *testing.T) {
+		filePath := filepath.Join(dir, "inode.txt")
+		info := cacheTestFile(t, hc, filePath, "hello", modTime)
+		if _, _, ok := FileIdentity(info); !ok {
+			t.Skip("inode is not supported")
+		}
+		// 用大小和修改时间都相同的另一个文件替换
+		tmpPath := filepath.Join(dir, "inode.tmp")
+		if err := ioutil.WriteFile(tmpPath, []byte("world"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(tmpPath, modTime, modTime)
+		if err := os.Rename(tmpPath, filePath); err != nil {
+			t.Fatal(err)
+		}
+		assertHashCacheMiss(t, hc, filePath)
+	})
+}
+
+func TestHashCacheClearStale(t *testing.T) {
+	hc := openTestHashCache(t)
+	dir := t.TempDir()
+	modTime := time.Date(2021, 1, 1, 12, 0, 0, 0, time.Local)
+
+	keepPath := filepath.Join(dir, "keep.txt")
+	keepInfo := cacheTestFile(t, hc, keepPath, "keep", modTime)
+	modifiedPath := filepath.Join(dir, "modified.txt")
+	cacheTestFile(t, hc, modifiedPath, "modified", modTime)
+	removedPath := filepath.Join(dir, "removed.txt")
+	cacheTestFile(t, hc, removedPath, "removed", modTime)
+
+	newTime := modTime.Add(time.Second)
+	os.Chtimes(modifiedPath, newTime, newTime)
+	os.Remove(removedPath)
+
+	count, err := hc.ClearStale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("ClearStale() = %d, want 2", count)
+	}
+	if stats, err := hc.Stats(); err != nil || stats.Entries != 1 {
+		t.Errorf("Stats() = %+v, %v, want 1 entry", stats, err)
+	}
+	if _, ok := hc.GetMD5(keepPath, keepInfo); !ok {
+		t.Error("unmodified file should stay cached")
+	}
+}
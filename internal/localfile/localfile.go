@@ -64,9 +64,12 @@ type (
 	// LocalFileEntity 校验本地文件
 	LocalFileEntity struct {
 		LocalFileMeta
+		HashCache *HashCache // 文件摘要缓存, 为nil则不使用缓存
+
 		bufSize int
 		buf     []byte
-		file    *os.File // 文件
+		file    *os.File    // 文件
+		info    os.FileInfo // 文件状态
 	}
 )
 
@@ -100,6 +103,7 @@ func (lfc *LocalFileEntity) OpenPath() error {
 		return err
 	}
 
+	lfc.info = info
 	lfc.Length = info.Size()
 	lfc.ModTime = info.ModTime().Unix()
 	return nil
@@ -199,6 +203,23 @@ func (lfc *LocalFileEntity) createChecksumWriteUnit(cw ChecksumWriter, isAll boo
 
 // Sum 计算文件摘要值
 func (lfc *LocalFileEntity) Sum(checkSumFlag int) (err error) {
+	if (checkSumFlag & CHECKSUM_MD5) != 0 {
+		// 优先使用缓存的md5
+		if md5Str, ok := lfc.HashCache.GetMD5(lfc.Path, lfc.info); ok {
+			lfc.MD5 = md5Str
+			checkSumFlag &^= CHECKSUM_MD5
+			if checkSumFlag == 0 {
+				return nil
+			}
+		} else {
+			defer func() {
+				if err == nil {
+					lfc.HashCache.PutMD5(lfc.Path, lfc.info, lfc.MD5)
+				}
+			}()
+		}
+	}
+
 	lfc.fix()
 	wus := make([]*ChecksumWriteUnit, 0, 2)
 	if (checkSumFlag & (CHECKSUM_MD5)) != 0 {
@@ -0,0 +1,178 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package localfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// SymlinkDescriptor 符号链接描述文件, 上传时以 --links store 保存符号链接, 下载时还原
+	SymlinkDescriptor struct {
+		Type   string `json:"type"`
+		Target string `json:"target"`
+	}
+
+	// DirVisitor 记录已遍历的目录, 以设备号和inode识别, 用于检测符号链接导致的循环
+	DirVisitor struct {
+		visited map[string]bool
+	}
+)
+
+const (
+	// SymlinkDescriptorSuffix 符号链接描述文件的后缀
+	SymlinkDescriptorSuffix = ".cloud189link"
+
+	// MaxSymlinkDescriptorSize 符号链接描述文件的最大值
+	MaxSymlinkDescriptorSize = 64 * 1024
+
+	symlinkDescriptorType = "symlink"
+)
+
+var (
+	// ErrUnsafeSymlinkTarget 符号链接指向绝对路径或保存目录之外
+	ErrUnsafeSymlinkTarget = errors.New("符号链接指向绝对路径或保存目录之外, 不还原")
+)
+
+// NewSymlinkDescriptor 读取符号链接, 生成描述文件的内容
+func NewSymlinkDescriptor(linkPath string) ([]byte, error) {
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&SymlinkDescriptor{
+		Type:   symlinkDescriptorType,
+		Target: filepath.ToSlash(target),
+	})
+}
+
+// ParseSymlinkDescriptor 解析符号链接描述文件的内容
+func ParseSymlinkDescriptor(data []byte) (*SymlinkDescriptor, error) {
+	sd := &SymlinkDescriptor{}
+	if err := json.Unmarshal(data, sd); err != nil {
+		return nil, err
+	}
+	if sd.Type != symlinkDescriptorType || sd.Target == "" {
+		return nil, fmt.Errorf("不是有效的符号链接描述文件")
+	}
+	return sd, nil
+}
+
+// IsSymlinkDescriptor 文件名是否是符号链接描述文件
+func IsSymlinkDescriptor(name string, size int64) bool {
+	return strings.HasSuffix(name, SymlinkDescriptorSuffix) && size <= MaxSymlinkDescriptorSize
+}
+
+// CheckSymlinkTarget 检查符号链接的目标, 只允许指向 rootDir 之内的相对路径.
+// 描述文件来自网盘, 不能信任其中的目标
+func CheckSymlinkTarget(linkPath, target, rootDir string) error {
+	localTarget := filepath.FromSlash(target)
+	if target == "" || path.IsAbs(target) || filepath.IsAbs(localTarget) || filepath.VolumeName(localTarget) != "" {
+		return ErrUnsafeSymlinkTarget
+	}
+	root, err := filepath.Abs(rootDir)
+	if err != nil {
+		return err
+	}
+	resolved, err := filepath.Abs(filepath.Join(filepath.Dir(linkPath), localTarget))
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return ErrUnsafeSymlinkTarget
+	}
+	return nil
+}
+
+// RestoreSymlink 将下载的描述文件还原为符号链接, 符号链接只能指向 rootDir 之内. 成功后删除描述文件, 返回符号链接的路径
+func RestoreSymlink(descriptorPath, rootDir string, overwrite bool) (string, error) {
+	data, err := os.ReadFile(descriptorPath)
+	if err != nil {
+		return "", err
+	}
+	sd, err := ParseSymlinkDescriptor(data)
+	if err != nil {
+		return "", err
+	}
+
+	linkPath := strings.TrimSuffix(descriptorPath, SymlinkDescriptorSuffix)
+	if err = CheckSymlinkTarget(linkPath, sd.Target, rootDir); err != nil {
+		return "", err
+	}
+	if _, err := os.Lstat(linkPath); err == nil {
+		if !overwrite {
+			return "", fmt.Errorf("文件已存在: %s", linkPath)
+		}
+		if err = os.Remove(linkPath); err != nil {
+			return "", err
+		}
+	}
+	if err = os.Symlink(filepath.FromSlash(sd.Target), linkPath); err != nil {
+		return "", err
+	}
+	return linkPath, os.Remove(descriptorPath)
+}
+
+// SpecialFileType 设备, socket 和命名管道等特殊文件的类型, 普通文件, 目录和符号链接返回空
+func SpecialFileType(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeCharDevice != 0:
+		return "字符设备"
+	case mode&os.ModeDevice != 0:
+		return "块设备"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeNamedPipe != 0:
+		return "命名管道"
+	case mode&os.ModeIrregular != 0:
+		return "未知类型文件"
+	}
+	return ""
+}
+
+// NewDirVisitor 初始化 DirVisitor
+func NewDirVisitor() *DirVisitor {
+	return &DirVisitor{
+		visited: map[string]bool{},
+	}
+}
+
+// Visit 记录目录, 目录已遍历过则返回 false. 不支持获取inode的系统使用解析符号链接后的绝对路径
+func (dv *DirVisitor) Visit(dirPath string, info os.FileInfo) bool {
+	var key string
+	if dev, ino, ok := FileIdentity(info); ok {
+		key = fmt.Sprintf("%d:%d", dev, ino)
+	} else {
+		realPath, err := filepath.EvalSymlinks(dirPath)
+		if err != nil {
+			realPath = dirPath
+		}
+		if absPath, err := filepath.Abs(realPath); err == nil {
+			realPath = absPath
+		}
+		key = realPath
+	}
+	if dv.visited[key] {
+		return false
+	}
+	dv.visited[key] = true
+	return true
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package localfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParseSymlinkDescriptor(t *testing.T) {
+	cases := []struct {
+		data   string
+		target string
+		ok     bool
+	}{
+		{`{"type":"symlink","target":"a/b.txt"}`, "a/b.txt", true},
+		{`{"type":"symlink","target":""}`, "", false},
+		{`{"type":"file","target":"a"}`, "", false},
+		{`not json`, "", false},
+	}
+	for _, c := range cases {
+		sd, err := ParseSymlinkDescriptor([]byte(c.data))
+		if c.ok != (err == nil) {
+			t.Fatalf("%s: err = %v", c.data, err)
+		}
+		if c.ok && sd.Target != c.target {
+			t.Fatalf("%s: target = %s, want %s", c.data, sd.Target, c.target)
+		}
+	}
+}
+
+func TestCheckSymlinkTarget(t *testing.T) {
+	root := filepath.Join("save", "root")
+	link := filepath.Join(root, "dir", "link")
+	cases := []struct {
+		target string
+		ok     bool
+	}{
+		{"file.txt", true},
+		{"../other/file.txt", true},
+		{"../file.txt", true},
+		{"../../file.txt", false},
+		{"../../rootx/file.txt", false},
+		{"/etc/passwd", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		err := CheckSymlinkTarget(link, c.target, root)
+		if c.ok != (err == nil) {
+			t.Fatalf("%s: err = %v", c.target, err)
+		}
+	}
+}
+
+func writeDescriptor(t *testing.T, path, target string) {
+	data := `{"type":"symlink","target":"` + target + `"}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows 创建符号链接需要权限")
+	}
+	root := t.TempDir()
+	descriptor := filepath.Join(root, "link"+SymlinkDescriptorSuffix)
+	writeDescriptor(t, descriptor, "sub/file.txt")
+
+	linkPath, err := RestoreSymlink(descriptor, root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := os.Readlink(linkPath)
+	if err != nil || target != filepath.FromSlash("sub/file.txt") {
+		t.Fatalf("target = %s, err = %v", target, err)
+	}
+	if _, err = os.Stat(descriptor); !os.IsNotExist(err) {
+		t.Fatal("描述文件未删除")
+	}
+
+	// 已存在且不覆盖
+	writeDescriptor(t, descriptor, "other.txt")
+	if _, err = RestoreSymlink(descriptor, root, false); err == nil {
+		t.Fatal("已存在的文件被覆盖")
+	}
+	if _, err = RestoreSymlink(descriptor, root, true); err != nil {
+		t.Fatal(err)
+	}
+	if target, _ = os.Readlink(linkPath); target != "other.txt" {
+		t.Fatalf("target = %s", target)
+	}
+}
+
+func TestRestoreSymlinkUnsafe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows 创建符号链接需要权限")
+	}
+	root := t.TempDir()
+	for _, target := range []string{"/etc/passwd", "../outside", "sub/../../outside"} {
+		descriptor := filepath.Join(root, "link"+SymlinkDescriptorSuffix)
+		writeDescriptor(t, descriptor, target)
+		if _, err := RestoreSymlink(descriptor, root, true); err != ErrUnsafeSymlinkTarget {
+			t.Fatalf("%s: err = %v", target, err)
+		}
+		if _, err := os.Lstat(filepath.Join(root, "link")); !os.IsNotExist(err) {
+			t.Fatalf("%s: 不安全的符号链接被创建", target)
+		}
+	}
+}
@@ -29,6 +29,8 @@ import (
 	"github.com/tickstep/library-go/logger"
 	"github.com/tickstep/library-go/requester"
 	"github.com/tickstep/cloudpan189-go/library/requester/transfer"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"path/filepath"
 	"regexp"
@@ -149,8 +151,72 @@ func GetLatestReleaseInfo(showPrompt bool) *ReleaseInfo {
 	return releaseInfo
 }
 
-// CheckUpdate 检测更新
-func CheckUpdate(version string, yes bool) {
+// assetExp 匹配当前系统的更新文件名
+func assetExp(tagName string) *regexp.Regexp {
+	builder := &strings.Builder{}
+	builder.WriteString(ReleaseName + "-" + tagName + "-" + runtime.GOOS + "-.*?")
+	if runtime.GOOS == "darwin" && (runtime.GOARCH == "arm" || runtime.GOARCH == "arm64") {
+		builder.WriteString("arm")
+	} else {
+		switch runtime.GOARCH {
+		case "amd64":
+			builder.WriteString("(amd64|x86_64|x64)")
+		case "386":
+			builder.WriteString("(386|x86)")
+		case "arm":
+			builder.WriteString("(armv5|armv7|arm)")
+		case "arm64":
+			builder.WriteString("arm64")
+		case "mips":
+			builder.WriteString("mips")
+		case "mips64":
+			builder.WriteString("mips64")
+		case "mipsle":
+			builder.WriteString("(mipsle|mipsel)")
+		case "mips64le":
+			builder.WriteString("(mips64le|mips64el)")
+		default:
+			builder.WriteString(runtime.GOARCH)
+		}
+	}
+	builder.WriteString("\\.zip")
+	return regexp.MustCompile(builder.String())
+}
+
+// readAsset 读取较小的发布文件, 例如校验文件和签名
+func readAsset(client *requester.HTTPClient, downloadURL string) ([]byte, error) {
+	resp, err := client.Req(http.MethodGet, downloadURL, nil, nil)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status: %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 1*converter.MB))
+}
+
+// confirm 询问是否继续
+func confirm(prompt string) bool {
+	line := cmdliner.NewLiner()
+	defer line.Close()
+
+	y, err := line.State.Prompt(prompt)
+	if err != nil {
+		fmt.Printf("输入错误: %s\n", err)
+		return false
+	}
+	if y != "y" && y != "Y" {
+		fmt.Printf("操作取消.\n")
+		return false
+	}
+	return true
+}
+
+// CheckUpdate 检测更新, noVerify 为 true 时不校验更新文件
+func CheckUpdate(version string, yes, noVerify bool) {
 	if !checkaccess.AccessRDWR(cmdutil.ExecutablePath()) {
 		fmt.Printf("程序目录不可写, 无法更新.\n")
 		return
@@ -190,42 +256,23 @@ func CheckUpdate(version string, yes bool) {
 		}
 	}
 
-	builder := &strings.Builder{}
-	builder.WriteString(ReleaseName + "-" + releaseInfo.TagName + "-" + runtime.GOOS + "-.*?")
-	if runtime.GOOS == "darwin" && (runtime.GOARCH == "arm" || runtime.GOARCH == "arm64") {
-		builder.WriteString("arm")
-	} else {
-		switch runtime.GOARCH {
-		case "amd64":
-			builder.WriteString("(amd64|x86_64|x64)")
-		case "386":
-			builder.WriteString("(386|x86)")
-		case "arm":
-			builder.WriteString("(armv5|armv7|arm)")
-		case "arm64":
-			builder.WriteString("arm64")
-		case "mips":
-			builder.WriteString("mips")
-		case "mips64":
-			builder.WriteString("mips64")
-		case "mipsle":
-			builder.WriteString("(mipsle|mipsel)")
-		case "mips64le":
-			builder.WriteString("(mips64le|mips64el)")
-		default:
-			builder.WriteString(runtime.GOARCH)
-		}
-	}
-	builder.WriteString("\\.zip")
-
-	exp := regexp.MustCompile(builder.String())
+	exp := assetExp(releaseInfo.TagName)
 
-	var targetList []*info
+	var (
+		targetList     []*info
+		checksumAsset  *AssetInfo
+		signatureAsset *AssetInfo
+	)
 	for _, asset := range releaseInfo.Assets {
 		if asset == nil || asset.State != "uploaded" {
 			continue
 		}
 
+		if isChecksumFile(asset.Name) {
+			checksumAsset = asset
+			continue
+		}
+
 		if exp.MatchString(asset.Name) {
 			targetList = append(targetList, &info{
 				filename:    asset.Name,
@@ -269,6 +316,17 @@ func CheckUpdate(version string, yes bool) {
 		target = *targetList[i]
 	}
 
+	if checksumAsset != nil {
+		for _, asset := range releaseInfo.Assets {
+			if asset != nil && asset.State == "uploaded" && asset.Name == checksumAsset.Name+SignatureSuffix {
+				signatureAsset = asset
+			}
+		}
+	} else if !noVerify {
+		fmt.Printf("发布中没有找到 SHA-256 校验文件, 无法校验更新文件. 如需跳过校验, 请使用 --no-verify\n")
+		return
+	}
+
 	if target.size > 0x7fffffff {
 		fmt.Printf("file size too large: %d\n", target.size)
 		return
@@ -346,14 +404,103 @@ func CheckUpdate(version string, yes bool) {
 		return
 	}
 
+	if !noVerify {
+		checksums, err := readAsset(client, checksumAsset.BrowserDownloadURL)
+		if err != nil {
+			fmt.Printf("下载校验文件发生错误: %s\n", err)
+			return
+		}
+		var signature []byte
+		if signatureAsset != nil {
+			signature, err = readAsset(client, signatureAsset.BrowserDownloadURL)
+			if err != nil {
+				fmt.Printf("下载签名文件发生错误: %s\n", err)
+				return
+			}
+		}
+		if err = verifyPackage(target.filename, buf[:downloadSize], checksums, signature); err != nil {
+			fmt.Printf("校验更新文件失败: %s\n", err)
+			return
+		}
+		fmt.Printf("校验更新文件成功\n")
+	}
+
+	applyUpdate(version, buf[:downloadSize])
+}
+
+// UpdateFromFile 使用本地的更新文件进行更新, 用于无法访问更新服务器的机器.
+// 校验文件需要和更新文件放在同一目录
+func UpdateFromFile(version, zipPath string, yes, noVerify bool) {
+	if !checkaccess.AccessRDWR(cmdutil.ExecutablePath()) {
+		fmt.Printf("程序目录不可写, 无法更新.\n")
+		return
+	}
+
+	data, err := ioutil.ReadFile(zipPath)
+	if err != nil {
+		fmt.Printf("读取更新文件发生错误: %s\n", err)
+		return
+	}
+
+	name := filepath.Base(zipPath)
+	if !assetExp(".*?").MatchString(name) {
+		fmt.Printf("Warning: 更新文件 %s 可能不适用于当前系统, GOOS: %s, GOARCH: %s\n", name, runtime.GOOS, runtime.GOARCH)
+	}
+
+	if !noVerify {
+		checksums, signature, err := readLocalChecksums(filepath.Dir(zipPath), name)
+		if err != nil {
+			fmt.Printf("%s. 如需跳过校验, 请使用 --no-verify\n", err)
+			return
+		}
+		if err = verifyPackage(name, data, checksums, signature); err != nil {
+			fmt.Printf("校验更新文件失败: %s\n", err)
+			return
+		}
+		fmt.Printf("校验更新文件成功\n")
+	}
+
+	if !yes && !confirm(fmt.Sprintf("是否使用 %s 进行更新 (y/n): ", name)) {
+		return
+	}
+
+	applyUpdate(version, data)
+}
+
+// Rollback 回滚到上次更新前的版本
+func Rollback(yes bool) {
+	if !checkaccess.AccessRDWR(cmdutil.ExecutablePath()) {
+		fmt.Printf("程序目录不可写, 无法回滚.\n")
+		return
+	}
+
+	if !yes && !confirm("是否回滚到上次更新前的版本 (y/n): ") {
+		return
+	}
+
+	version, err := rollback(cmdutil.ExecutablePath())
+	if err != nil {
+		fmt.Printf("回滚失败: %s\n", err)
+		return
+	}
+	fmt.Printf("已回滚到版本 %s, 请重启程序\n", version)
+}
+
+// applyUpdate 解压更新文件替换程序, 旧文件保留在备份目录, 替换出错时还原
+func applyUpdate(version string, data []byte) {
 	// 读取文件
-	reader, err := zip.NewReader(bytes.NewReader(buf), target.size)
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
 	if err != nil {
 		fmt.Printf("读取更新文件发生错误: %s\n", err)
 		return
 	}
 
 	execPath := cmdutil.ExecutablePath()
+	u, err := newUpdater(execPath, version)
+	if err != nil {
+		fmt.Printf("备份旧版本发生错误: %s\n", err)
+		return
+	}
 
 	var fileNum, errTimes int
 	for _, zipFile := range reader.File {
@@ -377,10 +524,11 @@ func CheckUpdate(version string, yes bool) {
 
 		name := zipFile.Name[strings.Index(zipFile.Name, "/")+1:]
 		if name == ReleaseName {
-			err = update(cmdutil.Executable(), rc)
+			err = u.update(cmdutil.Executable(), rc)
 		} else {
-			err = update(filepath.Join(execPath, name), rc)
+			err = u.update(filepath.Join(execPath, name), rc)
 		}
+		rc.Close()
 
 		if err != nil {
 			errTimes++
@@ -389,10 +537,14 @@ func CheckUpdate(version string, yes bool) {
 		}
 	}
 
-	if errTimes == fileNum {
-		fmt.Printf("更新失败\n")
+	if errTimes > 0 || fileNum == 0 {
+		if _, err = rollback(execPath); err != nil && err != ErrNoBackup {
+			fmt.Printf("更新失败, 还原旧版本发生错误: %s\n", err)
+			return
+		}
+		fmt.Printf("更新失败, 已还原\n")
 		return
 	}
 
-	fmt.Printf("更新完毕, 请重启程序\n")
+	fmt.Printf("更新完毕, 请重启程序. 如需回到旧版本, 请使用 update --rollback\n")
 }
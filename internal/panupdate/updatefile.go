@@ -14,13 +14,84 @@
 package panupdate
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-func update(targetPath string, src io.Reader) error {
+const (
+	// BackupDirName 更新前备份旧版本文件的目录, 位于程序所在目录, 只保留上一个版本
+	BackupDirName = "update-backup"
+
+	backupManifestName = "manifest.json"
+)
+
+var (
+	// ErrNoBackup 没有可以回滚的版本
+	ErrNoBackup = errors.New("没有可以回滚的版本")
+)
+
+type (
+	// backupManifest 备份信息, 每替换一个文件保存一次, 更新中断时也可以回滚
+	backupManifest struct {
+		Version string   `json:"version"` // 备份的版本
+		Files   []string `json:"files"`   // 已替换的文件, 相对程序目录的路径
+	}
+
+	// updater 替换程序目录中的文件, 并将旧文件移动到备份目录
+	updater struct {
+		dir      string
+		manifest backupManifest
+	}
+)
+
+func backupDir(dir string) string {
+	return filepath.Join(dir, BackupDirName)
+}
+
+func newUpdater(dir, version string) (*updater, error) {
+	// 清除更早的备份
+	if err := os.RemoveAll(backupDir(dir)); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(backupDir(dir), 0755); err != nil {
+		return nil, err
+	}
+	u := &updater{
+		dir:      dir,
+		manifest: backupManifest{Version: version},
+	}
+	return u, u.saveManifest()
+}
+
+func (u *updater) saveManifest() error {
+	data, err := json.Marshal(&u.manifest)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(backupDir(u.dir), backupManifestName), data, 0644)
+}
+
+// relPath 返回相对程序目录的路径, 不允许写到程序目录之外
+func (u *updater) relPath(targetPath string) (string, error) {
+	rel, err := filepath.Rel(u.dir, targetPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) || rel == BackupDirName || strings.HasPrefix(rel, BackupDirName+string(os.PathSeparator)) {
+		return "", fmt.Errorf("非法的更新文件路径: %s", targetPath)
+	}
+	return rel, nil
+}
+
+func (u *updater) update(targetPath string, src io.Reader) error {
+	rel, err := u.relPath(targetPath)
+	if err != nil {
+		return err
+	}
+
 	info, err := os.Stat(targetPath)
 	if err != nil {
 		fmt.Printf("Warning: %s\n", err)
@@ -29,20 +100,28 @@ func update(targetPath string, src io.Reader) error {
 
 	privMode := info.Mode()
 
-	oldPath := filepath.Join(filepath.Dir(targetPath), "old-"+filepath.Base(targetPath))
+	backupPath := filepath.Join(backupDir(u.dir), rel)
+	if err = os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+		return err
+	}
 
-	err = os.Rename(targetPath, oldPath)
+	err = os.Rename(targetPath, backupPath)
 	if err != nil {
 		return err
 	}
+	u.manifest.Files = append(u.manifest.Files, rel)
+	if err = u.saveManifest(); err != nil {
+		return err
+	}
 
-	newFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY, privMode)
+	newFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, privMode)
 	if err != nil {
 		return err
 	}
 
 	_, err = io.Copy(newFile, src)
 	if err != nil {
+		newFile.Close()
 		return err
 	}
 
@@ -50,10 +129,47 @@ func update(targetPath string, src io.Reader) error {
 	if err != nil {
 		fmt.Printf("Warning: 关闭文件发生错误: %s\n", err)
 	}
+	return nil
+}
 
-	err = os.Remove(oldPath)
+// rollback 将备份目录中的文件还原到程序目录, 返回还原的版本
+func rollback(dir string) (version string, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(backupDir(dir), backupManifestName))
 	if err != nil {
-		fmt.Printf("Warning: 移除旧文件发生错误: %s\n", err)
+		if os.IsNotExist(err) {
+			return "", ErrNoBackup
+		}
+		return "", err
 	}
-	return nil
+	manifest := backupManifest{}
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return "", err
+	}
+	if len(manifest.Files) == 0 {
+		return "", ErrNoBackup
+	}
+
+	for _, rel := range manifest.Files {
+		targetPath := filepath.Join(dir, rel)
+		if _, err = os.Stat(filepath.Join(backupDir(dir), rel)); os.IsNotExist(err) {
+			// 上次回滚中断, 已经还原过
+			continue
+		}
+		// 正在运行的程序不能被覆盖, 先移开
+		oldPath := filepath.Join(filepath.Dir(targetPath), "old-"+filepath.Base(targetPath))
+		if err = os.Rename(targetPath, oldPath); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err = os.Rename(filepath.Join(backupDir(dir), rel), targetPath); err != nil {
+			return "", err
+		}
+		if err = os.Remove(oldPath); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Warning: 移除旧文件发生错误: %s\n", err)
+		}
+	}
+
+	if err = os.RemoveAll(backupDir(dir)); err != nil {
+		fmt.Printf("Warning: 移除备份目录发生错误: %s\n", err)
+	}
+	return manifest.Version, nil
 }
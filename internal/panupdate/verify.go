@@ -0,0 +1,141 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupdate
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// SignatureSuffix 校验文件的签名文件后缀
+	SignatureSuffix = ".sig"
+)
+
+var (
+	// UpdatePublicKey 校验更新签名的 ed25519 公钥(base64), 编译时通过 -ldflags "-X" 设置, 为空则不校验签名
+	UpdatePublicKey = ""
+
+	// checksumFileExp 发布的 SHA-256 校验文件名
+	checksumFileExp = regexp.MustCompile(`(?i)(sha256sums|checksums)(\.txt)?$`)
+
+	// ErrChecksumNotFound 校验文件中没有对应的记录
+	ErrChecksumNotFound = errors.New("校验文件中没有该更新文件的 SHA-256")
+	// ErrChecksumMismatch SHA-256 不匹配
+	ErrChecksumMismatch = errors.New("更新文件的 SHA-256 不匹配")
+	// ErrSignatureNotFound 设置了公钥但没有签名
+	ErrSignatureNotFound = errors.New("没有找到校验文件的签名")
+	// ErrSignatureInvalid 签名校验失败
+	ErrSignatureInvalid = errors.New("校验文件的签名无效")
+)
+
+// isChecksumFile 是否为发布的 SHA-256 校验文件
+func isChecksumFile(name string) bool {
+	return checksumFileExp.MatchString(name)
+}
+
+// parseChecksums 解析 sha256sum 格式的校验文件, 返回文件名对应的 SHA-256
+func parseChecksums(data []byte) map[string]string {
+	sums := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name := strings.TrimPrefix(fields[len(fields)-1], "*")
+		sums[name] = strings.ToLower(fields[0])
+	}
+	return sums
+}
+
+// verifySHA256 校验更新文件的 SHA-256
+func verifySHA256(name string, data, checksums []byte) error {
+	expected, ok := parseChecksums(checksums)[name]
+	if !ok {
+		return ErrChecksumNotFound
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != expected {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// verifySignature 使用内置公钥校验校验文件的签名, 签名可以是原始数据或base64
+func verifySignature(publicKey string, checksums, signature []byte) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("内置的公钥无效")
+	}
+	if len(signature) == 0 {
+		return ErrSignatureNotFound
+	}
+	if len(signature) != ed25519.SignatureSize {
+		signature, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+		if err != nil {
+			return ErrSignatureInvalid
+		}
+	}
+	if !ed25519.Verify(key, checksums, signature) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// verifyPackage 校验更新文件, 设置了内置公钥时先校验校验文件的签名
+func verifyPackage(name string, data, checksums, signature []byte) error {
+	if UpdatePublicKey != "" {
+		if err := verifySignature(UpdatePublicKey, checksums, signature); err != nil {
+			return err
+		}
+	}
+	return verifySHA256(name, data, checksums)
+}
+
+// readLocalChecksums 在更新文件所在目录查找包含该文件的校验文件, 以及校验文件的签名
+func readLocalChecksums(dir, name string) (checksums, signature []byte, err error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, info := range infos {
+		if info.IsDir() || !isChecksumFile(info.Name()) {
+			continue
+		}
+		checksumPath := filepath.Join(dir, info.Name())
+		data, err := ioutil.ReadFile(checksumPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := parseChecksums(data)[name]; !ok {
+			continue
+		}
+		signature, err = ioutil.ReadFile(checksumPath + SignatureSuffix)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+		return data, signature, nil
+	}
+	return nil, nil, fmt.Errorf("目录 %s 中没有找到包含 %s 的 SHA-256 校验文件", dir, name)
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupdate
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyPackage(t *testing.T) {
+	data := []byte("zip data")
+	sum := sha256.Sum256(data)
+	checksums := []byte(hex.EncodeToString(sum[:]) + "  cloudpan189-go-v1.0.0-linux-amd64.zip\n" +
+		"0000  cloudpan189-go-v1.0.0-windows-x64.zip\n")
+
+	if err := verifySHA256("cloudpan189-go-v1.0.0-linux-amd64.zip", data, checksums); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySHA256("cloudpan189-go-v1.0.0-windows-x64.zip", data, checksums); err != ErrChecksumMismatch {
+		t.Fatalf("expect %s, got %v", ErrChecksumMismatch, err)
+	}
+	if err := verifySHA256("other.zip", data, checksums); err != ErrChecksumNotFound {
+		t.Fatalf("expect %s, got %v", ErrChecksumNotFound, err)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(pub)
+	sig := ed25519.Sign(priv, checksums)
+	if err = verifySignature(key, checksums, sig); err != nil {
+		t.Fatal(err)
+	}
+	if err = verifySignature(key, checksums, []byte(base64.StdEncoding.EncodeToString(sig)+"\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = verifySignature(key, append(checksums, 'x'), sig); err != ErrSignatureInvalid {
+		t.Fatalf("expect %s, got %v", ErrSignatureInvalid, err)
+	}
+	if err = verifySignature(key, checksums, nil); err != ErrSignatureNotFound {
+		t.Fatalf("expect %s, got %v", ErrSignatureNotFound, err)
+	}
+}
+
+func TestUpdateRollback(t *testing.T) {
+	dir := t.TempDir()
+	exe := filepath.Join(dir, ReleaseName)
+	if err := ioutil.WriteFile(exe, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := newUpdater(dir, "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.update(exe, strings.NewReader("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.update(filepath.Join(dir, "..", "evil"), strings.NewReader("x")); err == nil {
+		t.Fatal("expect error for path outside of the program directory")
+	}
+	if data, _ := ioutil.ReadFile(exe); string(data) != "new" {
+		t.Fatalf("expect new, got %s", data)
+	}
+
+	version, err := rollback(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "v1.0.0" {
+		t.Fatalf("expect v1.0.0, got %s", version)
+	}
+	if data, _ := ioutil.ReadFile(exe); string(data) != "old" {
+		t.Fatalf("expect old, got %s", data)
+	}
+	if _, err = rollback(dir); err != ErrNoBackup {
+		t.Fatalf("expect %s, got %v", ErrNoBackup, err)
+	}
+}
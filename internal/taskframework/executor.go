@@ -14,9 +14,10 @@
 package taskframework
 
 import (
+	"container/heap"
+	"context"
 	"github.com/GeertJohan/go.incremental"
 	"github.com/oleiade/lane"
-	"github.com/tickstep/cloudpan189-go/internal/waitgroup"
 	"strconv"
 	"sync"
 	"time"
@@ -25,9 +26,21 @@ import (
 type (
 	TaskExecutor struct {
 		incr     *incremental.Int // 任务id生成
-		deque    *lane.Deque      // 队列
+		queue    taskQueue        // 等待执行的任务, 按优先级排序
+		seq      uint64           // 加入队列的序号, 相同优先级的任务按加入顺序执行
 		parallel int              // 任务的最大并发量
 		locker   sync.Mutex
+		cond     *sync.Cond
+
+		running map[string]*TaskInfoItem // 正在执行的任务, 占用并发量
+		active  int                      // 尚未结束的任务数量, 包括等待重试的任务
+		paused  bool
+		stopped bool
+		ctx     context.Context
+		cancel  context.CancelFunc
+
+		retryPolicy  RetryPolicy               // 重试策略
+		priorityFunc func(unit TaskUnit) int64 // 计算任务优先级, 任务未指定优先级时使用
 
 		// 是否统计失败队列
 		IsFailedDeque bool
@@ -40,8 +53,16 @@ func NewTaskExecutor() *TaskExecutor {
 }
 
 func (te *TaskExecutor) lazyInit() {
-	if te.deque == nil {
-		te.deque = lane.NewDeque()
+	te.locker.Lock()
+	defer te.locker.Unlock()
+	if te.cond == nil {
+		te.cond = sync.NewCond(&te.locker)
+	}
+	if te.running == nil {
+		te.running = map[string]*TaskInfoItem{}
+	}
+	if te.ctx == nil {
+		te.ctx, te.cancel = context.WithCancel(context.Background())
 	}
 	if te.incr == nil {
 		te.incr = &incremental.Int{}
@@ -49,26 +70,72 @@ func (te *TaskExecutor) lazyInit() {
 	if te.parallel < 1 {
 		te.parallel = 1
 	}
-	if te.IsFailedDeque {
+	if te.IsFailedDeque && te.failedDeque == nil {
 		te.failedDeque = lane.NewDeque()
 	}
 }
 
-// 设置任务的最大并发量
+// 设置任务的最大并发量, 执行过程中也可以修改
 func (te *TaskExecutor) SetParallel(parallel int) {
+	te.locker.Lock()
 	te.parallel = parallel
+	if te.cond != nil {
+		te.cond.Broadcast()
+	}
+	te.locker.Unlock()
+}
+
+// Parallel 返回任务的最大并发量
+func (te *TaskExecutor) Parallel() int {
+	te.locker.Lock()
+	defer te.locker.Unlock()
+	return te.parallel
+}
+
+// SetRetryPolicy 设置重试策略, 任务未单独设置重试策略时使用
+func (te *TaskExecutor) SetRetryPolicy(policy RetryPolicy) {
+	te.retryPolicy = policy
+}
+
+// SetPriorityFunc 设置任务优先级的计算方法, 优先级高的任务先执行, 例如 SmallFirstPriority
+func (te *TaskExecutor) SetPriorityFunc(f func(unit TaskUnit) int64) {
+	te.priorityFunc = f
+}
+
+// taskRetryPolicy 获取任务的重试策略
+func (te *TaskExecutor) taskRetryPolicy(task *TaskInfoItem) RetryPolicy {
+	if task.Info.retryPolicy != nil {
+		return task.Info.retryPolicy
+	}
+	if te.retryPolicy != nil {
+		return te.retryPolicy
+	}
+	return DefaultRetryPolicy
 }
 
 //Append 将任务加到任务队列末尾
 func (te *TaskExecutor) Append(unit TaskUnit, maxRetry int) *TaskInfo {
+	var priority int64
+	if te.priorityFunc != nil {
+		priority = te.priorityFunc(unit)
+	}
+	return te.AppendWithPriority(unit, maxRetry, priority)
+}
+
+// AppendWithPriority 将任务加入任务队列, 优先级高的任务先执行
+func (te *TaskExecutor) AppendWithPriority(unit TaskUnit, maxRetry int, priority int64) *TaskInfo {
 	te.lazyInit()
+	ctx, cancel := context.WithCancel(te.ctx)
 	taskInfo := &TaskInfo{
 		id:       strconv.Itoa(te.incr.Next()),
 		maxRetry: maxRetry,
+		priority: priority,
+		ctx:      ctx,
+		cancel:   cancel,
 	}
 	unit.SetTaskInfo(taskInfo)
 	te.locker.Lock()
-	te.deque.Append(&TaskInfoItem{
+	te.push(&TaskInfoItem{
 		Info: taskInfo,
 		Unit: unit,
 	})
@@ -81,94 +148,151 @@ func (te *TaskExecutor) AppendNoRetry(unit TaskUnit) {
 	te.Append(unit, 0)
 }
 
-//Count 返回任务数量
+// push 加入队列, 需要持有锁
+func (te *TaskExecutor) push(task *TaskInfoItem) {
+	te.seq++
+	task.seq = te.seq
+	heap.Push(&te.queue, task)
+	te.cond.Broadcast()
+}
+
+//Count 返回等待执行的任务数量
 func (te *TaskExecutor) Count() int {
-	if te.deque == nil {
-		return 0
+	te.locker.Lock()
+	defer te.locker.Unlock()
+	return te.queue.Len()
+}
+
+// RunningCount 返回正在执行的任务数量
+func (te *TaskExecutor) RunningCount() int {
+	te.locker.Lock()
+	defer te.locker.Unlock()
+	return len(te.running)
+}
+
+// SetPriority 修改队列中任务的优先级, 任务不在队列中返回 false
+func (te *TaskExecutor) SetPriority(id string, priority int64) bool {
+	te.locker.Lock()
+	defer te.locker.Unlock()
+	for _, task := range te.queue {
+		if task.Info.id == id {
+			task.Info.priority = priority
+			heap.Fix(&te.queue, task.index)
+			return true
+		}
+	}
+	return false
+}
+
+// Cancel 取消任务, 队列中的任务直接移除, 正在执行的任务通过 context 通知取消
+func (te *TaskExecutor) Cancel(id string) bool {
+	te.locker.Lock()
+	defer te.locker.Unlock()
+	for _, task := range te.queue {
+		if task.Info.id == id {
+			heap.Remove(&te.queue, task.index)
+			task.Info.cancel()
+			te.cond.Broadcast()
+			return true
+		}
 	}
-	return te.deque.Size()
+	if task, ok := te.running[id]; ok {
+		task.Info.cancel()
+		return true
+	}
+	return false
 }
 
-//Execute 执行任务
+//Execute 执行任务, 直到队列为空且没有正在执行的任务, 或者调用了 Stop
 func (te *TaskExecutor) Execute() {
 	te.lazyInit()
 
+	te.locker.Lock()
+	defer te.locker.Unlock()
 	for {
-		wg := waitgroup.NewWaitGroup(te.parallel)
-		for {
-			te.locker.Lock()
-			e := te.deque.Shift()
-			te.locker.Unlock()
-			if e == nil { // 任务为空
-				break
-			}
+		if te.active == 0 && (te.stopped || te.queue.Len() == 0) {
+			return
+		}
 
-			// 获取任务
-			task, ok := e.(*TaskInfoItem)
-			if !ok {
-				// type cast failed
+		if !te.stopped && !te.paused && len(te.running) < te.parallel && te.queue.Len() > 0 {
+			task := heap.Pop(&te.queue).(*TaskInfoItem)
+			if task.Info.IsCanceled() {
+				continue
 			}
-			wg.AddDelta()
-
-			go func(task *TaskInfoItem) {
-				defer wg.Done()
-
-				result := task.Unit.Run()
-
-				// 返回结果为空
-				if result == nil {
-					task.Unit.OnComplete(result)
-					return
-				}
-
-				if result.Succeed {
-					task.Unit.OnSuccess(result)
-					task.Unit.OnComplete(result)
-					return
-				}
-
-				// 需要进行重试
-				if result.NeedRetry {
-					// 重试次数超出限制
-					// 执行失败
-					if task.Info.IsExceedRetry() {
-						task.Unit.OnFailed(result)
-						if te.IsFailedDeque {
-							// 加入失败队列
-							te.failedDeque.Append(task)
-						}
-						task.Unit.OnComplete(result)
-						return
-					}
-
-					task.Info.retry++         // 增加重试次数
-					task.Unit.OnRetry(result) // 调用重试
-					task.Unit.OnComplete(result)
-
-					time.Sleep(task.Unit.RetryWait()) // 等待
-					te.locker.Lock()
-					te.deque.Append(task)             // 重新加入队列末尾
-					te.locker.Unlock()
-					return
-				}
-
-				// 执行失败
-				task.Unit.OnFailed(result)
-				if te.IsFailedDeque {
-					// 加入失败队列
-					te.failedDeque.Append(task)
-				}
-				task.Unit.OnComplete(result)
-			}(task)
+			te.running[task.Info.id] = task
+			te.active++
+			go te.run(task)
+			continue
 		}
 
-		wg.Wait()
+		te.cond.Wait()
+	}
+}
+
+// run 执行单个任务
+func (te *TaskExecutor) run(task *TaskInfoItem) {
+	defer func() {
+		te.locker.Lock()
+		te.active--
+		te.cond.Broadcast()
+		te.locker.Unlock()
+	}()
+
+	result := task.Unit.Run()
+
+	te.locker.Lock()
+	delete(te.running, task.Info.id)
+	te.cond.Broadcast()
+	te.locker.Unlock()
+
+	// 返回结果为空
+	if result == nil {
+		task.Unit.OnComplete(result)
+		return
+	}
+
+	if result.Succeed {
+		task.Unit.OnSuccess(result)
+		task.Unit.OnComplete(result)
+		return
+	}
+
+	// 已取消的任务不重试, 也不加入失败队列
+	if task.Info.IsCanceled() {
+		task.Unit.OnComplete(result)
+		return
+	}
 
-		// 没有任务了
-		if te.deque.Size() == 0 {
-			break
+	// 需要进行重试
+	policy := te.taskRetryPolicy(task)
+	if policy.CanRetry(result) && !task.Info.IsExceedRetry() {
+		task.Info.retry++         // 增加重试次数
+		task.Unit.OnRetry(result) // 调用重试
+		task.Unit.OnComplete(result)
+
+		// 等待期间不占用并发量
+		timer := time.NewTimer(policy.NextWait(task.Info.retry))
+		select {
+		case <-timer.C:
+		case <-task.Info.ctx.Done():
+			timer.Stop()
+		}
+
+		te.locker.Lock()
+		if !task.Info.IsCanceled() {
+			te.push(task) // 重新加入队列
 		}
+		te.locker.Unlock()
+		return
+	}
+
+	// 执行失败
+	task.Unit.OnFailed(result)
+	if te.IsFailedDeque {
+		// 加入失败队列
+		te.failedDeque.Append(task)
 	}
+	task.Unit.OnComplete(result)
 }
 
 //FailedDeque 获取失败队列
@@ -176,16 +300,57 @@ func (te *TaskExecutor) FailedDeque() *lane.Deque {
 	return te.failedDeque
 }
 
-//Stop 停止执行
+//Stop 停止执行, 清空队列并取消正在执行的任务
 func (te *TaskExecutor) Stop() {
-
+	te.lazyInit()
+	te.locker.Lock()
+	defer te.locker.Unlock()
+	te.stopped = true
+	te.queue = nil
+	te.cancel()
+	te.cond.Broadcast()
 }
 
-//Pause 暂停执行
+//Pause 暂停执行, 队列中的任务暂不执行, 正在执行的任务如果实现了 Pauser 也会暂停
 func (te *TaskExecutor) Pause() {
+	te.lazyInit()
+	te.locker.Lock()
+	te.paused = true
+	pausers := te.runningPausers()
+	te.locker.Unlock()
 
+	for _, p := range pausers {
+		p.Pause()
+	}
 }
 
 //Resume 恢复执行
 func (te *TaskExecutor) Resume() {
+	te.lazyInit()
+	te.locker.Lock()
+	te.paused = false
+	pausers := te.runningPausers()
+	te.cond.Broadcast()
+	te.locker.Unlock()
+
+	for _, p := range pausers {
+		p.Resume()
+	}
+}
+
+// runningPausers 正在执行且支持暂停的任务, 需要持有锁
+func (te *TaskExecutor) runningPausers() (pausers []Pauser) {
+	for _, task := range te.running {
+		if p, ok := task.Unit.(Pauser); ok {
+			pausers = append(pausers, p)
+		}
+	}
+	return
+}
+
+// IsPaused 是否已暂停
+func (te *TaskExecutor) IsPaused() bool {
+	te.locker.Lock()
+	defer te.locker.Unlock()
+	return te.paused
 }
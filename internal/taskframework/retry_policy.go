@@ -0,0 +1,125 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package taskframework
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+type (
+	// RetryPolicy 任务重试策略, 由 TaskExecutor 在任务需要重试时调用
+	RetryPolicy interface {
+		// CanRetry 根据执行结果判断是否重试, 不包括重试次数的检查
+		CanRetry(result *TaskUnitRunResult) bool
+		// NextWait 第 retry 次重试前的等待时间, retry 从1开始
+		NextWait(retry int) time.Duration
+	}
+
+	// ConstantRetryPolicy 固定等待时间
+	ConstantRetryPolicy struct {
+		Wait time.Duration
+	}
+
+	// LinearRetryPolicy 等待时间随重试次数线性增长, 不超过 MaxWait
+	LinearRetryPolicy struct {
+		Step    time.Duration
+		MaxWait time.Duration
+	}
+
+	// ExponentialRetryPolicy 等待时间随重试次数翻倍, 不超过 MaxWait, 并加入随机抖动
+	ExponentialRetryPolicy struct {
+		BaseWait time.Duration
+		MaxWait  time.Duration
+	}
+
+	// ErrorClassRetryPolicy 只重试 IsRetryable 判断为可重试的错误, 等待时间由 RetryPolicy 决定
+	ErrorClassRetryPolicy struct {
+		RetryPolicy
+		IsRetryable func(err error) bool
+	}
+)
+
+var (
+	// DefaultRetryPolicy 默认重试策略, 依次等待 2, 4, 6, 6... 秒
+	DefaultRetryPolicy RetryPolicy = &LinearRetryPolicy{
+		Step:    2 * time.Second,
+		MaxWait: 6 * time.Second,
+	}
+)
+
+func (cp *ConstantRetryPolicy) CanRetry(result *TaskUnitRunResult) bool {
+	return result.NeedRetry
+}
+
+func (cp *ConstantRetryPolicy) NextWait(retry int) time.Duration {
+	return cp.Wait
+}
+
+func (lp *LinearRetryPolicy) CanRetry(result *TaskUnitRunResult) bool {
+	return result.NeedRetry
+}
+
+func (lp *LinearRetryPolicy) NextWait(retry int) time.Duration {
+	wait := lp.Step * time.Duration(retry)
+	if lp.MaxWait > 0 && (wait > lp.MaxWait || wait < 0) {
+		return lp.MaxWait
+	}
+	return wait
+}
+
+func (ep *ExponentialRetryPolicy) CanRetry(result *TaskUnitRunResult) bool {
+	return result.NeedRetry
+}
+
+func (ep *ExponentialRetryPolicy) NextWait(retry int) time.Duration {
+	if retry < 1 {
+		retry = 1
+	}
+	wait := ep.MaxWait
+	if retry < 32 {
+		if w := ep.BaseWait << uint(retry-1); w > 0 && (w < wait || wait <= 0) {
+			wait = w
+		}
+	}
+	if wait <= 0 {
+		return 0
+	}
+	// 随机抖动, 避免多个任务同时重试
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+func (ecp *ErrorClassRetryPolicy) CanRetry(result *TaskUnitRunResult) bool {
+	if !ecp.RetryPolicy.CanRetry(result) {
+		return false
+	}
+	if result.Err == nil || ecp.IsRetryable == nil {
+		return true
+	}
+	return ecp.IsRetryable(result.Err)
+}
+
+// ParseRetryPolicy 根据名称创建重试策略, 名称为 constant, linear 或 exponential, 为空时使用 linear
+func ParseRetryPolicy(name string, wait, maxWait time.Duration) (RetryPolicy, error) {
+	switch name {
+	case "constant":
+		return &ConstantRetryPolicy{Wait: wait}, nil
+	case "", "linear":
+		return &LinearRetryPolicy{Step: wait, MaxWait: maxWait}, nil
+	case "exponential":
+		return &ExponentialRetryPolicy{BaseWait: wait, MaxWait: maxWait}, nil
+	}
+	return nil, fmt.Errorf("未知的重试策略: %s", name)
+}
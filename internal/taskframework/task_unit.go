@@ -13,8 +13,6 @@
 // limitations under the License.
 package taskframework
 
-import "time"
-
 type (
 	TaskUnit interface {
 		SetTaskInfo(info *TaskInfo)
@@ -29,8 +27,17 @@ type (
 		OnFailed(lastRunResult *TaskUnitRunResult)
 		// 每次执行结束执行的方法, 不管成功失败
 		OnComplete(lastRunResult *TaskUnitRunResult)
-		// 重试等待的时间
-		RetryWait() time.Duration
+	}
+
+	// TaskSizer 可获取任务大小的任务单元, 用于小文件优先
+	TaskSizer interface {
+		TaskSize() int64
+	}
+
+	// Pauser 支持暂停的任务单元, 暂停执行器时正在执行的任务也会暂停
+	Pauser interface {
+		Pause()
+		Resume()
 	}
 
 	// 任务单元执行结果
@@ -50,3 +57,12 @@ var (
 	// TaskUnitRunResultSuccess 任务执行成功
 	TaskUnitRunResultSuccess = &TaskUnitRunResult{}
 )
+
+// SmallFirstPriority 小文件优先, 无法获取大小的任务 (例如目录) 最先执行
+func SmallFirstPriority(unit TaskUnit) int64 {
+	sizer, ok := unit.(TaskSizer)
+	if !ok {
+		return 0
+	}
+	return -sizer.TaskSize()
+}
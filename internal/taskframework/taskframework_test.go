@@ -14,8 +14,11 @@
 package taskframework_test
 
 import (
+	"errors"
 	"fmt"
 	"github.com/tickstep/cloudpan189-go/internal/taskframework"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -55,13 +58,10 @@ func (tu *TestUnit) OnRetry(lastRunResult *taskframework.TaskUnitRunResult) {
 	fmt.Printf("[%s] prepare retry, times [%d/%d]...\n", tu.taskInfo.Id(), tu.taskInfo.Retry(), tu.taskInfo.MaxRetry())
 }
 
-func (tu *TestUnit) RetryWait() time.Duration {
-	return 1 * time.Second
-}
-
 func TestTaskExecutor(t *testing.T) {
 	te := taskframework.NewTaskExecutor()
 	te.SetParallel(2)
+	te.SetRetryPolicy(&taskframework.ConstantRetryPolicy{Wait: 1 * time.Second})
 	for i := 0; i < 3; i++ {
 		tu := TestUnit{
 			retry: false,
@@ -70,3 +70,226 @@ func TestTaskExecutor(t *testing.T) {
 	}
 	te.Execute()
 }
+
+type (
+	// CountUnit 前 failTimes 次执行失败, 记录执行次数
+	CountUnit struct {
+		failTimes int
+		err       error
+		runs      int
+		failed    bool
+		taskInfo  *taskframework.TaskInfo
+	}
+)
+
+func (cu *CountUnit) SetTaskInfo(taskInfo *taskframework.TaskInfo) {
+	cu.taskInfo = taskInfo
+}
+
+func (cu *CountUnit) Run() (result *taskframework.TaskUnitRunResult) {
+	cu.runs++
+	if cu.runs <= cu.failTimes {
+		return &taskframework.TaskUnitRunResult{
+			NeedRetry: true,
+			Err:       cu.err,
+		}
+	}
+	return &taskframework.TaskUnitRunResult{Succeed: true}
+}
+
+func (cu *CountUnit) OnRetry(lastRunResult *taskframework.TaskUnitRunResult) {}
+
+func (cu *CountUnit) OnSuccess(lastRunResult *taskframework.TaskUnitRunResult) {}
+
+func (cu *CountUnit) OnFailed(lastRunResult *taskframework.TaskUnitRunResult) {
+	cu.failed = true
+}
+
+func (cu *CountUnit) OnComplete(lastRunResult *taskframework.TaskUnitRunResult) {}
+
+func TestLinearRetryPolicy(t *testing.T) {
+	expected := []time.Duration{2 * time.Second, 4 * time.Second, 6 * time.Second, 6 * time.Second}
+	for k, wait := range expected {
+		if w := taskframework.DefaultRetryPolicy.NextWait(k + 1); w != wait {
+			t.Errorf("retry %d: expected %s, got %s", k+1, wait, w)
+		}
+	}
+}
+
+func TestExponentialRetryPolicy(t *testing.T) {
+	policy := &taskframework.ExponentialRetryPolicy{
+		BaseWait: 1 * time.Second,
+		MaxWait:  10 * time.Second,
+	}
+	for retry := 1; retry <= 40; retry++ {
+		max := time.Duration(1<<uint(retry-1)) * time.Second
+		if retry > 4 {
+			max = 10 * time.Second
+		}
+		w := policy.NextWait(retry)
+		if w < max/2 || w > max {
+			t.Errorf("retry %d: %s not in [%s, %s]", retry, w, max/2, max)
+		}
+	}
+}
+
+func TestErrorClassRetryPolicy(t *testing.T) {
+	errFatal := errors.New("fatal")
+	policy := &taskframework.ErrorClassRetryPolicy{
+		RetryPolicy: &taskframework.ConstantRetryPolicy{},
+		IsRetryable: func(err error) bool {
+			return err != errFatal
+		},
+	}
+	cases := []struct {
+		result   *taskframework.TaskUnitRunResult
+		expected bool
+	}{
+		{&taskframework.TaskUnitRunResult{NeedRetry: true}, true},
+		{&taskframework.TaskUnitRunResult{NeedRetry: true, Err: errors.New("timeout")}, true},
+		{&taskframework.TaskUnitRunResult{NeedRetry: true, Err: errFatal}, false},
+		{&taskframework.TaskUnitRunResult{NeedRetry: false}, false},
+	}
+	for k, c := range cases {
+		if policy.CanRetry(c.result) != c.expected {
+			t.Errorf("case %d: expected %v", k, c.expected)
+		}
+	}
+}
+
+func TestTaskExecutorRetryPolicy(t *testing.T) {
+	errFatal := errors.New("fatal")
+	te := taskframework.NewTaskExecutor()
+	te.SetRetryPolicy(&taskframework.ConstantRetryPolicy{})
+
+	retried := &CountUnit{failTimes: 2, err: errors.New("timeout")}
+	te.Append(retried, 3)
+
+	// 单独设置的重试策略优先
+	notRetried := &CountUnit{failTimes: 2, err: errFatal}
+	te.Append(notRetried, 3).SetRetryPolicy(&taskframework.ErrorClassRetryPolicy{
+		RetryPolicy: &taskframework.ConstantRetryPolicy{},
+		IsRetryable: func(err error) bool {
+			return err != errFatal
+		},
+	})
+
+	exceeded := &CountUnit{failTimes: 5}
+	te.Append(exceeded, 2)
+
+	te.Execute()
+
+	if retried.runs != 3 || retried.failed {
+		t.Errorf("retried: runs %d, failed %v", retried.runs, retried.failed)
+	}
+	if notRetried.runs != 1 || !notRetried.failed {
+		t.Errorf("notRetried: runs %d, failed %v", notRetried.runs, notRetried.failed)
+	}
+	if exceeded.runs != 3 || !exceeded.failed {
+		t.Errorf("exceeded: runs %d, failed %v", exceeded.runs, exceeded.failed)
+	}
+}
+
+type (
+	// OrderUnit 记录执行顺序, 运行时等待 context 取消或 block 关闭
+	OrderUnit struct {
+		name     string
+		size     int64
+		order    *[]string
+		locker   *sync.Mutex
+		block    chan struct{}
+		taskInfo *taskframework.TaskInfo
+	}
+)
+
+func (ou *OrderUnit) SetTaskInfo(taskInfo *taskframework.TaskInfo) {
+	ou.taskInfo = taskInfo
+}
+
+func (ou *OrderUnit) Run() (result *taskframework.TaskUnitRunResult) {
+	ou.locker.Lock()
+	*ou.order = append(*ou.order, ou.name)
+	ou.locker.Unlock()
+	if ou.block != nil {
+		select {
+		case <-ou.block:
+		case <-ou.taskInfo.Context().Done():
+			return &taskframework.TaskUnitRunResult{NeedRetry: true, Err: ou.taskInfo.Context().Err()}
+		}
+	}
+	return &taskframework.TaskUnitRunResult{Succeed: true}
+}
+
+func (ou *OrderUnit) TaskSize() int64 {
+	return ou.size
+}
+
+func (ou *OrderUnit) OnRetry(lastRunResult *taskframework.TaskUnitRunResult) {}
+
+func (ou *OrderUnit) OnSuccess(lastRunResult *taskframework.TaskUnitRunResult) {}
+
+func (ou *OrderUnit) OnFailed(lastRunResult *taskframework.TaskUnitRunResult) {}
+
+func (ou *OrderUnit) OnComplete(lastRunResult *taskframework.TaskUnitRunResult) {}
+
+func TestTaskExecutorPriority(t *testing.T) {
+	var (
+		order  []string
+		locker = &sync.Mutex{}
+	)
+	te := taskframework.NewTaskExecutor()
+	te.SetPriorityFunc(taskframework.SmallFirstPriority)
+	te.Append(&OrderUnit{name: "large", size: 300, order: &order, locker: locker}, 0)
+	te.Append(&OrderUnit{name: "small", size: 100, order: &order, locker: locker}, 0)
+	medium := te.Append(&OrderUnit{name: "medium", size: 200, order: &order, locker: locker}, 0)
+	te.AppendWithPriority(&OrderUnit{name: "first", size: 1000, order: &order, locker: locker}, 0, 1)
+	te.SetPriority(medium.Id(), 2)
+	te.Execute()
+
+	if strings.Join(order, ",") != "medium,first,small,large" {
+		t.Errorf("unexpected order: %v", order)
+	}
+}
+
+func TestTaskExecutorCancelAndPause(t *testing.T) {
+	var (
+		order  []string
+		locker = &sync.Mutex{}
+		block  = make(chan struct{})
+	)
+	te := taskframework.NewTaskExecutor()
+	te.SetRetryPolicy(&taskframework.ConstantRetryPolicy{})
+	running := te.Append(&OrderUnit{name: "running", order: &order, locker: locker, block: block}, 3)
+	queued := te.Append(&OrderUnit{name: "queued", order: &order, locker: locker}, 0)
+	te.Append(&OrderUnit{name: "last", order: &order, locker: locker}, 0)
+
+	done := make(chan struct{})
+	go func() {
+		te.Execute()
+		close(done)
+	}()
+
+	// 等待第一个任务开始执行
+	for te.RunningCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	te.Pause()
+	if !te.Cancel(queued.Id()) || te.Count() != 1 {
+		t.Fatalf("cancel queued task failed, count: %d", te.Count())
+	}
+	// 取消正在执行的任务, 不会重试
+	te.Cancel(running.Id())
+	for te.RunningCount() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if te.Count() != 1 {
+		t.Fatalf("paused executor should not run queued task, count: %d", te.Count())
+	}
+	te.SetParallel(2)
+	te.Resume()
+	<-done
+
+	if strings.Join(order, ",") != "running,last" {
+		t.Errorf("unexpected order: %v", order)
+	}
+}
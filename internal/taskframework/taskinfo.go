@@ -13,17 +13,29 @@
 // limitations under the License.
 package taskframework
 
+import "context"
+
 type (
 	TaskInfo struct {
-		id       string
-		maxRetry int
-		retry    int
+		id          string
+		maxRetry    int
+		retry       int
+		retryPolicy RetryPolicy // 任务的重试策略, 为空则使用 TaskExecutor 的重试策略
+		priority    int64       // 优先级, 值越大越先执行
+		ctx         context.Context
+		cancel      context.CancelFunc
 	}
 
 	TaskInfoItem struct {
 		Info *TaskInfo
 		Unit TaskUnit
+
+		seq   uint64 // 加入队列的序号
+		index int    // 在队列中的位置
 	}
+
+	// taskQueue 按优先级排序的任务队列, 实现 heap.Interface
+	taskQueue []*TaskInfoItem
 )
 
 // IsExceedRetry 重试次数达到限制
@@ -46,3 +58,62 @@ func (t *TaskInfo) SetMaxRetry(maxRetry int) {
 func (t *TaskInfo) Retry() int {
 	return t.retry
 }
+
+// SetRetryPolicy 设置任务的重试策略
+func (t *TaskInfo) SetRetryPolicy(policy RetryPolicy) {
+	t.retryPolicy = policy
+}
+
+func (t *TaskInfo) RetryPolicy() RetryPolicy {
+	return t.retryPolicy
+}
+
+func (t *TaskInfo) Priority() int64 {
+	return t.priority
+}
+
+// Context 任务的 context, 任务被取消或执行器停止时 Done
+func (t *TaskInfo) Context() context.Context {
+	if t.ctx == nil {
+		return context.Background()
+	}
+	return t.ctx
+}
+
+// IsCanceled 任务是否已被取消
+func (t *TaskInfo) IsCanceled() bool {
+	return t.ctx != nil && t.ctx.Err() != nil
+}
+
+func (tq taskQueue) Len() int {
+	return len(tq)
+}
+
+func (tq taskQueue) Less(i, j int) bool {
+	if tq[i].Info.priority != tq[j].Info.priority {
+		return tq[i].Info.priority > tq[j].Info.priority
+	}
+	return tq[i].seq < tq[j].seq
+}
+
+func (tq taskQueue) Swap(i, j int) {
+	tq[i], tq[j] = tq[j], tq[i]
+	tq[i].index = i
+	tq[j].index = j
+}
+
+func (tq *taskQueue) Push(x interface{}) {
+	item := x.(*TaskInfoItem)
+	item.index = len(*tq)
+	*tq = append(*tq, item)
+}
+
+func (tq *taskQueue) Pop() interface{} {
+	old := *tq
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*tq = old[:n-1]
+	return item
+}
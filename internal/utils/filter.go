@@ -0,0 +1,191 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package utils
+
+import (
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// FileFilter 上传, 备份, 下载和导出共用的文件过滤条件
+	FileFilter struct {
+		ExcludeNames   []string  `json:"excludeNames,omitempty"`   // 排除的文件/目录名称, 正则表达式
+		IncludeNames   []string  `json:"includeNames,omitempty"`   // 只包含名称匹配的文件, 正则表达式, 不限制目录
+		MinSize        int64     `json:"minSize,omitempty"`        // 文件最小值, 0为不限制
+		MaxSize        int64     `json:"maxSize,omitempty"`        // 文件最大值, 0为不限制
+		NewerThan      time.Time `json:"newerThan"`                // 只包含修改时间晚于该时间的文件
+		OlderThan      time.Time `json:"olderThan"`                // 只包含修改时间早于该时间的文件
+		IgnoreFileName string    `json:"ignoreFileName,omitempty"` // 每一级目录中读取的忽略文件名, 为空则不读取
+		IgnoreFile     string    `json:"ignoreFile,omitempty"`     // --ignore-file 指定的忽略文件路径
+
+		globalRules *IgnoreRules            // --ignore-file 指定的忽略规则, 相对于每个传输的根目录
+		dirRules    map[string]*IgnoreRules // 目录 => 该目录下的忽略规则
+		mu          sync.RWMutex
+	}
+)
+
+const (
+	// DefaultIgnoreFileName 默认的忽略文件名
+	DefaultIgnoreFileName = ".cloud189ignore"
+)
+
+// SetIgnoreFile 读取 --ignore-file 指定的忽略文件, 规则相对于每个通过 AddRoot 添加的根目录
+func (ff *FileFilter) SetIgnoreFile(filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rules, err := ParseIgnoreRules(f)
+	if err != nil {
+		return err
+	}
+	ff.IgnoreFile = filePath
+	ff.globalRules = rules
+	return nil
+}
+
+// AddRoot 添加传输的根目录, 使用 / 分隔
+func (ff *FileFilter) AddRoot(root string) {
+	if ff == nil || ff.globalRules.Len() == 0 {
+		return
+	}
+	ff.addRules(root, ff.globalRules)
+}
+
+// AddIgnoreRules 添加目录中的忽略规则, 目录使用 / 分隔
+func (ff *FileFilter) AddIgnoreRules(dir string, r io.Reader) error {
+	rules, err := ParseIgnoreRules(r)
+	if err != nil {
+		return err
+	}
+	ff.addRules(dir, rules)
+	return nil
+}
+
+// LoadIgnoreFile 读取本地目录中的忽略文件, 未设置忽略文件名或文件不存在时忽略
+func (ff *FileFilter) LoadIgnoreFile(localDir string) error {
+	if ff == nil || ff.IgnoreFileName == "" {
+		return nil
+	}
+	f, err := os.Open(path.Join(ToSlashPath(localDir), ff.IgnoreFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	return ff.AddIgnoreRules(ToSlashPath(localDir), f)
+}
+
+func (ff *FileFilter) addRules(dir string, rules *IgnoreRules) {
+	if rules.Len() == 0 {
+		return
+	}
+	dir = cleanFilterDir(dir)
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	if ff.dirRules == nil {
+		ff.dirRules = map[string]*IgnoreRules{}
+	}
+	if exist, ok := ff.dirRules[dir]; ok {
+		// 同一目录的规则按添加顺序合并, 后添加的优先
+		rules = &IgnoreRules{rules: append(append([]*ignoreRule{}, exist.rules...), rules.rules...)}
+	}
+	ff.dirRules[dir] = rules
+}
+
+// IsIgnored 路径是否被忽略规则排除, 上级目录的规则先匹配, 下级目录的规则优先
+func (ff *FileFilter) IsIgnored(filePath string, isDir bool) bool {
+	if ff == nil {
+		return false
+	}
+	ff.mu.RLock()
+	defer ff.mu.RUnlock()
+	if len(ff.dirRules) == 0 {
+		return false
+	}
+
+	filePath = cleanFilterDir(filePath)
+	var dirs []string
+	for dir := path.Dir(filePath); ; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+		if parent := path.Dir(dir); parent == dir || dir == "." {
+			break
+		}
+	}
+
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rules, ok := ff.dirRules[dirs[i]]
+		if !ok {
+			continue
+		}
+		relPath := strings.TrimPrefix(strings.TrimPrefix(filePath, dirs[i]), "/")
+		if matched, ig := rules.Match(relPath, isDir); matched {
+			ignored = ig
+		}
+	}
+	return ignored
+}
+
+// IsExcluded 文件或目录是否被排除, 大小, 修改时间和包含条件只对文件有效
+func (ff *FileFilter) IsExcluded(filePath string, isDir bool, size int64, modTime time.Time) bool {
+	if ff == nil {
+		return false
+	}
+	filePath = ToSlashPath(filePath)
+	if IsExcludeFile(filePath, &ff.ExcludeNames) || ff.IsIgnored(filePath, isDir) {
+		return true
+	}
+	if isDir {
+		return false
+	}
+
+	if len(ff.IncludeNames) > 0 {
+		included := false
+		for _, pattern := range ff.IncludeNames {
+			if m, _ := regexp.MatchString(pattern, path.Base(filePath)); m {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return true
+		}
+	}
+	if (ff.MinSize > 0 && size < ff.MinSize) || (ff.MaxSize > 0 && size > ff.MaxSize) {
+		return true
+	}
+	if (!ff.NewerThan.IsZero() && !modTime.After(ff.NewerThan)) || (!ff.OlderThan.IsZero() && !modTime.Before(ff.OlderThan)) {
+		return true
+	}
+	return false
+}
+
+// ToSlashPath 将路径中的 \ 转换为 /
+func ToSlashPath(p string) string {
+	return strings.ReplaceAll(p, "\\", "/")
+}
+
+func cleanFilterDir(dir string) string {
+	return path.Clean(ToSlashPath(dir))
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIgnoreRulesMatch(t *testing.T) {
+	rules, err := ParseIgnoreRules(strings.NewReader(`
+# 注释
+*.log
+!keep.log
+build/
+/root.txt
+docs/*.md
+**/tmp/**
+a/**/z
+\#hash
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"x.log", false, true},
+		{"sub/x.log", false, true},
+		{"keep.log", false, false},
+		{"sub/keep.log", false, false},
+		{"build", true, true},
+		{"sub/build", true, true},
+		{"build", false, false},
+		{"root.txt", false, true},
+		{"sub/root.txt", false, false},
+		{"docs/a.md", false, true},
+		{"docs/sub/a.md", false, false},
+		{"x/tmp/y", false, true},
+		{"tmp/y/z", false, true},
+		{"a/z", false, true},
+		{"a/b/c/z", false, true},
+		{"#hash", false, true},
+		{"main.go", false, false},
+	}
+	for _, c := range cases {
+		if _, ignored := rules.Match(c.path, c.isDir); ignored != c.ignored {
+			t.Errorf("Match(%q, %v) = %v, want %v", c.path, c.isDir, ignored, c.ignored)
+		}
+	}
+}
+
+func TestFileFilterIgnoreLevels(t *testing.T) {
+	ff := &FileFilter{}
+	ff.AddIgnoreRules("/data", strings.NewReader("*.tmp\nlogs/\n"))
+	ff.AddIgnoreRules("/data/project", strings.NewReader("!important.tmp\n/local.txt\n"))
+
+	cases := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"/data/a.tmp", false, true},
+		{"/data/project/a.tmp", false, true},
+		{"/data/project/important.tmp", false, false},
+		{"/data/important.tmp", false, true},
+		{"/data/project/logs", true, true},
+		{"/data/project/local.txt", false, true},
+		{"/data/project/sub/local.txt", false, false},
+		{"/data/local.txt", false, false},
+		{"/other/a.tmp", false, false},
+	}
+	for _, c := range cases {
+		if ignored := ff.IsIgnored(c.path, c.isDir); ignored != c.ignored {
+			t.Errorf("IsIgnored(%q, %v) = %v, want %v", c.path, c.isDir, ignored, c.ignored)
+		}
+	}
+}
+
+func TestFileFilterIsExcluded(t *testing.T) {
+	now := time.Now()
+	ff := &FileFilter{
+		ExcludeNames: []string{`^~`},
+		IncludeNames: []string{`\.jpg$`, `\.png$`},
+		MinSize:      10,
+		MaxSize:      100,
+		NewerThan:    now.Add(-48 * time.Hour),
+		OlderThan:    now.Add(-time.Hour),
+	}
+	modTime := now.Add(-24 * time.Hour)
+
+	cases := []struct {
+		name     string
+		path     string
+		isDir    bool
+		size     int64
+		modTime  time.Time
+		excluded bool
+	}{
+		{"matched", "/a/1.jpg", false, 50, modTime, false},
+		{"exclude name", "/a/~1.jpg", false, 50, modTime, true},
+		{"not included", "/a/1.txt", false, 50, modTime, true},
+		{"dir not limited", "/a/dir", true, 0, now, false},
+		{"too small", "/a/1.png", false, 5, modTime, true},
+		{"too large", "/a/1.png", false, 500, modTime, true},
+		{"too old", "/a/1.png", false, 50, now.Add(-72 * time.Hour), true},
+		{"too new", "/a/1.png", false, 50, now, true},
+		{"windows path", `C:\a\1.png`, false, 50, modTime, false},
+	}
+	for _, c := range cases {
+		if excluded := ff.IsExcluded(c.path, c.isDir, c.size, c.modTime); excluded != c.excluded {
+			t.Errorf("%s: IsExcluded(%q) = %v, want %v", c.name, c.path, excluded, c.excluded)
+		}
+	}
+}
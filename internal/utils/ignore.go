@@ -0,0 +1,162 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package utils
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+type (
+	// IgnoreRules gitignore 语法的忽略规则
+	IgnoreRules struct {
+		rules []*ignoreRule
+	}
+
+	ignoreRule struct {
+		pattern string
+		re      *regexp.Regexp
+		negate  bool // ! 开头, 重新包含之前排除的文件
+		dirOnly bool // / 结尾, 只匹配目录
+	}
+)
+
+// ParseIgnoreRules 解析 gitignore 语法的忽略规则.
+// 支持 # 注释, ! 取反, / 结尾只匹配目录, 包含 / 的规则相对于忽略文件所在目录, 以及 *, ?, [...], ** 通配符
+func ParseIgnoreRules(r io.Reader) (*IgnoreRules, error) {
+	ir := &IgnoreRules{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if rule := parseIgnoreRule(scanner.Text()); rule != nil {
+			ir.rules = append(ir.rules, rule)
+		}
+	}
+	return ir, scanner.Err()
+}
+
+func parseIgnoreRule(line string) *ignoreRule {
+	line = strings.TrimSuffix(line, "\r")
+	// 未转义的行尾空格忽略
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	rule := &ignoreRule{pattern: line}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+
+	// 开头或中间包含 / 的规则, 相对于忽略文件所在的目录; 否则匹配任意层级的文件名
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := globToRegexp(line)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "(^|/)" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil
+	}
+	rule.re = re
+	return rule
+}
+
+// globToRegexp 将通配符转换为正则表达式, 通配符不匹配 /, ** 匹配任意层级目录
+func globToRegexp(glob string) string {
+	builder := &strings.Builder{}
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				atStart := i == 0 || glob[i-1] == '/'
+				i++
+				if atStart && i+1 < len(glob) && glob[i+1] == '/' {
+					// **/ 匹配零个或多个目录
+					builder.WriteString("(.*/)?")
+					i++
+				} else {
+					builder.WriteString(".*")
+				}
+				continue
+			}
+			builder.WriteString("[^/]*")
+		case '?':
+			builder.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				builder.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			builder.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				builder.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return builder.String()
+}
+
+// Match 匹配相对于忽略文件所在目录的路径, matched 表示是否有规则匹配, ignored 为最后一条匹配规则的结果
+func (ir *IgnoreRules) Match(relPath string, isDir bool) (matched, ignored bool) {
+	if ir == nil {
+		return false, false
+	}
+	relPath = strings.Trim(relPath, "/")
+	for _, rule := range ir.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(relPath) {
+			matched, ignored = true, !rule.negate
+		}
+	}
+	return
+}
+
+// Len 规则数量
+func (ir *IgnoreRules) Len() int {
+	if ir == nil {
+		return 0
+	}
+	return len(ir.rules)
+}
@@ -127,6 +127,7 @@ func main() {
 
 		os.Setenv(config.EnvVerbose, c.String("verbose"))
 		isCli = true
+		cmder.SetCliMode(true)
 		logger.Verbosef("提示: 你已经开启VERBOSE调试日志\n\n")
 
 		var (
@@ -153,7 +154,7 @@ func main() {
 				acceptCompleteFileCommands = []string{
 					"cd", "cp", "xcp", "download", "ls", "mkdir", "mv", "pwd", "rename", "rm", "share", "upload", "login", "loglist", "logout",
 					"clear", "quit", "exit", "quota", "who", "sign", "update", "who", "su", "config",
-					"family", "export", "import", "backup",
+					"family", "export", "import", "backup", "jobs", "cache",
 				}
 				closed = strings.LastIndex(line, " ") == len(line)-1
 			)
@@ -423,18 +424,37 @@ func main() {
 		// 上传文件/目录 upload
 		command.CmdUpload(),
 
+		// 未完成的上传 upload-pending
+		command.CmdUploadPending(),
+
 		// 手动秒传
 		command.CmdRapidUpload(),
 
+		// 文件历史版本 versions
+		command.CmdVersions(),
+
 		// 下载文件/目录 download
 		command.CmdDownload(),
 
+		// 清理未完成的下载 download-clean
+		command.CmdDownloadClean(),
+
+		// 未完成的下载任务 jobs
+		command.CmdJobs(),
+		command.CmdFg(),
+		command.CmdPause(),
+		command.CmdResume(),
+		command.CmdKill(),
+
 		// 导出文件/目录元数据 export
 		command.CmdExport(),
 
 		// 导入文件 import
 		command.CmdImport(),
 
+		// 根据本地文件生成导入文件 manifest
+		command.CmdManifest(),
+
 		// 回收站
 		//command.CmdRecycle(),
 
@@ -444,6 +464,9 @@ func main() {
 		// 工具箱 tool
 		command.CmdTool(),
 
+		// 本地文件摘要缓存 cache
+		command.CmdCache(),
+
 		// 清空控制台 clear
 		{
 			Name:        "clear",
@@ -460,8 +483,23 @@ func main() {
 
 		// 检测程序更新 update
 		{
-			Name:     "update",
-			Usage:    "检测程序更新",
+			Name:  "update",
+			Usage: "检测程序更新",
+			Description: `
+	更新前会校验更新文件的 SHA-256, 内置了签名公钥时同时校验 sha256sums.txt 的签名.
+	更新前的版本备份在程序目录的 ` + panupdate.BackupDirName + ` 目录, 只保留上一个版本.
+
+	示例:
+
+	使用本地下载的更新文件更新, 同一目录下需要有发布的 sha256sums.txt (以及签名 sha256sums.txt.sig)
+	cloudpan189-go update --from-file /root/Downloads/cloudpan189-go-v0.1.3-linux-amd64.zip
+
+	回滚到上次更新前的版本
+	cloudpan189-go update --rollback
+
+	不校验更新文件的 SHA-256 和签名
+	cloudpan189-go update --no-verify
+`,
 			Category: "其他",
 			Action: func(c *cli.Context) error {
 				if c.IsSet("y") {
@@ -469,7 +507,15 @@ func main() {
 						return nil
 					}
 				}
-				panupdate.CheckUpdate(app.Version, c.Bool("y"))
+				if c.Bool("rollback") {
+					panupdate.Rollback(c.Bool("y"))
+					return nil
+				}
+				if c.String("from-file") != "" {
+					panupdate.UpdateFromFile(app.Version, c.String("from-file"), c.Bool("y"), c.Bool("no-verify"))
+					return nil
+				}
+				panupdate.CheckUpdate(app.Version, c.Bool("y"), c.Bool("no-verify"))
 				return nil
 			},
 			Flags: []cli.Flag{
@@ -477,6 +523,18 @@ func main() {
 					Name:  "y",
 					Usage: "确认更新",
 				},
+				cli.BoolFlag{
+					Name:  "rollback",
+					Usage: "回滚到上次更新前的版本",
+				},
+				cli.StringFlag{
+					Name:  "from-file",
+					Usage: "使用本地的更新文件(zip)进行更新, 同目录下需要有发布的 SHA-256 校验文件",
+				},
+				cli.BoolFlag{
+					Name:  "no-verify",
+					Usage: "不校验更新文件的 SHA-256 和签名",
+				},
 			},
 		},
 